@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	openai "github.com/sashabaranov/go-openai"
+	"jaeger-storage/clients"
+	"log"
+)
+
+const systemPrompt = `
+	You help a software engineer troubleshoot a distributed system by answering questions about a single distributed trace.
+	You cannot see the trace, use the tools to gather evidence before answering. Start broad, e.g. with the trace timeline
+	or a search, then drill down into the relevant spans, their children, parents and logs.
+	Only answer from what the tools returned. Refer to spans by their span ID.
+	When you have enough evidence, answer without calling a tool with a JSON object: the answer in "answer" and "sufficient" set to true.
+	Keep the answer short, brief, and specific. If asked for a count return the number.
+	If the question cannot be answered from the evidence set "sufficient" to false.
+`
+
+// number of times an answer that is not valid JSON is sent back to the model once it cannot call tools anymore
+const answerRetries = 2
+
+// tool outputs are cut to this many characters to keep the conversation within the context window
+const maxToolOutputLength = 8000
+
+// Step is a tool call made by the model while answering.
+type Step struct {
+	Iteration int    `json:"iteration"`
+	Tool      string `json:"tool"`
+	Arguments string `json:"arguments"`
+	Output    string `json:"output"`
+	Error     string `json:"error,omitempty"`
+}
+
+type Result struct {
+	Answer     string `json:"answer"`
+	Transcript []Step `json:"transcript"`
+	Iterations int    `json:"iterations"`
+	// true when the model was forced to answer because it ran out of iterations
+	Exhausted bool `json:"exhausted"`
+}
+
+// Agent answers a question by letting the model call tools until it has gathered enough evidence.
+type Agent struct {
+	llm LLM
+	// maximum number of model calls that may request tools
+	maxIterations int
+}
+
+func NewAgent(llm LLM, maxIterations int) *Agent {
+	if maxIterations <= 0 {
+		maxIterations = 8
+	}
+	return &Agent{llm: llm, maxIterations: maxIterations}
+}
+
+func (a *Agent) Run(ctx context.Context, toolbox *Toolbox, question string) (*Result, error) {
+	tools := toolbox.Definitions()
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: systemPrompt},
+		{Role: openai.ChatMessageRoleUser, Content: fmt.Sprintf("<question>\n%s\n</question>", question)},
+	}
+	result := &Result{Transcript: make([]Step, 0)}
+
+	for iteration := 1; iteration <= a.maxIterations; iteration++ {
+		result.Iterations = iteration
+		res, err := a.llm.ChatWithTools(ctx, messages, tools)
+		if err != nil {
+			log.Println("[agent][Run][error] an error occurred while calling the model", err)
+			return nil, err
+		}
+		messages = append(messages, res)
+
+		if len(res.ToolCalls) == 0 {
+			answer, err := clients.ParseAnswer(res.Content)
+			if err != nil {
+				log.Println("[agent][Run][error] invalid answer, sending it back", err)
+				messages = append(messages, invalidAnswer(err))
+				continue
+			}
+			result.Answer = answer
+			return result, nil
+		}
+
+		for _, call := range res.ToolCalls {
+			step := Step{Iteration: iteration, Tool: call.Function.Name, Arguments: call.Function.Arguments}
+			output, err := toolbox.Call(ctx, call.Function.Name, call.Function.Arguments)
+			if err != nil {
+				log.Println("[agent][Run][error] tool call failed", call.Function.Name, err)
+				step.Error = err.Error()
+				output = "error: " + err.Error()
+			}
+			if len(output) > maxToolOutputLength {
+				output = output[:maxToolOutputLength] + "\n[truncated]"
+			}
+			step.Output = output
+			result.Transcript = append(result.Transcript, step)
+
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    output,
+				ToolCallID: call.ID,
+				Name:       call.Function.Name,
+			})
+		}
+	}
+
+	// out of iterations, the model has to answer with the evidence it has
+	log.Printf("[agent][Run] no answer after %d iterations, forcing an answer\n", a.maxIterations)
+	result.Exhausted = true
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: "You cannot call any more tools. Answer the question with the evidence gathered so far.",
+	})
+	for attempt := 0; ; attempt++ {
+		res, err := a.llm.ChatWithTools(ctx, messages, nil)
+		if err != nil {
+			log.Println("[agent][Run][error] an error occurred while calling the model", err)
+			return nil, err
+		}
+		answer, err := clients.ParseAnswer(res.Content)
+		if err == nil {
+			result.Answer = answer
+			return result, nil
+		}
+		log.Printf("[agent][Run][error] invalid answer on attempt %d: %v\n", attempt+1, err)
+		if attempt == answerRetries {
+			return nil, fmt.Errorf("no valid answer after %d attempts: %w", attempt+1, err)
+		}
+		messages = append(messages, res, invalidAnswer(err))
+	}
+}
+
+func invalidAnswer(err error) openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: fmt.Sprintf("The answer is invalid: %v. Answer again with only the JSON object.", err),
+	}
+}
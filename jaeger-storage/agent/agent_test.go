@@ -0,0 +1,347 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jaegertracing/jaeger/model"
+	openai "github.com/sashabaranov/go-openai"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTraceId = "e72ef241661424eb6970b65f6fd74b30"
+
+type fakeTraceReader struct {
+	trace *model.Trace
+}
+
+func (f *fakeTraceReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	return f.trace, nil
+}
+
+func testSpan(t *testing.T, spanId string, parentId string, service string, operation string, offset time.Duration, duration time.Duration) *model.Span {
+	t.Helper()
+	traceId, err := model.TraceIDFromString(testTraceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := model.SpanIDFromString(spanId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	span := &model.Span{
+		TraceID:       traceId,
+		SpanID:        id,
+		OperationName: operation,
+		StartTime:     time.Date(2024, 12, 19, 10, 0, 0, 0, time.UTC).Add(offset),
+		Duration:      duration,
+		Process:       model.NewProcess(service, nil),
+	}
+	if parentId != "" {
+		parent, err := model.SpanIDFromString(parentId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		span.References = []model.SpanRef{model.NewChildOfRef(traceId, parent)}
+	}
+	return span
+}
+
+// testTrace is /dispatch calling GetDriver, which fails with a redis timeout
+func testTrace(t *testing.T) *model.Trace {
+	root := testSpan(t, "0000000000000001", "", "frontend", "/dispatch", 0, 100*time.Millisecond)
+	child := testSpan(t, "0000000000000002", "0000000000000001", "redis-manual", "GetDriver", 10*time.Millisecond, 30*time.Millisecond)
+	child.Tags = []model.KeyValue{model.Bool("error", true)}
+	child.Logs = []model.Log{
+		{Timestamp: child.StartTime.Add(20 * time.Millisecond), Fields: []model.KeyValue{model.String("event", "redis timeout")}},
+	}
+	return &model.Trace{Spans: []*model.Span{root, child}}
+}
+
+func newTestToolbox(trace *model.Trace) *Toolbox {
+	// the tools used in the tests read the trace only, Neo4j is not needed
+	return NewToolbox(nil, &fakeTraceReader{trace: trace}, testTraceId)
+}
+
+func TestRunMultiStepToolLoop(t *testing.T) {
+	llm := NewFakeLLM(
+		FakeToolCall("call-1", ToolTraceTimeline, map[string]any{}),
+		FakeToolCall("call-2", ToolGetLogs, spanIdArgs{SpanId: "0000000000000002"}),
+		FakeAnswer("The Redis call timed out"),
+	)
+	result, err := NewAgent(llm, 5).Run(context.Background(), newTestToolbox(testTrace(t)), "Why did GetDriver fail?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Answer != "The Redis call timed out" {
+		t.Errorf("answer = %q, want the answer of the JSON object", result.Answer)
+	}
+	if result.Iterations != 3 || result.Exhausted {
+		t.Errorf("iterations = %d, exhausted = %v, want 3 iterations without exhausting them", result.Iterations, result.Exhausted)
+	}
+	if len(result.Transcript) != 2 {
+		t.Fatalf("transcript has %d steps, want 2", len(result.Transcript))
+	}
+	if !strings.Contains(result.Transcript[0].Output, "frontend /dispatch") || !strings.Contains(result.Transcript[0].Output, "redis-manual GetDriver (30ms) error") {
+		t.Errorf("timeline output = %q, want both spans with the error flagged", result.Transcript[0].Output)
+	}
+	if !strings.Contains(result.Transcript[1].Output, "event: redis timeout") {
+		t.Errorf("logs output = %q, want the log event", result.Transcript[1].Output)
+	}
+
+	// every call sees the tool results of the previous ones
+	if len(llm.Calls) != 3 {
+		t.Fatalf("the model was called %d times, want 3", len(llm.Calls))
+	}
+	last := llm.Calls[2].Messages
+	if n := len(last); n != 6 {
+		t.Fatalf("the last call got %d messages, want system, user and two assistant and tool pairs", n)
+	}
+	for i, id := range []string{"call-1", "call-2"} {
+		message := last[3+2*i]
+		if message.Role != openai.ChatMessageRoleTool || message.ToolCallID != id {
+			t.Errorf("message %d = %s %q, want the tool result of %s", 3+2*i, message.Role, message.ToolCallID, id)
+		}
+	}
+	if len(llm.Calls[2].Tools) == 0 {
+		t.Error("the tools were not offered to the model")
+	}
+}
+
+func TestRunExhaustsIterations(t *testing.T) {
+	llm := NewFakeLLM(
+		FakeToolCall("call-1", ToolTraceTimeline, map[string]any{}),
+		FakeToolCall("call-2", ToolLatencyBreakdown, map[string]any{}),
+		FakeAnswer("2 errors"),
+	)
+	result, err := NewAgent(llm, 2).Run(context.Background(), newTestToolbox(testTrace(t)), "How many errors occurred?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Exhausted || result.Iterations != 2 {
+		t.Errorf("exhausted = %v, iterations = %d, want exhausted after 2 iterations", result.Exhausted, result.Iterations)
+	}
+	if result.Answer != "2 errors" {
+		t.Errorf("answer = %q, want the forced answer", result.Answer)
+	}
+	if len(llm.Calls) != 3 {
+		t.Fatalf("the model was called %d times, want 2 iterations and the forced answer", len(llm.Calls))
+	}
+	forced := llm.Calls[2]
+	if forced.Tools != nil {
+		t.Error("tools were offered when forcing an answer")
+	}
+	if last := forced.Messages[len(forced.Messages)-1]; last.Role != openai.ChatMessageRoleUser || !strings.Contains(last.Content, "cannot call any more tools") {
+		t.Errorf("last message = %s %q, want the instruction to answer", last.Role, last.Content)
+	}
+}
+
+func TestRunAnswers(t *testing.T) {
+	text := func(content string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content}
+	}
+	tests := []struct {
+		name      string
+		responses []openai.ChatCompletionMessage
+		want      string
+		wantCalls int
+	}{
+		{"answer", []openai.ChatCompletionMessage{FakeAnswer("2 errors")}, "2 errors", 1},
+		{"insufficient", []openai.ChatCompletionMessage{text(`{"answer": "maybe redis", "sufficient": false}`)}, "Insufficient Information", 1},
+		{"wrapped in a code block", []openai.ChatCompletionMessage{text("```json\n{\"answer\": \" 2 errors \", \"sufficient\": true}\n```")}, "2 errors", 1},
+		{"tags instead of JSON", []openai.ChatCompletionMessage{text("<answer>The Redis call timed out</answer>"), FakeAnswer("The Redis call timed out")}, "The Redis call timed out", 2},
+		{"sufficient without answer", []openai.ChatCompletionMessage{text(`{"answer": "", "sufficient": true}`), FakeAnswer("2 errors")}, "2 errors", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := NewFakeLLM(tt.responses...)
+			result, err := NewAgent(llm, 3).Run(context.Background(), newTestToolbox(testTrace(t)), "How many errors occurred?")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Answer != tt.want || len(llm.Calls) != tt.wantCalls {
+				t.Errorf("answer = %q after %d calls, want %q after %d calls", result.Answer, len(llm.Calls), tt.want, tt.wantCalls)
+			}
+			if tt.wantCalls > 1 {
+				// the invalid answer is sent back with the error
+				last := llm.Calls[1].Messages
+				if m := last[len(last)-1]; m.Role != openai.ChatMessageRoleUser || !strings.Contains(m.Content, "The answer is invalid") {
+					t.Errorf("last message = %s %q, want the error of the invalid answer", m.Role, m.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestRunForcedAnswerInvalid(t *testing.T) {
+	invalid := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "The Redis call timed out"}
+	llm := NewFakeLLM(
+		FakeToolCall("call-1", ToolTraceTimeline, map[string]any{}),
+		invalid, invalid, invalid,
+	)
+	if _, err := NewAgent(llm, 1).Run(context.Background(), newTestToolbox(testTrace(t)), "Why did GetDriver fail?"); err == nil {
+		t.Fatal("answered from a response that is not JSON")
+	}
+	if len(llm.Calls) != 4 {
+		t.Errorf("the model was called %d times, want 1 iteration and 3 attempts to answer", len(llm.Calls))
+	}
+}
+
+func TestRunToolErrors(t *testing.T) {
+	rootless := testTrace(t)
+	// the spans are each other's parent
+	rootless.Spans[0].References = []model.SpanRef{model.NewChildOfRef(rootless.Spans[0].TraceID, rootless.Spans[1].SpanID)}
+
+	tests := []struct {
+		name      string
+		trace     *model.Trace
+		call      openai.ChatCompletionMessage
+		wantError string
+	}{
+		{
+			name:      "unknown tool",
+			trace:     testTrace(t),
+			call:      FakeToolCall("call-1", "drop_database", map[string]any{}),
+			wantError: `unknown tool "drop_database"`,
+		},
+		{
+			name:      "missing argument",
+			trace:     testTrace(t),
+			call:      FakeToolCall("call-1", ToolGetLogs, map[string]any{}),
+			wantError: "expects span_id",
+		},
+		{
+			name:      "trace without a root",
+			trace:     rootless,
+			call:      FakeToolCall("call-1", ToolTraceTimeline, map[string]any{}),
+			wantError: errRootless.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := NewFakeLLM(tt.call, FakeAnswer("Insufficient Information"))
+			result, err := NewAgent(llm, 3).Run(context.Background(), newTestToolbox(tt.trace), "What happened?")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(result.Transcript) != 1 {
+				t.Fatalf("transcript has %d steps, want 1", len(result.Transcript))
+			}
+			step := result.Transcript[0]
+			if step.Error != tt.wantError {
+				t.Errorf("error = %q, want %q", step.Error, tt.wantError)
+			}
+			if step.Output != "error: "+tt.wantError {
+				t.Errorf("output = %q, want the error handed to the model", step.Output)
+			}
+			// the model is told about the error and answers anyway
+			toolMessage := llm.Calls[1].Messages[3]
+			if toolMessage.Role != openai.ChatMessageRoleTool || toolMessage.Content != step.Output {
+				t.Errorf("tool message = %s %q, want the error", toolMessage.Role, toolMessage.Content)
+			}
+			if result.Answer != "Insufficient Information" {
+				t.Errorf("answer = %q", result.Answer)
+			}
+		})
+	}
+}
+
+func TestTranscriptShape(t *testing.T) {
+	llm := NewFakeLLM(
+		openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleAssistant,
+			ToolCalls: []openai.ToolCall{
+				FakeToolCall("call-1", ToolGetLogs, spanIdArgs{SpanId: "0000000000000002"}).ToolCalls[0],
+				FakeToolCall("call-2", "unknown", map[string]any{}).ToolCalls[0],
+			},
+		},
+		FakeToolCall("call-3", ToolLatencyBreakdown, spanIdArgs{SpanId: "0000000000000001"}),
+		FakeAnswer("done"),
+	)
+	result, err := NewAgent(llm, 5).Run(context.Background(), newTestToolbox(testTrace(t)), "Where is the time spent?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		iteration int
+		tool      string
+		arguments string
+		hasError  bool
+	}{
+		{1, ToolGetLogs, `{"span_id":"0000000000000002"}`, false},
+		{1, "unknown", `{}`, true},
+		{2, ToolLatencyBreakdown, `{"span_id":"0000000000000001"}`, false},
+	}
+	if len(result.Transcript) != len(want) {
+		t.Fatalf("transcript has %d steps, want %d", len(result.Transcript), len(want))
+	}
+	for i, w := range want {
+		step := result.Transcript[i]
+		if step.Iteration != w.iteration || step.Tool != w.tool || step.Arguments != w.arguments || (step.Error != "") != w.hasError {
+			t.Errorf("step %d = %+v, want iteration %d, tool %s, arguments %s, error %v", i, step, w.iteration, w.tool, w.arguments, w.hasError)
+		}
+		if step.Output == "" {
+			t.Errorf("step %d has no output", i)
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Answer     string           `json:"answer"`
+		Iterations int              `json:"iterations"`
+		Exhausted  *bool            `json:"exhausted"`
+		Transcript []map[string]any `json:"transcript"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Answer != "done" || decoded.Iterations != 3 || decoded.Exhausted == nil || *decoded.Exhausted {
+		t.Errorf("result = %s", data)
+	}
+	for i, step := range decoded.Transcript {
+		for _, key := range []string{"iteration", "tool", "arguments", "output"} {
+			if _, ok := step[key]; !ok {
+				t.Errorf("step %d has no %s: %v", i, key, step)
+			}
+		}
+		if _, ok := step["error"]; ok != want[i].hasError {
+			t.Errorf("step %d error present = %v, want %v", i, ok, want[i].hasError)
+		}
+	}
+}
+
+func TestLatencyBreakdownCycle(t *testing.T) {
+	trace := testTrace(t)
+	// the spans are each other's parent
+	trace.Spans[0].References = []model.SpanRef{model.NewChildOfRef(trace.Spans[0].TraceID, trace.Spans[1].SpanID)}
+
+	llm := NewFakeLLM(
+		FakeToolCall("call-1", ToolLatencyBreakdown, spanIdArgs{SpanId: "0000000000000002"}),
+		FakeAnswer("Insufficient Information"),
+	)
+	result, err := NewAgent(llm, 3).Run(context.Background(), newTestToolbox(trace), "Where is the time spent?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Transcript) != 1 {
+		t.Fatalf("transcript has %d steps, want 1", len(result.Transcript))
+	}
+	step := result.Transcript[0]
+	if step.Error != "" {
+		t.Fatalf("error = %q, want the breakdown of the cycle", step.Error)
+	}
+	for _, span := range []string{"frontend /dispatch", "redis-manual GetDriver"} {
+		if strings.Count(step.Output, span) == 0 {
+			t.Errorf("output = %q, want %s", step.Output, span)
+		}
+	}
+}
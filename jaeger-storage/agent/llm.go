@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	openai "github.com/sashabaranov/go-openai"
+	"jaeger-storage/clients"
+	"sync"
+)
+
+// LLM is a chat model that can call tools, implemented by clients.OpenAIClient.
+type LLM interface {
+	ChatWithTools(ctx context.Context, messages []openai.ChatCompletionMessage, tools []openai.Tool) (openai.ChatCompletionMessage, error)
+}
+
+// FakeLLM replays scripted responses instead of calling a model, so that the agent loop
+// can be exercised in tests without network access. Once the script is exhausted it answers
+// with "Insufficient Information".
+type FakeLLM struct {
+	Responses []openai.ChatCompletionMessage
+	// the messages and tools of every call, in order
+	Calls []FakeLLMCall
+	mutex sync.Mutex
+}
+
+type FakeLLMCall struct {
+	Messages []openai.ChatCompletionMessage
+	Tools    []openai.Tool
+}
+
+func NewFakeLLM(responses ...openai.ChatCompletionMessage) *FakeLLM {
+	return &FakeLLM{Responses: responses}
+}
+
+func (f *FakeLLM) ChatWithTools(ctx context.Context, messages []openai.ChatCompletionMessage, tools []openai.Tool) (openai.ChatCompletionMessage, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.Calls = append(f.Calls, FakeLLMCall{
+		Messages: append([]openai.ChatCompletionMessage(nil), messages...),
+		Tools:    tools,
+	})
+	if len(f.Responses) == 0 {
+		return FakeAnswer("Insufficient Information"), nil
+	}
+	res := f.Responses[0]
+	f.Responses = f.Responses[1:]
+	return res, nil
+}
+
+// FakeToolCall is a scripted response calling a single tool, args is marshalled to JSON.
+func FakeToolCall(id string, name string, args any) openai.ChatCompletionMessage {
+	arguments, err := json.Marshal(args)
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal fake tool call arguments: %v", err))
+	}
+	return openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleAssistant,
+		ToolCalls: []openai.ToolCall{
+			{
+				ID:   id,
+				Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      name,
+					Arguments: string(arguments),
+				},
+			},
+		},
+	}
+}
+
+// FakeAnswer is a scripted final answer in the JSON of clients.Answer, "Insufficient Information" is not sufficient.
+func FakeAnswer(answer string) openai.ChatCompletionMessage {
+	content, err := json.Marshal(clients.Answer{Answer: answer, Sufficient: answer != clients.InsufficientInformation})
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal fake answer: %v", err))
+	}
+	return openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleAssistant,
+		Content: string(content),
+	}
+}
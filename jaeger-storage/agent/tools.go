@@ -0,0 +1,442 @@
+package agent
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	openai "github.com/sashabaranov/go-openai"
+	"jaeger-storage/analysis"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ToolGetSpan          = "get_span"
+	ToolListChildren     = "list_children"
+	ToolListParents      = "list_parents"
+	ToolSearchSpans      = "search_spans"
+	ToolGetLogs          = "get_logs"
+	ToolTraceTimeline    = "get_trace_timeline"
+	ToolLatencyBreakdown = "latency_breakdown"
+)
+
+// errRootless is returned by the tools walking the call tree when every span has a parent, e.g. after a cycle
+// in the references of an imported trace. The agent hands it to the model as the output of the tool.
+var errRootless = errors.New("the trace has no root span, its spans reference each other in a cycle")
+
+// TraceReader reads a whole trace from Postgres, implemented by the span reader of the plugin.
+type TraceReader interface {
+	GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error)
+}
+
+// Toolbox holds the tools of a single question. Every tool is scoped to the trace being asked about,
+// graph lookups go to Neo4j and timing and logs are computed from the trace stored in Postgres.
+type Toolbox struct {
+	driver      neo4j.DriverWithContext
+	traceReader TraceReader
+	traceId     string
+
+	trace     *model.Trace
+	traceErr  error
+	traceOnce sync.Once
+}
+
+func NewToolbox(driver neo4j.DriverWithContext, traceReader TraceReader, traceId string) *Toolbox {
+	return &Toolbox{driver: driver, traceReader: traceReader, traceId: traceId}
+}
+
+type spanIdArgs struct {
+	SpanId string `json:"span_id"`
+}
+
+type searchArgs struct {
+	Text  string `json:"text"`
+	Limit int    `json:"limit"`
+}
+
+func spanIdParameters(description string) map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"span_id": map[string]any{"type": "string", "description": description},
+		},
+		"required": []string{"span_id"},
+	}
+}
+
+func function(name string, description string, parameters map[string]any) openai.Tool {
+	return openai.Tool{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+}
+
+func (t *Toolbox) Definitions() []openai.Tool {
+	return []openai.Tool{
+		function(ToolGetSpan, "Fetch a span of the trace by its ID, including its summary, status, errors and tags.",
+			spanIdParameters("ID of the span")),
+		function(ToolListChildren, "List the spans invoked by a span (INVOKES_CHILD) or started after it finished (INVOKES_FOLLOWS).",
+			spanIdParameters("ID of the parent span")),
+		function(ToolListParents, "List the spans that invoked a span or that it follows.",
+			spanIdParameters("ID of the child span")),
+		function(ToolSearchSpans, "Full text search over the span summaries of the trace, returns the best matching spans.",
+			map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"text":  map[string]any{"type": "string", "description": "words to search for"},
+					"limit": map[string]any{"type": "integer", "description": "maximum number of spans, defaults to 5"},
+				},
+				"required": []string{"text"},
+			}),
+		function(ToolGetLogs, "Get the log events of a span with all their fields, in time order.",
+			spanIdParameters("ID of the span")),
+		function(ToolTraceTimeline, "Get every span of the trace in start time order with its offset from the start of the trace, duration, service, operation and depth in the call tree.",
+			map[string]any{"type": "object", "properties": map[string]any{}}),
+		function(ToolLatencyBreakdown, "Break down where the time of a span and its descendants is spent. The self time of a span is the part of its duration not covered by its children, spans running in parallel can add up to more than 100%. Defaults to the root span.",
+			map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"span_id": map[string]any{"type": "string", "description": "ID of the span, defaults to the root span"},
+				},
+			}),
+	}
+}
+
+// Call runs a tool and returns its output as text for the model.
+func (t *Toolbox) Call(ctx context.Context, name string, arguments string) (string, error) {
+	switch name {
+	case ToolGetSpan, ToolListChildren, ToolListParents, ToolGetLogs:
+		args := spanIdArgs{}
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+		if args.SpanId == "" {
+			return "", errors.New("expects span_id")
+		}
+		switch name {
+		case ToolGetSpan:
+			return t.getSpan(ctx, args.SpanId)
+		case ToolListChildren:
+			return t.listRelated(ctx, args.SpanId, true)
+		case ToolListParents:
+			return t.listRelated(ctx, args.SpanId, false)
+		default:
+			return t.getLogs(ctx, args.SpanId)
+		}
+	case ToolSearchSpans:
+		args := searchArgs{}
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+		if strings.TrimSpace(args.Text) == "" {
+			return "", errors.New("expects text")
+		}
+		if args.Limit <= 0 || args.Limit > 20 {
+			args.Limit = 5
+		}
+		return t.searchSpans(ctx, args.Text, args.Limit)
+	case ToolTraceTimeline:
+		return t.traceTimeline(ctx)
+	case ToolLatencyBreakdown:
+		args := spanIdArgs{}
+		if arguments != "" {
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+		}
+		return t.latencyBreakdown(ctx, args.SpanId)
+	}
+	return "", fmt.Errorf("unknown tool %q", name)
+}
+
+func (t *Toolbox) getSpan(ctx context.Context, spanId string) (string, error) {
+	query := `
+		MATCH (:Trace {trace_id: $trace_id})-[:CONTAINS]->(s: Span {span_id: $span_id})
+		RETURN properties(s) as properties,
+			[(service: Service)-[:CONTAINS]->(s) | service.name][0] as service_name,
+			[(s)-[:RAISED]->(e: Error) | e.type + ': ' + coalesce(e.message, '')] as errors
+	`
+	res, err := neo4j.ExecuteQuery(ctx, t.driver, query, map[string]any{
+		"trace_id": t.traceId,
+		"span_id":  spanId,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[agent][getSpan][error] cannot fetch span", err)
+		return "", err
+	}
+	if len(res.Records) == 0 {
+		return fmt.Sprintf("span %s is not part of the trace", spanId), nil
+	}
+
+	record := res.Records[0]
+	properties, _, _ := neo4j.GetRecordValue[map[string]any](record, "properties")
+	serviceName, _, _ := neo4j.GetRecordValue[string](record, "service_name")
+	spanErrors, _, _ := neo4j.GetRecordValue[[]any](record, "errors")
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("span_id: %s\nservice: %s\n", spanId, serviceName))
+	keys := make([]string, 0, len(properties))
+	for k := range properties {
+		// the embedding is useless to the model and very long
+		if k == "embedding" || k == "span_id" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		v := properties[k]
+		if k == "duration" {
+			if d, ok := v.(int64); ok {
+				v = time.Duration(d).String()
+			}
+		}
+		sb.WriteString(fmt.Sprintf("%s: %v\n", k, v))
+	}
+	for _, e := range spanErrors {
+		sb.WriteString(fmt.Sprintf("error: %v\n", e))
+	}
+	return sb.String(), nil
+}
+
+func (t *Toolbox) listRelated(ctx context.Context, spanId string, children bool) (string, error) {
+	pattern := "(s)-[r:INVOKES_CHILD|INVOKES_FOLLOWS]->(o: Span)"
+	if !children {
+		pattern = "(s)<-[r:INVOKES_CHILD|INVOKES_FOLLOWS]-(o: Span)"
+	}
+	query := fmt.Sprintf(`
+		MATCH (:Trace {trace_id: $trace_id})-[:CONTAINS]->(s: Span {span_id: $span_id})
+		MATCH %s
+		RETURN o.span_id as span_id, o.operation_name as operation_name, type(r) as relationship,
+			o.duration as duration, o.span_status as span_status
+		ORDER BY o.start_time
+	`, pattern)
+	res, err := neo4j.ExecuteQuery(ctx, t.driver, query, map[string]any{
+		"trace_id": t.traceId,
+		"span_id":  spanId,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[agent][listRelated][error] cannot fetch related spans", err)
+		return "", err
+	}
+	if len(res.Records) == 0 {
+		if children {
+			return fmt.Sprintf("span %s has no children", spanId), nil
+		}
+		return fmt.Sprintf("span %s has no parents", spanId), nil
+	}
+
+	var sb strings.Builder
+	for _, record := range res.Records {
+		id, _, _ := neo4j.GetRecordValue[string](record, "span_id")
+		operationName, _, _ := neo4j.GetRecordValue[string](record, "operation_name")
+		relationship, _, _ := neo4j.GetRecordValue[string](record, "relationship")
+		duration, _, _ := neo4j.GetRecordValue[int64](record, "duration")
+		status, _, _ := neo4j.GetRecordValue[string](record, "span_status")
+		if children {
+			sb.WriteString(fmt.Sprintf("(%s, %s, %s)", spanId, relationship, id))
+		} else {
+			sb.WriteString(fmt.Sprintf("(%s, %s, %s)", id, relationship, spanId))
+		}
+		sb.WriteString(fmt.Sprintf(" operation: %s, duration: %s", operationName, time.Duration(duration)))
+		if status != "" {
+			sb.WriteString(fmt.Sprintf(", status: %s", status))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+var luceneSpecialCharacters = strings.NewReplacer(
+	`\`, `\\`, `+`, `\+`, `-`, `\-`, `&`, `\&`, `|`, `\|`, `!`, `\!`, `(`, `\(`, `)`, `\)`,
+	`{`, `\{`, `}`, `\}`, `[`, `\[`, `]`, `\]`, `^`, `\^`, `"`, `\"`, `~`, `\~`, `*`, `\*`,
+	`?`, `\?`, `:`, `\:`, `/`, `\/`,
+)
+
+func (t *Toolbox) searchSpans(ctx context.Context, text string, limit int) (string, error) {
+	query := `
+		CALL db.index.fulltext.queryNodes('span_summary_fulltext', $text) YIELD node, score
+		MATCH (:Trace {trace_id: $trace_id})-[:CONTAINS]->(node)
+		RETURN node.span_id as span_id, node.operation_name as operation_name, node.summary as summary, score
+		ORDER BY score DESC
+		LIMIT $limit
+	`
+	res, err := neo4j.ExecuteQuery(ctx, t.driver, query, map[string]any{
+		"trace_id": t.traceId,
+		"text":     luceneSpecialCharacters.Replace(text),
+		"limit":    limit,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[agent][searchSpans][error] cannot search spans", err)
+		return "", err
+	}
+	if len(res.Records) == 0 {
+		return "no span matches", nil
+	}
+
+	var sb strings.Builder
+	for _, record := range res.Records {
+		id, _, _ := neo4j.GetRecordValue[string](record, "span_id")
+		operationName, _, _ := neo4j.GetRecordValue[string](record, "operation_name")
+		summary, _, _ := neo4j.GetRecordValue[string](record, "summary")
+		sb.WriteString(fmt.Sprintf("Span ID: %s\nOperation: %s\nSummary: %s\n\n", id, operationName, strings.TrimSpace(summary)))
+	}
+	return sb.String(), nil
+}
+
+func (t *Toolbox) loadTrace(ctx context.Context) (*model.Trace, error) {
+	t.traceOnce.Do(func() {
+		traceId, err := model.TraceIDFromString(t.traceId)
+		if err != nil {
+			t.traceErr = err
+			return
+		}
+		t.trace, t.traceErr = t.traceReader.GetTrace(ctx, traceId)
+		if t.traceErr == nil && len(t.trace.Spans) == 0 {
+			t.traceErr = fmt.Errorf("trace %s has no spans", t.traceId)
+		}
+	})
+	return t.trace, t.traceErr
+}
+
+func (t *Toolbox) findSpan(ctx context.Context, spanId string) (*model.Span, error) {
+	trace, err := t.loadTrace(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, span := range trace.Spans {
+		if span.SpanID.String() == spanId {
+			return span, nil
+		}
+	}
+	return nil, nil
+}
+
+func (t *Toolbox) getLogs(ctx context.Context, spanId string) (string, error) {
+	span, err := t.findSpan(ctx, spanId)
+	if err != nil {
+		return "", err
+	}
+	if span == nil {
+		return fmt.Sprintf("span %s is not part of the trace", spanId), nil
+	}
+	if len(span.Logs) == 0 {
+		return fmt.Sprintf("span %s has no logs", spanId), nil
+	}
+
+	logs := slices.Clone(span.Logs)
+	slices.SortStableFunc(logs, func(a, b model.Log) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	var sb strings.Builder
+	for _, l := range logs {
+		sb.WriteString(fmt.Sprintf("+%s\n", l.Timestamp.Sub(span.StartTime)))
+		for _, f := range l.Fields {
+			sb.WriteString(fmt.Sprintf("  %s: %s\n", f.Key, f.AsString()))
+		}
+	}
+	return sb.String(), nil
+}
+
+func (t *Toolbox) traceTimeline(ctx context.Context) (string, error) {
+	trace, err := t.loadTrace(ctx)
+	if err != nil {
+		return "", err
+	}
+	tree := analysis.NewCallTree(trace)
+	if len(tree.Roots) == 0 {
+		return "", errRootless
+	}
+	traceStart := tree.Roots[0].StartTime
+	for _, span := range trace.Spans {
+		if span.StartTime.Before(traceStart) {
+			traceStart = span.StartTime
+		}
+	}
+
+	var sb strings.Builder
+	for _, root := range tree.Roots {
+		tree.Walk(root, 0, func(span *model.Span, depth int) {
+			sb.WriteString(fmt.Sprintf("%s+%s %s %s %s (%s)", strings.Repeat("  ", depth), span.StartTime.Sub(traceStart),
+				span.SpanID, span.Process.ServiceName, span.OperationName, span.Duration))
+			if tag, ok := model.KeyValues(span.Tags).FindByKey("error"); ok && tag.AsString() == "true" {
+				sb.WriteString(" error")
+			}
+			sb.WriteString("\n")
+		})
+	}
+	return sb.String(), nil
+}
+
+func (t *Toolbox) latencyBreakdown(ctx context.Context, spanId string) (string, error) {
+	trace, err := t.loadTrace(ctx)
+	if err != nil {
+		return "", err
+	}
+	tree := analysis.NewCallTree(trace)
+
+	var root *model.Span
+	if spanId != "" {
+		root = tree.Find(spanId)
+		if root == nil {
+			return fmt.Sprintf("span %s is not part of the trace", spanId), nil
+		}
+	} else {
+		if len(tree.Roots) == 0 {
+			return "", errRootless
+		}
+		root = tree.Roots[0]
+	}
+	if root.Duration <= 0 {
+		return fmt.Sprintf("span %s has no duration", root.SpanID), nil
+	}
+
+	type entry struct {
+		name     string
+		selfTime time.Duration
+	}
+	bySpan := make([]entry, 0)
+	byService := make(map[string]time.Duration)
+	tree.Walk(root, 0, func(span *model.Span, depth int) {
+		self := analysis.SelfTime(span, tree.Children[span.SpanID])
+		bySpan = append(bySpan, entry{name: fmt.Sprintf("%s %s %s", span.SpanID, span.Process.ServiceName, span.OperationName), selfTime: self})
+		byService[span.Process.ServiceName] += self
+	})
+	services := make([]entry, 0, len(byService))
+	for name, d := range byService {
+		services = append(services, entry{name: name, selfTime: d})
+	}
+	bySelfTime := func(a, b entry) int {
+		if c := cmp.Compare(b.selfTime, a.selfTime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.name, b.name)
+	}
+	slices.SortFunc(bySpan, bySelfTime)
+	slices.SortFunc(services, bySelfTime)
+
+	percent := func(d time.Duration) float64 {
+		return float64(d) / float64(root.Duration) * 100
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("span %s %s took %s\n\nself time by service:\n", root.SpanID, root.OperationName, root.Duration))
+	for _, s := range services {
+		sb.WriteString(fmt.Sprintf("%s: %s (%.1f%%)\n", s.name, s.selfTime, percent(s.selfTime)))
+	}
+	sb.WriteString("\nspans with the most self time:\n")
+	for _, s := range bySpan[:min(len(bySpan), 10)] {
+		sb.WriteString(fmt.Sprintf("%s: %s (%.1f%%)\n", s.name, s.selfTime, percent(s.selfTime)))
+	}
+	return sb.String(), nil
+}
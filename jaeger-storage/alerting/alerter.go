@@ -0,0 +1,295 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"jaeger-storage/common"
+	"jaeger-storage/incidents"
+	"jaeger-storage/storage"
+	"jaeger-storage/usage"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// number of alerts waiting for their explanation and delivery, further alerts are dropped
+const queueSize = 100
+
+// number of distinct trace IDs given with an error rate alert
+const errorRateTraceIds = 5
+
+// Alert is the JSON payload posted to the webhooks
+type Alert struct {
+	Rule          string   `json:"rule"`
+	Kind          RuleKind `json:"kind"`
+	ServiceName   string   `json:"service_name"`
+	OperationName string   `json:"operation_name,omitempty"`
+	// what fired the rule, the explanation is written by the LLM from it
+	Summary     string         `json:"summary"`
+	Explanation string         `json:"explanation"`
+	TraceIds    []string       `json:"trace_ids"`
+	Links       []string       `json:"links"`
+	Details     map[string]any `json:"details"`
+	FiredAt     time.Time      `json:"fired_at"`
+
+	webhooks []string
+}
+
+// Explainer writes the explanation of an alert
+type Explainer interface {
+	ExplainAlert(ctx context.Context, alert string) (string, error)
+}
+
+type rateEvent struct {
+	at      time.Time
+	isError bool
+	traceId string
+}
+
+// Alerter evaluates the rules on the ingested spans and on the new error clusters, and posts the alerts to the webhooks
+// of their rule. An alert is not sent again for the same rule and subject within the dedup window, nor while silenced.
+type Alerter struct {
+	config       Config
+	explainer    Explainer
+	usageTracker *usage.Tracker
+	client       *http.Client
+	queue        chan Alert
+	// rule and subject to the time the last alert was fired
+	lastFired map[string]time.Time
+	// rule and service to the spans of the error rate window
+	rates map[string][]rateEvent
+	mutex sync.Mutex
+	// replaced in the tests
+	now          func() time.Time
+	retryBackoff time.Duration
+}
+
+func NewAlerter(config Config, explainer Explainer, usageTracker *usage.Tracker) *Alerter {
+	return &Alerter{
+		config:       config,
+		explainer:    explainer,
+		usageTracker: usageTracker,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		queue:        make(chan Alert, queueSize),
+		lastFired:    make(map[string]time.Time),
+		rates:        make(map[string][]rateEvent),
+		now:          time.Now,
+		retryBackoff: time.Second,
+	}
+}
+
+// Run explains and delivers the fired alerts until the context is done
+func (a *Alerter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alert := <-a.queue:
+			a.explain(ctx, &alert)
+			a.deliver(ctx, alert)
+		}
+	}
+}
+
+// ObserveSpan evaluates the error rate and latency anomaly rules on a stored span
+func (a *Alerter) ObserveSpan(span *model.Span, anomaly *storage.LatencyAnomaly) {
+	serviceName := span.Process.GetServiceName()
+	for _, rule := range a.config.Rules {
+		if !rule.appliesTo(serviceName) {
+			continue
+		}
+		switch rule.Kind {
+		case RuleKindErrorRate:
+			a.observeErrorRate(rule, span)
+		case RuleKindLatencyAnomaly:
+			if anomaly == nil || anomaly.Ratio < rule.MinRatio {
+				continue
+			}
+			a.fire(rule, serviceName+"/"+span.OperationName, Alert{
+				ServiceName:   serviceName,
+				OperationName: span.OperationName,
+				Summary: fmt.Sprintf("The span %s of %s %s took %s, %.1fx slower than usual. Usually p50 %s, p95 %s, p99 %s over the last %d calls.",
+					span.SpanID, serviceName, span.OperationName, anomaly.Duration, anomaly.Ratio, anomaly.Baseline.P50, anomaly.Baseline.P95, anomaly.Baseline.P99, anomaly.Baseline.Samples),
+				TraceIds: []string{span.TraceID.String()},
+				Details: map[string]any{
+					"span_id":  span.SpanID.String(),
+					"duration": anomaly.Duration,
+					"ratio":    anomaly.Ratio,
+					"baseline": anomaly.Baseline,
+				},
+			})
+		}
+	}
+}
+
+func (a *Alerter) observeErrorRate(rule Rule, span *model.Span) {
+	serviceName := span.Process.GetServiceName()
+	key := rule.Name + "/" + serviceName
+	now := a.now()
+
+	a.mutex.Lock()
+	events := append(a.rates[key], rateEvent{
+		at:      now,
+		isError: common.ClassifySpanErrors(span).Status == common.SpanStatusError,
+		traceId: span.TraceID.String(),
+	})
+	start := 0
+	for start < len(events) && events[start].at.Before(now.Add(-rule.Window)) {
+		start++
+	}
+	events = events[start:]
+	a.rates[key] = events
+
+	errors := 0
+	traceIds := make([]string, 0, errorRateTraceIds)
+	for i := len(events) - 1; i >= 0; i-- {
+		if !events[i].isError {
+			continue
+		}
+		errors++
+		if len(traceIds) < errorRateTraceIds && !slices.Contains(traceIds, events[i].traceId) {
+			traceIds = append(traceIds, events[i].traceId)
+		}
+	}
+	a.mutex.Unlock()
+
+	rate := float64(errors) / float64(len(events))
+	if len(events) < rule.MinSpans || rate < rule.Threshold {
+		return
+	}
+	a.fire(rule, serviceName, Alert{
+		ServiceName: serviceName,
+		Summary: fmt.Sprintf("%.1f%% of the spans of %s failed in the last %s (%d of %d), above the threshold of %.1f%%.",
+			rate*100, serviceName, rule.Window, errors, len(events), rule.Threshold*100),
+		TraceIds: traceIds,
+		Details: map[string]any{
+			"error_rate": rate,
+			"errors":     errors,
+			"spans":      len(events),
+			"threshold":  rule.Threshold,
+			"window":     rule.Window.String(),
+		},
+	})
+}
+
+// NewErrorCluster evaluates the new error cluster rules, it is called by the incident clustering job
+func (a *Alerter) NewErrorCluster(cluster incidents.ErrorCluster) {
+	for _, rule := range a.config.Rules {
+		if rule.Kind != RuleKindNewErrorCluster || !rule.appliesTo(cluster.ServiceName) {
+			continue
+		}
+		a.fire(rule, cluster.ClusterId, Alert{
+			ServiceName: cluster.ServiceName,
+			Summary: fmt.Sprintf("A new %s error appeared in %s: %s. Example: %s. It happened %d times since %s.",
+				cluster.ErrorType, cluster.ServiceName, cluster.Template, cluster.ExampleMessage, cluster.Count, cluster.FirstSeen.Format(time.RFC3339)),
+			TraceIds: []string{cluster.ExampleTraceId},
+			Details: map[string]any{
+				"cluster_id": cluster.ClusterId,
+				"error_type": cluster.ErrorType,
+				"template":   cluster.Template,
+				"span_id":    cluster.ExampleSpanId,
+				"count":      cluster.Count,
+			},
+		})
+	}
+}
+
+// fire queues the alert unless an alert of the rule about the same subject was fired within the dedup window
+// or the rule is silenced for the service
+func (a *Alerter) fire(rule Rule, subject string, alert Alert) {
+	now := a.now()
+	for _, s := range a.config.Silences {
+		if s.mutes(rule.Name, alert.ServiceName, now) {
+			return
+		}
+	}
+
+	key := rule.Name + "/" + subject
+	a.mutex.Lock()
+	if last, ok := a.lastFired[key]; ok && now.Sub(last) < rule.DedupWindow {
+		a.mutex.Unlock()
+		return
+	}
+	a.lastFired[key] = now
+	a.mutex.Unlock()
+
+	alert.Rule = rule.Name
+	alert.Kind = rule.Kind
+	alert.FiredAt = now
+	alert.webhooks = rule.Webhooks
+	alert.Links = make([]string, 0, len(alert.TraceIds))
+	for _, traceId := range alert.TraceIds {
+		alert.Links = append(alert.Links, a.config.traceLink(traceId))
+	}
+
+	select {
+	case a.queue <- alert:
+		log.Printf("[alerting][fire] rule %s fired for %s\n", rule.Name, subject)
+	default:
+		log.Printf("[alerting][fire][error] the alert queue is full, dropped the alert of rule %s for %s\n", rule.Name, subject)
+	}
+}
+
+// explain adds the LLM explanation, alerts are still sent without it when the budget is exceeded or the call fails
+func (a *Alerter) explain(ctx context.Context, alert *Alert) {
+	if a.usageTracker.BudgetExceeded() {
+		return
+	}
+	ctx = usage.WithAttribution(ctx, usage.Attribution{
+		Caller:        usage.CallerAlerting,
+		ServiceName:   alert.ServiceName,
+		OperationName: alert.OperationName,
+	})
+	explanation, err := a.explainer.ExplainAlert(ctx, alert.Summary)
+	if err != nil {
+		log.Println("[alerting][explain][error] cannot explain the alert of rule", alert.Rule, err)
+		return
+	}
+	alert.Explanation = explanation
+}
+
+func (a *Alerter) deliver(ctx context.Context, alert Alert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		log.Println("[alerting][deliver][error] cannot encode the alert", err)
+		return
+	}
+	for _, webhook := range alert.webhooks {
+		// retried with a backoff of 1s then 2s
+		for attempt := 0; attempt < 3; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Duration(attempt) * a.retryBackoff):
+				}
+			}
+			if err = a.post(ctx, webhook, body); err == nil {
+				break
+			}
+			log.Println("[alerting][deliver][error] cannot post the alert to", webhook, err)
+		}
+	}
+}
+
+func (a *Alerter) post(ctx context.Context, webhook string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("the webhook returned status %d", res.StatusCode)
+	}
+	return nil
+}
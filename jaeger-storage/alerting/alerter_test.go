@@ -0,0 +1,300 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"jaeger-storage/incidents"
+	"jaeger-storage/storage"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeExplainer struct{}
+
+func (fakeExplainer) ExplainAlert(ctx context.Context, alert string) (string, error) {
+	return "explained: " + alert, nil
+}
+
+// webhook records the alerts it receives, it answers with the given statuses first and 200 afterward.
+// The alerts about the sentinel service are always accepted and not counted.
+type webhook struct {
+	server   *httptest.Server
+	alerts   chan Alert
+	mutex    sync.Mutex
+	statuses []int
+	attempts int
+}
+
+func newWebhook(t *testing.T, statuses ...int) *webhook {
+	w := &webhook{alerts: make(chan Alert, queueSize), statuses: statuses}
+	w.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("cannot decode the alert: %v", err)
+		}
+		if alert.ServiceName == "sentinel" {
+			w.alerts <- alert
+			return
+		}
+
+		w.mutex.Lock()
+		w.attempts++
+		status := http.StatusOK
+		if len(w.statuses) > 0 {
+			status, w.statuses = w.statuses[0], w.statuses[1:]
+		}
+		w.mutex.Unlock()
+		if status != http.StatusOK {
+			rw.WriteHeader(status)
+			return
+		}
+		w.alerts <- alert
+	}))
+	t.Cleanup(w.server.Close)
+	return w
+}
+
+// testAlerter has a sentinel rule so that the tests can wait for the alerts fired before it to be delivered
+type testAlerter struct {
+	*Alerter
+	t         *testing.T
+	webhook   *webhook
+	clock     time.Time
+	sentinels int
+}
+
+func newTestAlerter(t *testing.T, config Config, statuses ...int) *testAlerter {
+	w := newWebhook(t, statuses...)
+	config.Webhooks = []string{w.server.URL}
+	config.Rules = append(config.Rules, Rule{Name: "sentinel", Kind: RuleKindNewErrorCluster, Services: []string{"sentinel"}})
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	a := &testAlerter{
+		Alerter: NewAlerter(config, fakeExplainer{}, nil),
+		t:       t,
+		webhook: w,
+		clock:   time.Date(2024, 12, 19, 10, 0, 0, 0, time.UTC),
+	}
+	a.now = func() time.Time { return a.clock }
+	a.retryBackoff = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go a.Run(ctx)
+	return a
+}
+
+func (a *testAlerter) advance(d time.Duration) {
+	a.clock = a.clock.Add(d)
+}
+
+// delivered returns the alerts delivered since the last call
+func (a *testAlerter) delivered() []Alert {
+	a.t.Helper()
+	a.sentinels++
+	a.NewErrorCluster(incidents.ErrorCluster{ClusterId: fmt.Sprint("sentinel-", a.sentinels), ServiceName: "sentinel"})
+
+	alerts := make([]Alert, 0)
+	for {
+		select {
+		case alert := <-a.webhook.alerts:
+			if alert.ServiceName != "sentinel" {
+				alerts = append(alerts, alert)
+			} else if alert.Rule == "sentinel" {
+				return alerts
+			}
+		case <-time.After(5 * time.Second):
+			a.t.Fatal("timed out waiting for the alerts")
+		}
+	}
+}
+
+func errorCluster(clusterId string, serviceName string) incidents.ErrorCluster {
+	return incidents.ErrorCluster{
+		ClusterId:      clusterId,
+		ServiceName:    serviceName,
+		ErrorType:      "timeout",
+		Template:       "redis timeout after <num>",
+		ExampleMessage: "redis timeout after 250ms",
+		ExampleTraceId: "e72ef241661424eb6970b65f6fd74b30",
+		Count:          3,
+	}
+}
+
+func testSpan(serviceName string, isError bool) *model.Span {
+	span := &model.Span{
+		TraceID:       model.NewTraceID(0, 0xe72ef241),
+		SpanID:        model.NewSpanID(1),
+		OperationName: "GetDriver",
+		Process:       model.NewProcess(serviceName, nil),
+	}
+	if isError {
+		span.Tags = []model.KeyValue{model.Bool("error", true)}
+	}
+	return span
+}
+
+func TestDelivery(t *testing.T) {
+	a := newTestAlerter(t, Config{
+		TraceUrl: "http://jaeger/trace/{trace_id}",
+		Rules:    []Rule{{Name: "new-errors", Kind: RuleKindNewErrorCluster}},
+	})
+	a.NewErrorCluster(errorCluster("c1", "redis-manual"))
+
+	alerts := a.delivered()
+	if len(alerts) != 1 {
+		t.Fatalf("delivered %d alerts, want 1", len(alerts))
+	}
+	alert := alerts[0]
+	if alert.Rule != "new-errors" || alert.Kind != RuleKindNewErrorCluster || alert.ServiceName != "redis-manual" {
+		t.Errorf("alert = %+v", alert)
+	}
+	if alert.Explanation != "explained: "+alert.Summary {
+		t.Errorf("explanation = %q, want the explanation of the summary", alert.Explanation)
+	}
+	if len(alert.Links) != 1 || alert.Links[0] != "http://jaeger/trace/e72ef241661424eb6970b65f6fd74b30" {
+		t.Errorf("links = %v", alert.Links)
+	}
+	if !alert.FiredAt.Equal(a.clock) || alert.Details["cluster_id"] != "c1" {
+		t.Errorf("fired at %s with details %v", alert.FiredAt, alert.Details)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	a := newTestAlerter(t, Config{
+		DedupWindow: 10 * time.Minute,
+		Rules: []Rule{
+			{Name: "new-errors", Kind: RuleKindNewErrorCluster},
+			{Name: "new-redis-errors", Kind: RuleKindNewErrorCluster, Services: []string{"redis-manual"}, DedupWindow: time.Hour},
+		},
+	})
+
+	a.NewErrorCluster(errorCluster("c1", "redis-manual"))
+	a.NewErrorCluster(errorCluster("c1", "redis-manual"))
+	a.NewErrorCluster(errorCluster("c2", "redis-manual"))
+	if alerts := a.delivered(); len(alerts) != 4 {
+		t.Fatalf("delivered %d alerts, want one per rule and cluster", len(alerts))
+	}
+
+	a.advance(9 * time.Minute)
+	a.NewErrorCluster(errorCluster("c1", "redis-manual"))
+	if alerts := a.delivered(); len(alerts) != 0 {
+		t.Fatalf("delivered %v within the dedup window", alerts)
+	}
+
+	a.advance(time.Minute)
+	a.NewErrorCluster(errorCluster("c1", "redis-manual"))
+	alerts := a.delivered()
+	if len(alerts) != 1 || alerts[0].Rule != "new-errors" {
+		t.Fatalf("delivered %v, want the rule with the dedup window of the config only", alerts)
+	}
+}
+
+func TestSilences(t *testing.T) {
+	start := time.Date(2024, 12, 19, 10, 0, 0, 0, time.UTC)
+	a := newTestAlerter(t, Config{
+		Rules: []Rule{
+			{Name: "new-errors", Kind: RuleKindNewErrorCluster},
+			{Name: "latency", Kind: RuleKindLatencyAnomaly},
+		},
+		Silences: []Silence{
+			{Rules: []string{"new-errors"}, Services: []string{"redis-manual"}, StartsAt: start, EndsAt: start.Add(time.Hour)},
+		},
+	})
+	anomaly := &storage.LatencyAnomaly{Duration: time.Second, Ratio: 10}
+
+	a.NewErrorCluster(errorCluster("c1", "redis-manual"))
+	a.NewErrorCluster(errorCluster("c2", "driver"))
+	a.ObserveSpan(testSpan("redis-manual", false), anomaly)
+	alerts := a.delivered()
+	if len(alerts) != 2 {
+		t.Fatalf("delivered %d alerts, want the other service and the other rule", len(alerts))
+	}
+	for _, alert := range alerts {
+		if alert.Rule == "new-errors" && alert.ServiceName == "redis-manual" {
+			t.Errorf("delivered the silenced alert %+v", alert)
+		}
+	}
+
+	a.advance(time.Hour)
+	a.NewErrorCluster(errorCluster("c1", "redis-manual"))
+	if alerts := a.delivered(); len(alerts) != 1 || alerts[0].ServiceName != "redis-manual" {
+		t.Fatalf("delivered %v after the silence ended, want the alert", alerts)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantAlerts   int
+	}{
+		{"first attempt", nil, 1, 1},
+		{"after two failures", []int{http.StatusInternalServerError, http.StatusBadGateway}, 3, 1},
+		{"gives up after three attempts", []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAlerter(t, Config{Rules: []Rule{{Name: "new-errors", Kind: RuleKindNewErrorCluster}}}, tt.statuses...)
+			a.NewErrorCluster(errorCluster("c1", "redis-manual"))
+
+			alerts := a.delivered()
+			a.webhook.mutex.Lock()
+			attempts := a.webhook.attempts
+			a.webhook.mutex.Unlock()
+			if attempts != tt.wantAttempts || len(alerts) != tt.wantAlerts {
+				t.Errorf("%d attempts delivered %d alerts, want %d attempts and %d alerts", attempts, len(alerts), tt.wantAttempts, tt.wantAlerts)
+			}
+		})
+	}
+}
+
+func TestErrorRateWindow(t *testing.T) {
+	a := newTestAlerter(t, Config{
+		Rules: []Rule{{Name: "errors", Kind: RuleKindErrorRate, Threshold: 0.5, Window: time.Minute, MinSpans: 4}},
+	})
+	observe := func(serviceName string, isError ...bool) {
+		for _, e := range isError {
+			a.ObserveSpan(testSpan(serviceName, e), nil)
+		}
+	}
+
+	// too few spans to trust the rate
+	observe("redis-manual", true, true, true)
+	if alerts := a.delivered(); len(alerts) != 0 {
+		t.Fatalf("delivered %v below the minimum number of spans", alerts)
+	}
+
+	// the errors dropped out of the window
+	a.advance(61 * time.Second)
+	observe("redis-manual", false, false, false, true)
+	if alerts := a.delivered(); len(alerts) != 0 {
+		t.Fatalf("delivered %v for a rate of 25%% in the window", alerts)
+	}
+
+	// each service has its own window
+	observe("driver", true, true, true, false)
+	alerts := a.delivered()
+	if len(alerts) != 1 || alerts[0].ServiceName != "driver" {
+		t.Fatalf("delivered %v, want the alert of driver", alerts)
+	}
+	if alerts[0].Details["errors"] != float64(3) || alerts[0].Details["spans"] != float64(4) {
+		t.Errorf("details = %v, want 3 errors in 4 spans", alerts[0].Details)
+	}
+	if len(alerts[0].TraceIds) != 1 {
+		t.Errorf("trace IDs = %v, want the trace of the errors once", alerts[0].TraceIds)
+	}
+
+	observe("redis-manual", true, true)
+	alerts = a.delivered()
+	if len(alerts) != 1 || alerts[0].ServiceName != "redis-manual" || alerts[0].Details["error_rate"] != 0.5 {
+		t.Fatalf("delivered %v, want the alert of redis-manual at 50%%", alerts)
+	}
+}
@@ -0,0 +1,152 @@
+package alerting
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type RuleKind string
+
+const (
+	// RuleKindNewErrorCluster fires when errors with a message never seen before appear in a service
+	RuleKindNewErrorCluster RuleKind = "new_error_cluster"
+	// RuleKindErrorRate fires when the share of error spans of a service goes above the threshold
+	RuleKindErrorRate RuleKind = "error_rate"
+	// RuleKindLatencyAnomaly fires when a span is much slower than the baseline of its operation
+	RuleKindLatencyAnomaly RuleKind = "latency_anomaly"
+)
+
+type Rule struct {
+	Name string   `yaml:"name"`
+	Kind RuleKind `yaml:"kind"`
+	// services the rule applies to, all when empty
+	Services []string `yaml:"services"`
+	// error_rate: share of error spans, e.g. 0.1 for 10%
+	Threshold float64 `yaml:"threshold"`
+	// error_rate: period the rate is computed over
+	Window time.Duration `yaml:"window"`
+	// error_rate: number of spans in the window before the rate is trusted
+	MinSpans int `yaml:"min_spans"`
+	// latency_anomaly: minimum duration divided by the usual median
+	MinRatio float64 `yaml:"min_ratio"`
+	// overrides the webhooks of the config
+	Webhooks []string `yaml:"webhooks"`
+	// overrides the dedup window of the config
+	DedupWindow time.Duration `yaml:"dedup_window"`
+}
+
+// Silence mutes the matching rules and services for a period, e.g. during a planned maintenance
+type Silence struct {
+	// all rules when empty
+	Rules []string `yaml:"rules"`
+	// all services when empty
+	Services []string  `yaml:"services"`
+	StartsAt time.Time `yaml:"starts_at"`
+	EndsAt   time.Time `yaml:"ends_at"`
+	Comment  string    `yaml:"comment"`
+}
+
+type Config struct {
+	Webhooks []string `yaml:"webhooks"`
+	// link to a trace, {trace_id} is replaced by the trace ID
+	TraceUrl string `yaml:"trace_url"`
+	// an alert of a rule about the same subject, e.g. the same service, is only sent once within the window
+	DedupWindow time.Duration `yaml:"dedup_window"`
+	Rules       []Rule        `yaml:"rules"`
+	Silences    []Silence     `yaml:"silences"`
+}
+
+// LoadConfig reads the alerting rules from the YAML file in ALERTING_CONFIG_FILE, alerting is disabled when it is not set.
+func LoadConfig() (Config, error) {
+	config := Config{}
+	path := os.Getenv("ALERTING_CONFIG_FILE")
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Println("[alerting][LoadConfig][error] cannot read alerting config file", path, err)
+		return config, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		log.Println("[alerting][LoadConfig][error] cannot parse alerting config file", path, err)
+		return config, err
+	}
+	if err := config.validate(); err != nil {
+		log.Println("[alerting][LoadConfig][error] invalid alerting config file", path, err)
+		return config, err
+	}
+	log.Printf("[alerting][LoadConfig] loaded %d alerting rules and %d silences from %s\n", len(config.Rules), len(config.Silences), path)
+	return config, nil
+}
+
+func (c *Config) validate() error {
+	if c.DedupWindow <= 0 {
+		c.DedupWindow = 30 * time.Minute
+	}
+	if c.TraceUrl == "" {
+		c.TraceUrl = "http://localhost:16686/trace/{trace_id}"
+	}
+	names := make([]string, 0, len(c.Rules))
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.Name == "" || slices.Contains(names, rule.Name) {
+			return fmt.Errorf("rule %d: the name must be set and unique", i)
+		}
+		names = append(names, rule.Name)
+		switch rule.Kind {
+		case RuleKindNewErrorCluster:
+		case RuleKindErrorRate:
+			if rule.Threshold <= 0 || rule.Threshold > 1 {
+				return fmt.Errorf("rule %q: the threshold must be between 0 and 1", rule.Name)
+			}
+			if rule.Window <= 0 {
+				rule.Window = 5 * time.Minute
+			}
+			if rule.MinSpans <= 0 {
+				rule.MinSpans = 20
+			}
+		case RuleKindLatencyAnomaly:
+			if rule.MinRatio <= 0 {
+				rule.MinRatio = 1
+			}
+		default:
+			return fmt.Errorf("rule %q: unknown kind %q", rule.Name, rule.Kind)
+		}
+		if len(rule.Webhooks) == 0 {
+			rule.Webhooks = c.Webhooks
+		}
+		if len(rule.Webhooks) == 0 {
+			return fmt.Errorf("rule %q: no webhook to send its alerts to", rule.Name)
+		}
+		if rule.DedupWindow <= 0 {
+			rule.DedupWindow = c.DedupWindow
+		}
+	}
+	for i, s := range c.Silences {
+		if !s.EndsAt.After(s.StartsAt) {
+			return fmt.Errorf("silence %d: ends_at must be after starts_at", i)
+		}
+	}
+	return nil
+}
+
+func (r Rule) appliesTo(serviceName string) bool {
+	return len(r.Services) == 0 || slices.Contains(r.Services, serviceName)
+}
+
+func (s Silence) mutes(rule string, serviceName string, at time.Time) bool {
+	return (len(s.Rules) == 0 || slices.Contains(s.Rules, rule)) &&
+		(len(s.Services) == 0 || slices.Contains(s.Services, serviceName)) &&
+		!at.Before(s.StartsAt) && at.Before(s.EndsAt)
+}
+
+func (c Config) traceLink(traceId string) string {
+	return strings.ReplaceAll(c.TraceUrl, "{trace_id}", traceId)
+}
@@ -0,0 +1,114 @@
+package analysis
+
+import (
+	"github.com/jaegertracing/jaeger/model"
+	"slices"
+	"time"
+)
+
+// CallTree indexes the spans of a trace by their parent, children are sorted by start time.
+// Spans whose parent is missing from the trace are roots.
+type CallTree struct {
+	Spans    map[model.SpanID]*model.Span
+	Children map[model.SpanID][]*model.Span
+	Roots    []*model.Span
+}
+
+func NewCallTree(trace *model.Trace) *CallTree {
+	tree := &CallTree{
+		Spans:    make(map[model.SpanID]*model.Span, len(trace.Spans)),
+		Children: make(map[model.SpanID][]*model.Span),
+	}
+	for _, span := range trace.Spans {
+		tree.Spans[span.SpanID] = span
+	}
+	for _, span := range trace.Spans {
+		parentId := span.ParentSpanID()
+		if _, ok := tree.Spans[parentId]; ok && parentId != span.SpanID {
+			tree.Children[parentId] = append(tree.Children[parentId], span)
+		} else {
+			tree.Roots = append(tree.Roots, span)
+		}
+	}
+	byStart := func(a, b *model.Span) int {
+		return a.StartTime.Compare(b.StartTime)
+	}
+	slices.SortStableFunc(tree.Roots, byStart)
+	for _, c := range tree.Children {
+		slices.SortStableFunc(c, byStart)
+	}
+	return tree
+}
+
+// Find returns the span with the given hex ID, or nil.
+func (tree *CallTree) Find(spanId string) *model.Span {
+	id, err := model.SpanIDFromString(spanId)
+	if err != nil {
+		return nil
+	}
+	return tree.Spans[id]
+}
+
+// Walk visits the span and its descendants depth first, children in start time order. Every span is visited once,
+// a span whose references form a cycle stops the walk where the cycle closes.
+func (tree *CallTree) Walk(span *model.Span, depth int, visit func(span *model.Span, depth int)) {
+	tree.walk(span, depth, visit, make(map[model.SpanID]bool))
+}
+
+func (tree *CallTree) walk(span *model.Span, depth int, visit func(span *model.Span, depth int), visited map[model.SpanID]bool) {
+	if visited[span.SpanID] {
+		return
+	}
+	visited[span.SpanID] = true
+	visit(span, depth)
+	for _, c := range tree.Children[span.SpanID] {
+		tree.walk(c, depth+1, visit, visited)
+	}
+}
+
+type interval struct {
+	start time.Time
+	end   time.Time
+}
+
+// childIntervals are the children of the span clipped to it, merged where they overlap and sorted by start time
+func childIntervals(span *model.Span, children []*model.Span) []interval {
+	spanEnd := span.StartTime.Add(span.Duration)
+	intervals := make([]interval, 0, len(children))
+	for _, c := range children {
+		start, end := c.StartTime, c.StartTime.Add(c.Duration)
+		if start.Before(span.StartTime) {
+			start = span.StartTime
+		}
+		if end.After(spanEnd) {
+			end = spanEnd
+		}
+		if end.After(start) {
+			intervals = append(intervals, interval{start, end})
+		}
+	}
+	slices.SortFunc(intervals, func(a, b interval) int {
+		return a.start.Compare(b.start)
+	})
+
+	merged := make([]interval, 0, len(intervals))
+	for _, i := range intervals {
+		if n := len(merged); n > 0 && !i.start.After(merged[n-1].end) {
+			if i.end.After(merged[n-1].end) {
+				merged[n-1].end = i.end
+			}
+			continue
+		}
+		merged = append(merged, i)
+	}
+	return merged
+}
+
+// SelfTime is the part of the span not covered by any of its children.
+func SelfTime(span *model.Span, children []*model.Span) time.Duration {
+	covered := time.Duration(0)
+	for _, i := range childIntervals(span, children) {
+		covered += i.end.Sub(i.start)
+	}
+	return max(span.Duration-covered, 0)
+}
@@ -0,0 +1,64 @@
+package analysis
+
+import (
+	"github.com/jaegertracing/jaeger/model"
+	"slices"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2024, 12, 19, 10, 0, 0, 0, time.UTC)
+
+// testSpan starts offset milliseconds after testStart and lasts duration milliseconds, parent 0 is no parent
+func testSpan(id uint64, parent uint64, service string, operation string, offset int, duration int) *model.Span {
+	traceId := model.NewTraceID(0, 0xe72ef241)
+	span := &model.Span{
+		TraceID:       traceId,
+		SpanID:        model.NewSpanID(id),
+		OperationName: operation,
+		StartTime:     testStart.Add(time.Duration(offset) * time.Millisecond),
+		Duration:      time.Duration(duration) * time.Millisecond,
+		Process:       model.NewProcess(service, nil),
+	}
+	if parent != 0 {
+		span.References = []model.SpanRef{model.NewChildOfRef(traceId, model.NewSpanID(parent))}
+	}
+	return span
+}
+
+func walked(tree *CallTree, span *model.Span) []uint64 {
+	ids := make([]uint64, 0)
+	tree.Walk(span, 0, func(span *model.Span, depth int) {
+		ids = append(ids, uint64(span.SpanID))
+	})
+	return ids
+}
+
+func TestWalk(t *testing.T) {
+	tree := NewCallTree(&model.Trace{Spans: []*model.Span{
+		testSpan(3, 1, "driver", "FindNearest", 20, 10),
+		testSpan(1, 0, "frontend", "/dispatch", 0, 100),
+		testSpan(4, 3, "redis-manual", "GetDriver", 22, 5),
+		testSpan(2, 1, "customer", "/customer", 5, 10),
+	}})
+	if len(tree.Roots) != 1 || tree.Roots[0].SpanID != 1 {
+		t.Fatalf("roots = %v, want /dispatch", tree.Roots)
+	}
+	if got := walked(tree, tree.Roots[0]); !slices.Equal(got, []uint64{1, 2, 3, 4}) {
+		t.Errorf("walked %v, want depth first in start time order", got)
+	}
+}
+
+func TestWalkCycle(t *testing.T) {
+	// the spans are each other's parent, none of them is a root
+	tree := NewCallTree(&model.Trace{Spans: []*model.Span{
+		testSpan(1, 2, "frontend", "/dispatch", 0, 100),
+		testSpan(2, 1, "redis-manual", "GetDriver", 10, 30),
+	}})
+	if len(tree.Roots) != 0 {
+		t.Fatalf("roots = %v, want none", tree.Roots)
+	}
+	if got := walked(tree, tree.Find("0000000000000002")); !slices.Equal(got, []uint64{2, 1}) {
+		t.Errorf("walked %v, want every span of the cycle once", got)
+	}
+}
@@ -0,0 +1,360 @@
+package analysis
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"jaeger-storage/common"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// a matched call is reported as slower or faster when its duration changed by at least this much...
+	minLatencyDelta = time.Millisecond
+	// ...and by at least this fraction of the baseline duration
+	minLatencyDeltaRatio = 0.2
+	// a call is expected when it appears in at least this fraction of the baseline traces
+	expectedCallRatio = 0.5
+)
+
+// SpanDiff describes how a call, i.e. a span at a given position of the call tree, differs from the baseline.
+// The durations of a baseline with several traces are medians. Durations are in nanoseconds.
+type SpanDiff struct {
+	// service:operation of the span and its ancestors, with the occurrence among its siblings
+	Key              string        `json:"key"`
+	ServiceName      string        `json:"service_name"`
+	OperationName    string        `json:"operation_name"`
+	BaselineSpanId   string        `json:"baseline_span_id,omitempty"`
+	TargetSpanId     string        `json:"target_span_id,omitempty"`
+	BaselineDuration time.Duration `json:"baseline_duration"`
+	TargetDuration   time.Duration `json:"target_duration"`
+	DurationDelta    time.Duration `json:"duration_delta"`
+	BaselineStatus   string        `json:"baseline_status,omitempty"`
+	TargetStatus     string        `json:"target_status,omitempty"`
+	// log messages only found in the target, with numbers masked
+	AddedLogs []string `json:"added_logs,omitempty"`
+	// log messages of the baseline that the target does not have
+	MissingLogs []string `json:"missing_logs,omitempty"`
+	// number of calls below an added or missing call, they are not reported separately
+	Descendants int `json:"descendants,omitempty"`
+}
+
+type Comparison struct {
+	TargetTraceId    string        `json:"target_trace_id"`
+	BaselineTraceIds []string      `json:"baseline_trace_ids"`
+	TargetDuration   time.Duration `json:"target_duration"`
+	BaselineDuration time.Duration `json:"baseline_duration"`
+	DurationDelta    time.Duration `json:"duration_delta"`
+	MatchedCalls     int           `json:"matched_calls"`
+	// calls of the target that the baseline does not have
+	Added []SpanDiff `json:"added"`
+	// calls of the baseline that the target does not have
+	Missing []SpanDiff `json:"missing"`
+	// matched calls with a latency, status or log difference, sorted by latency delta
+	Changed []SpanDiff `json:"changed"`
+}
+
+// alignedSpan is a span keyed by its position in the call tree
+type alignedSpan struct {
+	key       string
+	parentKey string
+	span      *model.Span
+	status    string
+	logs      []string
+}
+
+var numberRegex = regexp.MustCompile(`\d+`)
+
+// alignTrace keys every span by the service and operation of itself and its ancestors. Siblings with the same
+// service and operation are told apart by their start order, so the third call to redis GetDriver matches the third one.
+func alignTrace(trace *model.Trace) map[string]alignedSpan {
+	tree := NewCallTree(trace)
+	aligned := make(map[string]alignedSpan, len(trace.Spans))
+	var walk func(span *model.Span, key string, parentKey string)
+	walk = func(span *model.Span, key string, parentKey string) {
+		aligned[key] = alignedSpan{
+			key:       key,
+			parentKey: parentKey,
+			span:      span,
+			status:    common.ClassifySpanErrors(span).Status,
+			logs:      logMessages(span),
+		}
+		occurrences := make(map[string]int)
+		for _, c := range tree.Children[span.SpanID] {
+			name := c.Process.GetServiceName() + ":" + c.OperationName
+			walk(c, fmt.Sprintf("%s/%s#%d", key, name, occurrences[name]), key)
+			occurrences[name]++
+		}
+	}
+	occurrences := make(map[string]int)
+	for _, root := range tree.Roots {
+		name := root.Process.GetServiceName() + ":" + root.OperationName
+		walk(root, fmt.Sprintf("%s#%d", name, occurrences[name]), "")
+		occurrences[name]++
+	}
+	return aligned
+}
+
+// logMessages renders the logs of a span without timestamps, numbers are masked so that IDs and durations do not count as differences
+func logMessages(span *model.Span) []string {
+	messages := make([]string, 0, len(span.Logs))
+	for _, l := range span.Logs {
+		fields := make([]string, 0, len(l.Fields))
+		for _, f := range l.Fields {
+			fields = append(fields, fmt.Sprintf("%s=%s", f.Key, f.AsString()))
+		}
+		messages = append(messages, numberRegex.ReplaceAllString(strings.Join(fields, " "), "N"))
+	}
+	slices.Sort(messages)
+	return slices.Compact(messages)
+}
+
+// baselineCall aggregates a call over the baseline traces
+type baselineCall struct {
+	key       string
+	parentKey string
+	example   *model.Span
+	durations []time.Duration
+	statuses  map[string]int
+	logs      map[string]int
+}
+
+func traceDuration(trace *model.Trace) time.Duration {
+	start, end := trace.Spans[0].StartTime, trace.Spans[0].StartTime.Add(trace.Spans[0].Duration)
+	for _, span := range trace.Spans {
+		start = minTime(start, span.StartTime)
+		end = maxTime(end, span.StartTime.Add(span.Duration))
+	}
+	return end.Sub(start)
+}
+
+func median(durations []time.Duration) time.Duration {
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	return sorted[len(sorted)/2]
+}
+
+func mostCommon(counts map[string]int) string {
+	best, bestCount := "", 0
+	for k, c := range counts {
+		if c > bestCount || (c == bestCount && k < best) {
+			best, bestCount = k, c
+		}
+	}
+	return best
+}
+
+// Compare aligns the target trace with one or more baseline traces and reports the calls that were added or went missing,
+// and the matched calls whose latency, status or logs changed. Calls are aligned from the root spans, a target without
+// root returns ErrNoRoot and baseline traces without root are left out.
+func Compare(baseline []*model.Trace, target *model.Trace) (*Comparison, error) {
+	if target == nil || len(target.Spans) == 0 {
+		return nil, errors.New("the target trace has no spans")
+	}
+	if len(NewCallTree(target).Roots) == 0 {
+		return nil, ErrNoRoot
+	}
+	baseline = slices.DeleteFunc(slices.Clone(baseline), func(t *model.Trace) bool {
+		return t == nil || len(t.Spans) == 0 || len(NewCallTree(t).Roots) == 0
+	})
+	if len(baseline) == 0 {
+		return nil, errors.New("the baseline has no traces with spans")
+	}
+
+	calls := make(map[string]*baselineCall)
+	traceDurations := make([]time.Duration, 0, len(baseline))
+	comparison := &Comparison{
+		TargetTraceId:    target.Spans[0].TraceID.String(),
+		BaselineTraceIds: make([]string, 0, len(baseline)),
+		Added:            make([]SpanDiff, 0),
+		Missing:          make([]SpanDiff, 0),
+		Changed:          make([]SpanDiff, 0),
+	}
+	for _, trace := range baseline {
+		comparison.BaselineTraceIds = append(comparison.BaselineTraceIds, trace.Spans[0].TraceID.String())
+		traceDurations = append(traceDurations, traceDuration(trace))
+		for key, s := range alignTrace(trace) {
+			call, ok := calls[key]
+			if !ok {
+				call = &baselineCall{key: key, parentKey: s.parentKey, example: s.span, statuses: map[string]int{}, logs: map[string]int{}}
+				calls[key] = call
+			}
+			call.durations = append(call.durations, s.span.Duration)
+			call.statuses[s.status]++
+			for _, l := range s.logs {
+				call.logs[l]++
+			}
+		}
+	}
+	comparison.TargetDuration = traceDuration(target)
+	comparison.BaselineDuration = median(traceDurations)
+	comparison.DurationDelta = comparison.TargetDuration - comparison.BaselineDuration
+
+	expected := func(call *baselineCall) bool {
+		return float64(len(call.durations)) >= expectedCallRatio*float64(len(baseline))
+	}
+
+	targetSpans := alignTrace(target)
+	for key, s := range targetSpans {
+		call, ok := calls[key]
+		if !ok || !expected(call) {
+			if parent, ok := calls[s.parentKey]; s.parentKey != "" && (!ok || !expected(parent)) {
+				// reported as a descendant of the added parent
+				continue
+			}
+			comparison.Added = append(comparison.Added, SpanDiff{
+				Key:            key,
+				ServiceName:    s.span.Process.GetServiceName(),
+				OperationName:  s.span.OperationName,
+				TargetSpanId:   s.span.SpanID.String(),
+				TargetDuration: s.span.Duration,
+				TargetStatus:   s.status,
+				Descendants:    countDescendants(targetSpans, key),
+			})
+			continue
+		}
+
+		comparison.MatchedCalls++
+		diff := SpanDiff{
+			Key:              key,
+			ServiceName:      s.span.Process.GetServiceName(),
+			OperationName:    s.span.OperationName,
+			BaselineSpanId:   call.example.SpanID.String(),
+			TargetSpanId:     s.span.SpanID.String(),
+			BaselineDuration: median(call.durations),
+			TargetDuration:   s.span.Duration,
+			BaselineStatus:   mostCommon(call.statuses),
+			TargetStatus:     s.status,
+		}
+		diff.DurationDelta = diff.TargetDuration - diff.BaselineDuration
+		for _, l := range s.logs {
+			if call.logs[l] == 0 {
+				diff.AddedLogs = append(diff.AddedLogs, l)
+			}
+		}
+		for l, count := range call.logs {
+			if float64(count) >= expectedCallRatio*float64(len(call.durations)) && !slices.Contains(s.logs, l) {
+				diff.MissingLogs = append(diff.MissingLogs, l)
+			}
+		}
+		slices.Sort(diff.MissingLogs)
+
+		absDelta := max(diff.DurationDelta, -diff.DurationDelta)
+		latencyChanged := absDelta >= minLatencyDelta && float64(absDelta) >= minLatencyDeltaRatio*float64(diff.BaselineDuration)
+		if latencyChanged || diff.BaselineStatus != diff.TargetStatus || len(diff.AddedLogs) > 0 || len(diff.MissingLogs) > 0 {
+			comparison.Changed = append(comparison.Changed, diff)
+		}
+	}
+
+	for key, call := range calls {
+		if !expected(call) {
+			continue
+		}
+		if _, ok := targetSpans[key]; ok {
+			continue
+		}
+		if parent, ok := calls[call.parentKey]; ok && expected(parent) {
+			if _, parentInTarget := targetSpans[call.parentKey]; !parentInTarget {
+				// reported as a descendant of the missing parent
+				continue
+			}
+		}
+		descendants := 0
+		for k, c := range calls {
+			if strings.HasPrefix(k, key+"/") && expected(c) {
+				descendants++
+			}
+		}
+		comparison.Missing = append(comparison.Missing, SpanDiff{
+			Key:              key,
+			ServiceName:      call.example.Process.GetServiceName(),
+			OperationName:    call.example.OperationName,
+			BaselineSpanId:   call.example.SpanID.String(),
+			BaselineDuration: median(call.durations),
+			BaselineStatus:   mostCommon(call.statuses),
+			Descendants:      descendants,
+		})
+	}
+
+	byKey := func(a, b SpanDiff) int {
+		return strings.Compare(a.Key, b.Key)
+	}
+	slices.SortFunc(comparison.Added, byKey)
+	slices.SortFunc(comparison.Missing, byKey)
+	slices.SortFunc(comparison.Changed, func(a, b SpanDiff) int {
+		if c := cmp.Compare(max(b.DurationDelta, -b.DurationDelta), max(a.DurationDelta, -a.DurationDelta)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return comparison, nil
+}
+
+func countDescendants(spans map[string]alignedSpan, key string) int {
+	count := 0
+	for k := range spans {
+		if strings.HasPrefix(k, key+"/") {
+			count++
+		}
+	}
+	return count
+}
+
+// Describe renders the comparison for the LLM, summaries are the span summaries by span ID, if known.
+func (c *Comparison) Describe(summaries map[string]string) string {
+	var sb strings.Builder
+	baseline := "the baseline trace " + strings.Join(c.BaselineTraceIds, "")
+	if len(c.BaselineTraceIds) > 1 {
+		baseline = fmt.Sprintf("a baseline of %d traces (medians)", len(c.BaselineTraceIds))
+	}
+	sb.WriteString(fmt.Sprintf("Trace %s took %s, %s took %s, a difference of %s.\n",
+		c.TargetTraceId, c.TargetDuration, baseline, c.BaselineDuration, c.DurationDelta))
+	sb.WriteString(fmt.Sprintf("%d calls matched.\n", c.MatchedCalls))
+
+	summary := func(spanId string) string {
+		if s := strings.TrimSpace(summaries[spanId]); s != "" {
+			return "\n  Summary: " + s
+		}
+		return ""
+	}
+	if len(c.Added) > 0 {
+		sb.WriteString("\nCalls only in the target trace:\n")
+		for _, d := range c.Added {
+			sb.WriteString(fmt.Sprintf("- %s %s (Span ID %s) %s, status %s, with %d calls below it%s\n",
+				d.ServiceName, d.OperationName, d.TargetSpanId, d.TargetDuration, d.TargetStatus, d.Descendants, summary(d.TargetSpanId)))
+		}
+	}
+	if len(c.Missing) > 0 {
+		sb.WriteString("\nCalls of the baseline missing from the target trace:\n")
+		for _, d := range c.Missing {
+			sb.WriteString(fmt.Sprintf("- %s %s (baseline Span ID %s) %s, status %s, with %d calls below it%s\n",
+				d.ServiceName, d.OperationName, d.BaselineSpanId, d.BaselineDuration, d.BaselineStatus, d.Descendants, summary(d.BaselineSpanId)))
+		}
+	}
+	if len(c.Changed) > 0 {
+		sb.WriteString("\nCalls that changed:\n")
+		for _, d := range c.Changed {
+			sb.WriteString(fmt.Sprintf("- %s %s (Span ID %s): %s instead of %s (%+.1fms)",
+				d.ServiceName, d.OperationName, d.TargetSpanId, d.TargetDuration, d.BaselineDuration, float64(d.DurationDelta)/float64(time.Millisecond)))
+			if d.TargetStatus != d.BaselineStatus {
+				sb.WriteString(fmt.Sprintf(", status %s instead of %s", d.TargetStatus, d.BaselineStatus))
+			}
+			sb.WriteString(summary(d.TargetSpanId))
+			sb.WriteString("\n")
+			for _, l := range d.AddedLogs {
+				sb.WriteString(fmt.Sprintf("  new log: %s\n", l))
+			}
+			for _, l := range d.MissingLogs {
+				sb.WriteString(fmt.Sprintf("  missing log: %s\n", l))
+			}
+		}
+	}
+	if len(c.Added) == 0 && len(c.Missing) == 0 && len(c.Changed) == 0 {
+		sb.WriteString("No call was added, went missing or changed significantly.\n")
+	}
+	return sb.String()
+}
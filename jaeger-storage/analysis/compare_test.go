@@ -0,0 +1,246 @@
+package analysis
+
+import (
+	"errors"
+	"github.com/jaegertracing/jaeger/model"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	rootKey     = "frontend:/dispatch#0"
+	customerKey = rootKey + "/customer:/customer#0"
+	driverKey   = rootKey + "/driver:FindNearest#0"
+	redisKey    = driverKey + "/redis-manual:GetDriver#"
+)
+
+// dispatchTrace is /dispatch calling the customer, then the driver which calls redis twice.
+// The changes are applied to the spans by ID before they are returned.
+func dispatchTrace(changes ...func(spans map[uint64]*model.Span) []*model.Span) *model.Trace {
+	spans := map[uint64]*model.Span{
+		1: testSpan(1, 0, "frontend", "/dispatch", 0, 100),
+		2: testSpan(2, 1, "customer", "/customer", 5, 20),
+		3: testSpan(3, 1, "driver", "FindNearest", 30, 40),
+		4: testSpan(4, 3, "redis-manual", "GetDriver", 32, 5),
+		5: testSpan(5, 3, "redis-manual", "GetDriver", 40, 5),
+	}
+	added := make([]*model.Span, 0)
+	for _, change := range changes {
+		added = append(added, change(spans)...)
+	}
+	trace := &model.Trace{}
+	for id := uint64(1); id <= 5; id++ {
+		if span, ok := spans[id]; ok {
+			trace.Spans = append(trace.Spans, span)
+		}
+	}
+	trace.Spans = append(trace.Spans, added...)
+	return trace
+}
+
+func withDuration(id uint64, duration int) func(spans map[uint64]*model.Span) []*model.Span {
+	return func(spans map[uint64]*model.Span) []*model.Span {
+		spans[id].Duration = time.Duration(duration) * time.Millisecond
+		return nil
+	}
+}
+
+func withoutSpans(ids ...uint64) func(spans map[uint64]*model.Span) []*model.Span {
+	return func(spans map[uint64]*model.Span) []*model.Span {
+		for _, id := range ids {
+			delete(spans, id)
+		}
+		return nil
+	}
+}
+
+func withSpans(added ...*model.Span) func(spans map[uint64]*model.Span) []*model.Span {
+	return func(spans map[uint64]*model.Span) []*model.Span {
+		return added
+	}
+}
+
+func withError(id uint64) func(spans map[uint64]*model.Span) []*model.Span {
+	return func(spans map[uint64]*model.Span) []*model.Span {
+		spans[id].Tags = append(spans[id].Tags, model.Bool("error", true))
+		return nil
+	}
+}
+
+func withLog(id uint64, message string) func(spans map[uint64]*model.Span) []*model.Span {
+	return func(spans map[uint64]*model.Span) []*model.Span {
+		spans[id].Logs = append(spans[id].Logs, model.Log{Timestamp: spans[id].StartTime, Fields: []model.KeyValue{model.String("event", message)}})
+		return nil
+	}
+}
+
+func diffKeys(diffs []SpanDiff) []string {
+	keys := make([]string, len(diffs))
+	for i, d := range diffs {
+		keys[i] = d.Key
+	}
+	return keys
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name        string
+		baseline    []*model.Trace
+		target      *model.Trace
+		wantMatched int
+		wantAdded   []string
+		wantMissing []string
+		wantChanged []string
+		check       func(t *testing.T, c *Comparison)
+	}{
+		{
+			name:        "identical",
+			baseline:    []*model.Trace{dispatchTrace()},
+			target:      dispatchTrace(),
+			wantMatched: 5,
+		},
+		{
+			name:        "slower call",
+			baseline:    []*model.Trace{dispatchTrace()},
+			target:      dispatchTrace(withDuration(2, 60), withDuration(1, 150)),
+			wantMatched: 5,
+			// the largest delta first
+			wantChanged: []string{rootKey, customerKey},
+			check: func(t *testing.T, c *Comparison) {
+				if d := c.Changed[1]; d.DurationDelta != 40*time.Millisecond || d.BaselineSpanId != d.TargetSpanId {
+					t.Errorf("changed %+v, want the customer 40ms slower", d)
+				}
+				if c.DurationDelta != 50*time.Millisecond || c.TargetDuration != 150*time.Millisecond {
+					t.Errorf("trace took %s, a difference of %s", c.TargetDuration, c.DurationDelta)
+				}
+			},
+		},
+		{
+			name:        "small latency change",
+			baseline:    []*model.Trace{dispatchTrace()},
+			target:      dispatchTrace(withDuration(2, 22)),
+			wantMatched: 5,
+		},
+		{
+			name:     "added call",
+			baseline: []*model.Trace{dispatchTrace()},
+			target: dispatchTrace(withSpans(
+				testSpan(6, 1, "mysql", "SQL SELECT", 75, 20),
+				testSpan(7, 6, "mysql", "fetch", 80, 10),
+				testSpan(8, 3, "redis-manual", "GetDriver", 50, 5),
+			)),
+			wantMatched: 5,
+			wantAdded:   []string{redisKey + "2", rootKey + "/mysql:SQL SELECT#0"},
+			check: func(t *testing.T, c *Comparison) {
+				if c.Added[1].Descendants != 1 || c.Added[1].TargetSpanId != model.NewSpanID(6).String() {
+					t.Errorf("added %+v, want the query with the fetch below it", c.Added[1])
+				}
+			},
+		},
+		{
+			name:        "missing call",
+			baseline:    []*model.Trace{dispatchTrace()},
+			target:      dispatchTrace(withoutSpans(3, 4, 5)),
+			wantMatched: 2,
+			wantMissing: []string{driverKey},
+			check: func(t *testing.T, c *Comparison) {
+				if d := c.Missing[0]; d.Descendants != 2 || d.BaselineDuration != 40*time.Millisecond {
+					t.Errorf("missing %+v, want the driver with its two redis calls", d)
+				}
+			},
+		},
+		{
+			name:        "status changed",
+			baseline:    []*model.Trace{dispatchTrace()},
+			target:      dispatchTrace(withError(4)),
+			wantMatched: 5,
+			wantChanged: []string{redisKey + "0"},
+			check: func(t *testing.T, c *Comparison) {
+				if d := c.Changed[0]; d.BaselineStatus == d.TargetStatus {
+					t.Errorf("changed %+v, want a different status", d)
+				}
+			},
+		},
+		{
+			name:        "logs changed",
+			baseline:    []*model.Trace{dispatchTrace(withLog(2, "cache miss for customer 123"), withLog(3, "search started"))},
+			target:      dispatchTrace(withLog(2, "cache miss for customer 456"), withLog(2, "retrying")),
+			wantMatched: 5,
+			wantChanged: []string{customerKey, driverKey},
+			check: func(t *testing.T, c *Comparison) {
+				if !slices.Equal(c.Changed[0].AddedLogs, []string{"event=retrying"}) || len(c.Changed[0].MissingLogs) != 0 {
+					t.Errorf("customer logs added %v, missing %v, want the retry only", c.Changed[0].AddedLogs, c.Changed[0].MissingLogs)
+				}
+				if !slices.Equal(c.Changed[1].MissingLogs, []string{"event=search started"}) {
+					t.Errorf("driver logs missing %v", c.Changed[1].MissingLogs)
+				}
+			},
+		},
+		{
+			name: "medians of several baseline traces",
+			baseline: []*model.Trace{
+				dispatchTrace(withDuration(2, 20)),
+				dispatchTrace(withDuration(2, 30), withSpans(testSpan(6, 1, "mysql", "SQL SELECT", 75, 20))),
+				dispatchTrace(withDuration(2, 90)),
+				{},
+			},
+			target:      dispatchTrace(withDuration(2, 30), withSpans(testSpan(6, 1, "mysql", "SQL SELECT", 75, 20))),
+			wantMatched: 5,
+			wantAdded:   []string{rootKey + "/mysql:SQL SELECT#0"},
+			check: func(t *testing.T, c *Comparison) {
+				if len(c.BaselineTraceIds) != 3 {
+					t.Errorf("baseline traces = %v, want the traces with spans", c.BaselineTraceIds)
+				}
+			},
+		},
+		{
+			name: "rootless baseline trace",
+			baseline: []*model.Trace{
+				dispatchTrace(),
+				{Spans: []*model.Span{testSpan(1, 2, "frontend", "/dispatch", 0, 100), testSpan(2, 1, "customer", "/customer", 5, 20)}},
+			},
+			target:      dispatchTrace(),
+			wantMatched: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Compare(tt.baseline, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.MatchedCalls != tt.wantMatched {
+				t.Errorf("matched %d calls, want %d", c.MatchedCalls, tt.wantMatched)
+			}
+			if got := diffKeys(c.Added); !slices.Equal(got, tt.wantAdded) && len(got)+len(tt.wantAdded) > 0 {
+				t.Errorf("added %v, want %v", got, tt.wantAdded)
+			}
+			if got := diffKeys(c.Missing); !slices.Equal(got, tt.wantMissing) && len(got)+len(tt.wantMissing) > 0 {
+				t.Errorf("missing %v, want %v", got, tt.wantMissing)
+			}
+			if got := diffKeys(c.Changed); !slices.Equal(got, tt.wantChanged) && len(got)+len(tt.wantChanged) > 0 {
+				t.Errorf("changed %v, want %v", got, tt.wantChanged)
+			}
+			if tt.check != nil && !t.Failed() {
+				tt.check(t, c)
+			}
+		})
+	}
+}
+
+func TestCompareErrors(t *testing.T) {
+	rootless := &model.Trace{Spans: []*model.Span{
+		testSpan(1, 2, "frontend", "/dispatch", 0, 100),
+		testSpan(2, 1, "customer", "/customer", 5, 20),
+	}}
+	if _, err := Compare([]*model.Trace{dispatchTrace()}, rootless); !errors.Is(err, ErrNoRoot) {
+		t.Errorf("err = %v for a rootless target, want ErrNoRoot", err)
+	}
+	if _, err := Compare([]*model.Trace{dispatchTrace()}, &model.Trace{}); err == nil {
+		t.Error("compared a target without spans")
+	}
+	if _, err := Compare([]*model.Trace{rootless, {}}, dispatchTrace()); err == nil {
+		t.Error("compared with a baseline without usable traces")
+	}
+}
@@ -0,0 +1,287 @@
+package analysis
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"slices"
+	"strings"
+	"time"
+)
+
+// number of rows of each list rendered by Summary, the report itself is not truncated
+const summaryLimit = 10
+
+// Segment is a stretch of time on the critical path spent in a span itself rather than in one of its children.
+// Durations are in nanoseconds.
+type Segment struct {
+	SpanId        string        `json:"span_id"`
+	ServiceName   string        `json:"service_name"`
+	OperationName string        `json:"operation_name"`
+	StartOffset   time.Duration `json:"start_offset"`
+	Duration      time.Duration `json:"duration"`
+}
+
+type SpanLatency struct {
+	SpanId        string        `json:"span_id"`
+	ServiceName   string        `json:"service_name"`
+	OperationName string        `json:"operation_name"`
+	Duration      time.Duration `json:"duration"`
+	// the part of the span not covered by its children
+	SelfTime time.Duration `json:"self_time"`
+	// the part of the span's self time that is on the critical path
+	CriticalTime time.Duration `json:"critical_time"`
+	// sum of the durations of the children divided by the time they cover, 1 when they run one after another
+	Parallelism float64 `json:"parallelism"`
+}
+
+// Gap is a period inside a span between two of its children during which no child runs.
+type Gap struct {
+	SpanId        string        `json:"span_id"`
+	ServiceName   string        `json:"service_name"`
+	OperationName string        `json:"operation_name"`
+	AfterSpanId   string        `json:"after_span_id"`
+	BeforeSpanId  string        `json:"before_span_id"`
+	StartOffset   time.Duration `json:"start_offset"`
+	Duration      time.Duration `json:"duration"`
+}
+
+type CriticalPathReport struct {
+	TraceId   string        `json:"trace_id"`
+	Duration  time.Duration `json:"duration"`
+	SpanCount int           `json:"span_count"`
+	// sum of the self time of all spans divided by the trace duration, i.e. how many spans work at the same time on average
+	Parallelism  float64   `json:"parallelism"`
+	CriticalPath []Segment `json:"critical_path"`
+	// sorted by critical time then self time, longest first
+	Spans []SpanLatency `json:"spans"`
+	// sorted by duration, longest first
+	Gaps []Gap `json:"gaps"`
+}
+
+// ErrNoRoot is returned for a trace in which every span has a parent, e.g. when the references of an imported
+// trace form a cycle.
+var ErrNoRoot = errors.New("the trace has no root span, its spans reference each other in a cycle")
+
+// CriticalPath finds the chain of work that determined the duration of the trace. Starting from the end of the root span
+// it walks backwards, at each point descending into the child that finished last, the same way Jaeger UI does.
+func CriticalPath(trace *model.Trace) (*CriticalPathReport, error) {
+	if trace == nil || len(trace.Spans) == 0 {
+		return nil, errors.New("the trace has no spans")
+	}
+	tree := NewCallTree(trace)
+	if len(tree.Roots) == 0 {
+		return nil, ErrNoRoot
+	}
+
+	traceStart, traceEnd := trace.Spans[0].StartTime, trace.Spans[0].StartTime.Add(trace.Spans[0].Duration)
+	for _, span := range trace.Spans {
+		traceStart = minTime(traceStart, span.StartTime)
+		traceEnd = maxTime(traceEnd, span.StartTime.Add(span.Duration))
+	}
+	report := &CriticalPathReport{
+		TraceId:   trace.Spans[0].TraceID.String(),
+		Duration:  traceEnd.Sub(traceStart),
+		SpanCount: len(trace.Spans),
+	}
+
+	// the trace is driven by its longest root, other roots are spans whose parent was not reported
+	root := tree.Roots[0]
+	for _, r := range tree.Roots {
+		if r.Duration > root.Duration {
+			root = r
+		}
+	}
+
+	segments := make([]Segment, 0)
+	criticalTime := make(map[model.SpanID]time.Duration)
+	addSegment := func(span *model.Span, start time.Time, end time.Time) {
+		if !end.After(start) {
+			return
+		}
+		criticalTime[span.SpanID] += end.Sub(start)
+		segments = append(segments, Segment{
+			SpanId:        span.SpanID.String(),
+			ServiceName:   span.Process.GetServiceName(),
+			OperationName: span.OperationName,
+			StartOffset:   start.Sub(traceStart),
+			Duration:      end.Sub(start),
+		})
+	}
+	var walk func(span *model.Span, until time.Time)
+	walk = func(span *model.Span, until time.Time) {
+		cursor := minTime(span.StartTime.Add(span.Duration), until)
+		children := slices.Clone(tree.Children[span.SpanID])
+		slices.SortStableFunc(children, func(a, b *model.Span) int {
+			return b.StartTime.Add(b.Duration).Compare(a.StartTime.Add(a.Duration))
+		})
+		for _, c := range children {
+			if !c.StartTime.Before(cursor) || !cursor.After(span.StartTime) {
+				continue
+			}
+			childEnd := minTime(c.StartTime.Add(c.Duration), cursor)
+			addSegment(span, childEnd, cursor)
+			walk(c, childEnd)
+			cursor = maxTime(c.StartTime, span.StartTime)
+		}
+		addSegment(span, span.StartTime, cursor)
+	}
+	walk(root, root.StartTime.Add(root.Duration))
+
+	// segments were collected backwards
+	slices.Reverse(segments)
+	for _, s := range segments {
+		if n := len(report.CriticalPath); n > 0 && report.CriticalPath[n-1].SpanId == s.SpanId {
+			report.CriticalPath[n-1].Duration += s.Duration
+			continue
+		}
+		report.CriticalPath = append(report.CriticalPath, s)
+	}
+
+	totalSelfTime := time.Duration(0)
+	report.Spans = make([]SpanLatency, 0, len(trace.Spans))
+	report.Gaps = make([]Gap, 0)
+	for _, span := range trace.Spans {
+		children := tree.Children[span.SpanID]
+		latency := SpanLatency{
+			SpanId:        span.SpanID.String(),
+			ServiceName:   span.Process.GetServiceName(),
+			OperationName: span.OperationName,
+			Duration:      span.Duration,
+			SelfTime:      SelfTime(span, children),
+			CriticalTime:  criticalTime[span.SpanID],
+			Parallelism:   parallelism(span, children),
+		}
+		totalSelfTime += latency.SelfTime
+		report.Spans = append(report.Spans, latency)
+		report.Gaps = append(report.Gaps, gaps(span, children, traceStart)...)
+	}
+	if report.Duration > 0 {
+		report.Parallelism = float64(totalSelfTime) / float64(report.Duration)
+	}
+
+	slices.SortStableFunc(report.Spans, func(a, b SpanLatency) int {
+		if c := cmp.Compare(b.CriticalTime, a.CriticalTime); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.SelfTime, a.SelfTime)
+	})
+	slices.SortStableFunc(report.Gaps, func(a, b Gap) int {
+		return cmp.Compare(b.Duration, a.Duration)
+	})
+	return report, nil
+}
+
+func parallelism(span *model.Span, children []*model.Span) float64 {
+	covered := time.Duration(0)
+	for _, i := range childIntervals(span, children) {
+		covered += i.end.Sub(i.start)
+	}
+	if covered == 0 {
+		return 0
+	}
+	total := time.Duration(0)
+	spanEnd := span.StartTime.Add(span.Duration)
+	for _, c := range children {
+		start := maxTime(c.StartTime, span.StartTime)
+		end := minTime(c.StartTime.Add(c.Duration), spanEnd)
+		if end.After(start) {
+			total += end.Sub(start)
+		}
+	}
+	return float64(total) / float64(covered)
+}
+
+func gaps(span *model.Span, children []*model.Span, traceStart time.Time) []Gap {
+	intervals := childIntervals(span, children)
+	result := make([]Gap, 0)
+	for i := 1; i < len(intervals); i++ {
+		start, end := intervals[i-1].end, intervals[i].start
+		result = append(result, Gap{
+			SpanId:        span.SpanID.String(),
+			ServiceName:   span.Process.GetServiceName(),
+			OperationName: span.OperationName,
+			AfterSpanId:   lastEndingAt(children, start),
+			BeforeSpanId:  firstStartingAt(children, end),
+			StartOffset:   start.Sub(traceStart),
+			Duration:      end.Sub(start),
+		})
+	}
+	return result
+}
+
+func lastEndingAt(spans []*model.Span, t time.Time) string {
+	for i := len(spans) - 1; i >= 0; i-- {
+		if !spans[i].StartTime.Add(spans[i].Duration).Before(t) && !spans[i].StartTime.After(t) {
+			return spans[i].SpanID.String()
+		}
+	}
+	return ""
+}
+
+func firstStartingAt(spans []*model.Span, t time.Time) string {
+	for _, s := range spans {
+		if !s.StartTime.Before(t) {
+			return s.SpanID.String()
+		}
+	}
+	return ""
+}
+
+// Summary renders the report for the LLM, it is appended to the graph-rag passage.
+func (r *CriticalPathReport) Summary() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Trace duration: %s across %d spans, on average %.1f spans work at the same time\n", r.Duration, r.SpanCount, r.Parallelism))
+
+	sb.WriteString("Critical path, the spans that determined the trace duration in time order:\n")
+	for i, s := range r.CriticalPath {
+		if i == summaryLimit*2 {
+			sb.WriteString(fmt.Sprintf("... %d more segments\n", len(r.CriticalPath)-i))
+			break
+		}
+		sb.WriteString(fmt.Sprintf("+%s Span ID: %s, %s %s, %s\n", s.StartOffset, s.SpanId, s.ServiceName, s.OperationName, s.Duration))
+	}
+
+	sb.WriteString("Spans with the most time on the critical path:\n")
+	for i, s := range r.Spans {
+		if i == summaryLimit || s.CriticalTime == 0 {
+			break
+		}
+		sb.WriteString(fmt.Sprintf("Span ID: %s, %s %s, %s on the critical path (%.1f%%), self time %s of %s\n",
+			s.SpanId, s.ServiceName, s.OperationName, s.CriticalTime, percent(s.CriticalTime, r.Duration), s.SelfTime, s.Duration))
+	}
+
+	if len(r.Gaps) > 0 {
+		sb.WriteString("Largest gaps where a span waited without any child running:\n")
+		for i, g := range r.Gaps {
+			if i == summaryLimit {
+				break
+			}
+			sb.WriteString(fmt.Sprintf("Span ID: %s, %s %s, idle for %s at +%s between Span ID %s and Span ID %s\n",
+				g.SpanId, g.ServiceName, g.OperationName, g.Duration, g.StartOffset, g.AfterSpanId, g.BeforeSpanId))
+		}
+	}
+	return sb.String()
+}
+
+func percent(d time.Duration, total time.Duration) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(d) / float64(total) * 100
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,130 @@
+package analysis
+
+import (
+	"errors"
+	"github.com/jaegertracing/jaeger/model"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+type pathSegment struct {
+	spanId   uint64
+	offset   int
+	duration int
+}
+
+func segmentsOf(report *CriticalPathReport) []pathSegment {
+	segments := make([]pathSegment, len(report.CriticalPath))
+	for i, s := range report.CriticalPath {
+		id, _ := model.SpanIDFromString(s.SpanId)
+		segments[i] = pathSegment{uint64(id), int(s.StartOffset / time.Millisecond), int(s.Duration / time.Millisecond)}
+	}
+	return segments
+}
+
+func latencyOf(report *CriticalPathReport, spanId uint64) SpanLatency {
+	for _, s := range report.Spans {
+		if s.SpanId == model.NewSpanID(spanId).String() {
+			return s
+		}
+	}
+	return SpanLatency{}
+}
+
+func TestCriticalPathOverlappingChildren(t *testing.T) {
+	report, err := CriticalPath(&model.Trace{Spans: []*model.Span{
+		testSpan(1, 0, "frontend", "/dispatch", 0, 100),
+		testSpan(3, 1, "driver", "FindNearest", 30, 40),
+		testSpan(2, 1, "customer", "/customer", 10, 30),
+		testSpan(4, 1, "redis-manual", "GetDriver", 80, 10),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the customer call is on the path only until the driver call, which overlaps it, starts
+	want := []pathSegment{{1, 0, 10}, {2, 10, 20}, {3, 30, 40}, {1, 70, 10}, {4, 80, 10}, {1, 90, 10}}
+	if got := segmentsOf(report); !slices.Equal(got, want) {
+		t.Errorf("critical path = %v, want %v", got, want)
+	}
+	if report.Duration != 100*time.Millisecond || report.SpanCount != 4 {
+		t.Errorf("duration %s of %d spans", report.Duration, report.SpanCount)
+	}
+	if math.Abs(report.Parallelism-1.1) > 1e-9 {
+		t.Errorf("parallelism = %f, want 110ms of self time in 100ms", report.Parallelism)
+	}
+
+	root := latencyOf(report, 1)
+	if root.SelfTime != 30*time.Millisecond || root.CriticalTime != 30*time.Millisecond {
+		t.Errorf("root self time %s, critical time %s, want 30ms", root.SelfTime, root.CriticalTime)
+	}
+	if math.Abs(root.Parallelism-80.0/70.0) > 1e-9 {
+		t.Errorf("root parallelism = %f, want 80ms of children in 70ms", root.Parallelism)
+	}
+	if customer := latencyOf(report, 2); customer.SelfTime != 30*time.Millisecond || customer.CriticalTime != 20*time.Millisecond {
+		t.Errorf("customer self time %s, critical time %s", customer.SelfTime, customer.CriticalTime)
+	}
+	if report.Spans[0].SpanId != model.NewSpanID(3).String() {
+		t.Errorf("spans start with %s, want the longest on the critical path", report.Spans[0].SpanId)
+	}
+
+	wantGap := Gap{
+		SpanId:        model.NewSpanID(1).String(),
+		ServiceName:   "frontend",
+		OperationName: "/dispatch",
+		AfterSpanId:   model.NewSpanID(3).String(),
+		BeforeSpanId:  model.NewSpanID(4).String(),
+		StartOffset:   70 * time.Millisecond,
+		Duration:      10 * time.Millisecond,
+	}
+	if len(report.Gaps) != 1 || report.Gaps[0] != wantGap {
+		t.Errorf("gaps = %+v, want %+v", report.Gaps, wantGap)
+	}
+}
+
+func TestCriticalPathChildrenOutsideParent(t *testing.T) {
+	report, err := CriticalPath(&model.Trace{Spans: []*model.Span{
+		testSpan(1, 0, "frontend", "/dispatch", 0, 50),
+		// ends after its parent, e.g. clock skew
+		testSpan(2, 1, "driver", "FindNearest", 30, 50),
+		// fire and forget, starts after its parent ended
+		testSpan(3, 1, "mysql", "SQL UPDATE", 60, 10),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []pathSegment{{1, 0, 30}, {2, 30, 20}}
+	if got := segmentsOf(report); !slices.Equal(got, want) {
+		t.Errorf("critical path = %v, want the children cut at the end of the root", got)
+	}
+	if report.Duration != 80*time.Millisecond {
+		t.Errorf("duration = %s, want up to the end of the last span", report.Duration)
+	}
+	root := latencyOf(report, 1)
+	if root.SelfTime != 30*time.Millisecond || root.Parallelism != 1 {
+		t.Errorf("root self time %s, parallelism %f, want the children counted within the root only", root.SelfTime, root.Parallelism)
+	}
+	if latency := latencyOf(report, 3); latency.CriticalTime != 0 {
+		t.Errorf("the span after the root has %s on the critical path", latency.CriticalTime)
+	}
+	if len(report.Gaps) != 0 {
+		t.Errorf("gaps = %+v, want none within the root", report.Gaps)
+	}
+}
+
+func TestCriticalPathWithoutRoot(t *testing.T) {
+	_, err := CriticalPath(&model.Trace{Spans: []*model.Span{
+		testSpan(1, 2, "frontend", "/dispatch", 0, 100),
+		testSpan(2, 1, "customer", "/customer", 10, 30),
+	}})
+	if !errors.Is(err, ErrNoRoot) {
+		t.Errorf("err = %v, want ErrNoRoot", err)
+	}
+
+	if _, err := CriticalPath(&model.Trace{}); err == nil || errors.Is(err, ErrNoRoot) {
+		t.Errorf("err = %v for a trace without spans", err)
+	}
+}
@@ -4,251 +4,363 @@ import (
 	"context"
 	"fmt"
 	openai "github.com/sashabaranov/go-openai"
+	"jaeger-storage/prompts"
+	"jaeger-storage/redaction"
+	"jaeger-storage/usage"
 	"log"
 	"os"
+	"strings"
 )
 
 type OpenAIClient struct {
-	client *openai.Client
+	client       *openai.Client
+	usageTracker *usage.Tracker
+	redactor     *redaction.Redactor
+	prompts      *prompts.Registry
+	// replaces the model of every call when set, e.g. the model of a local server
+	modelOverride          string
+	structuredOutputFormat string
 }
 
-func NewOpenAIClient() *OpenAIClient {
-	token := os.Getenv("OPENAI_API_KEY")
-	return &OpenAIClient{client: openai.NewClient(token)}
+func NewOpenAIClient(usageTracker *usage.Tracker, redactor *redaction.Redactor, promptRegistry *prompts.Registry) *OpenAIClient {
+	config := openai.DefaultConfig(os.Getenv("OPENAI_API_KEY"))
+	// e.g. a local OpenAI compatible server
+	if baseUrl := os.Getenv("OPENAI_BASE_URL"); baseUrl != "" {
+		config.BaseURL = baseUrl
+	}
+	structuredOutputFormat := os.Getenv("LLM_STRUCTURED_OUTPUT")
+	if structuredOutputFormat != "" && structuredOutputFormat != StructuredOutputJSONSchema && structuredOutputFormat != StructuredOutputJSONObject {
+		log.Println("[NewOpenAIClient][error] invalid LLM_STRUCTURED_OUTPUT, choosing per model", structuredOutputFormat)
+		structuredOutputFormat = ""
+	}
+	return &OpenAIClient{
+		client:                 openai.NewClientWithConfig(config),
+		usageTracker:           usageTracker,
+		redactor:               redactor,
+		prompts:                promptRegistry,
+		modelOverride:          os.Getenv("OPENAI_MODEL"),
+		structuredOutputFormat: structuredOutputFormat,
+	}
 }
 
-func (c *OpenAIClient) SummarizeSpan(ctx context.Context, passage string) (string, error) {
-	prompt := `	
-		You are to help a software engineer troubleshoot a distributed system. Summarize the given distributed tracing spans. Elaborate what you know about the span. 
-		For example if it is a HTTP request explain briefly the flow of the request.
-		Some examples are below. The raw span is delimited by <raw-span></raw-span>. The summary is delimited by <summary></summary>. 
-		If there are extra keys, include them in your summary. Do not include the delimiter in your response.
-		
-		<raw-span>
-		service name: member-service
-		operation name: user-registration
-		span id: 001
-		duration: 100 nanoseconds
-		start time: Nov 18, 2024
-		span kind: client
-		action kind: http
-		</raw-span>
-		<summary>
-		The operation "user-registration" to create a new user in member-service succeeded. It is associated with registering new customers when they sign up via the web application. It is a HTTP request that lasted 100 nano seconds. Its span ID is 001.
-		</summary>
-`
-	p := fmt.Sprintf(`
-Here is the raw span you need to summarize.
-<raw-span>
-%s
-</raw-span>
-`, passage)
-	resp, err := c.client.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: openai.GPT3Dot5Turbo,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: prompt,
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: p,
-				},
-			},
-			Temperature: 0,
+func (c *OpenAIClient) model(model string) string {
+	if c.modelOverride != "" {
+		return c.modelOverride
+	}
+	return model
+}
+
+func (c *OpenAIClient) recordUsage(ctx context.Context, model string, u openai.Usage) {
+	c.usageTracker.Record(ctx, usage.Record{
+		Attribution:      usage.AttributionFromContext(ctx),
+		Model:            model,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+	})
+}
+
+func promptMessages(prompt prompts.Prompt) []openai.ChatCompletionMessage {
+	return []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: prompt.System,
 		},
-	)
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: prompt.User,
+		},
+	}
+}
 
+// SummarizeSpan summarizes the raw span with the template of its service or SDK language, it returns the summary
+// and the version of the template
+func (c *OpenAIClient) SummarizeSpan(ctx context.Context, scope prompts.Scope, passage string) (string, string, error) {
+	passage = c.redactor.Redact(ctx, "summarize_span", passage)
+	prompt, err := c.prompts.Render(prompts.SummarizeSpan, scope, struct{ RawSpan string }{passage})
+	if err != nil {
+		log.Println("[SummarizeSpan] cannot render the prompt", err)
+		return "", "", err
+	}
+	result, err := completeStructured[SpanSummary](ctx, c, "span_summary", openai.GPT3Dot5Turbo, promptMessages(prompt))
 	if err != nil {
 		log.Println("[SummarizeSpan] an error occurred", err)
-		return "", err
+		return "", "", err
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return strings.TrimSpace(result.Summary), prompt.Version, nil
 }
 
-func (c *OpenAIClient) SummarizeLog(ctx context.Context, passage string) (string, error) {
-	prompt := `
-		You are to help a software engineer troubleshoot a distributed system. You are given logs that you need to summarize. If the log is empty return #EMPTY#. Some examples are below. The raw log is delimited by <raw-log></raw-log>. The summary is delimited by <summary></summary>. 
-		If there are extra keys, include them in your summary. Do not include the delimiter in your response.
-		
-		<raw-log>
-		Service is attempting to connect to Redis server
-		Fetching key auth_token from Redis
-		Found auth token, adding it to header
-		API request to vendor is being made
-		API response got 401 unauthorized
-		</raw-log>
-		<summary>
-		An auth token was retrieved from Redis under key name auth_token. However, the API request using that auth token failed most likely due to the auth token being expired, indicated by the 401 status code.
-		</summary>
-		
-		<raw-log>
-		event: HTTP request received
-		method: GET
-		url: /customer?customer=123
-		level: info
-		</raw-log>
-		
-		<summary>
-		A HTTP request at endpoint GET /customer was received with query parameter customer=123. This endpoint is used to retrieve a customer's personal details
-		</summary>
-		
-		<raw-log>
-		</raw-log>
-		<summary>
-		#EMPTY#
-		</summary>
-`
-	p := fmt.Sprintf(`
-		Here are the logs that you need to summarize
-		<raw-log>
-		%s
-		</raw-log>
-	`, passage)
-	resp, err := c.client.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: openai.GPT3Dot5Turbo,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: prompt,
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: p,
-				},
-			},
-			Temperature: 0,
-		},
-	)
-
+// SummarizeLog summarizes the logs of a span with the template of its service or SDK language, it returns the summary,
+// empty when there are no logs, and the version of the template
+func (c *OpenAIClient) SummarizeLog(ctx context.Context, scope prompts.Scope, passage string) (string, string, error) {
+	passage = c.redactor.Redact(ctx, "summarize_log", passage)
+	prompt, err := c.prompts.Render(prompts.SummarizeLog, scope, struct{ RawLog string }{passage})
+	if err != nil {
+		log.Println("[SummarizeLog] cannot render the prompt", err)
+		return "", "", err
+	}
+	result, err := completeStructured[LogSummary](ctx, c, "log_summary", openai.GPT3Dot5Turbo, promptMessages(prompt))
 	if err != nil {
 		log.Println("[SummarizeLog] an error occurred", err)
-		return "", err
+		return "", "", err
+	}
+	if result.Empty {
+		return "", prompt.Version, nil
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return strings.TrimSpace(result.Summary), prompt.Version, nil
 }
 
 func (c *OpenAIClient) CreateEmbeddings(ctx context.Context, content string) ([]float32, error) {
+	content = c.redactor.Redact(ctx, "embedding", content)
 	res, err := c.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
 		Input: content,
 		Model: openai.SmallEmbedding3,
 	})
 	if err != nil {
-		log.Println("[SummarizeLog] an error occurred", err)
+		log.Println("[CreateEmbeddings] an error occurred", err)
 		return []float32{}, err
 	}
+	c.recordUsage(ctx, string(openai.SmallEmbedding3), res.Usage)
 
 	return res.Data[0].Embedding, nil
 }
 
-func (c *OpenAIClient) GenerateAnswer(ctx context.Context, query string, passage string, method string) (string, error) {
-	prompt := `
-		You need to provide a factual answer based on the given question and passage. Use the passage to answer the question.
-		If you believe the question cannot be answered from the given passage return the phrase "Insufficient Information". Keep the answer concise and specific. Do not include redundant information.
-
-		Here are some examples to show you. The passage is delimited by <passage></passage>, question is delimited by <question></question>, and answer is delimited by <answer></answer>. You are also given <explanation></explanation> to help you reason how to arrive at an answer. Do not include <explanation></explanation> in your response.
-
-		The passage is a graph structure of a distributed tracing application.
-		The nodes are spans. Each span has an ID and summary.
-		The edges are of the format (span_id, relationship, span_id). This indicates that there is a directed relationship between spans.
-		It is important that you use the (span_id, relationship, span_id) format to help you reason about the answer. 
-		Do not include the edge format in your answer unless asked to.
-				
-		<passage>	
-		Edge types:
-		INVOKES_CHILD means that a span calls or invokes another span 
-		
-		Edges:
-		(01, INVOKES_CHILD, 02)
-		(02, INVOKES_CHILD, 03)
-		(01, INVOKES_CHILD, 04)
-		
-		Nodes:
-		Span ID: 01
-		Operation: initiate-transfer
-		Summary: User Joe initiated a money transfer to Bob.
-		
-		Span ID: 02
-		Operation: wallet-processor
-		Summary: Wallet service received request for a money transfer. It cannot do the transfer for unknown reasons.
-		
-		Span ID: 03
-		Operation: convert-currency
-		Summary: Currency service cannot convert to the destination currency. It failed because exchange market is closed today.
-		
-		Span ID: 04
-		Operation: get-customer
-		Summary: Returning customer information. Joe is a gold tier member.
-		</passage>
-		
+// GenerateAnswer answers the question from the passage with the template of the method, it returns the answer and
+// the version of the template
+func (c *OpenAIClient) GenerateAnswer(ctx context.Context, query string, passage string, method string) (Answer, string, error) {
+	name := prompts.AnswerGraphRag
+	switch method {
+	case "text2cypher":
+		name = prompts.AnswerText2Cypher
+	case "naive-rag":
+		name = prompts.AnswerNaiveRag
+	}
+
+	query = c.redactor.Redact(ctx, "answer_question", query)
+	passage = c.redactor.Redact(ctx, "answer_passage", passage)
+	prompt, err := c.prompts.Render(name, prompts.Scope{}, struct {
+		Question string
+		Passage  string
+	}{query, passage})
+	if err != nil {
+		log.Println("[GenerateAnswer] cannot render the prompt", err)
+		return Answer{}, "", err
+	}
+	answer, err := completeStructured[Answer](ctx, c, "answer", openai.GPT4oMini20240718, promptMessages(prompt))
+	if err != nil {
+		log.Println("[GenerateAnswer] an error occurred", err)
+		return Answer{}, "", err
+	}
+	answer.Answer = strings.TrimSpace(answer.Answer)
+	if !answer.Sufficient {
+		answer.Answer = InsufficientInformation
+	}
+
+	return answer, prompt.Version, nil
+}
+
+// ChatWithTools lets the model either answer or call one of the given tools, used by the agent method of /api/ask.
+// Passing no tools forces an answer. An answer is the JSON of Answer, see ParseAnswer.
+func (c *OpenAIClient) ChatWithTools(ctx context.Context, messages []openai.ChatCompletionMessage, tools []openai.Tool) (openai.ChatCompletionMessage, error) {
+	redacted := make([]openai.ChatCompletionMessage, len(messages))
+	for i, m := range messages {
+		if m.Role == openai.ChatMessageRoleUser || m.Role == openai.ChatMessageRoleTool {
+			m.Content = c.redactor.Redact(ctx, "agent_"+m.Role, m.Content)
+		}
+		redacted[i] = m
+	}
+
+	request := openai.ChatCompletionRequest{
+		Model:       c.model(openai.GPT4oMini20240718),
+		Messages:    redacted,
+		Tools:       tools,
+		Temperature: 0,
+	}
+	// the final answer is the JSON of Answer, read with ParseAnswer
+	if _, err := requestStructured[Answer](c, &request, "answer"); err != nil {
+		return openai.ChatCompletionMessage{}, err
+	}
+	res, err := c.client.CreateChatCompletion(ctx, request)
+	if err != nil {
+		log.Println("[ChatWithTools] an error occurred", err)
+		return openai.ChatCompletionMessage{}, err
+	}
+	c.recordUsage(ctx, res.Model, res.Usage)
+
+	return res.Choices[0].Message, nil
+}
+
+// GenerateCypher writes a read-only Cypher query answering a question about a single trace, used by the text2cypher method of /api/ask.
+func (c *OpenAIClient) GenerateCypher(ctx context.Context, schema string, question string, feedback string) (string, error) {
+	prompt := fmt.Sprintf(`
+		You translate questions about a distributed trace into Cypher queries for Neo4j.
+		Only use the node labels, relationships and properties of the schema below. The schema is delimited by <schema></schema>.
+		The query must only read, never create, merge, set, delete or remove anything, and must not call procedures.
+		The query must be scoped to the trace by matching (t: Trace {trace_id: $trace_id})-[:CONTAINS]->(s: Span).
+		Every other node must be reached through a path from a node of the trace, a variable dropped or computed by WITH cannot be matched again.
+		Return only the columns needed to answer the question and use aliases that explain them.
+		Respond with the query alone in the query field, without the <query></query> delimiters of the examples and without explanation.
+
+		<schema>
+		%s
+		</schema>
+
+		Some examples are below. The question is delimited by <question></question>, the query is delimited by <query></query>.
+
 		<question>
-		Why did the money transfer failed?
+		How many spans did redis produce in this trace?
 		</question>
-		<explanation>
-		The passage says that "It failed because exchange market is closed today.". 
-		</<explanation>
-		<answer>
-		An error occurred in currency service because the exchange market is closed.
-		</answer>	
+		<query>
+		MATCH (t: Trace {trace_id: $trace_id})-[:CONTAINS]->(s: Span)<-[:CONTAINS]-(service: Service {name: 'redis'})
+		RETURN count(s) AS redis_spans
+		</query>
 
-		<question> 
-		Why did the database connection shutdown?
+		<question>
+		List the distinct operations invoked by /dispatch
 		</question>
-		<explanation>
-		Database connection shutdown is not mentioned anywhere in the passage.
-		</explanation>
-		<answer>
-		Insufficient Information
-		</answer>
+		<query>
+		MATCH (t: Trace {trace_id: $trace_id})-[:CONTAINS]->(parent: Span {operation_name: '/dispatch'})-[:INVOKES_CHILD]->(child: Span)
+		RETURN DISTINCT child.operation_name AS operation
+		</query>
 
-		<question> 
-		Which span did the market closure occurred in?
+		<question>
+		Which span took the longest?
 		</question>
-		<explanation>
-		The passage mentions "Span ID: 03" where "It failed because exchange market is closed today.".
-		</explanation>
-		<answer>
-		Span ID 03
-		</answer>
+		<query>
+		MATCH (t: Trace {trace_id: $trace_id})-[:CONTAINS]->(s: Span)
+		RETURN s.span_id AS span_id, s.operation_name AS operation, s.duration AS duration_ns
+		ORDER BY s.duration DESC
+		LIMIT 1
+		</query>
 
 		<question>
-		What is the operation name invoked by operation initiate-transfer? 
+		How often did each log message appear in this trace?
 		</question>
-		<explanation>
-		operation "initiate-transfer" is in Span ID: 01 which invokes Span ID: 02 which is wallet-processor and Span ID: 04 which is get-customer.
-		</explanation>
-		<answer>
-		wallet-processor and get-customer.
-		</answer>
-	`
+		<query>
+		MATCH (t: Trace {trace_id: $trace_id})-[:CONTAINS]->(s: Span)-[:PRODUCES]->(l: Log)-[:INSTANCE_OF]->(template: LogTemplate)
+		RETURN template.template AS message, count(l) AS occurrences
+		ORDER BY occurrences DESC
+		</query>
+`, schema)
+
+	question = c.redactor.Redact(ctx, "cypher_question", question)
+	user := fmt.Sprintf(`
+		<question>
+		%s
+		</question>
+	`, question)
+	if feedback != "" {
+		user += "\n" + feedback + "\nWrite a corrected query."
+	}
 
-	if method == "naive-rag" {
-		prompt = `
-		You need to provide a factual answer based on the given question and passage. Use the passage to answer the question.
-		If you believe the question cannot be answered from the given passage return the phrase "Insufficient Information". Keep the answer concise and specific. Do not include redundant information.
-	`
+	result, err := completeStructured[CypherQuery](ctx, c, "cypher_query", openai.GPT4oMini20240718, []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: prompt,
+		},
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: user,
+		},
+	})
+	if err != nil {
+		log.Println("[GenerateCypher] an error occurred", err)
+		return "", err
 	}
+	return result.Query, nil
+}
 
+// ExplainComparison explains in plain language how a trace differs from its baseline, used by /api/compare.
+func (c *OpenAIClient) ExplainComparison(ctx context.Context, comparison string) (string, error) {
+	prompt := `
+		You are to help a software engineer troubleshoot a distributed system. You are given the differences between a trace and a baseline,
+		usually a trace of the same request that worked or was fast. The differences are delimited by <comparison></comparison>.
+		Explain in plain language what changed and what most likely caused the target trace to behave differently, starting with the most likely cause.
+		Refer to spans by their service, operation and span ID. Only use the given differences, do not make up calls or errors.
+		If nothing significant changed, say so. Keep the explanation short, brief, and specific.
+`
+	comparison = c.redactor.Redact(ctx, "explain_comparison", comparison)
 	user := fmt.Sprintf(`
-		Keep the answer short, brief, and specific. If asked for a count return the number. Do not include redundant information.
+		<comparison>
+		%s
+		</comparison>
+	`, comparison)
+
+	res, err := c.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: c.model(openai.GPT4oMini20240718),
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: prompt,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: user,
+			},
+		},
+		Temperature: 0,
+	})
 
-		<question> 
-		%s 
-		</question>	
-		<passage> 
-		%s 
-		</passage>
-	`, query, passage)
+	if err != nil {
+		log.Println("[ExplainComparison] an error occurred", err)
+		return "", err
+	}
+	c.recordUsage(ctx, res.Model, res.Usage)
+
+	return strings.TrimSpace(res.Choices[0].Message.Content), nil
+}
+
+// DescribeIncident writes a short title and a description of an incident from the description of its error clusters
+func (c *OpenAIClient) DescribeIncident(ctx context.Context, clusters string) (string, string, error) {
+	prompt := `
+		You are to help a software engineer troubleshoot a distributed system. You are given clusters of similar errors
+		that happened in the same traces, i.e. most likely a single incident. The clusters are delimited by <clusters></clusters>,
+		sorted by number of occurrences. Write a title of at most 10 words naming the failing service and the failure,
+		then a description of a few sentences explaining what fails, where it most likely starts and how it propagates.
+		Only use the given clusters, do not make up services or errors.
+`
+	clusters = c.redactor.Redact(ctx, "describe_incident", clusters)
+	user := fmt.Sprintf(`
+		<clusters>
+		%s
+		</clusters>
+	`, clusters)
+
+	result, err := completeStructured[IncidentDescription](ctx, c, "incident_description", openai.GPT4oMini20240718, []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: prompt,
+		},
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: user,
+		},
+	})
+	if err != nil {
+		log.Println("[DescribeIncident] an error occurred", err)
+		return "", "", err
+	}
+
+	return strings.TrimSpace(result.Title), strings.TrimSpace(result.Description), nil
+}
+
+// ExplainAlert explains to the on-call engineer what an alert means and what to look at first
+func (c *OpenAIClient) ExplainAlert(ctx context.Context, alert string) (string, error) {
+	prompt := `
+		You are to help a software engineer on call for a distributed system. You are given an alert raised from the traces
+		of the system, delimited by <alert></alert>. Explain in two or three sentences what is most likely happening, how
+		it affects the users and what to look at first. Only use the given alert, do not make up services or errors.
+`
+	alert = c.redactor.Redact(ctx, "explain_alert", alert)
+	user := fmt.Sprintf(`
+		<alert>
+		%s
+		</alert>
+	`, alert)
 
 	res, err := c.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: openai.GPT4oMini20240718,
-		//Model: openai.GPT4Turbo,
+		Model: c.model(openai.GPT4oMini20240718),
 		Messages: []openai.ChatCompletionMessage{
 			{
 				Role:    openai.ChatMessageRoleSystem,
@@ -263,9 +375,52 @@ func (c *OpenAIClient) GenerateAnswer(ctx context.Context, query string, passage
 	})
 
 	if err != nil {
-		log.Println("[GenerateAnswer] an error occurred", err)
+		log.Println("[ExplainAlert] an error occurred", err)
 		return "", err
 	}
+	c.recordUsage(ctx, res.Model, res.Usage)
+
+	return strings.TrimSpace(res.Choices[0].Message.Content), nil
+}
 
-	return res.Choices[0].Message.Content, nil
+// JudgeAnswer scores an answer against the reference answer of the question, used by the eval subcommand
+func (c *OpenAIClient) JudgeAnswer(ctx context.Context, question string, reference string, answer string) (Judgement, error) {
+	prompt := `
+		You grade the answers of a question answering system about distributed traces. You are given a question, the
+		reference answer written by an engineer and the answer to grade, delimited by <question></question>,
+		<reference></reference> and <answer></answer>. Score how well the answer matches the reference from 1 to 5:
+		5 the answer is correct and complete, extra correct details are fine,
+		4 the answer is correct with a minor omission or imprecision,
+		3 the answer is partly correct,
+		2 the answer is mostly wrong but related,
+		1 the answer is wrong, unrelated or says there is insufficient information.
+		A different wording of the same facts is correct, e.g. "13 calls" and "13 times". Explain your reasoning before the score.
+`
+	user := fmt.Sprintf(`
+		<question>
+		%s
+		</question>
+		<reference>
+		%s
+		</reference>
+		<answer>
+		%s
+		</answer>
+	`, c.redactor.Redact(ctx, "judge_question", question), c.redactor.Redact(ctx, "judge_reference", reference), c.redactor.Redact(ctx, "judge_answer", answer))
+
+	judgement, err := completeStructured[Judgement](ctx, c, "judgement", openai.GPT4oMini20240718, []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: prompt,
+		},
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: user,
+		},
+	})
+	if err != nil {
+		log.Println("[JudgeAnswer] an error occurred", err)
+		return Judgement{}, err
+	}
+	return judgement, nil
 }
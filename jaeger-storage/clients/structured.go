@@ -0,0 +1,226 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"log"
+	"slices"
+	"strings"
+)
+
+// number of times an invalid response is sent back to the model to be corrected
+const structuredOutputRetries = 2
+
+// InsufficientInformation is the answer when the passage does not answer the question
+const InsufficientInformation = "Insufficient Information"
+
+const (
+	// StructuredOutputJSONSchema constrains the response to the JSON schema of the result, OpenAI structured outputs.
+	// Local OpenAI compatible servers such as llama.cpp or Ollama enforce it with a grammar.
+	StructuredOutputJSONSchema = "json_schema"
+	// StructuredOutputJSONObject only guarantees a JSON object, the schema is given in the prompt and checked after
+	StructuredOutputJSONObject = "json_object"
+)
+
+type structuredResult interface {
+	validate() error
+}
+
+type SpanSummary struct {
+	Summary string `json:"summary" description:"summary of the span"`
+}
+
+func (s SpanSummary) validate() error {
+	if strings.TrimSpace(s.Summary) == "" {
+		return errors.New("summary is empty")
+	}
+	return nil
+}
+
+type LogSummary struct {
+	Empty   bool   `json:"empty" description:"true when no logs were given"`
+	Summary string `json:"summary" description:"summary of the logs, empty when no logs were given"`
+}
+
+func (s LogSummary) validate() error {
+	if !s.Empty && strings.TrimSpace(s.Summary) == "" {
+		return errors.New("summary is empty although empty is false")
+	}
+	return nil
+}
+
+type Answer struct {
+	Answer string `json:"answer" description:"the answer, empty when the passage does not answer the question"`
+	// false when the passage does not answer the question
+	Sufficient bool `json:"sufficient" description:"false when the passage does not answer the question"`
+}
+
+func (a Answer) validate() error {
+	if a.Sufficient && strings.TrimSpace(a.Answer) == "" {
+		return errors.New("answer is empty although sufficient is true")
+	}
+	return nil
+}
+
+// ParseAnswer reads an answer written as the JSON of Answer, e.g. the final answer of the agent through ChatWithTools.
+// The answer is InsufficientInformation when the model does not find it sufficient.
+func ParseAnswer(content string) (string, error) {
+	schema, err := jsonschema.GenerateSchemaForType(Answer{})
+	if err != nil {
+		return "", err
+	}
+	answer, err := parseStructured[Answer](*schema, content)
+	if err != nil {
+		return "", err
+	}
+	if !answer.Sufficient {
+		return InsufficientInformation, nil
+	}
+	return strings.TrimSpace(answer.Answer), nil
+}
+
+type CypherQuery struct {
+	Query string `json:"query" description:"the Cypher query alone, without delimiters or explanation"`
+}
+
+func (q CypherQuery) validate() error {
+	if strings.TrimSpace(q.Query) == "" {
+		return errors.New("query is empty")
+	}
+	return nil
+}
+
+type IncidentDescription struct {
+	Title       string `json:"title" description:"at most 10 words naming the failing service and the failure"`
+	Description string `json:"description" description:"a few sentences explaining what fails, where it most likely starts and how it propagates"`
+}
+
+func (d IncidentDescription) validate() error {
+	if strings.TrimSpace(d.Title) == "" || strings.TrimSpace(d.Description) == "" {
+		return errors.New("title and description must be set")
+	}
+	return nil
+}
+
+type Judgement struct {
+	Reasoning string `json:"reasoning" description:"why the answer is or is not correct, compared to the reference"`
+	Score     int    `json:"score" description:"1 when the answer is wrong or missing, 5 when it is fully correct and complete"`
+}
+
+func (j Judgement) validate() error {
+	if j.Score < 1 || j.Score > 5 {
+		return fmt.Errorf("score %d is not between 1 and 5", j.Score)
+	}
+	return nil
+}
+
+// Normalized maps the score between 0 and 1
+func (j Judgement) Normalized() float64 {
+	return float64(j.Score-1) / 4
+}
+
+// structuredOutput is the response format of the model, the models without structured outputs fall back to JSON mode
+func (c *OpenAIClient) structuredOutput(model string) string {
+	if c.structuredOutputFormat != "" {
+		return c.structuredOutputFormat
+	}
+	if strings.HasPrefix(model, "gpt-3.5") {
+		return StructuredOutputJSONObject
+	}
+	return StructuredOutputJSONSchema
+}
+
+// completeStructured asks the model for a JSON result of type T. A response that does not parse or validate is repaired
+// when it only wraps the JSON in text or a code block, otherwise it is sent back to the model with the error.
+func completeStructured[T structuredResult](ctx context.Context, c *OpenAIClient, name string, model string, messages []openai.ChatCompletionMessage) (T, error) {
+	var result T
+	request := openai.ChatCompletionRequest{
+		Model:       c.model(model),
+		Messages:    slices.Clone(messages),
+		Temperature: 0,
+	}
+	schema, err := requestStructured[T](c, &request, name)
+	if err != nil {
+		return result, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := c.client.CreateChatCompletion(ctx, request)
+		if err != nil {
+			log.Printf("[%s] an error occurred %v\n", name, err)
+			return result, err
+		}
+		c.recordUsage(ctx, res.Model, res.Usage)
+
+		content := res.Choices[0].Message.Content
+		result, err = parseStructured[T](*schema, content)
+		if err == nil {
+			return result, nil
+		}
+		log.Printf("[%s][error] invalid response on attempt %d: %v\n", name, attempt+1, err)
+		if attempt == structuredOutputRetries {
+			return result, fmt.Errorf("no valid %s after %d attempts: %w", name, attempt+1, err)
+		}
+		request.Messages = append(request.Messages,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+			openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: fmt.Sprintf("The response is invalid: %v. Respond again with only the corrected JSON object.", err),
+			},
+		)
+	}
+}
+
+// requestStructured sets the response format of the request to the JSON of T, in JSON mode the schema is appended
+// to the messages. It returns the schema the response is parsed with.
+func requestStructured[T structuredResult](c *OpenAIClient, request *openai.ChatCompletionRequest, name string) (*jsonschema.Definition, error) {
+	var result T
+	schema, err := jsonschema.GenerateSchemaForType(result)
+	if err != nil {
+		return nil, err
+	}
+	switch c.structuredOutput(request.Model) {
+	case StructuredOutputJSONObject:
+		schemaJson, err := json.Marshal(schema)
+		if err != nil {
+			return nil, err
+		}
+		request.Messages = append(request.Messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: fmt.Sprintf("Respond with a JSON object matching this JSON schema, and nothing else: %s", schemaJson),
+		})
+		request.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	default:
+		request.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:   name,
+				Schema: schema,
+				Strict: true,
+			},
+		}
+	}
+	return schema, nil
+}
+
+func parseStructured[T structuredResult](schema jsonschema.Definition, content string) (T, error) {
+	var result T
+	if err := schema.Unmarshal(repairJSON(content), &result); err != nil {
+		return result, err
+	}
+	return result, result.validate()
+}
+
+// repairJSON removes what models commonly put around a JSON object, e.g. a markdown code block or an introduction
+func repairJSON(content string) string {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return content
+	}
+	return content[start : end+1]
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+const (
+	TagPropertyPrefix      = "tag_"
+	LogFieldPropertyPrefix = "field_"
+)
+
+// AttributePropertyName turns a tag or log field key into a neo4j property name,
+// e.g. http.status_code becomes tag_http_status_code.
+func AttributePropertyName(prefix string, key string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, r := range key {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// RedactFunc returns the value of a tag or log field with its sensitive parts replaced.
+type RedactFunc func(key string, value string) string
+
+// attributePropertyNames names the properties of the keys. Distinct keys that end up with the same name,
+// e.g. http.status_code and http_status_code, would overwrite each other, so the key that is not a valid name
+// already gets a hash of itself appended.
+func attributePropertyNames(prefix string, keys []string) []string {
+	names := make([]string, len(keys))
+	keysByName := make(map[string]map[string]bool, len(keys))
+	for i, k := range keys {
+		names[i] = AttributePropertyName(prefix, k)
+		if keysByName[names[i]] == nil {
+			keysByName[names[i]] = make(map[string]bool)
+		}
+		keysByName[names[i]][k] = true
+	}
+	for i, k := range keys {
+		if len(keysByName[names[i]]) > 1 && names[i] != prefix+k {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(k))
+			names[i] = fmt.Sprintf("%s_%08x", names[i], h.Sum32())
+		}
+	}
+	return names
+}
+
+// redactValue runs strings and integers, which may hold card numbers, through redact. A redacted value is
+// stored as the redacted string.
+func redactValue(key string, value any, redact RedactFunc) any {
+	switch v := value.(type) {
+	case string:
+		return redact(key, v)
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		if redacted := redact(key, s); redacted != s {
+			return redacted
+		}
+	}
+	return value
+}
+
+// TagProperties keeps the typed tag values so they can be filtered on in cypher. The values are redacted
+// like the summaries, as the properties are returned by text2cypher and the graph exports.
+func TagProperties(tags []model.KeyValue, redact RedactFunc) map[string]any {
+	keys := make([]string, len(tags))
+	for i, t := range tags {
+		keys[i] = t.Key
+	}
+	names := attributePropertyNames(TagPropertyPrefix, keys)
+	properties := make(map[string]any, len(tags))
+	for i, t := range tags {
+		properties[names[i]] = redactValue(t.Key, EncodeKeyValue(t).Value, redact)
+	}
+	return properties
+}
+
+// LogFieldProperties keeps the typed log field values so they can be filtered on in cypher, redacted as the tags.
+func LogFieldProperties(fields []InternalKeyValue, redact RedactFunc) map[string]any {
+	keys := make([]string, len(fields))
+	for i, f := range fields {
+		keys[i] = f.Key
+	}
+	names := attributePropertyNames(LogFieldPropertyPrefix, keys)
+	properties := make(map[string]any, len(fields))
+	for i, f := range fields {
+		properties[names[i]] = redactValue(f.Key, f.Value, redact)
+	}
+	return properties
+}
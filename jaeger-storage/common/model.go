@@ -78,6 +78,7 @@ type InternalSpan struct {
 	Logs      []byte     `db:"logs"`
 	Kind      string     `db:"kind"`
 	Refs      []byte     `db:"refs"`
+	Imported  bool       `db:"imported"`
 	CreatedAt time.Time  `db:"created_at"`
 	DeletedAt *time.Time `db:"deleted_at"`
 }
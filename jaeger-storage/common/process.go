@@ -0,0 +1,98 @@
+package common
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/jaegertracing/jaeger/model"
+	"sort"
+)
+
+// ProcessInfo is the infrastructure a span ran on, taken from the process tags of
+// the jaeger clients (hostname, ip, client-uuid) and the OpenTelemetry resource attributes.
+type ProcessInfo struct {
+	Key         string
+	ServiceName string
+	Hostname    string
+	Ip          string
+	ClientUuid  string
+	Pid         string
+	SdkLanguage string
+	SdkName     string
+	SdkVersion  string
+	OsType      string
+	PodName     string
+	Namespace   string
+	NodeName    string
+	ContainerId string
+}
+
+func ExtractProcessInfo(process *model.Process) ProcessInfo {
+	tags := make(map[string]string, len(process.GetTags()))
+	for _, t := range process.GetTags() {
+		tags[t.Key] = t.AsString()
+	}
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := tags[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	info := ProcessInfo{
+		ServiceName: process.GetServiceName(),
+		Hostname:    first("host.name", "hostname"),
+		Ip:          first("ip", "host.ip"),
+		ClientUuid:  first("client-uuid", "service.instance.id"),
+		Pid:         first("process.pid"),
+		SdkLanguage: first("telemetry.sdk.language"),
+		SdkName:     first("telemetry.sdk.name"),
+		SdkVersion:  first("telemetry.sdk.version", "jaeger.version"),
+		OsType:      first("os.type"),
+		PodName:     first("k8s.pod.name"),
+		Namespace:   first("k8s.namespace.name"),
+		NodeName:    first("k8s.node.name"),
+		ContainerId: first("container.id"),
+	}
+	if info.SdkName == "" && tags["jaeger.version"] != "" {
+		info.SdkName = "jaeger"
+	}
+
+	// processes of the same service with identical tags are the same process
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha1.New()
+	h.Write([]byte(info.ServiceName))
+	for _, k := range keys {
+		h.Write([]byte("|" + k + "=" + tags[k]))
+	}
+	info.Key = hex.EncodeToString(h.Sum(nil))
+
+	return info
+}
+
+// Properties returns the non-empty process attributes as neo4j properties.
+func (p ProcessInfo) Properties() map[string]any {
+	properties := map[string]any{
+		"service_name": p.ServiceName,
+		"hostname":     p.Hostname,
+		"ip":           p.Ip,
+		"client_uuid":  p.ClientUuid,
+		"pid":          p.Pid,
+		"sdk_language": p.SdkLanguage,
+		"sdk_name":     p.SdkName,
+		"sdk_version":  p.SdkVersion,
+		"os_type":      p.OsType,
+		"container_id": p.ContainerId,
+	}
+	for k, v := range properties {
+		if v == "" {
+			delete(properties, k)
+		}
+	}
+	return properties
+}
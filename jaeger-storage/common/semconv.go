@@ -0,0 +1,156 @@
+package common
+
+import (
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	ActionKindHttp      = "http"
+	ActionKindDb        = "db"
+	ActionKindRpc       = "rpc"
+	ActionKindMessaging = "messaging"
+	ActionKindFaas      = "faas"
+	ActionKindInternal  = "internal"
+)
+
+// SpanAction is what a span does according to the OpenTelemetry semantic conventions
+// see: https://opentelemetry.io/docs/specs/semconv/general/trace/
+type SpanAction struct {
+	Kind string
+	// key attributes of the action, the keys are valid neo4j property names e.g. http_method
+	Attributes map[string]any
+}
+
+var sqlTableRegex = regexp.MustCompile(`(?i)\b(?:from|into|update|join|table)\s+["'\x60]?([A-Za-z_][\w.]*)`)
+
+// well known databases that can be recognised from the service name when the span carries no db.* tags
+var knownDbSystems = []string{"redis", "mysql", "postgres", "mongodb", "cassandra", "memcached", "elasticsearch"}
+
+// ClassifySpan infers the action kind of a span from its tags.
+func ClassifySpan(span *model.Span) SpanAction {
+	tags := make(map[string]model.KeyValue, len(span.Tags))
+	for _, t := range span.Tags {
+		tags[t.Key] = t
+	}
+	get := func(key string) (string, bool) {
+		t, ok := tags[key]
+		if !ok {
+			return "", false
+		}
+		return t.AsString(), true
+	}
+	hasPrefix := func(prefix string) bool {
+		for k := range tags {
+			if strings.HasPrefix(k, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	attributes := make(map[string]any)
+	copyTag := func(property string, keys ...string) {
+		for _, key := range keys {
+			t, ok := tags[key]
+			if !ok {
+				continue
+			}
+			attributes[property] = t.Value()
+			return
+		}
+	}
+
+	_, hasDbSystem := get("db.system")
+	_, hasSqlQuery := get("sql.query")
+	_, hasDbStatement := get("db.statement")
+	switch {
+	case hasDbSystem || hasSqlQuery || hasDbStatement || hasPrefix("db."):
+		copyTag("db_system", "db.system")
+		copyTag("db_name", "db.name", "db.namespace")
+		copyTag("db_operation", "db.operation", "db.operation.name")
+		copyTag("db_statement", "db.statement", "db.query.text", "sql.query")
+		copyTag("db_table", "db.sql.table", "db.collection.name", "db.mongodb.collection")
+		if _, ok := attributes["db_system"]; !ok {
+			if system, ok := inferDbSystem(span); ok {
+				attributes["db_system"] = system
+			} else if hasSqlQuery {
+				attributes["db_system"] = "sql"
+			}
+		}
+		if _, ok := attributes["db_table"]; !ok {
+			if statement, ok := attributes["db_statement"].(string); ok {
+				if m := sqlTableRegex.FindStringSubmatch(statement); m != nil {
+					attributes["db_table"] = m[1]
+				}
+			}
+		}
+		return SpanAction{Kind: ActionKindDb, Attributes: attributes}
+	case hasPrefix("rpc."):
+		copyTag("rpc_system", "rpc.system")
+		copyTag("rpc_service", "rpc.service")
+		copyTag("rpc_method", "rpc.method")
+		copyTag("rpc_status_code", "rpc.grpc.status_code")
+		return SpanAction{Kind: ActionKindRpc, Attributes: attributes}
+	case hasPrefix("messaging."):
+		copyTag("messaging_system", "messaging.system")
+		copyTag("messaging_operation", "messaging.operation", "messaging.operation.type")
+		copyTag("messaging_destination", "messaging.destination.name", "messaging.destination", "messaging.kafka.topic")
+		return SpanAction{Kind: ActionKindMessaging, Attributes: attributes}
+	case hasPrefix("faas."):
+		copyTag("faas_name", "faas.name", "faas.invoked_name")
+		copyTag("faas_trigger", "faas.trigger")
+		copyTag("faas_provider", "faas.invoked_provider", "cloud.provider")
+		return SpanAction{Kind: ActionKindFaas, Attributes: attributes}
+	case hasPrefix("http."):
+		copyTag("http_method", "http.method", "http.request.method")
+		copyTag("http_route", "http.route")
+		copyTag("http_target", "http.target", "url.path")
+		copyTag("http_url", "http.url", "url.full")
+		copyTag("http_status_code", "http.status_code", "http.response.status_code")
+		return SpanAction{Kind: ActionKindHttp, Attributes: attributes}
+	}
+
+	if system, ok := inferDbSystem(span); ok {
+		attributes["db_system"] = system
+		return SpanAction{Kind: ActionKindDb, Attributes: attributes}
+	}
+
+	return SpanAction{Kind: ActionKindInternal, Attributes: attributes}
+}
+
+// inferDbSystem recognises database spans that only carry the database in their service name
+// or peer.service, e.g. the redis spans of HotROD.
+func inferDbSystem(span *model.Span) (string, bool) {
+	candidates := []string{span.Process.GetServiceName()}
+	if t, ok := model.KeyValues(span.Tags).FindByKey("peer.service"); ok {
+		candidates = append(candidates, t.AsString())
+	}
+	for _, c := range candidates {
+		c = strings.ToLower(c)
+		for _, system := range knownDbSystems {
+			if strings.Contains(c, system) {
+				return system, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Describe renders the action attributes as "key: value" lines, sorted by key.
+func (a SpanAction) Describe() string {
+	keys := make([]string, 0, len(a.Attributes))
+	for k := range a.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var description string
+	for _, k := range keys {
+		description += fmt.Sprintf("%s: %v\n", strings.ReplaceAll(k, "_", " "), a.Attributes[k])
+	}
+	return description
+}
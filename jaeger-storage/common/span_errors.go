@@ -0,0 +1,237 @@
+package common
+
+import (
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"strings"
+	"time"
+)
+
+const (
+	SpanStatusOk      = "OK"
+	SpanStatusWarning = "WARNING"
+	SpanStatusError   = "ERROR"
+)
+
+const (
+	ErrorSourceException = "exception"
+	ErrorSourceLog       = "log"
+	ErrorSourceStatus    = "status"
+	ErrorSourceHttp      = "http"
+	ErrorSourceGrpc      = "grpc"
+)
+
+type SpanError struct {
+	// normalized error type, e.g. ConnectionRefused or Timeout
+	Type string
+	// type reported by the instrumentation, e.g. *net.OpError
+	RawType    string
+	Message    string
+	Stacktrace string
+	Source     string
+	Timestamp  time.Time
+}
+
+type SpanErrorReport struct {
+	Status   string
+	Errors   []SpanError
+	Warnings []string
+}
+
+// Primary returns the error that best describes why the span failed.
+func (r SpanErrorReport) Primary() (SpanError, bool) {
+	if len(r.Errors) == 0 {
+		return SpanError{}, false
+	}
+	return r.Errors[0], true
+}
+
+// see: https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+var grpcStatusCodes = map[int64]string{
+	1:  "CANCELLED",
+	2:  "UNKNOWN",
+	3:  "INVALID_ARGUMENT",
+	4:  "DEADLINE_EXCEEDED",
+	5:  "NOT_FOUND",
+	6:  "ALREADY_EXISTS",
+	7:  "PERMISSION_DENIED",
+	8:  "RESOURCE_EXHAUSTED",
+	9:  "FAILED_PRECONDITION",
+	10: "ABORTED",
+	11: "OUT_OF_RANGE",
+	12: "UNIMPLEMENTED",
+	13: "INTERNAL",
+	14: "UNAVAILABLE",
+	15: "DATA_LOSS",
+	16: "UNAUTHENTICATED",
+}
+
+// message fragments that map to a normalized error type, checked in order
+var errorTypePatterns = []struct {
+	fragment  string
+	errorType string
+}{
+	{"connection refused", "ConnectionRefused"},
+	{"connection reset", "ConnectionReset"},
+	{"no such host", "DnsFailure"},
+	{"deadline exceeded", "Timeout"},
+	{"timed out", "Timeout"},
+	{"timeout", "Timeout"},
+	{"context canceled", "Canceled"},
+	{"unauthorized", "Unauthorized"},
+	{"unauthenticated", "Unauthorized"},
+	{"permission denied", "PermissionDenied"},
+	{"forbidden", "PermissionDenied"},
+	{"not found", "NotFound"},
+	{"unavailable", "Unavailable"},
+	{"out of memory", "OutOfMemory"},
+	{"null pointer", "NullPointer"},
+	{"nil pointer", "NullPointer"},
+}
+
+// ClassifySpanErrors combines the error signals of a span: the otel status, the jaeger error tag,
+// HTTP and gRPC status codes, exception events and error logs.
+func ClassifySpanErrors(span *model.Span) SpanErrorReport {
+	report := SpanErrorReport{Status: SpanStatusOk, Warnings: span.Warnings}
+	failed := false
+	statusError := SpanError{Source: ErrorSourceStatus, Timestamp: span.StartTime}
+
+	for _, t := range span.Tags {
+		switch t.Key {
+		case "otel.status_code":
+			if t.AsString() == "ERROR" {
+				failed = true
+			}
+		case "otel.status_description":
+			statusError.Message = t.AsString()
+		case "error":
+			if t.AsString() == "true" {
+				failed = true
+			}
+		case "http.status_code", "http.response.status_code":
+			code := tagInt64(t)
+			if code >= 500 {
+				failed = true
+				statusError.Source = ErrorSourceHttp
+				statusError.RawType = fmt.Sprintf("HTTP %d", code)
+			}
+		case "rpc.grpc.status_code":
+			code := tagInt64(t)
+			if name, ok := grpcStatusCodes[code]; ok {
+				failed = true
+				statusError.Source = ErrorSourceGrpc
+				statusError.RawType = name
+			}
+		}
+	}
+
+	// exceptions are collected before error logs, which often repeat the exception message
+	logFields := make([]map[string]string, len(span.Logs))
+	for i, l := range span.Logs {
+		fields := make(map[string]string, len(l.Fields))
+		for _, f := range l.Fields {
+			fields[f.Key] = f.AsString()
+		}
+		logFields[i] = fields
+
+		if fields["event"] == "exception" || fields["exception.type"] != "" || fields["exception.message"] != "" {
+			failed = true
+			report.Errors = append(report.Errors, newSpanError(ErrorSourceException, fields["exception.type"], fields["exception.message"], fields["exception.stacktrace"], l.Timestamp))
+		}
+	}
+
+	for i, fields := range logFields {
+		if !strings.EqualFold(fields["level"], "error") {
+			continue
+		}
+		failed = true
+		message := fields["error"]
+		if message == "" {
+			message = fields["event"]
+		}
+		if !report.hasMessage(message) {
+			report.Errors = append(report.Errors, newSpanError(ErrorSourceLog, "", message, fields["stack"], span.Logs[i].Timestamp))
+		}
+	}
+
+	if failed {
+		report.Status = SpanStatusError
+		if len(report.Errors) == 0 {
+			report.Errors = append(report.Errors, newSpanError(statusError.Source, statusError.RawType, statusError.Message, "", statusError.Timestamp))
+		}
+	} else if len(span.Warnings) > 0 {
+		report.Status = SpanStatusWarning
+	}
+
+	return report
+}
+
+func (r SpanErrorReport) hasMessage(message string) bool {
+	for _, e := range r.Errors {
+		if e.Message == message {
+			return true
+		}
+	}
+	return false
+}
+
+func newSpanError(source, rawType, message, stacktrace string, timestamp time.Time) SpanError {
+	return SpanError{
+		Type:       normalizeErrorType(rawType, message),
+		RawType:    rawType,
+		Message:    message,
+		Stacktrace: stacktrace,
+		Source:     source,
+		Timestamp:  timestamp,
+	}
+}
+
+func normalizeErrorType(rawType, message string) string {
+	text := strings.ToLower(rawType + " " + message)
+	for _, p := range errorTypePatterns {
+		if strings.Contains(text, p.fragment) {
+			return p.errorType
+		}
+	}
+
+	if rawType != "" && !isGenericErrorType(rawType) {
+		// *net.OpError -> OpError
+		rawType = strings.TrimLeft(rawType, "*")
+		if i := strings.LastIndexAny(rawType, "./"); i >= 0 {
+			rawType = rawType[i+1:]
+		}
+		return rawType
+	}
+
+	return "Unknown"
+}
+
+// types that do not tell anything about the error, e.g. the ones created by errors.New in go
+func isGenericErrorType(rawType string) bool {
+	switch strings.TrimLeft(rawType, "*") {
+	case "errors.errorString", "fmt.wrapError", "errors.joinError", "Error", "Exception", "error":
+		return true
+	}
+	return false
+}
+
+func tagInt64(t model.KeyValue) int64 {
+	switch t.VType {
+	case model.ValueType_INT64:
+		return t.VInt64
+	case model.ValueType_FLOAT64:
+		return int64(t.VFloat64)
+	}
+	var v int64
+	_, _ = fmt.Sscan(t.AsString(), &v)
+	return v
+}
+
+// Describe renders the status and primary error as "key: value" lines.
+func (r SpanErrorReport) Describe() string {
+	description := fmt.Sprintf("status: %s\n", r.Status)
+	if e, ok := r.Primary(); ok {
+		description += fmt.Sprintf("error type: %s\nerror message: %s\n", e.Type, e.Message)
+	}
+	return description
+}
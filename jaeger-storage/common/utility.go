@@ -174,3 +174,11 @@ func WriteToFile(filename string, content string) error {
 
 	return nil
 }
+
+// NilIfEmpty avoids storing empty strings in neo4j, setting a property to null removes it
+func NilIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
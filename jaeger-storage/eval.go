@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"jaeger-storage/alerting"
+	"jaeger-storage/evaluation"
+	"jaeger-storage/traceio"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+)
+
+// runEval evaluates the methods of /api/ask on a dataset of questions with reference answers, against an in-process
+// router using the configured databases and OpenAI
+func runEval(args []string) {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	datasetPath := flags.String("dataset", "../eval/dataset.yaml", "YAML dataset of traces, questions and reference answers")
+	out := flags.String("out", "eval-report", "directory report.json and report.md are written to")
+	methods := flags.String("methods", "", "comma separated names of the methods to evaluate, all methods of the dataset by default")
+	judge := flags.Bool("judge", true, "score the answers with an LLM judge")
+	_ = flags.Parse(args)
+
+	dataset, err := evaluation.LoadDataset(*datasetPath)
+	if err != nil {
+		log.Fatalln("[eval] cannot load dataset", err)
+	}
+	if *methods != "" {
+		if err := dataset.Select(strings.Split(*methods, ",")); err != nil {
+			log.Fatalln("[eval] invalid methods", err)
+		}
+	}
+
+	deps, err := newDependencies()
+	if err != nil {
+		log.Fatalln("[eval] cannot initialize", err)
+	}
+	ctx := context.Background()
+
+	// alerting is not configured, the spans of the fixtures must not page anyone
+	alerter := alerting.NewAlerter(alerting.Config{}, deps.openaiClient, deps.usageTracker)
+	spanWriter, err := createSpanWriter(deps.db, deps.neo4jDriver, deps.openaiClient, deps.usageTracker, deps.redactor, alerter)
+	if err != nil {
+		log.Fatalln("[eval] cannot create span writer", err)
+	}
+	spanReader := NewReaderDBClient(deps.db)
+	for _, trace := range dataset.Traces {
+		if err := ingestFixture(ctx, spanReader, spanWriter, trace); err != nil {
+			log.Fatalln("[eval] cannot ingest fixture of trace", trace.TraceId, err)
+		}
+	}
+
+	router := NewRouter(deps.openaiClient, deps.neo4jDriver, deps.db, deps.usageTracker, spanWriter)
+	report := evaluation.NewRunner(askRouter(router), deps.openaiClient, *judge).Run(ctx, dataset)
+	report.Dataset = *datasetPath
+	if err := report.Write(*out); err != nil {
+		log.Fatalln("[eval] cannot write report", err)
+	}
+	log.Println("[eval] wrote report.json and report.md to", *out)
+}
+
+// ingestFixture writes the spans of the fixture of the trace unless the trace is already stored
+func ingestFixture(ctx context.Context, spanReader *ReaderDbClient, spanWriter *WriterClient, trace evaluation.TraceCase) error {
+	if trace.Fixture == "" {
+		return nil
+	}
+	traceId, err := model.TraceIDFromString(trace.TraceId)
+	if err != nil {
+		return err
+	}
+	stored, err := traceStored(ctx, spanReader, traceId)
+	if err != nil {
+		return err
+	}
+	if stored {
+		log.Printf("[eval][ingestFixture] trace %s is already stored, skipping %s\n", trace.TraceId, trace.Fixture)
+		return nil
+	}
+
+	f, err := os.Open(trace.Fixture)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	spans, _, err := traceio.Read(f)
+	if err != nil {
+		return err
+	}
+	written := 0
+	for _, span := range spans {
+		if span.TraceID != traceId {
+			continue
+		}
+		if err := spanWriter.ImportSpan(ctx, span, ImportSummarizationPolicy); err != nil {
+			return err
+		}
+		written++
+	}
+	if written == 0 {
+		return fmt.Errorf("the fixture %s has no span of the trace", trace.Fixture)
+	}
+	log.Printf("[eval][ingestFixture] ingested %d spans of trace %s from %s\n", written, trace.TraceId, trace.Fixture)
+	return nil
+}
+
+// askRouter sends the questions to /api/ask of the router without going through the network
+func askRouter(router http.Handler) evaluation.Asker {
+	return func(ctx context.Context, req evaluation.AskRequest) (evaluation.AskResponse, error) {
+		body, err := json.Marshal(req)
+		if err != nil {
+			return evaluation.AskResponse{}, err
+		}
+		httpReq := httptest.NewRequest(http.MethodPost, "/api/ask", bytes.NewReader(body)).WithContext(ctx)
+		httpReq.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httpReq)
+		if recorder.Code != http.StatusOK {
+			return evaluation.AskResponse{}, fmt.Errorf("/api/ask returned status %d: %s", recorder.Code, strings.TrimSpace(recorder.Body.String()))
+		}
+		res := evaluation.AskResponse{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &res); err != nil {
+			return evaluation.AskResponse{}, err
+		}
+		return res, nil
+	}
+}
@@ -0,0 +1,109 @@
+package evaluation
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Method is a configuration of /api/ask to evaluate
+type Method struct {
+	// name of the configuration in the report, e.g. graph-rag-hop-2
+	Name     string `yaml:"name" json:"name"`
+	Method   string `yaml:"method" json:"method"`
+	Hop      int    `yaml:"hop" json:"hop,omitempty"`
+	Strategy string `yaml:"strategy" json:"strategy,omitempty"`
+	TopK     int    `yaml:"top_k" json:"top_k,omitempty"`
+}
+
+type Question struct {
+	Question  string `yaml:"question"`
+	Reference string `yaml:"reference"`
+}
+
+// TraceCase is a trace and the questions asked about it
+type TraceCase struct {
+	TraceId string `yaml:"trace_id"`
+	// Jaeger UI or OTLP JSON file of the trace, ingested when the trace is not stored yet. Relative to the dataset file.
+	Fixture   string     `yaml:"fixture"`
+	Questions []Question `yaml:"questions"`
+}
+
+type Dataset struct {
+	// evaluated configurations, DefaultMethods when empty
+	Methods []Method    `yaml:"methods"`
+	Traces  []TraceCase `yaml:"traces"`
+}
+
+// DefaultMethods are graph-rag at 0 to 3 hops, naive-rag, text2cypher and agent
+func DefaultMethods() []Method {
+	return []Method{
+		{Name: "graph-rag-hop-0", Method: "graph-rag", Hop: 0},
+		{Name: "graph-rag-hop-1", Method: "graph-rag", Hop: 1},
+		{Name: "graph-rag-hop-2", Method: "graph-rag", Hop: 2},
+		{Name: "graph-rag-hop-3", Method: "graph-rag", Hop: 3},
+		{Name: "graph-rag-adaptive", Method: "graph-rag", Hop: 2, Strategy: "adaptive"},
+		{Name: "naive-rag", Method: "naive-rag", Hop: 3},
+		{Name: "text2cypher", Method: "text2cypher"},
+		{Name: "agent", Method: "agent"},
+	}
+}
+
+// LoadDataset reads the YAML dataset, fixtures are resolved relative to it
+func LoadDataset(path string) (Dataset, error) {
+	dataset := Dataset{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Println("[evaluation][LoadDataset][error] cannot read dataset", path, err)
+		return dataset, err
+	}
+	if err := yaml.Unmarshal(data, &dataset); err != nil {
+		log.Println("[evaluation][LoadDataset][error] cannot parse dataset", path, err)
+		return dataset, err
+	}
+	if len(dataset.Methods) == 0 {
+		dataset.Methods = DefaultMethods()
+	}
+	names := make([]string, 0, len(dataset.Methods))
+	for i, m := range dataset.Methods {
+		if m.Method == "" {
+			return dataset, fmt.Errorf("method %d: method must be set", i)
+		}
+		if m.Name == "" {
+			dataset.Methods[i].Name = m.Method
+		}
+		if slices.Contains(names, dataset.Methods[i].Name) {
+			return dataset, fmt.Errorf("method %d: duplicate name %q", i, dataset.Methods[i].Name)
+		}
+		names = append(names, dataset.Methods[i].Name)
+	}
+	for i, t := range dataset.Traces {
+		if t.TraceId == "" {
+			return dataset, fmt.Errorf("trace %d: trace_id must be set", i)
+		}
+		if t.Fixture != "" && !filepath.IsAbs(t.Fixture) {
+			dataset.Traces[i].Fixture = filepath.Join(filepath.Dir(path), t.Fixture)
+		}
+	}
+	return dataset, nil
+}
+
+// Select keeps the methods with the given names, all of them when names is empty
+func (d *Dataset) Select(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	selected := make([]Method, 0, len(names))
+	for _, name := range names {
+		i := slices.IndexFunc(d.Methods, func(m Method) bool { return m.Name == name })
+		if i < 0 {
+			return fmt.Errorf("unknown method %q", name)
+		}
+		selected = append(selected, d.Methods[i])
+	}
+	d.Methods = selected
+	return nil
+}
@@ -0,0 +1,29 @@
+package evaluation
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// every trace of the dataset is ingested from its fixture, so the evaluation runs on an empty database
+func TestDatasetFixtures(t *testing.T) {
+	dataset, err := LoadDataset("../../eval/dataset.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trace := range dataset.Traces {
+		if trace.Fixture == "" {
+			t.Errorf("trace %s has no fixture", trace.TraceId)
+			continue
+		}
+		data, err := os.ReadFile(trace.Fixture)
+		if err != nil {
+			t.Errorf("cannot read the fixture of trace %s: %v", trace.TraceId, err)
+			continue
+		}
+		if !strings.Contains(string(data), trace.TraceId) {
+			t.Errorf("the fixture %s has no span of trace %s", trace.Fixture, trace.TraceId)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package evaluation
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+var articles = map[string]bool{"a": true, "an": true, "the": true}
+
+// normalize lowercases the text and drops punctuation and articles, as in the SQuAD evaluation
+func normalize(text string) []string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) {
+			return ' '
+		}
+		return unicode.ToLower(r)
+	}, text)
+	tokens := make([]string, 0)
+	for _, t := range strings.Fields(text) {
+		if !articles[t] {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// ExactMatch is 1 when the normalized answer equals the normalized reference
+func ExactMatch(answer string, reference string) float64 {
+	if strings.Join(normalize(answer), " ") == strings.Join(normalize(reference), " ") {
+		return 1
+	}
+	return 0
+}
+
+// TokenF1 is the harmonic mean of the share of answer tokens in the reference and of reference tokens in the answer
+func TokenF1(answer string, reference string) float64 {
+	answerTokens, referenceTokens := normalize(answer), normalize(reference)
+	if len(answerTokens) == 0 || len(referenceTokens) == 0 {
+		if len(answerTokens) == len(referenceTokens) {
+			return 1
+		}
+		return 0
+	}
+	counts := make(map[string]int)
+	for _, t := range referenceTokens {
+		counts[t]++
+	}
+	common := 0
+	for _, t := range answerTokens {
+		if counts[t] > 0 {
+			counts[t]--
+			common++
+		}
+	}
+	if common == 0 {
+		return 0
+	}
+	precision := float64(common) / float64(len(answerTokens))
+	recall := float64(common) / float64(len(referenceTokens))
+	return 2 * precision * recall / (precision + recall)
+}
+
+func cosineSimilarity(a []float32, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,56 @@
+package evaluation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExactMatch(t *testing.T) {
+	tests := []struct {
+		answer    string
+		reference string
+		want      float64
+	}{
+		{"731", "731", 1},
+		{"True.", "true", 1},
+		{"The Driver service", "driver service!", 1},
+		{"  SQL   SELECT ", "SQL SELECT", 1},
+		{"2 errors", "2 errors occurred", 0},
+		{"False", "True", 0},
+		{"", "", 1},
+		{"", "731", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.answer+"/"+tt.reference, func(t *testing.T) {
+			if got := ExactMatch(tt.answer, tt.reference); got != tt.want {
+				t.Errorf("ExactMatch(%q, %q) = %v, want %v", tt.answer, tt.reference, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenF1(t *testing.T) {
+	tests := []struct {
+		answer    string
+		reference string
+		want      float64
+	}{
+		{"This is a Redis timeout error", "This is a Redis timeout error", 1},
+		// precision 2/2, recall 2/3
+		{"2 errors", "2 errors occurred", 0.8},
+		// precision 1/3, recall 1/1
+		{"It is 731.", "731", 0.5},
+		// a repeated token counts as often as in the reference
+		{"redis redis", "redis driver", 0.5},
+		{"Driver service", "redis-manual", 0},
+		{"the", "a", 1},
+		{"", "731", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.answer+"/"+tt.reference, func(t *testing.T) {
+			if got := TokenF1(tt.answer, tt.reference); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("TokenF1(%q, %q) = %v, want %v", tt.answer, tt.reference, got, tt.want)
+			}
+		})
+	}
+}
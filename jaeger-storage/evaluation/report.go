@@ -0,0 +1,143 @@
+package evaluation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Result is the answer of a method to a question and its scores, all between 0 and 1
+type Result struct {
+	TraceId            string  `json:"trace_id"`
+	Question           string  `json:"question"`
+	Reference          string  `json:"reference"`
+	Method             string  `json:"method"`
+	Answer             string  `json:"answer"`
+	PromptVersion      string  `json:"prompt_version,omitempty"`
+	Error              string  `json:"error,omitempty"`
+	Answered           bool    `json:"answered"`
+	ExactMatch         float64 `json:"exact_match"`
+	TokenF1            float64 `json:"token_f1"`
+	SemanticSimilarity float64 `json:"semantic_similarity"`
+	Judge              float64 `json:"judge"`
+	JudgeReasoning     string  `json:"judge_reasoning,omitempty"`
+	LatencyMs          int64   `json:"latency_ms"`
+}
+
+// MethodSummary averages the scores of a method over all questions, a failed question scores 0
+type MethodSummary struct {
+	Method             Method  `json:"method"`
+	Questions          int     `json:"questions"`
+	Answered           int     `json:"answered"`
+	Errors             int     `json:"errors"`
+	ExactMatch         float64 `json:"exact_match"`
+	TokenF1            float64 `json:"token_f1"`
+	SemanticSimilarity float64 `json:"semantic_similarity"`
+	Judge              float64 `json:"judge"`
+	MeanLatencyMs      int64   `json:"mean_latency_ms"`
+}
+
+type Report struct {
+	Dataset   string          `json:"dataset"`
+	StartedAt time.Time       `json:"started_at"`
+	Duration  string          `json:"duration"`
+	Judge     bool            `json:"judge"`
+	Methods   []MethodSummary `json:"methods"`
+	Results   []Result        `json:"results"`
+}
+
+func summarize(methods []Method, results []Result) []MethodSummary {
+	summaries := make([]MethodSummary, 0, len(methods))
+	for _, m := range methods {
+		s := MethodSummary{Method: m}
+		var latency int64
+		for _, r := range results {
+			if r.Method != m.Name {
+				continue
+			}
+			s.Questions++
+			if r.Error != "" {
+				s.Errors++
+			}
+			if r.Answered {
+				s.Answered++
+			}
+			s.ExactMatch += r.ExactMatch
+			s.TokenF1 += r.TokenF1
+			s.SemanticSimilarity += r.SemanticSimilarity
+			s.Judge += r.Judge
+			latency += r.LatencyMs
+		}
+		if s.Questions > 0 {
+			n := float64(s.Questions)
+			s.ExactMatch /= n
+			s.TokenF1 /= n
+			s.SemanticSimilarity /= n
+			s.Judge /= n
+			s.MeanLatencyMs = latency / int64(s.Questions)
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
+
+// Write writes the report to report.json and report.md in the directory
+func (r Report) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "report.json"), data, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "report.md"), []byte(r.Markdown()), 0644)
+}
+
+// Markdown compares the methods in a table, followed by the answers of every method to every question
+func (r Report) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("# Evaluation report\n\n")
+	sb.WriteString(fmt.Sprintf("Dataset `%s`, run on %s in %s.", r.Dataset, r.StartedAt.Format(time.RFC3339), r.Duration))
+	if !r.Judge {
+		sb.WriteString(" The LLM judge was turned off.")
+	}
+	sb.WriteString("\n\n")
+
+	sb.WriteString("| Method | Answered | Errors | Exact match | Token F1 | Semantic similarity | LLM judge | Mean latency |\n")
+	sb.WriteString("|---|---|---|---|---|---|---|---|\n")
+	for _, m := range r.Methods {
+		sb.WriteString(fmt.Sprintf("| %s | %d/%d | %d | %.2f | %.2f | %.2f | %.2f | %s |\n",
+			m.Method.Name, m.Answered, m.Questions, m.Errors, m.ExactMatch, m.TokenF1, m.SemanticSimilarity, m.Judge,
+			(time.Duration(m.MeanLatencyMs) * time.Millisecond).String()))
+	}
+
+	sb.WriteString("\n## Answers\n")
+	question := ""
+	for _, res := range r.Results {
+		if res.TraceId+res.Question != question {
+			question = res.TraceId + res.Question
+			sb.WriteString(fmt.Sprintf("\n### %s\n\nTrace `%s`, reference: %s\n\n", cell(res.Question), res.TraceId, cell(res.Reference)))
+			sb.WriteString("| Method | Answer | Exact match | Token F1 | Semantic similarity | LLM judge |\n")
+			sb.WriteString("|---|---|---|---|---|---|\n")
+		}
+		answer := cell(res.Answer)
+		if res.Error != "" {
+			answer = "error: " + cell(res.Error)
+		}
+		sb.WriteString(fmt.Sprintf("| %s | %s | %.0f | %.2f | %.2f | %.2f |\n",
+			res.Method, answer, res.ExactMatch, res.TokenF1, res.SemanticSimilarity, res.Judge))
+	}
+	return sb.String()
+}
+
+// cell keeps a text on a single markdown table cell
+func cell(text string) string {
+	text = strings.ReplaceAll(text, "|", "\\|")
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,75 @@
+package evaluation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	methods := []Method{{Name: "graph-rag-hop-2", Method: "graph-rag", Hop: 2}, {Name: "agent", Method: "agent"}, {Name: "naive-rag", Method: "naive-rag"}}
+	results := []Result{
+		{Method: "graph-rag-hop-2", Answered: true, ExactMatch: 1, TokenF1: 1, SemanticSimilarity: 0.9, Judge: 1, LatencyMs: 1000},
+		{Method: "agent", Answered: true, TokenF1: 0.5, SemanticSimilarity: 0.6, Judge: 0.5, LatencyMs: 3000},
+		{Method: "graph-rag-hop-2", Answered: true, TokenF1: 0.4, SemanticSimilarity: 0.5, LatencyMs: 2000},
+		{Method: "graph-rag-hop-2", Error: "timeout", LatencyMs: 6000},
+		{Method: "unknown", Answered: true, ExactMatch: 1},
+	}
+
+	summaries := summarize(methods, results)
+	if len(summaries) != len(methods) {
+		t.Fatalf("got %d summaries, want one per method", len(summaries))
+	}
+	tests := []MethodSummary{
+		{Method: methods[0], Questions: 3, Answered: 2, Errors: 1, ExactMatch: 1.0 / 3, TokenF1: 1.4 / 3, SemanticSimilarity: 1.4 / 3, Judge: 1.0 / 3, MeanLatencyMs: 3000},
+		{Method: methods[1], Questions: 1, Answered: 1, TokenF1: 0.5, SemanticSimilarity: 0.6, Judge: 0.5, MeanLatencyMs: 3000},
+		// no result, no division by zero
+		{Method: methods[2]},
+	}
+	for i, want := range tests {
+		got := summaries[i]
+		if got.Method != want.Method || got.Questions != want.Questions || got.Answered != want.Answered || got.Errors != want.Errors || got.MeanLatencyMs != want.MeanLatencyMs {
+			t.Errorf("summary %d = %+v, want %+v", i, got, want)
+		}
+		for _, score := range []struct {
+			name      string
+			got, want float64
+		}{
+			{"exact match", got.ExactMatch, want.ExactMatch},
+			{"token F1", got.TokenF1, want.TokenF1},
+			{"semantic similarity", got.SemanticSimilarity, want.SemanticSimilarity},
+			{"judge", got.Judge, want.Judge},
+		} {
+			if diff := score.got - score.want; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("summary %d %s = %v, want %v", i, score.name, score.got, score.want)
+			}
+		}
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	report := Report{
+		Dataset: "../eval/dataset.yaml",
+		Methods: []MethodSummary{
+			{Method: Method{Name: "agent"}, Questions: 2, Answered: 1, Errors: 1, ExactMatch: 0.5, TokenF1: 0.75, MeanLatencyMs: 1500},
+		},
+		Results: []Result{
+			{TraceId: "t1", Question: "What is the customer ID?", Reference: "731", Method: "agent", Answer: "731 | customer", ExactMatch: 1, TokenF1: 1},
+			{TraceId: "t1", Question: "How many errors\noccurred?", Reference: "2 errors", Method: "agent", Error: "context deadline exceeded"},
+		},
+	}
+
+	markdown := report.Markdown()
+	for _, want := range []string{
+		"Dataset `../eval/dataset.yaml`",
+		"The LLM judge was turned off.",
+		"| agent | 1/2 | 1 | 0.50 | 0.75 | 0.00 | 0.00 | 1.5s |",
+		"### What is the customer ID?\n\nTrace `t1`, reference: 731",
+		"| agent | 731 \\| customer | 1 | 1.00 | 0.00 | 0.00 |",
+		"### How many errors occurred?",
+		"| agent | error: context deadline exceeded | 0 | 0.00 | 0.00 | 0.00 |",
+	} {
+		if !strings.Contains(markdown, want) {
+			t.Errorf("the report has no %q:\n%s", want, markdown)
+		}
+	}
+}
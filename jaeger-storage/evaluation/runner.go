@@ -0,0 +1,121 @@
+package evaluation
+
+import (
+	"context"
+	"jaeger-storage/clients"
+	"jaeger-storage/usage"
+	"log"
+	"time"
+)
+
+// AskRequest is the body of /api/ask
+type AskRequest struct {
+	TraceId  string `json:"trace_id"`
+	Question string `json:"question"`
+	Method   string `json:"method"`
+	Hop      int    `json:"hop"`
+	Strategy string `json:"strategy,omitempty"`
+	TopK     int    `json:"top_k,omitempty"`
+}
+
+type AskResponse struct {
+	Answer        string `json:"answer"`
+	PromptVersion string `json:"prompt_version"`
+}
+
+// Asker sends a question to /api/ask
+type Asker func(ctx context.Context, req AskRequest) (AskResponse, error)
+
+// Scorer computes the scores that need a model
+type Scorer interface {
+	CreateEmbeddings(ctx context.Context, content string) ([]float32, error)
+	JudgeAnswer(ctx context.Context, question string, reference string, answer string) (clients.Judgement, error)
+}
+
+// Runner asks every question of the dataset with every method and scores the answers against the references
+type Runner struct {
+	ask    Asker
+	scorer Scorer
+	// the LLM judge costs a call per answer, it can be turned off
+	judge bool
+	// reference to its embedding, the references are embedded once for all methods
+	references map[string][]float32
+}
+
+func NewRunner(ask Asker, scorer Scorer, judge bool) *Runner {
+	return &Runner{ask: ask, scorer: scorer, judge: judge, references: make(map[string][]float32)}
+}
+
+func (r *Runner) Run(ctx context.Context, dataset Dataset) Report {
+	report := Report{StartedAt: time.Now(), Judge: r.judge, Results: make([]Result, 0)}
+	for _, trace := range dataset.Traces {
+		for _, q := range trace.Questions {
+			for _, method := range dataset.Methods {
+				result := r.evaluate(ctx, trace.TraceId, q, method)
+				log.Printf("[evaluation][Run] %s %q: %q, f1 %.2f, judge %.2f\n", method.Name, q.Question, result.Answer, result.TokenF1, result.Judge)
+				report.Results = append(report.Results, result)
+			}
+		}
+	}
+	report.Duration = time.Since(report.StartedAt).Round(time.Second).String()
+	report.Methods = summarize(dataset.Methods, report.Results)
+	return report
+}
+
+func (r *Runner) evaluate(ctx context.Context, traceId string, q Question, method Method) Result {
+	result := Result{TraceId: traceId, Question: q.Question, Reference: q.Reference, Method: method.Name}
+	start := time.Now()
+	res, err := r.ask(ctx, AskRequest{
+		TraceId:  traceId,
+		Question: q.Question,
+		Method:   method.Method,
+		Hop:      method.Hop,
+		Strategy: method.Strategy,
+		TopK:     method.TopK,
+	})
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		log.Println("[evaluation][evaluate][error] cannot ask", method.Name, q.Question, err)
+		result.Error = err.Error()
+		return result
+	}
+	result.Answer = res.Answer
+	result.PromptVersion = res.PromptVersion
+	result.Answered = res.Answer != clients.InsufficientInformation && res.Answer != ""
+	result.ExactMatch = ExactMatch(res.Answer, q.Reference)
+	result.TokenF1 = TokenF1(res.Answer, q.Reference)
+
+	ctx = usage.WithAttribution(ctx, usage.Attribution{Caller: usage.CallerEval, TraceId: traceId})
+	if similarity, err := r.similarity(ctx, res.Answer, q.Reference); err != nil {
+		log.Println("[evaluation][evaluate][error] cannot compute the semantic similarity", err)
+	} else {
+		result.SemanticSimilarity = similarity
+	}
+	if r.judge {
+		judgement, err := r.scorer.JudgeAnswer(ctx, q.Question, q.Reference, res.Answer)
+		if err != nil {
+			log.Println("[evaluation][evaluate][error] cannot judge the answer", err)
+		} else {
+			result.Judge = judgement.Normalized()
+			result.JudgeReasoning = judgement.Reasoning
+		}
+	}
+	return result
+}
+
+func (r *Runner) similarity(ctx context.Context, answer string, reference string) (float64, error) {
+	referenceEmbedding, ok := r.references[reference]
+	if !ok {
+		embedding, err := r.scorer.CreateEmbeddings(ctx, reference)
+		if err != nil {
+			return 0, err
+		}
+		r.references[reference] = embedding
+		referenceEmbedding = embedding
+	}
+	answerEmbedding, err := r.scorer.CreateEmbeddings(ctx, answer)
+	if err != nil {
+		return 0, err
+	}
+	return cosineSimilarity(answerEmbedding, referenceEmbedding), nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	uiconv "github.com/jaegertracing/jaeger/model/converter/json"
+	ui "github.com/jaegertracing/jaeger/model/json"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"io"
+	"jaeger-storage/graphexport"
+	"jaeger-storage/traceio"
+	"log"
+	"os"
+)
+
+type exportFormat struct {
+	contentType string
+	extension   string
+}
+
+// exportFormats are the formats of /api/traces/:id/export, jaeger and otlp-json can be imported back
+var exportFormats = map[string]exportFormat{
+	"jaeger":        {contentType: "application/json", extension: "json"},
+	"otlp-json":     {contentType: "application/json", extension: "otlp.json"},
+	"otlp-protobuf": {contentType: "application/x-protobuf", extension: "otlp.pb"},
+	"annotated":     {contentType: "application/json", extension: "annotated.json"},
+}
+
+// graphExportFormats are the formats of /api/traces/:id/graph and of the export-graph subcommand
+var graphExportFormats = map[string]exportFormat{
+	graphexport.FormatJSON:    {contentType: "application/json", extension: "graph.json"},
+	graphexport.FormatGraphML: {contentType: "application/graphml+xml", extension: "graphml"},
+	graphexport.FormatCypher:  {contentType: "text/plain; charset=utf-8", extension: "cypher"},
+}
+
+// spanAnnotations is what the knowledge graph adds to a span stored in Postgres
+type spanAnnotations struct {
+	Summary           string   `json:"summary,omitempty"`
+	SpanSummary       string   `json:"span_summary,omitempty"`
+	LogSummary        string   `json:"log_summary,omitempty"`
+	TagSummary        string   `json:"tag_summary,omitempty"`
+	Summarized        bool     `json:"summarized"`
+	SummaryReason     string   `json:"summary_reason,omitempty"`
+	SpanSummaryPrompt string   `json:"span_summary_prompt,omitempty"`
+	LogSummaryPrompt  string   `json:"log_summary_prompt,omitempty"`
+	SpanStatus        string   `json:"span_status,omitempty"`
+	ErrorType         string   `json:"error_type,omitempty"`
+	ErrorMessage      string   `json:"error_message,omitempty"`
+	Warnings          []string `json:"warnings,omitempty"`
+	LatencyAnomaly    bool     `json:"latency_anomaly"`
+	LatencyRatio      float64  `json:"latency_ratio,omitempty"`
+	// baseline of the span when it is a latency anomaly, in nanoseconds
+	LatencyP50  int64     `json:"latency_p50,omitempty"`
+	LatencyP95  int64     `json:"latency_p95,omitempty"`
+	LatencyP99  int64     `json:"latency_p99,omitempty"`
+	LatencyEwma int64     `json:"latency_ewma,omitempty"`
+	Embedding   []float64 `json:"embedding,omitempty"`
+}
+
+type annotatedSpan struct {
+	ui.Span
+	// nil when the span is not in the knowledge graph, e.g. filtered as postgres-only
+	Annotations *spanAnnotations `json:"annotations"`
+}
+
+// annotatedTrace is a trace of the Jaeger UI JSON format with annotated spans, it can still be imported
+type annotatedTrace struct {
+	TraceID   ui.TraceID                  `json:"traceID"`
+	Spans     []annotatedSpan             `json:"spans"`
+	Processes map[ui.ProcessID]ui.Process `json:"processes"`
+	Warnings  []string                    `json:"warnings"`
+}
+
+// exportTrace writes the trace in the format, the annotated format reads the knowledge graph
+func exportTrace(ctx context.Context, w io.Writer, neo4jDriver *neo4j.DriverWithContext, trace *model.Trace, format string, embeddings bool) error {
+	switch format {
+	case "jaeger":
+		return traceio.WriteJaegerJSON(w, trace.Spans)
+	case "otlp-json":
+		return traceio.WriteOTLPJSON(w, trace.Spans)
+	case "otlp-protobuf":
+		return traceio.WriteOTLPProtobuf(w, trace.Spans)
+	case "annotated":
+		uiTrace := uiconv.FromDomain(trace)
+		annotations, err := annotateSpans(ctx, neo4jDriver, string(uiTrace.TraceID), embeddings)
+		if err != nil {
+			return err
+		}
+		annotated := annotatedTrace{
+			TraceID:   uiTrace.TraceID,
+			Spans:     make([]annotatedSpan, 0, len(uiTrace.Spans)),
+			Processes: uiTrace.Processes,
+			Warnings:  uiTrace.Warnings,
+		}
+		for _, span := range uiTrace.Spans {
+			annotated.Spans = append(annotated.Spans, annotatedSpan{Span: span, Annotations: annotations[string(span.SpanID)]})
+		}
+		return json.NewEncoder(w).Encode(struct {
+			Data []annotatedTrace `json:"data"`
+		}{
+			Data: []annotatedTrace{annotated},
+		})
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// annotateSpans reads the summaries, status and latency anomaly of the spans of the trace from Neo4j, by span ID
+func annotateSpans(ctx context.Context, neo4jDriver *neo4j.DriverWithContext, traceId string, embeddings bool) (map[string]*spanAnnotations, error) {
+	res, err := neo4j.ExecuteQuery(ctx, *neo4jDriver, `
+		MATCH (:Trace { trace_id: $trace_id })-[:CONTAINS]->(s: Span)
+		RETURN s.span_id as span_id, s.summary as summary, s.span_summary as span_summary, s.log_summary as log_summary,
+			s.tag_summary as tag_summary, s.summarized as summarized, s.summary_reason as summary_reason,
+			s.span_summary_prompt as span_summary_prompt, s.log_summary_prompt as log_summary_prompt,
+			s.span_status as span_status, s.error_type as error_type, s.error_message as error_message, s.warnings as warnings,
+			s.latency_anomaly as latency_anomaly, s.latency_ratio as latency_ratio, s.latency_p50 as latency_p50,
+			s.latency_p95 as latency_p95, s.latency_p99 as latency_p99, s.latency_ewma as latency_ewma,
+			CASE WHEN $embeddings THEN s.embedding END as embedding
+	`, map[string]any{"trace_id": traceId, "embeddings": embeddings}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[export][annotateSpans] cannot read the spans of trace", traceId, err)
+		return nil, err
+	}
+
+	annotations := make(map[string]*spanAnnotations, len(res.Records))
+	for _, record := range res.Records {
+		spanId, _, _ := neo4j.GetRecordValue[string](record, "span_id")
+		a := &spanAnnotations{}
+		a.Summary, _, _ = neo4j.GetRecordValue[string](record, "summary")
+		a.SpanSummary, _, _ = neo4j.GetRecordValue[string](record, "span_summary")
+		a.LogSummary, _, _ = neo4j.GetRecordValue[string](record, "log_summary")
+		a.TagSummary, _, _ = neo4j.GetRecordValue[string](record, "tag_summary")
+		a.Summarized, _, _ = neo4j.GetRecordValue[bool](record, "summarized")
+		a.SummaryReason, _, _ = neo4j.GetRecordValue[string](record, "summary_reason")
+		a.SpanSummaryPrompt, _, _ = neo4j.GetRecordValue[string](record, "span_summary_prompt")
+		a.LogSummaryPrompt, _, _ = neo4j.GetRecordValue[string](record, "log_summary_prompt")
+		a.SpanStatus, _, _ = neo4j.GetRecordValue[string](record, "span_status")
+		a.ErrorType, _, _ = neo4j.GetRecordValue[string](record, "error_type")
+		a.ErrorMessage, _, _ = neo4j.GetRecordValue[string](record, "error_message")
+		a.LatencyAnomaly, _, _ = neo4j.GetRecordValue[bool](record, "latency_anomaly")
+		a.LatencyRatio, _, _ = neo4j.GetRecordValue[float64](record, "latency_ratio")
+		a.LatencyP50, _, _ = neo4j.GetRecordValue[int64](record, "latency_p50")
+		a.LatencyP95, _, _ = neo4j.GetRecordValue[int64](record, "latency_p95")
+		a.LatencyP99, _, _ = neo4j.GetRecordValue[int64](record, "latency_p99")
+		a.LatencyEwma, _, _ = neo4j.GetRecordValue[int64](record, "latency_ewma")
+		warnings, _, _ := neo4j.GetRecordValue[[]any](record, "warnings")
+		for _, w := range warnings {
+			if s, ok := w.(string); ok {
+				a.Warnings = append(a.Warnings, s)
+			}
+		}
+		embedding, _, _ := neo4j.GetRecordValue[[]any](record, "embedding")
+		for _, v := range embedding {
+			if f, ok := v.(float64); ok {
+				a.Embedding = append(a.Embedding, f)
+			}
+		}
+		annotations[spanId] = a
+	}
+	return annotations, nil
+}
+
+// runExportGraph writes the knowledge graph of a trace to a file or the standard output
+func runExportGraph(args []string) {
+	flags := flag.NewFlagSet("export-graph", flag.ExitOnError)
+	format := flags.String("format", graphexport.FormatGraphML, "graphml, json or cypher")
+	out := flags.String("out", "", "file the graph is written to, the standard output by default")
+	embeddings := flags.Bool("embeddings", false, "include the embeddings of the spans")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: jaeger-storage export-graph [-format graphml|json|cypher] [-out file] [-embeddings] trace-id")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if _, ok := graphExportFormats[*format]; !ok {
+		log.Fatalln("[export-graph] unknown format", *format)
+	}
+
+	neo4jDriver, err := NewNeo4jDriver()
+	if err != nil {
+		log.Fatalln("[export-graph] cannot connect to neo4j", err)
+	}
+	defer (*neo4jDriver).Close(context.Background())
+
+	graph, err := graphexport.Read(context.Background(), neo4jDriver, flags.Arg(0), graphexport.Options{Embeddings: *embeddings})
+	if err != nil {
+		log.Fatalln("[export-graph] cannot read the graph of trace", flags.Arg(0), err)
+	}
+	w := os.Stdout
+	if *out != "" {
+		if w, err = os.Create(*out); err != nil {
+			log.Fatalln("[export-graph] cannot create", *out, err)
+		}
+		defer w.Close()
+	}
+	if err := graph.Write(w, *format); err != nil {
+		log.Fatalln("[export-graph] cannot write the graph", err)
+	}
+	if *out != "" {
+		log.Printf("[export-graph] wrote %d nodes and %d edges to %s\n", len(graph.Nodes), len(graph.Edges), *out)
+	}
+}
@@ -0,0 +1,326 @@
+package graphexport
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatJSON    = "json"
+	FormatGraphML = "graphml"
+	FormatCypher  = "cypher"
+)
+
+// Write writes the graph as a JSON nodes and edges document, GraphML or a Cypher script
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(g)
+	case FormatGraphML:
+		return g.writeGraphML(w)
+	case FormatCypher:
+		return g.writeCypher(w)
+	}
+	return fmt.Errorf("unknown format %q, expected json, graphml or cypher", format)
+}
+
+type graphMLKey struct {
+	id       string
+	owner    string
+	name     string
+	dataType string
+}
+
+// writeGraphML follows the conventions of the APOC GraphML export, the labels of a node are in its labels attribute
+// and the type of an edge in its label attribute, which yEd, Gephi and Cytoscape read. Lists and dates are strings.
+func (g *Graph) writeGraphML(w io.Writer) error {
+	nodeProperties := make([]map[string]any, 0, len(g.Nodes))
+	for _, n := range g.Nodes {
+		nodeProperties = append(nodeProperties, n.Properties)
+	}
+	edgeProperties := make([]map[string]any, 0, len(g.Edges))
+	for _, e := range g.Edges {
+		edgeProperties = append(edgeProperties, e.Properties)
+	}
+	nodeKeys, edgeKeys := graphMLKeys("node", nodeProperties), graphMLKeys("edge", edgeProperties)
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	b.WriteString(`  <key id="labels" for="node" attr.name="labels" attr.type="string"/>` + "\n")
+	b.WriteString(`  <key id="label" for="edge" attr.name="label" attr.type="string"/>` + "\n")
+	for _, k := range append(nodeKeys, edgeKeys...) {
+		fmt.Fprintf(&b, "  <key id=\"%s\" for=\"%s\" attr.name=\"%s\" attr.type=\"%s\"/>\n", escape(k.id), k.owner, escape(k.name), k.dataType)
+	}
+	fmt.Fprintf(&b, "  <graph id=\"%s\" edgedefault=\"directed\">\n", escape(g.TraceId))
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "    <node id=\"%s\" labels=\"%s\">\n", escape(n.Id), escape(":"+strings.Join(n.Labels, ":")))
+		fmt.Fprintf(&b, "      <data key=\"labels\">%s</data>\n", escape(":"+strings.Join(n.Labels, ":")))
+		writeGraphMLData(&b, nodeKeys, n.Properties)
+		b.WriteString("    </node>\n")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "    <edge id=\"%s\" source=\"%s\" target=\"%s\" label=\"%s\">\n", escape(e.Id), escape(e.Source), escape(e.Target), escape(e.Type))
+		fmt.Fprintf(&b, "      <data key=\"label\">%s</data>\n", escape(e.Type))
+		writeGraphMLData(&b, edgeKeys, e.Properties)
+		b.WriteString("    </edge>\n")
+	}
+	b.WriteString("  </graph>\n</graphml>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// graphMLKeys declares every property with its type, a property with values of different types is a string.
+// Null values are not written and do not count.
+func graphMLKeys(owner string, properties []map[string]any) []graphMLKey {
+	types := make(map[string]string)
+	for _, props := range properties {
+		for name, v := range props {
+			if v == nil {
+				continue
+			}
+			t := graphMLType(v)
+			if previous, ok := types[name]; ok && previous != t {
+				t = "string"
+			}
+			types[name] = t
+		}
+	}
+	keys := make([]graphMLKey, 0, len(types))
+	for name, t := range types {
+		keys = append(keys, graphMLKey{id: owner[:1] + "_" + name, owner: owner, name: name, dataType: t})
+	}
+	slices.SortFunc(keys, func(a, b graphMLKey) int { return strings.Compare(a.name, b.name) })
+	return keys
+}
+
+func graphMLType(v any) string {
+	switch v.(type) {
+	case bool:
+		return "boolean"
+	case int64:
+		return "long"
+	case float64:
+		return "double"
+	}
+	return "string"
+}
+
+func writeGraphMLData(b *strings.Builder, keys []graphMLKey, props map[string]any) {
+	for _, k := range keys {
+		v, ok := props[k.name]
+		if !ok || v == nil {
+			continue
+		}
+		fmt.Fprintf(b, "      <data key=\"%s\">%s</data>\n", escape(k.id), escape(textValue(v)))
+	}
+}
+
+// textValue renders a property as text, lists as JSON
+func textValue(v any) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// writeCypher writes a script recreating the graph in another database, e.g. for a test fixture. Nodes are merged on
+// their key, logs on their span, timestamp and value and other nodes on all their properties, so running the script
+// again does not duplicate anything.
+func (g *Graph) writeCypher(w io.Writer) error {
+	nodes := make(map[string]Node, len(g.Nodes))
+	for _, n := range g.Nodes {
+		nodes[n.Id] = n
+	}
+	// the log nodes are merged along with the relationship to their span
+	producers := make(map[string]Edge)
+	for _, e := range g.Edges {
+		if e.Type == "PRODUCES" {
+			producers[e.Target] = e
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// knowledge graph of trace %s, exported by jaeger-storage\n", g.TraceId)
+	for _, n := range g.Nodes {
+		if producer, ok := producers[n.Id]; ok {
+			fmt.Fprintf(&b, "MATCH %s MERGE (a)-[r:PRODUCES]->(n:%s %s) SET n += %s%s;\n",
+				cypherMatch("a", nodes[producer.Source], producers, nodes),
+				cypherLabels(n.Labels), cypherMap(logKey(n)), cypherMap(n.Properties), cypherEdgeProperties(producer))
+			continue
+		}
+		label := primaryLabel(n.Labels)
+		key, ok := keys[label]
+		if !ok {
+			fmt.Fprintf(&b, "MERGE (n:%s %s);\n", cypherLabels(n.Labels), cypherMap(nonNull(n.Properties)))
+			continue
+		}
+		fmt.Fprintf(&b, "MERGE (n:%s %s) SET n += %s%s;\n", cypherName(label), cypherMap(map[string]any{key: n.Properties[key]}),
+			cypherMap(n.Properties), cypherExtraLabels(n.Labels, label))
+	}
+	for _, e := range g.Edges {
+		if e.Type == "PRODUCES" {
+			continue
+		}
+		fmt.Fprintf(&b, "MATCH %s, %s MERGE (a)-[r:%s]->(b)%s;\n",
+			cypherMatch("a", nodes[e.Source], producers, nodes), cypherMatch("b", nodes[e.Target], producers, nodes),
+			cypherName(e.Type), cypherEdgeProperties(e))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func logKey(n Node) map[string]any {
+	key := make(map[string]any)
+	for _, k := range []string{"timestamp", "value"} {
+		if v, ok := n.Properties[k]; ok && v != nil {
+			key[k] = v
+		}
+	}
+	return key
+}
+
+// cypherMatch is the pattern matching the node in another database
+func cypherMatch(variable string, n Node, producers map[string]Edge, nodes map[string]Node) string {
+	if producer, ok := producers[n.Id]; ok {
+		return fmt.Sprintf("%s-[:PRODUCES]->(%s:%s %s)", cypherMatch("", nodes[producer.Source], producers, nodes), variable,
+			cypherLabels(n.Labels), cypherMap(logKey(n)))
+	}
+	label := primaryLabel(n.Labels)
+	if key, ok := keys[label]; ok {
+		return fmt.Sprintf("(%s:%s %s)", variable, cypherName(label), cypherMap(map[string]any{key: n.Properties[key]}))
+	}
+	return fmt.Sprintf("(%s:%s %s)", variable, cypherLabels(n.Labels), cypherMap(nonNull(n.Properties)))
+}
+
+// nonNull drops the null properties, MERGE fails on them and MATCH never matches them
+func nonNull(props map[string]any) map[string]any {
+	result := make(map[string]any, len(props))
+	for k, v := range props {
+		if v != nil {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+func cypherEdgeProperties(e Edge) string {
+	if len(e.Properties) == 0 {
+		return ""
+	}
+	return " SET r += " + cypherMap(e.Properties)
+}
+
+func cypherExtraLabels(nodeLabels []string, primary string) string {
+	extra := make([]string, 0)
+	for _, l := range nodeLabels {
+		if l != primary {
+			extra = append(extra, cypherName(l))
+		}
+	}
+	if len(extra) == 0 {
+		return ""
+	}
+	return ", n:" + strings.Join(extra, ":")
+}
+
+func cypherLabels(nodeLabels []string) string {
+	names := make([]string, 0, len(nodeLabels))
+	for _, l := range nodeLabels {
+		names = append(names, cypherName(l))
+	}
+	return strings.Join(names, ":")
+}
+
+// cypherName quotes a label, relationship type or property key when it is not a plain identifier
+func cypherName(name string) string {
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+		}
+	}
+	return name
+}
+
+func cypherMap(props map[string]any) string {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	entries := make([]string, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, cypherName(name)+": "+cypherValue(props[name]))
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+func cypherValue(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return cypherString(value)
+	case bool:
+		return strconv.FormatBool(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return "null"
+		}
+		s := strconv.FormatFloat(value, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case time.Time:
+		return "datetime(" + cypherString(value.Format(time.RFC3339Nano)) + ")"
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			values = append(values, cypherValue(item))
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	}
+	return cypherString(fmt.Sprint(v))
+}
+
+// cypherString quotes a string, the escapes of JSON strings are valid in Cypher
+func cypherString(s string) string {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
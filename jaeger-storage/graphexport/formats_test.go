@@ -0,0 +1,121 @@
+package graphexport
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the exports")
+
+// testGraph covers the property types, names that need quoting and a log, whose key is its span
+func testGraph() *Graph {
+	start := time.Date(2024, 12, 19, 10, 0, 0, 123456789, time.UTC)
+	return &Graph{
+		TraceId: "e72ef241661424eb6970b65f6fd74b30",
+		Nodes: []Node{
+			{Id: "trace:e72ef241661424eb6970b65f6fd74b30", Labels: []string{"Trace"}, Properties: map[string]any{
+				"trace_id":   "e72ef241661424eb6970b65f6fd74b30",
+				"start_time": start,
+			}},
+			{Id: "service:redis-manual", Labels: []string{"Service"}, Properties: map[string]any{"name": "redis-manual"}},
+			{Id: "span:57d9372746d52e20", Labels: []string{"Span", "Slow `cache` call"}, Properties: map[string]any{
+				"span_id":          "57d9372746d52e20",
+				"duration":         int64(250000000),
+				"latency_ratio":    3.5,
+				"similarity":       2.0,
+				"latency_anomaly":  true,
+				"start_time":       start,
+				"summary":          "The \"GetDriver\" call of redis-manual's pool timed out <after> 250ms & retried\nwith a 'backoff'",
+				"http.status_code": int64(503),
+				"tags":             []any{"retry", int64(2)},
+				"embedding":        nil,
+			}},
+			{Id: "span:a3cd538c449a5ee1", Labels: []string{"Span"}, Properties: map[string]any{
+				"span_id":  "a3cd538c449a5ee1",
+				"duration": "unknown",
+			}},
+			{Id: "log:57d9372746d52e20:0", Labels: []string{"Log"}, Properties: map[string]any{
+				"timestamp": start.Add(time.Millisecond),
+				"value":     "redis timeout after 250ms",
+				"level":     "error",
+			}},
+			{Id: "logtemplate:4:c4ca4238", Labels: []string{"LogTemplate"}, Properties: map[string]any{
+				"template": "redis timeout after <*>",
+				"count":    int64(3),
+				"removed":  nil,
+			}},
+		},
+		Edges: []Edge{
+			{Id: "e1", Type: "CONTAINS", Source: "trace:e72ef241661424eb6970b65f6fd74b30", Target: "span:57d9372746d52e20"},
+			{Id: "e2", Type: "CONTAINS", Source: "service:redis-manual", Target: "span:57d9372746d52e20"},
+			{Id: "e3", Type: "INVOKES_CHILD", Source: "span:a3cd538c449a5ee1", Target: "span:57d9372746d52e20", Properties: map[string]any{"weight": 1.0}},
+			{Id: "e4", Type: "PRODUCES", Source: "span:57d9372746d52e20", Target: "log:57d9372746d52e20:0", Properties: map[string]any{"index": int64(0)}},
+			{Id: "e5", Type: "INSTANCE OF", Source: "log:57d9372746d52e20:0", Target: "logtemplate:4:c4ca4238"},
+		},
+	}
+}
+
+func TestWriteGolden(t *testing.T) {
+	for _, format := range []string{FormatGraphML, FormatCypher} {
+		t.Run(format, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := testGraph().Write(&b, format); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "graph."+format)
+			if *update {
+				if err := os.WriteFile(golden, b.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != string(want) {
+				t.Errorf("the %s export differs from %s, rerun with -update if the change is expected:\n%s", format, golden, got)
+			}
+		})
+	}
+}
+
+func TestCypherQuoting(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{"span_id", "span_id"},
+		{"http.status_code", "`http.status_code`"},
+		{"2xx", "`2xx`"},
+		{"Slow `cache` call", "`Slow ``cache`` call`"},
+	}
+	for _, tt := range tests {
+		if got := cypherName(tt.value.(string)); got != tt.want {
+			t.Errorf("cypherName(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+
+	values := []struct {
+		value any
+		want  string
+	}{
+		{`it's "quoted"` + "\n", `"it's \"quoted\"\n"`},
+		{"<*> & co", `"<*> & co"`},
+		{int64(-42), "-42"},
+		{2.0, "2.0"},
+		{1e21, "1e+21"},
+		{0.25, "0.25"},
+		{nil, "null"},
+		{time.Date(2024, 12, 19, 10, 0, 0, 5, time.FixedZone("", 3600)), `datetime("2024-12-19T10:00:00.000000005+01:00")`},
+		{[]any{"a", int64(1), 1.5, true}, `["a", 1, 1.5, true]`},
+	}
+	for _, tt := range values {
+		if got := cypherValue(tt.value); got != tt.want {
+			t.Errorf("cypherValue(%v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
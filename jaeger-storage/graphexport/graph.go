@@ -0,0 +1,214 @@
+package graphexport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrTraceNotFound = errors.New("trace not found in the knowledge graph")
+
+// keys identify the nodes across databases, a Log is identified by the span producing it
+var keys = map[string]string{
+	"Trace":   "trace_id",
+	"Service": "name",
+	"Span":    "span_id",
+}
+
+// labels are exported in this order
+var labels = []string{"Trace", "Service", "Span", "Log"}
+
+type Node struct {
+	// stable ID made of the label and key of the node, e.g. span:57d9372746d52e20, or log:<span ID>:<index>
+	Id         string         `json:"id"`
+	Labels     []string       `json:"labels"`
+	Properties map[string]any `json:"properties"`
+	elementId  string
+}
+
+type Edge struct {
+	Id         string         `json:"id"`
+	Type       string         `json:"type"`
+	Source     string         `json:"source"`
+	Target     string         `json:"target"`
+	Properties map[string]any `json:"properties"`
+}
+
+// Graph is the subgraph of a trace: the Trace, its Spans, their Services and Logs, and the CONTAINS, INVOKES_CHILD,
+// INVOKES_FOLLOWS and PRODUCES relationships between them
+type Graph struct {
+	TraceId string `json:"trace_id"`
+	Nodes   []Node `json:"nodes"`
+	Edges   []Edge `json:"edges"`
+}
+
+type Options struct {
+	// embeddings are dropped by default, they are large and useless outside of the vector index
+	Embeddings bool
+}
+
+// Read reads the subgraph of the trace from Neo4j, nodes and edges are sorted so that exports of the same graph are equal
+func Read(ctx context.Context, driver *neo4j.DriverWithContext, traceId string, options Options) (*Graph, error) {
+	res, err := neo4j.ExecuteQuery(ctx, *driver, `
+		MATCH (t: Trace { trace_id: $trace_id })-[:CONTAINS]->(s: Span)
+		OPTIONAL MATCH (service: Service)-[:CONTAINS]->(s)
+		OPTIONAL MATCH (s)-[:PRODUCES]->(l: Log)
+		WITH collect(DISTINCT t) + collect(DISTINCT service) + collect(DISTINCT s) + collect(DISTINCT l) as nodes
+		UNWIND nodes as n
+		RETURN n
+	`, map[string]any{"trace_id": traceId}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[graphexport][Read][error] cannot read the nodes of trace", traceId, err)
+		return nil, err
+	}
+	if len(res.Records) == 0 {
+		return nil, ErrTraceNotFound
+	}
+
+	graph := &Graph{TraceId: traceId, Nodes: make([]Node, 0, len(res.Records)), Edges: make([]Edge, 0)}
+	elementIds := make([]string, 0, len(res.Records))
+	for _, record := range res.Records {
+		n, _, err := neo4j.GetRecordValue[neo4j.Node](record, "n")
+		if err != nil {
+			return nil, err
+		}
+		graph.Nodes = append(graph.Nodes, Node{
+			Labels:     n.Labels,
+			Properties: properties(n.Props, options),
+			elementId:  n.ElementId,
+		})
+		elementIds = append(elementIds, n.ElementId)
+	}
+
+	res, err = neo4j.ExecuteQuery(ctx, *driver, `
+		UNWIND $element_ids as element_id
+		MATCH (a) WHERE elementId(a) = element_id
+		MATCH (a)-[r:CONTAINS|INVOKES_CHILD|INVOKES_FOLLOWS|PRODUCES]->(b)
+		WHERE elementId(b) IN $element_ids
+		RETURN r
+	`, map[string]any{"element_ids": elementIds}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[graphexport][Read][error] cannot read the relationships of trace", traceId, err)
+		return nil, err
+	}
+	relationships := make([]neo4j.Relationship, 0, len(res.Records))
+	for _, record := range res.Records {
+		r, _, err := neo4j.GetRecordValue[neo4j.Relationship](record, "r")
+		if err != nil {
+			return nil, err
+		}
+		relationships = append(relationships, r)
+	}
+
+	ids := assignIds(graph.Nodes, relationships)
+	for i := range graph.Nodes {
+		graph.Nodes[i].Id = ids[graph.Nodes[i].elementId]
+	}
+	for _, r := range relationships {
+		source, target := ids[r.StartElementId], ids[r.EndElementId]
+		graph.Edges = append(graph.Edges, Edge{
+			Id:         fmt.Sprintf("%s-%s->%s", source, r.Type, target),
+			Type:       r.Type,
+			Source:     source,
+			Target:     target,
+			Properties: properties(r.Props, options),
+		})
+	}
+	graph.sort()
+	return graph, nil
+}
+
+// assignIds gives the nodes their stable ID by element ID, the logs of a span are numbered by timestamp
+func assignIds(nodes []Node, relationships []neo4j.Relationship) map[string]string {
+	ids := make(map[string]string, len(nodes))
+	byElementId := make(map[string]*Node, len(nodes))
+	for i := range nodes {
+		n := &nodes[i]
+		byElementId[n.elementId] = n
+		label := primaryLabel(n.Labels)
+		if key, ok := keys[label]; ok {
+			ids[n.elementId] = fmt.Sprintf("%s:%v", strings.ToLower(label), n.Properties[key])
+		}
+	}
+
+	logs := make(map[string][]*Node)
+	for _, r := range relationships {
+		if r.Type == "PRODUCES" && byElementId[r.EndElementId] != nil {
+			logs[r.StartElementId] = append(logs[r.StartElementId], byElementId[r.EndElementId])
+		}
+	}
+	for spanElementId, spanLogs := range logs {
+		sort.SliceStable(spanLogs, func(i, j int) bool {
+			ti, _ := spanLogs[i].Properties["timestamp"].(time.Time)
+			tj, _ := spanLogs[j].Properties["timestamp"].(time.Time)
+			if !ti.Equal(tj) {
+				return ti.Before(tj)
+			}
+			vi, _ := spanLogs[i].Properties["value"].(string)
+			vj, _ := spanLogs[j].Properties["value"].(string)
+			return vi < vj
+		})
+		spanId := strings.TrimPrefix(ids[spanElementId], "span:")
+		for i, l := range spanLogs {
+			ids[l.elementId] = fmt.Sprintf("log:%s:%d", spanId, i)
+		}
+	}
+
+	// nodes without a key or producer, which the query does not return today
+	for _, n := range nodes {
+		if _, ok := ids[n.elementId]; !ok {
+			ids[n.elementId] = fmt.Sprintf("%s:%s", strings.ToLower(primaryLabel(n.Labels)), n.elementId)
+		}
+	}
+	return ids
+}
+
+func (g *Graph) sort() {
+	sort.SliceStable(g.Nodes, func(i, j int) bool {
+		li, lj := labelOrder(g.Nodes[i].Labels), labelOrder(g.Nodes[j].Labels)
+		if li != lj {
+			return li < lj
+		}
+		return g.Nodes[i].Id < g.Nodes[j].Id
+	})
+	sort.SliceStable(g.Edges, func(i, j int) bool {
+		return g.Edges[i].Id < g.Edges[j].Id
+	})
+}
+
+func primaryLabel(nodeLabels []string) string {
+	for _, l := range labels {
+		if slices.Contains(nodeLabels, l) {
+			return l
+		}
+	}
+	if len(nodeLabels) > 0 {
+		return nodeLabels[0]
+	}
+	return "Node"
+}
+
+func labelOrder(nodeLabels []string) int {
+	i := slices.Index(labels, primaryLabel(nodeLabels))
+	if i < 0 {
+		return len(labels)
+	}
+	return i
+}
+
+func properties(props map[string]any, options Options) map[string]any {
+	properties := make(map[string]any, len(props))
+	for k, v := range props {
+		if !options.Embeddings && (k == "embedding" || strings.HasSuffix(k, "_embedding")) {
+			continue
+		}
+		properties[k] = v
+	}
+	return properties
+}
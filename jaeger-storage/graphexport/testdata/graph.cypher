@@ -0,0 +1,11 @@
+// knowledge graph of trace e72ef241661424eb6970b65f6fd74b30, exported by jaeger-storage
+MERGE (n:Trace {trace_id: "e72ef241661424eb6970b65f6fd74b30"}) SET n += {start_time: datetime("2024-12-19T10:00:00.123456789Z"), trace_id: "e72ef241661424eb6970b65f6fd74b30"};
+MERGE (n:Service {name: "redis-manual"}) SET n += {name: "redis-manual"};
+MERGE (n:Span {span_id: "57d9372746d52e20"}) SET n += {duration: 250000000, embedding: null, `http.status_code`: 503, latency_anomaly: true, latency_ratio: 3.5, similarity: 2.0, span_id: "57d9372746d52e20", start_time: datetime("2024-12-19T10:00:00.123456789Z"), summary: "The \"GetDriver\" call of redis-manual's pool timed out <after> 250ms & retried\nwith a 'backoff'", tags: ["retry", 2]}, n:`Slow ``cache`` call`;
+MERGE (n:Span {span_id: "a3cd538c449a5ee1"}) SET n += {duration: "unknown", span_id: "a3cd538c449a5ee1"};
+MATCH (a:Span {span_id: "57d9372746d52e20"}) MERGE (a)-[r:PRODUCES]->(n:Log {timestamp: datetime("2024-12-19T10:00:00.124456789Z"), value: "redis timeout after 250ms"}) SET n += {level: "error", timestamp: datetime("2024-12-19T10:00:00.124456789Z"), value: "redis timeout after 250ms"} SET r += {index: 0};
+MERGE (n:LogTemplate {count: 3, template: "redis timeout after <*>"});
+MATCH (a:Trace {trace_id: "e72ef241661424eb6970b65f6fd74b30"}), (b:Span {span_id: "57d9372746d52e20"}) MERGE (a)-[r:CONTAINS]->(b);
+MATCH (a:Service {name: "redis-manual"}), (b:Span {span_id: "57d9372746d52e20"}) MERGE (a)-[r:CONTAINS]->(b);
+MATCH (a:Span {span_id: "a3cd538c449a5ee1"}), (b:Span {span_id: "57d9372746d52e20"}) MERGE (a)-[r:INVOKES_CHILD]->(b) SET r += {weight: 1.0};
+MATCH (:Span {span_id: "57d9372746d52e20"})-[:PRODUCES]->(a:Log {timestamp: datetime("2024-12-19T10:00:00.124456789Z"), value: "redis timeout after 250ms"}), (b:LogTemplate {count: 3, template: "redis timeout after <*>"}) MERGE (a)-[r:`INSTANCE OF`]->(b);
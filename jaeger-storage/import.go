@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"io"
+	"jaeger-storage/alerting"
+	"jaeger-storage/traceio"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// errInvalidImport is returned when the document is not a trace export, as opposed to a failure to store it
+var errInvalidImport = errors.New("invalid trace export")
+
+// importResult is what was imported from a file
+type importResult struct {
+	File   string   `json:"file,omitempty"`
+	Format string   `json:"format"`
+	Spans  int      `json:"spans"`
+	Traces []string `json:"traces"`
+	// traces already stored, importing a file twice writes its spans once
+	Skipped []string `json:"skipped"`
+}
+
+// runImport writes the spans of Jaeger UI JSON and OTLP JSON files to the stores, a directory imports all its .json files
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	summarize := flags.String("summarize", string(ImportSummarizationPolicy), "policy summarizes like live spans, skip makes no LLM call, replay summarizes every span")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: jaeger-storage import [-summarize policy|skip|replay] file-or-directory...")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	summarization, err := ParseImportSummarization(*summarize)
+	if err != nil {
+		log.Fatalln("[import]", err)
+	}
+	files, err := importFiles(flags.Args())
+	if err != nil {
+		log.Fatalln("[import] cannot list files", err)
+	}
+
+	deps, err := newDependencies()
+	if err != nil {
+		log.Fatalln("[import] cannot initialize", err)
+	}
+	// alerting is not configured, imported spans are not live traffic and must not page anyone
+	alerter := alerting.NewAlerter(alerting.Config{}, deps.openaiClient, deps.usageTracker)
+	spanWriter, err := createSpanWriter(deps.db, deps.neo4jDriver, deps.openaiClient, deps.usageTracker, deps.redactor, alerter)
+	if err != nil {
+		log.Fatalln("[import] cannot create span writer", err)
+	}
+	spanReader := NewReaderDBClient(deps.db)
+
+	ctx := context.Background()
+	spans := 0
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatalln("[import] cannot open", file, err)
+		}
+		result, err := importTraces(ctx, spanReader, spanWriter, f, summarization)
+		f.Close()
+		if err != nil {
+			log.Fatalln("[import] cannot import", file, err)
+		}
+		log.Printf("[import] imported %d spans of %d traces from %s (%s), skipped %d traces already stored\n", result.Spans, len(result.Traces), file, result.Format, len(result.Skipped))
+		spans += result.Spans
+	}
+	log.Printf("[import] imported %d spans from %d files\n", spans, len(files))
+}
+
+// importFiles expands the directories of the arguments to the .json files they contain
+func importFiles(args []string) ([]string, error) {
+	files := make([]string, 0, len(args))
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no .json file in %s", arg)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// importTraces reads a Jaeger UI JSON or OTLP JSON document and writes the spans of the traces that are not stored yet
+func importTraces(ctx context.Context, spanReader *ReaderDbClient, spanWriter *WriterClient, r io.Reader, summarization ImportSummarization) (importResult, error) {
+	result := importResult{Traces: make([]string, 0), Skipped: make([]string, 0)}
+	spans, format, err := traceio.Read(r)
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", errInvalidImport, err)
+	}
+	result.Format = format
+
+	// decided before writing, the spans of a trace are not always next to each other in a file
+	stored := make(map[model.TraceID]bool)
+	for _, span := range spans {
+		if _, ok := stored[span.TraceID]; ok {
+			continue
+		}
+		if stored[span.TraceID], err = traceStored(ctx, spanReader, span.TraceID); err != nil {
+			return result, fmt.Errorf("trace %s: %w", span.TraceID, err)
+		}
+		if stored[span.TraceID] {
+			result.Skipped = append(result.Skipped, span.TraceID.String())
+		}
+	}
+
+	for _, span := range spans {
+		if stored[span.TraceID] {
+			continue
+		}
+		if err := spanWriter.ImportSpan(ctx, span, summarization); err != nil {
+			return result, fmt.Errorf("span %s: %w", span.SpanID, err)
+		}
+		result.Spans++
+		if traceId := span.TraceID.String(); !slices.Contains(result.Traces, traceId) {
+			result.Traces = append(result.Traces, traceId)
+		}
+	}
+	return result, nil
+}
+
+// traceStored tells whether a span of the trace is already stored
+func traceStored(ctx context.Context, spanReader *ReaderDbClient, traceId model.TraceID) (bool, error) {
+	trace, err := spanReader.GetTrace(ctx, traceId)
+	if errors.Is(err, spanstore.ErrTraceNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(trace.Spans) > 0, nil
+}
+
+// importStatus is the http status of an import error
+func importStatus(err error) int {
+	if errors.Is(err, errInvalidImport) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
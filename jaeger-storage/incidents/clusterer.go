@@ -0,0 +1,673 @@
+package incidents
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"jaeger-storage/usage"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	// how often new errors are clustered
+	Interval time.Duration
+	// maximum number of errors clustered per run
+	BatchSize int
+	// minimum cosine similarity between the embedding of a span and the centroid of a cluster of the same service
+	// and error type for the span to join it although its message template differs
+	SimilarityThreshold float64
+	// clusters are only grouped into an incident when their last errors are at most this far apart, so that an
+	// error recurring for weeks does not pull every error it ever co-occurred with into the same incident
+	Window time.Duration
+}
+
+// Describer writes the title and description of an incident from the description of its clusters
+type Describer interface {
+	DescribeIncident(ctx context.Context, passage string) (string, string, error)
+}
+
+// ClusterNotifier is told about the clusters of errors never seen before, e.g. to alert on them
+type ClusterNotifier interface {
+	NewErrorCluster(cluster ErrorCluster)
+}
+
+// Clusterer groups the errors raised by spans into ErrorCluster nodes, one per service, error type and message template,
+// and the clusters whose errors happen in the same traces into Incident nodes.
+type Clusterer struct {
+	driver       *neo4j.DriverWithContext
+	describer    Describer
+	usageTracker *usage.Tracker
+	notifier     ClusterNotifier
+	options      Options
+}
+
+func NewClusterer(driver *neo4j.DriverWithContext, describer Describer, usageTracker *usage.Tracker, notifier ClusterNotifier, options Options) *Clusterer {
+	if options.Interval <= 0 {
+		options.Interval = time.Minute
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 500
+	}
+	if options.SimilarityThreshold <= 0 {
+		options.SimilarityThreshold = 0.9
+	}
+	if options.Window <= 0 {
+		options.Window = time.Hour
+	}
+	return &Clusterer{
+		driver:       driver,
+		describer:    describer,
+		usageTracker: usageTracker,
+		notifier:     notifier,
+		options:      options,
+	}
+}
+
+type member struct {
+	errorId   string
+	spanId    string
+	traceId   string
+	operation string
+	message   string
+	timestamp time.Time
+	embedding []float64
+}
+
+type cluster struct {
+	id             string
+	serviceName    string
+	errorType      string
+	template       string
+	exampleMessage string
+	exampleSpanId  string
+	exampleTraceId string
+	firstSeen      time.Time
+	lastSeen       time.Time
+	count          int64
+	centroid       []float64
+	// number of members whose embedding is part of the centroid
+	centroidCount int64
+	// members assigned during this run
+	members []member
+	// created during this run
+	isNew bool
+}
+
+// Run clusters new errors every interval until the context is done
+func (c *Clusterer) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.RunOnce(ctx); err != nil {
+				log.Println("[Clusterer][Run][error] an error occurred while clustering errors", err)
+			}
+		}
+	}
+}
+
+// RunOnce assigns the errors not yet clustered to clusters, groups the clusters into incidents and describes
+// the incidents that are new or have grown since they were described.
+func (c *Clusterer) RunOnce(ctx context.Context) error {
+	ctx = usage.WithAttribution(ctx, usage.Attribution{Caller: usage.CallerIncidents})
+
+	members, services, err := c.unclusteredErrors(ctx)
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	clusters, err := c.loadClusters(ctx, services)
+	if err != nil {
+		return err
+	}
+
+	touched := make(map[string]*cluster)
+	for key, ms := range members {
+		for _, m := range ms {
+			cl := c.assign(clusters, key, m)
+			touched[cl.id] = cl
+		}
+	}
+	clusterIds := make([]string, 0, len(touched))
+	for id, cl := range touched {
+		if err := c.saveCluster(ctx, cl); err != nil {
+			return err
+		}
+		if cl.isNew {
+			c.notifier.NewErrorCluster(cl.export())
+		}
+		clusterIds = append(clusterIds, id)
+	}
+
+	incidentIds, err := c.groupIncidents(ctx, clusterIds)
+	if err != nil {
+		return err
+	}
+	log.Printf("[Clusterer][RunOnce] clustered errors into %d clusters and %d incidents\n", len(clusterIds), len(incidentIds))
+
+	return c.describeIncidents(ctx, incidentIds)
+}
+
+// memberKey identifies the clusters an error may join
+type memberKey struct {
+	serviceName string
+	errorType   string
+}
+
+// unclusteredErrors reads the errors that did not join a cluster yet, the errors of imported spans never do
+func (c *Clusterer) unclusteredErrors(ctx context.Context) (map[memberKey][]member, []string, error) {
+	query := `
+		MATCH (service: Service)-[:CONTAINS]->(span: Span)-[:RAISED]->(e: Error)
+		WHERE NOT (e)-[:MEMBER_OF]->(:ErrorCluster)
+		  AND NOT coalesce(span.imported, false)
+		RETURN e.error_id as error_id, e.type as type, e.message as message, e.timestamp as timestamp,
+			span.span_id as span_id, span.operation_name as operation_name, span.embedding as embedding,
+			service.name as service_name, e.trace_id as trace_id
+		ORDER BY e.timestamp
+		LIMIT $limit
+	`
+	res, err := neo4j.ExecuteQuery(ctx, *c.driver, query, map[string]any{
+		"limit": c.options.BatchSize,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[Clusterer][unclusteredErrors][error] cannot read errors", err)
+		return nil, nil, err
+	}
+
+	members := make(map[memberKey][]member)
+	services := make([]string, 0)
+	for _, record := range res.Records {
+		key := memberKey{}
+		key.serviceName, _, _ = neo4j.GetRecordValue[string](record, "service_name")
+		key.errorType, _, _ = neo4j.GetRecordValue[string](record, "type")
+		m := member{}
+		m.errorId, _, _ = neo4j.GetRecordValue[string](record, "error_id")
+		m.spanId, _, _ = neo4j.GetRecordValue[string](record, "span_id")
+		m.traceId, _, _ = neo4j.GetRecordValue[string](record, "trace_id")
+		m.operation, _, _ = neo4j.GetRecordValue[string](record, "operation_name")
+		m.message, _, _ = neo4j.GetRecordValue[string](record, "message")
+		m.timestamp, _, _ = neo4j.GetRecordValue[time.Time](record, "timestamp")
+		embedding, _, _ := neo4j.GetRecordValue[[]any](record, "embedding")
+		m.embedding = floats(embedding)
+
+		members[key] = append(members[key], m)
+		if !slices.Contains(services, key.serviceName) {
+			services = append(services, key.serviceName)
+		}
+	}
+	return members, services, nil
+}
+
+func (c *Clusterer) loadClusters(ctx context.Context, services []string) (map[memberKey][]*cluster, error) {
+	query := `
+		MATCH (c: ErrorCluster)
+		WHERE c.service_name IN $services
+		RETURN c.cluster_id as cluster_id, c.service_name as service_name, c.error_type as error_type,
+			c.template as template, c.example_message as example_message, c.example_span_id as example_span_id,
+			c.example_trace_id as example_trace_id, c.first_seen as first_seen, c.last_seen as last_seen,
+			c.count as count, c.centroid as centroid, c.centroid_count as centroid_count
+	`
+	res, err := neo4j.ExecuteQuery(ctx, *c.driver, query, map[string]any{
+		"services": services,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[Clusterer][loadClusters][error] cannot read clusters", err)
+		return nil, err
+	}
+
+	clusters := make(map[memberKey][]*cluster)
+	for _, record := range res.Records {
+		cl := &cluster{}
+		cl.id, _, _ = neo4j.GetRecordValue[string](record, "cluster_id")
+		cl.serviceName, _, _ = neo4j.GetRecordValue[string](record, "service_name")
+		cl.errorType, _, _ = neo4j.GetRecordValue[string](record, "error_type")
+		cl.template, _, _ = neo4j.GetRecordValue[string](record, "template")
+		cl.exampleMessage, _, _ = neo4j.GetRecordValue[string](record, "example_message")
+		cl.exampleSpanId, _, _ = neo4j.GetRecordValue[string](record, "example_span_id")
+		cl.exampleTraceId, _, _ = neo4j.GetRecordValue[string](record, "example_trace_id")
+		cl.firstSeen, _, _ = neo4j.GetRecordValue[time.Time](record, "first_seen")
+		cl.lastSeen, _, _ = neo4j.GetRecordValue[time.Time](record, "last_seen")
+		cl.count, _, _ = neo4j.GetRecordValue[int64](record, "count")
+		cl.centroidCount, _, _ = neo4j.GetRecordValue[int64](record, "centroid_count")
+		centroid, _, _ := neo4j.GetRecordValue[[]any](record, "centroid")
+		cl.centroid = floats(centroid)
+
+		key := memberKey{serviceName: cl.serviceName, errorType: cl.errorType}
+		clusters[key] = append(clusters[key], cl)
+	}
+	return clusters, nil
+}
+
+// memberTemplate is the template of the error message, errors without a message are grouped by operation
+func memberTemplate(m member) string {
+	if strings.TrimSpace(m.message) == "" {
+		return fmt.Sprintf("%s failed", m.operation)
+	}
+	return Template(m.message)
+}
+
+// assign adds the member to the cluster with the same template, or else to the most similar cluster by embedding,
+// or else to a new cluster.
+func (c *Clusterer) assign(clusters map[memberKey][]*cluster, key memberKey, m member) *cluster {
+	template := memberTemplate(m)
+	var match *cluster
+	for _, cl := range clusters[key] {
+		if cl.template == template {
+			match = cl
+			break
+		}
+	}
+	if match == nil && m.embedding != nil {
+		best := c.options.SimilarityThreshold
+		for _, cl := range clusters[key] {
+			if similarity := cosine(m.embedding, cl.centroid); similarity >= best {
+				match, best = cl, similarity
+			}
+		}
+	}
+	if match == nil {
+		match = &cluster{
+			id:             clusterId(key, template),
+			serviceName:    key.serviceName,
+			errorType:      key.errorType,
+			template:       template,
+			exampleMessage: m.message,
+			exampleSpanId:  m.spanId,
+			exampleTraceId: m.traceId,
+			firstSeen:      m.timestamp,
+			lastSeen:       m.timestamp,
+			isNew:          true,
+		}
+		clusters[key] = append(clusters[key], match)
+	}
+
+	match.count++
+	match.members = append(match.members, m)
+	if m.timestamp.Before(match.firstSeen) {
+		match.firstSeen = m.timestamp
+	}
+	if m.timestamp.After(match.lastSeen) {
+		match.lastSeen = m.timestamp
+	}
+	if m.embedding != nil && (match.centroid == nil || len(match.centroid) == len(m.embedding)) {
+		// running mean of the embeddings of the members
+		if match.centroid == nil {
+			match.centroid = make([]float64, len(m.embedding))
+		}
+		match.centroidCount++
+		for i := range match.centroid {
+			match.centroid[i] += (m.embedding[i] - match.centroid[i]) / float64(match.centroidCount)
+		}
+	}
+	return match
+}
+
+func (cl *cluster) export() ErrorCluster {
+	return ErrorCluster{
+		ClusterId:      cl.id,
+		ServiceName:    cl.serviceName,
+		ErrorType:      cl.errorType,
+		Template:       cl.template,
+		ExampleMessage: cl.exampleMessage,
+		ExampleSpanId:  cl.exampleSpanId,
+		ExampleTraceId: cl.exampleTraceId,
+		FirstSeen:      cl.firstSeen,
+		LastSeen:       cl.lastSeen,
+		Count:          cl.count,
+	}
+}
+
+func clusterId(key memberKey, template string) string {
+	sum := sha1.Sum([]byte(key.serviceName + "\x00" + key.errorType + "\x00" + template))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (c *Clusterer) saveCluster(ctx context.Context, cl *cluster) error {
+	members := make([]map[string]any, 0, len(cl.members))
+	for _, m := range cl.members {
+		members = append(members, map[string]any{"span_id": m.spanId, "error_id": m.errorId})
+	}
+	query := `
+		MERGE (c: ErrorCluster { cluster_id: $cluster_id })
+		SET c.service_name = $service_name,
+			c.error_type = $error_type,
+			c.template = $template,
+			c.example_message = $example_message,
+			c.example_span_id = $example_span_id,
+			c.example_trace_id = $example_trace_id,
+			c.first_seen = $first_seen,
+			c.last_seen = $last_seen,
+			c.count = $count,
+			c.centroid = $centroid,
+			c.centroid_count = $centroid_count
+		WITH c
+		MATCH (service: Service { name: $service_name })
+		MERGE (service)-[:RAISED]->(c)
+		WITH c
+		UNWIND $members as m
+		MATCH (span: Span { span_id: m.span_id })-[:RAISED]->(e: Error { error_id: m.error_id })
+		MERGE (span)-[:MEMBER_OF]->(c)
+		MERGE (e)-[:MEMBER_OF]->(c)
+	`
+	var centroid any
+	if cl.centroid != nil {
+		centroid = cl.centroid
+	}
+	_, err := neo4j.ExecuteQuery(ctx, *c.driver, query, map[string]any{
+		"cluster_id":       cl.id,
+		"service_name":     cl.serviceName,
+		"error_type":       cl.errorType,
+		"template":         cl.template,
+		"example_message":  cl.exampleMessage,
+		"example_span_id":  cl.exampleSpanId,
+		"example_trace_id": cl.exampleTraceId,
+		"first_seen":       cl.firstSeen,
+		"last_seen":        cl.lastSeen,
+		"count":            cl.count,
+		"centroid":         centroid,
+		"centroid_count":   cl.centroidCount,
+		"members":          members,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[Clusterer][saveCluster][error] cannot save cluster", cl.id, err)
+		return err
+	}
+	return nil
+}
+
+// groupIncidents puts the clusters whose errors happen in the same traces within the window in the same incident,
+// merging incidents when a cluster joins two of them. Returns the incidents that changed.
+func (c *Clusterer) groupIncidents(ctx context.Context, clusterIds []string) ([]string, error) {
+	// clusters already part of an incident come with all the clusters of that incident, so that it is merged as a whole
+	res, err := neo4j.ExecuteQuery(ctx, *c.driver, `
+		MATCH (c: ErrorCluster) WHERE c.cluster_id IN $cluster_ids
+		OPTIONAL MATCH (c)<-[:MEMBER_OF]-(:Span)<-[:CONTAINS]-(:Trace)-[:CONTAINS]->(:Span)-[:MEMBER_OF]->(other: ErrorCluster)
+		WHERE other <> c
+		WITH c, collect(DISTINCT other) + [c] as related
+		UNWIND related as r
+		OPTIONAL MATCH (r)-[:PART_OF]->(:Incident)<-[:PART_OF]-(sibling: ErrorCluster)
+		WITH c, r, collect(DISTINCT sibling.cluster_id) as siblings
+		RETURN c.cluster_id as cluster_id, c.last_seen as last_seen,
+			collect({cluster_id: r.cluster_id, last_seen: r.last_seen, siblings: siblings}) as related
+	`, map[string]any{"cluster_ids": clusterIds}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[Clusterer][groupIncidents][error] cannot read co-occurring clusters", err)
+		return nil, err
+	}
+
+	occurrences := make([]coOccurrence, 0, len(res.Records))
+	for _, record := range res.Records {
+		o := coOccurrence{}
+		o.clusterId, _, _ = neo4j.GetRecordValue[string](record, "cluster_id")
+		o.lastSeen, _, _ = neo4j.GetRecordValue[time.Time](record, "last_seen")
+		related, _, _ := neo4j.GetRecordValue[[]any](record, "related")
+		for _, r := range related {
+			fields, ok := r.(map[string]any)
+			if !ok {
+				continue
+			}
+			rc := relatedCluster{}
+			rc.clusterId, _ = fields["cluster_id"].(string)
+			rc.lastSeen, _ = fields["last_seen"].(time.Time)
+			siblings, _ := fields["siblings"].([]any)
+			for _, sibling := range siblings {
+				if id, ok := sibling.(string); ok {
+					rc.siblings = append(rc.siblings, id)
+				}
+			}
+			o.related = append(o.related, rc)
+		}
+		occurrences = append(occurrences, o)
+	}
+
+	components := groupCoOccurrences(occurrences, c.options.Window)
+	incidentIds := make([]string, 0, len(components))
+	for _, ids := range components {
+		incidentId, err := c.mergeIncident(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		incidentIds = append(incidentIds, incidentId)
+	}
+	return incidentIds, nil
+}
+
+// coOccurrence is a cluster and the clusters whose errors happen in the same traces, the cluster itself included
+type coOccurrence struct {
+	clusterId string
+	lastSeen  time.Time
+	related   []relatedCluster
+}
+
+type relatedCluster struct {
+	clusterId string
+	lastSeen  time.Time
+	// the other clusters of the incident of the related cluster
+	siblings []string
+}
+
+// groupCoOccurrences is a union find over the clusters. A related cluster, and the incident it is part of, joins
+// the cluster when their last errors are at most window apart. A cluster always stays with its own incident.
+func groupCoOccurrences(occurrences []coOccurrence, window time.Duration) [][]string {
+	parent := make(map[string]string)
+	var find func(id string) string
+	find = func(id string) string {
+		if _, ok := parent[id]; !ok {
+			parent[id] = id
+		}
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+	union := func(a, b string) {
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			parent[max(ra, rb)] = min(ra, rb)
+		}
+	}
+	for _, o := range occurrences {
+		find(o.clusterId)
+		for _, r := range o.related {
+			apart := o.lastSeen.Sub(r.lastSeen)
+			if r.clusterId != o.clusterId && (apart > window || apart < -window) {
+				continue
+			}
+			union(o.clusterId, r.clusterId)
+			for _, sibling := range r.siblings {
+				union(o.clusterId, sibling)
+			}
+		}
+	}
+
+	byRoot := make(map[string][]string)
+	for id := range parent {
+		root := find(id)
+		byRoot[root] = append(byRoot[root], id)
+	}
+	components := make([][]string, 0, len(byRoot))
+	for _, ids := range byRoot {
+		slices.Sort(ids)
+		components = append(components, ids)
+	}
+	slices.SortFunc(components, func(a, b []string) int {
+		return strings.Compare(a[0], b[0])
+	})
+	return components
+}
+
+// mergeIncident links the clusters to a single incident, the oldest of their current incidents or else a new one,
+// and updates its first seen, last seen and count
+func (c *Clusterer) mergeIncident(ctx context.Context, clusterIds []string) (string, error) {
+	slices.Sort(clusterIds)
+	res, err := neo4j.ExecuteQuery(ctx, *c.driver, `
+		MATCH (c: ErrorCluster)-[:PART_OF]->(i: Incident)
+		WHERE c.cluster_id IN $cluster_ids
+		WITH DISTINCT i
+		ORDER BY i.first_seen
+		LIMIT 1
+		RETURN i.incident_id as incident_id
+	`, map[string]any{"cluster_ids": clusterIds}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[Clusterer][mergeIncident][error] cannot read incidents", err)
+		return "", err
+	}
+	incidentId := clusterIds[0]
+	if len(res.Records) > 0 {
+		incidentId, _, _ = neo4j.GetRecordValue[string](res.Records[0], "incident_id")
+	}
+
+	_, err = neo4j.ExecuteQuery(ctx, *c.driver, `
+		MERGE (i: Incident { incident_id: $incident_id })
+		WITH i
+		UNWIND $cluster_ids as cluster_id
+		MATCH (c: ErrorCluster { cluster_id: cluster_id })
+		OPTIONAL MATCH (c)-[old:PART_OF]->(previous: Incident)
+		WHERE previous <> i
+		DELETE old
+		MERGE (c)-[:PART_OF]->(i)
+		WITH DISTINCT i, previous
+		WHERE previous IS NOT NULL AND NOT ()-[:PART_OF]->(previous)
+		DETACH DELETE previous
+	`, map[string]any{
+		"incident_id": incidentId,
+		"cluster_ids": clusterIds,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[Clusterer][mergeIncident][error] cannot link clusters to incident", incidentId, err)
+		return "", err
+	}
+
+	_, err = neo4j.ExecuteQuery(ctx, *c.driver, `
+		MATCH (i: Incident { incident_id: $incident_id })<-[:PART_OF]-(c: ErrorCluster)
+		WITH i, min(c.first_seen) as first_seen, max(c.last_seen) as last_seen, sum(c.count) as count,
+			collect(DISTINCT c.service_name) as services, count(c) as cluster_count
+		SET i.first_seen = first_seen,
+			i.last_seen = last_seen,
+			i.count = count,
+			i.services = services,
+			i.cluster_count = cluster_count
+	`, map[string]any{"incident_id": incidentId}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[Clusterer][mergeIncident][error] cannot update incident", incidentId, err)
+		return "", err
+	}
+	return incidentId, nil
+}
+
+// describeIncidents asks the LLM for a title and a description of the incidents that were never described
+// or whose number of errors doubled since
+func (c *Clusterer) describeIncidents(ctx context.Context, incidentIds []string) error {
+	if c.usageTracker.BudgetExceeded() {
+		log.Println("[Clusterer][describeIncidents] the LLM budget is exceeded, incidents are not described")
+		return nil
+	}
+	res, err := neo4j.ExecuteQuery(ctx, *c.driver, `
+		MATCH (i: Incident)<-[:PART_OF]-(c: ErrorCluster)
+		WHERE i.incident_id IN $incident_ids AND (i.described_count IS NULL OR i.count >= 2 * i.described_count)
+		OPTIONAL MATCH (span: Span { span_id: c.example_span_id })
+		WITH i, c, span
+		ORDER BY c.count DESC
+		WITH i, collect({
+			service_name: c.service_name, error_type: c.error_type, template: c.template, example_message: c.example_message,
+			count: c.count, first_seen: c.first_seen, last_seen: c.last_seen, operation_name: span.operation_name,
+			summary: span.span_summary
+		})[..10] as clusters
+		RETURN i.incident_id as incident_id, i.count as count, clusters
+	`, map[string]any{"incident_ids": incidentIds}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[Clusterer][describeIncidents][error] cannot read incidents", err)
+		return err
+	}
+
+	for _, record := range res.Records {
+		incidentId, _, _ := neo4j.GetRecordValue[string](record, "incident_id")
+		count, _, _ := neo4j.GetRecordValue[int64](record, "count")
+		clusters, _, _ := neo4j.GetRecordValue[[]any](record, "clusters")
+
+		title, description, err := c.describer.DescribeIncident(ctx, describeClusters(clusters))
+		if err != nil {
+			log.Println("[Clusterer][describeIncidents][error] cannot describe incident", incidentId, err)
+			continue
+		}
+		_, err = neo4j.ExecuteQuery(ctx, *c.driver, `
+			MATCH (i: Incident { incident_id: $incident_id })
+			SET i.title = $title,
+				i.description = $description,
+				i.described_count = $count
+		`, map[string]any{
+			"incident_id": incidentId,
+			"title":       title,
+			"description": description,
+			"count":       count,
+		}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+		if err != nil {
+			log.Println("[Clusterer][describeIncidents][error] cannot save the description of incident", incidentId, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func describeClusters(clusters []any) string {
+	var sb strings.Builder
+	for _, c := range clusters {
+		cl, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("Service: %v, operation: %v, error type: %v\n", cl["service_name"], cl["operation_name"], cl["error_type"]))
+		sb.WriteString(fmt.Sprintf("Error template: %v\n", cl["template"]))
+		if cl["example_message"] != "" {
+			sb.WriteString(fmt.Sprintf("Example message: %v\n", cl["example_message"]))
+		}
+		sb.WriteString(fmt.Sprintf("Occurrences: %v, first seen %v, last seen %v\n", cl["count"], cl["first_seen"], cl["last_seen"]))
+		if cl["summary"] != nil {
+			sb.WriteString(fmt.Sprintf("Example span: %v\n", cl["summary"]))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func floats(values []any) []float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	result := make([]float64, 0, len(values))
+	for _, v := range values {
+		f, ok := v.(float64)
+		if !ok {
+			return nil
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+func cosine(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,158 @@
+package incidents
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"user 42 not found", "user <num> not found"},
+		{"redis timeout after 250ms", "redis timeout after <num>"},
+		{"order 3fa85f64-5717-4562-b3fc-2c963f66afa6 failed", "order <uuid> failed"},
+		{"cannot reach 10.0.0.12:6379", "cannot reach <ip>"},
+		{"GET https://api.example.com/v1/users?id=7 returned 503", "GET <url> returned <num>"},
+		{"no customer with email jane@example.com", "no customer with email <email>"},
+		{`driver "T7991012" is busy`, "driver <str> is busy"},
+		{"checksum deadbeefcafe mismatch at 0x1f", "checksum <hex> mismatch at <hex>"},
+		{"  too   many\nspaces ", "too many spaces"},
+		{"connection refused", "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			if got := Template(tt.message); got != tt.want {
+				t.Errorf("Template(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssign(t *testing.T) {
+	c := &Clusterer{options: Options{SimilarityThreshold: 0.9}}
+	key := memberKey{serviceName: "redis-manual", errorType: "timeout"}
+	at := func(minutes int) time.Time {
+		return time.Date(2024, 12, 19, 10, minutes, 0, 0, time.UTC)
+	}
+	clusters := make(map[memberKey][]*cluster)
+
+	first := c.assign(clusters, key, member{errorId: "e1", spanId: "s1", traceId: "t1", message: "redis timeout after 250ms", timestamp: at(5), embedding: []float64{1, 0}})
+	if !first.isNew || first.template != "redis timeout after <num>" || first.count != 1 {
+		t.Fatalf("first member created %+v, want a new cluster with the template of the message", first)
+	}
+	if first.id != clusterId(key, first.template) || first.exampleSpanId != "s1" || first.exampleTraceId != "t1" {
+		t.Errorf("cluster id %s, example span %s, example trace %s", first.id, first.exampleSpanId, first.exampleTraceId)
+	}
+
+	// same template, earlier error
+	same := c.assign(clusters, key, member{errorId: "e2", spanId: "s2", message: "redis timeout after 900ms", timestamp: at(1), embedding: []float64{0, 1}})
+	if same != first || same.count != 2 || len(same.members) != 2 {
+		t.Fatalf("a message with the same template did not join the cluster")
+	}
+	if !same.firstSeen.Equal(at(1)) || !same.lastSeen.Equal(at(5)) {
+		t.Errorf("first seen %s, last seen %s, want %s and %s", same.firstSeen, same.lastSeen, at(1), at(5))
+	}
+	if !slices.Equal(same.centroid, []float64{0.5, 0.5}) || same.centroidCount != 2 {
+		t.Errorf("centroid = %v of %d members, want the mean of the embeddings", same.centroid, same.centroidCount)
+	}
+
+	// different template, embedding close to the centroid
+	similar := c.assign(clusters, key, member{errorId: "e3", spanId: "s3", message: "Redis read timed out", timestamp: at(9), embedding: []float64{0.52, 0.48}})
+	if similar != first || !similar.lastSeen.Equal(at(9)) {
+		t.Fatalf("a similar error created %+v, want it to join the cluster", similar)
+	}
+
+	// different template, dissimilar embedding
+	other := c.assign(clusters, key, member{errorId: "e4", spanId: "s4", message: "connection refused", timestamp: at(2), embedding: []float64{1, -1}})
+	if other == first || !other.isNew || len(clusters[key]) != 2 {
+		t.Fatalf("a dissimilar error joined %s, want a new cluster", other.id)
+	}
+
+	// without a message errors are grouped by operation, and never join by embedding
+	noMessage := c.assign(clusters, key, member{errorId: "e5", spanId: "s5", operation: "GetDriver", timestamp: at(3)})
+	if noMessage.template != "GetDriver failed" || !noMessage.isNew || noMessage.centroid != nil {
+		t.Errorf("an error without a message created %+v", noMessage)
+	}
+
+	// the service and the error type are part of the key
+	otherKey := memberKey{serviceName: "driver", errorType: "timeout"}
+	elsewhere := c.assign(clusters, otherKey, member{errorId: "e6", spanId: "s6", message: "redis timeout after 250ms", timestamp: at(4)})
+	if elsewhere == first || elsewhere.id == first.id {
+		t.Errorf("an error of another service joined the cluster of redis-manual")
+	}
+}
+
+func TestGroupCoOccurrences(t *testing.T) {
+	now := time.Date(2024, 12, 19, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		occurrences []coOccurrence
+		want        [][]string
+	}{
+		{
+			name: "co-occurring within the window",
+			occurrences: []coOccurrence{
+				{clusterId: "a", lastSeen: now, related: []relatedCluster{
+					{clusterId: "a", lastSeen: now},
+					{clusterId: "b", lastSeen: now.Add(-30 * time.Minute)},
+				}},
+			},
+			want: [][]string{{"a", "b"}},
+		},
+		{
+			name: "co-occurring long ago",
+			occurrences: []coOccurrence{
+				{clusterId: "a", lastSeen: now, related: []relatedCluster{
+					{clusterId: "a", lastSeen: now},
+					{clusterId: "b", lastSeen: now.Add(-3 * time.Hour), siblings: []string{"c"}},
+				}},
+			},
+			want: [][]string{{"a"}},
+		},
+		{
+			name: "joins the incident of a related cluster",
+			occurrences: []coOccurrence{
+				{clusterId: "a", lastSeen: now, related: []relatedCluster{
+					{clusterId: "a", lastSeen: now},
+					{clusterId: "b", lastSeen: now.Add(10 * time.Minute), siblings: []string{"b", "c"}},
+				}},
+			},
+			want: [][]string{{"a", "b", "c"}},
+		},
+		{
+			name: "stays with its own incident",
+			occurrences: []coOccurrence{
+				{clusterId: "a", lastSeen: now, related: []relatedCluster{
+					{clusterId: "a", lastSeen: now, siblings: []string{"a", "old"}},
+				}},
+			},
+			want: [][]string{{"a", "old"}},
+		},
+		{
+			name: "transitive only through recent links",
+			occurrences: []coOccurrence{
+				{clusterId: "a", lastSeen: now, related: []relatedCluster{
+					{clusterId: "a", lastSeen: now},
+					{clusterId: "b", lastSeen: now.Add(-20 * time.Minute)},
+				}},
+				{clusterId: "c", lastSeen: now.Add(-5 * time.Hour), related: []relatedCluster{
+					{clusterId: "c", lastSeen: now.Add(-5 * time.Hour)},
+					{clusterId: "b", lastSeen: now.Add(-20 * time.Minute)},
+				}},
+				{clusterId: "d", lastSeen: now},
+			},
+			want: [][]string{{"a", "b"}, {"c"}, {"d"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := groupCoOccurrences(tt.occurrences, time.Hour)
+			if !slices.EqualFunc(got, tt.want, slices.Equal[[]string]) {
+				t.Errorf("groupCoOccurrences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package incidents
+
+import (
+	"context"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"log"
+	"time"
+)
+
+type ErrorCluster struct {
+	ClusterId      string    `json:"cluster_id"`
+	ServiceName    string    `json:"service_name"`
+	ErrorType      string    `json:"error_type"`
+	Template       string    `json:"template"`
+	ExampleMessage string    `json:"example_message"`
+	ExampleSpanId  string    `json:"example_span_id"`
+	ExampleTraceId string    `json:"example_trace_id"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	Count          int64     `json:"count"`
+}
+
+type Incident struct {
+	IncidentId string `json:"incident_id"`
+	// empty until the incident is described by the LLM
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Services    []string       `json:"services"`
+	FirstSeen   time.Time      `json:"first_seen"`
+	LastSeen    time.Time      `json:"last_seen"`
+	Count       int64          `json:"count"`
+	Clusters    []ErrorCluster `json:"clusters"`
+}
+
+type ListQuery struct {
+	// only incidents seen since
+	Since time.Time
+	// only incidents with an error in the service, ignored when empty
+	ServiceName string
+	Limit       int
+}
+
+// List returns the incidents seen most recently first, with their clusters sorted by number of errors
+func List(ctx context.Context, driver *neo4j.DriverWithContext, query ListQuery) ([]Incident, error) {
+	res, err := neo4j.ExecuteQuery(ctx, *driver, `
+		MATCH (i: Incident)<-[:PART_OF]-(c: ErrorCluster)
+		WHERE i.last_seen >= $since AND ($service_name = '' OR $service_name IN i.services)
+		WITH i, c
+		ORDER BY c.count DESC
+		WITH i, collect(c) as clusters
+		RETURN i, clusters
+		ORDER BY i.last_seen DESC
+		LIMIT $limit
+	`, map[string]any{
+		"since":        query.Since,
+		"service_name": query.ServiceName,
+		"limit":        query.Limit,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[incidents][List][error] cannot read incidents", err)
+		return nil, err
+	}
+
+	incidents := make([]Incident, 0, len(res.Records))
+	for _, record := range res.Records {
+		node, _, err := neo4j.GetRecordValue[neo4j.Node](record, "i")
+		if err != nil {
+			return nil, err
+		}
+		incident := Incident{
+			IncidentId:  property[string](node, "incident_id"),
+			Title:       property[string](node, "title"),
+			Description: property[string](node, "description"),
+			FirstSeen:   property[time.Time](node, "first_seen"),
+			LastSeen:    property[time.Time](node, "last_seen"),
+			Count:       property[int64](node, "count"),
+			Services:    make([]string, 0),
+			Clusters:    make([]ErrorCluster, 0),
+		}
+		for _, s := range property[[]any](node, "services") {
+			if service, ok := s.(string); ok {
+				incident.Services = append(incident.Services, service)
+			}
+		}
+
+		clusters, _, _ := neo4j.GetRecordValue[[]any](record, "clusters")
+		for _, c := range clusters {
+			clusterNode, ok := c.(neo4j.Node)
+			if !ok {
+				continue
+			}
+			incident.Clusters = append(incident.Clusters, ErrorCluster{
+				ClusterId:      property[string](clusterNode, "cluster_id"),
+				ServiceName:    property[string](clusterNode, "service_name"),
+				ErrorType:      property[string](clusterNode, "error_type"),
+				Template:       property[string](clusterNode, "template"),
+				ExampleMessage: property[string](clusterNode, "example_message"),
+				ExampleSpanId:  property[string](clusterNode, "example_span_id"),
+				ExampleTraceId: property[string](clusterNode, "example_trace_id"),
+				FirstSeen:      property[time.Time](clusterNode, "first_seen"),
+				LastSeen:       property[time.Time](clusterNode, "last_seen"),
+				Count:          property[int64](clusterNode, "count"),
+			})
+		}
+		incidents = append(incidents, incident)
+	}
+	return incidents, nil
+}
+
+func property[T any](node neo4j.Node, key string) T {
+	value, _ := node.Props[key].(T)
+	return value
+}
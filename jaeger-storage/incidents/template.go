@@ -0,0 +1,32 @@
+package incidents
+
+import (
+	"regexp"
+	"strings"
+)
+
+// the order matters, e.g. a UUID or an address would otherwise be masked as numbers
+var maskers = []struct {
+	regex *regexp.Regexp
+	mask  string
+}{
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`), "<email>"},
+	{regexp.MustCompile(`(?i)\b[a-z][a-z0-9+.-]*://[^\s"']+`), "<url>"},
+	{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}(?::\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`'[^']*'|"[^"]*"`), "<str>"},
+	{regexp.MustCompile(`-?\b\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h)?\b`), "<num>"},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b|\b[0-9a-f]{8,}\b`), "<hex>"},
+}
+
+var whitespaceRegex = regexp.MustCompile(`\s+`)
+
+// Template normalises an error message so that errors that only differ by IDs, addresses, durations or quoted values
+// are the same, e.g. `user 42 not found` and `user 43 not found` are both `user <num> not found`.
+func Template(message string) string {
+	template := strings.TrimSpace(message)
+	for _, m := range maskers {
+		template = m.regex.ReplaceAllString(template, m.mask)
+	}
+	return whitespaceRegex.ReplaceAllString(template, " ")
+}
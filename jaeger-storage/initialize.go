@@ -5,8 +5,13 @@ import (
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"jaeger-storage/incidents"
+	"jaeger-storage/logtemplate"
+	"jaeger-storage/text2cypher"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 type NewDbOpt struct {
@@ -64,3 +69,131 @@ func MakeSureThingsAreOk() {
 		panic("please provide a value to OPENAI_API_KEY env")
 	}
 }
+
+func spanFilterReloadInterval() time.Duration {
+	interval := 10 * time.Second
+	if v := os.Getenv("SPAN_FILTER_RELOAD_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Println("[spanFilterReloadInterval][error] invalid SPAN_FILTER_RELOAD_INTERVAL", v, err)
+			return interval
+		}
+		interval = d
+	}
+	return interval
+}
+
+func promptTemplatesReloadInterval() time.Duration {
+	interval := 10 * time.Second
+	if v := os.Getenv("PROMPT_TEMPLATES_RELOAD_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Println("[promptTemplatesReloadInterval][error] invalid PROMPT_TEMPLATES_RELOAD_INTERVAL", v, err)
+			return interval
+		}
+		interval = d
+	}
+	return interval
+}
+
+// passageTokenBudget is the default maximum number of tokens of the graph-rag passage
+func passageTokenBudget() int {
+	budget := 6000
+	if v := os.Getenv("PASSAGE_TOKEN_BUDGET"); v != "" {
+		b, err := strconv.Atoi(v)
+		if err != nil {
+			log.Println("[passageTokenBudget][error] invalid PASSAGE_TOKEN_BUDGET", v, err)
+			return budget
+		}
+		budget = b
+	}
+	return budget
+}
+
+// agentMaxIterations bounds the number of model calls of the agent method of /api/ask
+func agentMaxIterations() int {
+	iterations := 8
+	if v := os.Getenv("AGENT_MAX_ITERATIONS"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil || i <= 0 {
+			log.Println("[agentMaxIterations][error] invalid AGENT_MAX_ITERATIONS", v, err)
+			return iterations
+		}
+		iterations = i
+	}
+	return iterations
+}
+
+// text2cypherOptions bounds the rows and the duration of the queries generated by the text2cypher method of /api/ask
+func text2cypherOptions() text2cypher.Options {
+	options := text2cypher.Options{MaxRows: 100, Timeout: 10 * time.Second, MaxAttempts: 3}
+	if v := os.Getenv("TEXT2CYPHER_MAX_ROWS"); v != "" {
+		rows, err := strconv.Atoi(v)
+		if err != nil || rows <= 0 {
+			log.Println("[text2cypherOptions][error] invalid TEXT2CYPHER_MAX_ROWS", v, err)
+		} else {
+			options.MaxRows = rows
+		}
+	}
+	if v := os.Getenv("TEXT2CYPHER_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Println("[text2cypherOptions][error] invalid TEXT2CYPHER_TIMEOUT", v, err)
+		} else {
+			options.Timeout = d
+		}
+	}
+	return options
+}
+
+// incidentOptions configures the background job clustering errors into incidents
+func incidentOptions() incidents.Options {
+	options := incidents.Options{Interval: time.Minute, BatchSize: 500, SimilarityThreshold: 0.9, Window: time.Hour}
+	if v := os.Getenv("INCIDENT_CLUSTERING_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Println("[incidentOptions][error] invalid INCIDENT_CLUSTERING_INTERVAL", v, err)
+		} else {
+			options.Interval = d
+		}
+	}
+	if v := os.Getenv("INCIDENT_SIMILARITY_THRESHOLD"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t <= 0 || t > 1 {
+			log.Println("[incidentOptions][error] invalid INCIDENT_SIMILARITY_THRESHOLD", v, err)
+		} else {
+			options.SimilarityThreshold = t
+		}
+	}
+	if v := os.Getenv("INCIDENT_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Println("[incidentOptions][error] invalid INCIDENT_WINDOW", v, err)
+		} else {
+			options.Window = d
+		}
+	}
+	return options
+}
+
+// logTemplateOptions configures the miner grouping the logs into templates
+func logTemplateOptions() logtemplate.Options {
+	options := logtemplate.Options{Depth: 4, SimilarityThreshold: 0.4, MaxChildren: 100}
+	if v := os.Getenv("LOG_TEMPLATE_DEPTH"); v != "" {
+		depth, err := strconv.Atoi(v)
+		if err != nil || depth < 3 {
+			log.Println("[logTemplateOptions][error] invalid LOG_TEMPLATE_DEPTH", v, err)
+		} else {
+			options.Depth = depth
+		}
+	}
+	if v := os.Getenv("LOG_TEMPLATE_SIMILARITY"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t <= 0 || t > 1 {
+			log.Println("[logTemplateOptions][error] invalid LOG_TEMPLATE_SIMILARITY", v, err)
+		} else {
+			options.SimilarityThreshold = t
+		}
+	}
+	return options
+}
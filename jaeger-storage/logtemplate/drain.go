@@ -0,0 +1,199 @@
+package logtemplate
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Wildcard marks the variable parts of a template
+const Wildcard = "<*>"
+
+type Options struct {
+	// depth of the routing tree, a message is routed by its token count then by its first Depth-2 tokens
+	Depth int
+	// minimum share of the tokens of a template a message must have for the message to match it
+	SimilarityThreshold float64
+	// maximum number of children of a node of the routing tree, further tokens go to the wildcard child
+	MaxChildren int
+}
+
+// Template is a snapshot of a mined template
+type Template struct {
+	Id       string
+	Template string
+}
+
+// Match is the template of a message and the values of its wildcards in order
+type Match struct {
+	Template
+	Variables []string
+}
+
+type cluster struct {
+	id     string
+	tokens []string
+}
+
+type node struct {
+	children map[string]*node
+	clusters []*cluster
+}
+
+func newNode() *node {
+	return &node{children: make(map[string]*node)}
+}
+
+// Miner extracts templates from log messages with the Drain algorithm: messages are routed through a fixed depth tree
+// by their number of tokens and their first tokens, then matched against the templates of the leaf by the share of
+// equal tokens. Tokens that differ between a template and a matching message become wildcards.
+type Miner struct {
+	options Options
+	root    *node
+	mutex   sync.Mutex
+}
+
+func NewMiner(options Options) *Miner {
+	if options.Depth <= 0 {
+		options.Depth = 4
+	}
+	// the root and the token count are the first two levels
+	options.Depth = max(options.Depth, 3)
+	if options.SimilarityThreshold <= 0 {
+		options.SimilarityThreshold = 0.4
+	}
+	if options.MaxChildren <= 0 {
+		options.MaxChildren = 100
+	}
+	return &Miner{options: options, root: newNode()}
+}
+
+// Add matches the message against the known templates, creating or generalising a template as needed
+func (m *Miner) Add(message string) Match {
+	tokens := strings.Fields(message)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	leaf := m.leaf(tokens)
+	best := m.bestCluster(leaf, tokens)
+	if best == nil {
+		best = &cluster{id: templateId(tokens), tokens: tokens}
+		leaf.clusters = append(leaf.clusters, best)
+	} else {
+		for i, t := range best.tokens {
+			if t != tokens[i] {
+				best.tokens[i] = Wildcard
+			}
+		}
+	}
+	return Match{Template: best.snapshot(), Variables: variables(best.tokens, tokens)}
+}
+
+// Load restores a template mined before, e.g. read back from the database on start up
+func (m *Miner) Load(id string, template string) {
+	tokens := strings.Fields(template)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	leaf := m.leaf(tokens)
+	for _, c := range leaf.clusters {
+		if c.id == id {
+			return
+		}
+	}
+	leaf.clusters = append(leaf.clusters, &cluster{id: id, tokens: tokens})
+}
+
+// leaf routes the tokens to the leaf holding their candidate templates, creating the path as needed
+func (m *Miner) leaf(tokens []string) *node {
+	current := m.root
+	path := append([]string{strconv.Itoa(len(tokens))}, tokens[:min(len(tokens), m.options.Depth-2)]...)
+	for i, token := range path {
+		key := token
+		if i > 0 && hasDigit(token) {
+			key = Wildcard
+		}
+		child, ok := current.children[key]
+		if !ok {
+			if i > 0 && len(current.children) >= m.options.MaxChildren {
+				key = Wildcard
+				child, ok = current.children[key]
+			}
+			if !ok {
+				child = newNode()
+				current.children[key] = child
+			}
+		}
+		current = child
+	}
+	return current
+}
+
+// bestCluster returns the template with the most tokens equal to the message, or nil when none is similar enough.
+// Ties go to the template with the most wildcards.
+func (m *Miner) bestCluster(leaf *node, tokens []string) *cluster {
+	var best *cluster
+	bestSimilarity, bestWildcards := -1.0, -1
+	for _, c := range leaf.clusters {
+		if len(c.tokens) != len(tokens) {
+			continue
+		}
+		equal, wildcards := 0, 0
+		for i, t := range c.tokens {
+			if t == Wildcard {
+				wildcards++
+			} else if t == tokens[i] {
+				equal++
+			}
+		}
+		similarity := 1.0
+		if len(tokens) > 0 {
+			similarity = float64(equal) / float64(len(tokens))
+		}
+		if similarity > bestSimilarity || (similarity == bestSimilarity && wildcards > bestWildcards) {
+			best, bestSimilarity, bestWildcards = c, similarity, wildcards
+		}
+	}
+	if best == nil || bestSimilarity < m.options.SimilarityThreshold {
+		return nil
+	}
+	return best
+}
+
+func (c *cluster) snapshot() Template {
+	return Template{Id: c.id, Template: strings.Join(c.tokens, " ")}
+}
+
+// Variables returns the values of the wildcards of the template in the message, or nil when the message does not have
+// the same number of tokens as the template
+func Variables(template string, message string) []string {
+	return variables(strings.Fields(template), strings.Fields(message))
+}
+
+func variables(template []string, tokens []string) []string {
+	if len(template) != len(tokens) {
+		return nil
+	}
+	result := make([]string, 0)
+	for i, t := range template {
+		if t == Wildcard {
+			result = append(result, tokens[i])
+		}
+	}
+	return result
+}
+
+// templateId is derived from the first message of the template so that it is stable while the template generalises
+func templateId(tokens []string) string {
+	sum := sha1.Sum([]byte(strings.Join(tokens, " ")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func hasDigit(token string) bool {
+	return strings.IndexFunc(token, unicode.IsDigit) >= 0
+}
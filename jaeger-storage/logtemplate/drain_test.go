@@ -0,0 +1,137 @@
+package logtemplate
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  Options
+		messages []string
+		// template of each message once it is added
+		want []string
+	}{
+		{
+			name:     "token count",
+			messages: []string{"connection refused", "connection refused by peer", "connection reset by peer"},
+			want:     []string{"connection refused", "connection refused by peer", "connection reset by peer"},
+		},
+		{
+			name:     "first tokens",
+			messages: []string{"GET /api/drivers", "POST /api/drivers"},
+			want:     []string{"GET /api/drivers", "POST /api/drivers"},
+		},
+		{
+			name:     "similar enough",
+			messages: []string{"redis timeout after 250ms", "redis timeout while reading"},
+			want:     []string{"redis timeout after 250ms", "redis timeout <*> <*>"},
+		},
+		{
+			name:     "below the similarity threshold",
+			options:  Options{SimilarityThreshold: 0.6},
+			messages: []string{"redis timeout after 250ms", "redis timeout while reading"},
+			want:     []string{"redis timeout after 250ms", "redis timeout while reading"},
+		},
+		{
+			name:     "digits in the routing tokens",
+			messages: []string{"user 42 not found", "user 7 not found", "3 retries left", "10 retries left"},
+			want:     []string{"user 42 not found", "user <*> not found", "3 retries left", "<*> retries left"},
+		},
+		{
+			name:     "routed by the first tokens only",
+			messages: []string{"user 42 not found", "user bob not found"},
+			want:     []string{"user 42 not found", "user bob not found"},
+		},
+		{
+			name:     "wildcards merged",
+			messages: []string{"cache miss for customer 123", "cache miss for customer 456", "cache miss for driver T7991", "cache miss for customer 7"},
+			want:     []string{"cache miss for customer 123", "cache miss for customer <*>", "cache miss for <*> <*>", "cache miss for <*> <*>"},
+		},
+		{
+			name:     "too many children",
+			options:  Options{MaxChildren: 1},
+			messages: []string{"GET /api/drivers", "POST /api/drivers", "PUT /api/drivers"},
+			want:     []string{"GET /api/drivers", "POST /api/drivers", "<*> /api/drivers"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMiner(tt.options)
+			for i, message := range tt.messages {
+				if got := m.Add(message); got.Template.Template != tt.want[i] {
+					t.Errorf("Add(%q) = %q, want %q", message, got.Template.Template, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAddGeneralises(t *testing.T) {
+	m := NewMiner(Options{})
+	first := m.Add("order 1234 failed after 3 attempts")
+	second := m.Add("order 99 failed after 5 attempts")
+
+	if second.Template.Template != "order <*> failed after <*> attempts" {
+		t.Errorf("template = %q", second.Template.Template)
+	}
+	if second.Id != first.Id {
+		t.Errorf("id changed from %s to %s while generalising", first.Id, second.Id)
+	}
+	if !slices.Equal(second.Variables, []string{"99", "5"}) {
+		t.Errorf("variables = %v, want the values of the wildcards", second.Variables)
+	}
+	if len(first.Variables) != 0 {
+		t.Errorf("variables of the first message = %v, want none", first.Variables)
+	}
+}
+
+func TestBestClusterPrefersWildcards(t *testing.T) {
+	m := NewMiner(Options{})
+	m.Load("specific", "job started x now")
+	m.Load("general", "job started <*> now")
+
+	match := m.Add("job started y now")
+	if match.Id != "general" || match.Template.Template != "job started <*> now" {
+		t.Errorf("matched %+v, want the template with the wildcard", match.Template)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	m := NewMiner(Options{})
+	m.Load("user-not-found", "user <*> not found")
+	m.Load("user-not-found", "user <*> not found")
+	m.Load("retries-left", "<*> retries left")
+
+	tests := []struct {
+		message   string
+		wantId    string
+		variables []string
+	}{
+		{"user 42 not found", "user-not-found", []string{"42"}},
+		{"user 1234 not found", "user-not-found", []string{"1234"}},
+		{"3 retries left", "retries-left", []string{"3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			match := m.Add(tt.message)
+			if match.Id != tt.wantId || !slices.Equal(match.Variables, tt.variables) {
+				t.Errorf("Add(%q) = %+v, want the loaded template %s with %v", tt.message, match, tt.wantId, tt.variables)
+			}
+		})
+	}
+
+	if n := len(m.leaf([]string{"user", "<*>", "not", "found"}).clusters); n != 1 {
+		t.Errorf("the template loaded twice is in %d clusters", n)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	if got := Variables("redis timeout after <*>", "redis timeout after 250ms"); !slices.Equal(got, []string{"250ms"}) {
+		t.Errorf("Variables() = %v", got)
+	}
+	if got := Variables("redis timeout after <*>", "redis timeout"); got != nil {
+		t.Errorf("Variables() = %v for another number of tokens, want nil", got)
+	}
+}
@@ -11,19 +11,47 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/reflection"
+	"jaeger-storage/alerting"
 	"jaeger-storage/clients"
+	"jaeger-storage/incidents"
+	"jaeger-storage/logtemplate"
+	"jaeger-storage/prompts"
+	"jaeger-storage/redaction"
 	"jaeger-storage/storage"
+	"jaeger-storage/usage"
 	"log"
 	"net"
 	"net/http"
+	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 )
 
+// createSpanWriter builds the write path of the spans, used by the grpc server, /api/import and the subcommands ingesting traces
+func createSpanWriter(db *sqlx.DB, neo4jDriver *neo4j.DriverWithContext, openaiClient *clients.OpenAIClient, usageTracker *usage.Tracker, redactor *redaction.Redactor, alerter *alerting.Alerter) (*WriterClient, error) {
+	latencyBaselines := storage.NewLatencyBaselines(db)
+	policy, err := storage.LoadSummarizationPolicy(latencyBaselines)
+	if err != nil {
+		return nil, err
+	}
+	spanFilter, err := storage.LoadSpanFilter()
+	if err != nil {
+		return nil, err
+	}
+	go spanFilter.Watch(context.Background(), spanFilterReloadInterval())
+	neo4jWriter := storage.NewNeo4jWriter(neo4jDriver, openaiClient, usageTracker, policy, redactor, logtemplate.NewMiner(logTemplateOptions()))
+	if err := neo4jWriter.EnsureAttributeIndexes(context.Background(), storage.IndexedAttributeKeys()); err != nil {
+		return nil, err
+	}
+	if err := neo4jWriter.LoadLogTemplates(context.Background()); err != nil {
+		return nil, err
+	}
+	return NewWriterClient(storage.NewSqlWriter(db, latencyBaselines), neo4jWriter, spanFilter, alerter), nil
+}
+
 // adapted from https://github.com/jaegertracing/jaeger/blob/main/cmd/remote-storage/app/server.go
-func createGrpcHandler(db *sqlx.DB, neo4jDriver *neo4j.DriverWithContext, openaiClient *clients.OpenAIClient) (*shared.GRPCHandler, error) {
-	spanWriter := NewWriterClient(storage.NewSqlWriter(db), storage.NewNeo4jWriter(neo4jDriver, openaiClient))
+func createGrpcHandler(db *sqlx.DB, spanWriter *WriterClient) *shared.GRPCHandler {
 	spanReader := NewReaderDBClient(db)
 
 	impl := &shared.GRPCHandlerStorageImpl{
@@ -48,7 +76,7 @@ func createGrpcHandler(db *sqlx.DB, neo4jDriver *neo4j.DriverWithContext, openai
 		},
 	}
 
-	return shared.NewGRPCHandler(impl), nil
+	return shared.NewGRPCHandler(impl)
 }
 
 func createGrpcServer(handler *shared.GRPCHandler) (*grpc.Server, error) {
@@ -100,12 +128,8 @@ func (s *GrpcServer) Close() error {
 	return nil
 }
 
-func NewGrpcServer(db *sqlx.DB, neo4jDriver *neo4j.DriverWithContext, openaiClient *clients.OpenAIClient) (*GrpcServer, error) {
-	handler, err := createGrpcHandler(db, neo4jDriver, openaiClient)
-	if err != nil {
-		return nil, err
-	}
-	server, err := createGrpcServer(handler)
+func NewGrpcServer(db *sqlx.DB, neo4jDriver *neo4j.DriverWithContext, spanWriter *WriterClient) (*GrpcServer, error) {
+	server, err := createGrpcServer(createGrpcHandler(db, spanWriter))
 	if err != nil {
 		return nil, err
 	}
@@ -116,7 +140,17 @@ func NewGrpcServer(db *sqlx.DB, neo4jDriver *neo4j.DriverWithContext, openaiClie
 	}, nil
 }
 
-func main() {
+// dependencies are shared by the server and the subcommands
+type dependencies struct {
+	db             *sqlx.DB
+	neo4jDriver    *neo4j.DriverWithContext
+	redactor       *redaction.Redactor
+	promptRegistry *prompts.Registry
+	usageTracker   *usage.Tracker
+	openaiClient   *clients.OpenAIClient
+}
+
+func newDependencies() (*dependencies, error) {
 	MakeSureThingsAreOk()
 	db, err := NewDb(NewDbOpt{
 		Username: "postgres",
@@ -125,17 +159,73 @@ func main() {
 	})
 	if err != nil {
 		log.Println("error connecting to DB", err)
-		return
+		return nil, err
 	}
 
 	neo4jDriver, err := NewNeo4jDriver()
 	if err != nil {
 		log.Println("error connecting to neo4j", err)
-		return
+		return nil, err
+	}
+
+	redactor, err := redaction.LoadRedactor()
+	if err != nil {
+		log.Println("[newDependencies] cannot load redaction config", err)
+		return nil, err
 	}
 
-	openaiClient := clients.NewOpenAIClient()
-	server, err := NewGrpcServer(db, neo4jDriver, openaiClient)
+	promptRegistry, err := prompts.LoadRegistry()
+	if err != nil {
+		log.Println("[newDependencies] cannot load prompt templates", err)
+		return nil, err
+	}
+
+	usageTracker, err := usage.NewTracker(db)
+	if err != nil {
+		log.Println("[newDependencies] cannot create usage tracker", err)
+		return nil, err
+	}
+	return &dependencies{
+		db:             db,
+		neo4jDriver:    neo4jDriver,
+		redactor:       redactor,
+		promptRegistry: promptRegistry,
+		usageTracker:   usageTracker,
+		openaiClient:   clients.NewOpenAIClient(usageTracker, redactor, promptRegistry),
+	}, nil
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "eval":
+			runEval(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "export-graph":
+			runExportGraph(os.Args[2:])
+			return
+		}
+	}
+
+	deps, err := newDependencies()
+	if err != nil {
+		log.Fatalln("[main] cannot initialize", err)
+	}
+	db, neo4jDriver, openaiClient, usageTracker, redactor := deps.db, deps.neo4jDriver, deps.openaiClient, deps.usageTracker, deps.redactor
+
+	alertingConfig, err := alerting.LoadConfig()
+	if err != nil {
+		log.Fatalln("[main] cannot load alerting config", err)
+	}
+	alerter := alerting.NewAlerter(alertingConfig, openaiClient, usageTracker)
+	spanWriter, err := createSpanWriter(db, neo4jDriver, openaiClient, usageTracker, redactor, alerter)
+	if err != nil {
+		log.Fatalln("[main] cannot create span writer", err)
+	}
+	server, err := NewGrpcServer(db, neo4jDriver, spanWriter)
 	if err != nil {
 		log.Fatalln("[main] cannot create new grpc server", err)
 	}
@@ -148,7 +238,11 @@ func main() {
 		return
 	}
 
-	router := NewRouter(openaiClient, neo4jDriver, db)
+	go deps.promptRegistry.Watch(ctx, promptTemplatesReloadInterval())
+	go alerter.Run(ctx)
+	go incidents.NewClusterer(neo4jDriver, openaiClient, usageTracker, alerter, incidentOptions()).Run(ctx)
+
+	router := NewRouter(openaiClient, neo4jDriver, db, usageTracker, spanWriter)
 
 	go func() {
 		if err := http.ListenAndServe(":54320", router); err != nil {
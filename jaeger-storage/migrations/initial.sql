@@ -4,7 +4,7 @@
 BEGIN
 TRANSACTION;
 
-DROP TABLE IF EXISTS traces, operations, services, spans;
+DROP TABLE IF EXISTS traces, operations, services, spans, llm_usage, latency_anomalies;
 DROP TYPE IF EXISTS SPANKIND;
 
 CREATE TYPE SPANKIND AS ENUM ('server', 'client', 'unspecified', 'producer', 'consumer', 'ephemeral', 'internal');
@@ -59,9 +59,51 @@ CREATE TABLE IF NOT EXISTS spans
     kind         SPANKIND                          NOT NULL,
     refs         JSONB                             NOT NULL,
     summary      TEXT,
+    -- read from a file by the import subcommand or /api/import, not live traffic
+    imported     BOOLEAN                           NOT NULL DEFAULT FALSE,
     created_at   TIMESTAMPTZ                       NOT NULL,
     deleted_at   TIMESTAMPTZ
     );
 
+CREATE TABLE IF NOT EXISTS llm_usage
+(
+    id                BIGSERIAL PRIMARY KEY,
+    day               DATE             NOT NULL,
+    caller            TEXT             NOT NULL,
+    model             TEXT             NOT NULL,
+    service_name      TEXT             NOT NULL,
+    operation_name    TEXT             NOT NULL,
+    trace_id          TEXT             NOT NULL,
+    requests          BIGINT           NOT NULL,
+    prompt_tokens     BIGINT           NOT NULL,
+    completion_tokens BIGINT           NOT NULL,
+    cost_usd          DOUBLE PRECISION NOT NULL,
+    created_at        TIMESTAMPTZ      NOT NULL,
+    updated_at        TIMESTAMPTZ      NOT NULL,
+
+    UNIQUE (day, caller, model, service_name, operation_name, trace_id)
+    );
+
+CREATE TABLE IF NOT EXISTS latency_anomalies
+(
+    id             BIGSERIAL PRIMARY KEY,
+    span_id        TEXT             NOT NULL,
+    trace_id       TEXT             NOT NULL,
+    service_name   TEXT             NOT NULL,
+    operation_name TEXT             NOT NULL,
+    kind           SPANKIND         NOT NULL,
+    start_time     TIMESTAMP        NOT NULL,
+    duration_ns    BIGINT           NOT NULL,
+    ratio          DOUBLE PRECISION NOT NULL,
+    samples        BIGINT           NOT NULL,
+    p50_ns         BIGINT           NOT NULL,
+    p95_ns         BIGINT           NOT NULL,
+    p99_ns         BIGINT           NOT NULL,
+    ewma_ns        BIGINT           NOT NULL,
+    created_at     TIMESTAMPTZ      NOT NULL
+    );
+
+CREATE INDEX IF NOT EXISTS latency_anomalies_created_at ON latency_anomalies (created_at);
+
 END
 TRANSACTION;
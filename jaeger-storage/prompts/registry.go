@@ -0,0 +1,221 @@
+package prompts
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+const (
+	SummarizeSpan     = "summarize_span"
+	SummarizeLog      = "summarize_log"
+	AnswerGraphRag    = "answer_graph_rag"
+	AnswerNaiveRag    = "answer_naive_rag"
+	AnswerText2Cypher = "answer_text2cypher"
+)
+
+//go:embed templates/*.tmpl
+var embedded embed.FS
+
+// e.g. {{/* version: v2 */}} on the first line of a template file
+var versionPattern = regexp.MustCompile(`^\s*\{\{-?\s*/\*\s*version:\s*(\S+)\s*\*/\s*-?}}`)
+
+// Scope selects the override of a template, the most specific existing file wins:
+// <name>.service.<service>.tmpl, then <name>.language.<language>.tmpl, then <name>.tmpl.
+type Scope struct {
+	ServiceName string
+	// telemetry.sdk.language of the process, e.g. go, java, nodejs
+	Language string
+}
+
+// Prompt is a rendered template
+type Prompt struct {
+	System string
+	User   string
+	// file the prompt was rendered from and its version, e.g. summarize_span.service.frontend@v2
+	Version string
+}
+
+type promptTemplate struct {
+	version  string
+	template *template.Template
+}
+
+// Registry holds the prompt templates. Each template file defines a "system" and a "user" template, rendered into
+// the system and user messages of the chat completion. The templates embedded in the binary are the defaults,
+// the files of the directory override them and add the per-service and per-language overrides.
+type Registry struct {
+	dir     string
+	modTime time.Time
+	current atomic.Pointer[map[string]promptTemplate]
+}
+
+// LoadRegistry reads the embedded templates and the overrides in PROMPT_TEMPLATES_DIR when it is set
+func LoadRegistry() (*Registry, error) {
+	r := &Registry{dir: os.Getenv("PROMPT_TEMPLATES_DIR")}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Registry) reload() error {
+	templates := make(map[string]promptTemplate)
+	if err := parseDir(embedded, "templates", templates); err != nil {
+		log.Println("[prompts][reload][error] invalid embedded templates", err)
+		return err
+	}
+	if r.dir == "" {
+		r.current.Store(&templates)
+		return nil
+	}
+
+	modTime, err := latestModTime(r.dir)
+	if err != nil {
+		log.Println("[prompts][reload][error] cannot stat templates dir", r.dir, err)
+		return err
+	}
+	// an invalid change is only reported once, the next change is tried again
+	r.modTime = modTime
+	if err := parseDir(os.DirFS(r.dir), ".", templates); err != nil {
+		log.Println("[prompts][reload][error] invalid templates in", r.dir, err)
+		return err
+	}
+	r.current.Store(&templates)
+	log.Printf("[prompts][reload] loaded %d prompt templates with the overrides of %s\n", len(templates), r.dir)
+	return nil
+}
+
+func parseDir(fsys fs.FS, dir string, templates map[string]promptTemplate) error {
+	paths, err := fs.Glob(fsys, path.Join(dir, "*.tmpl"))
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(path.Base(p), ".tmpl")
+		t, err := parse(name, string(data))
+		if err != nil {
+			return err
+		}
+		templates[name] = t
+	}
+	return nil
+}
+
+func parse(name string, text string) (promptTemplate, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return promptTemplate{}, err
+	}
+	for _, part := range []string{"system", "user"} {
+		if t.Lookup(part) == nil {
+			return promptTemplate{}, fmt.Errorf("template %s does not define %q", name, part)
+		}
+	}
+	// templates without a version are identified by their content
+	version := ""
+	if m := versionPattern.FindStringSubmatch(text); m != nil {
+		version = m[1]
+	} else {
+		sum := sha1.Sum([]byte(text))
+		version = hex.EncodeToString(sum[:4])
+	}
+	return promptTemplate{version: version, template: t}, nil
+}
+
+// latestModTime changes when a file of the directory is added, removed or modified
+func latestModTime(dir string) (time.Time, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest := info.ModTime()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Watch reloads the templates whenever the directory changes. Invalid templates keep the previous ones.
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	if r.dir == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTime, err := latestModTime(r.dir)
+			if err != nil {
+				log.Println("[prompts][Watch][error] cannot stat templates dir", r.dir, err)
+				continue
+			}
+			if modTime.Equal(r.modTime) {
+				continue
+			}
+			_ = r.reload()
+		}
+	}
+}
+
+// Render renders the most specific template of the scope with the data
+func (r *Registry) Render(name string, scope Scope, data any) (Prompt, error) {
+	templates := *r.current.Load()
+	candidates := make([]string, 0, 3)
+	if scope.ServiceName != "" {
+		candidates = append(candidates, name+".service."+scope.ServiceName)
+	}
+	if scope.Language != "" {
+		candidates = append(candidates, name+".language."+scope.Language)
+	}
+	candidates = append(candidates, name)
+
+	for _, candidate := range candidates {
+		t, ok := templates[candidate]
+		if !ok {
+			continue
+		}
+		var system, user bytes.Buffer
+		if err := t.template.ExecuteTemplate(&system, "system", data); err != nil {
+			return Prompt{}, err
+		}
+		if err := t.template.ExecuteTemplate(&user, "user", data); err != nil {
+			return Prompt{}, err
+		}
+		return Prompt{
+			System:  strings.TrimSpace(system.String()),
+			User:    strings.TrimSpace(user.String()),
+			Version: candidate + "@" + t.version,
+		}, nil
+	}
+	return Prompt{}, fmt.Errorf("unknown prompt template %q", name)
+}
@@ -0,0 +1,116 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"log"
+	"slices"
+)
+
+// AdaptiveRetriever seeds from the top-k similar spans, expands up to Hop hops around them,
+// scores each span by its similarity and its distance to a seed and keeps the best spans within the token budget.
+type AdaptiveRetriever struct {
+	driver neo4j.DriverWithContext
+	// weight of the similarity in the score, the rest goes to the structural proximity
+	similarityWeight float64
+	// upper bound of spans read from neo4j, protects against very wide traces
+	maxNodes int
+}
+
+func (r *AdaptiveRetriever) Retrieve(ctx context.Context, req Request) (*Subgraph, error) {
+	topK := req.TopK
+	if topK <= 0 {
+		topK = 3
+	}
+	// without hops only the seeds would be kept
+	hop := req.Hop
+	if hop <= 0 {
+		hop = 2
+	}
+	countTokens := req.CountTokens
+	if countTokens == nil {
+		countTokens = estimateTokens
+	}
+
+	seeds, err := topSimilarSpans(ctx, r.driver, req.TraceId, req.Embedding, topK)
+	if err != nil {
+		return nil, err
+	}
+	if len(seeds) == 0 {
+		return &Subgraph{}, nil
+	}
+
+	// the spanning tree reaches every span once per seed through its shortest path, enumerating every path
+	// instead blows up on wide traces
+	query := fmt.Sprintf(`
+		MATCH (seed: Span)
+		WHERE seed.span_id IN $seeds
+		CALL apoc.path.spanningTree(seed, {relationshipFilter: 'INVOKES_CHILD|INVOKES_FOLLOWS', minLevel: 0, maxLevel: $hop, bfs: true})
+		YIELD path
+		WITH last(nodes(path)) as s, min(length(path)) as distance
+		ORDER BY distance
+		LIMIT $max_nodes
+		RETURN %s, coalesce(vector.similarity.cosine(s.embedding, $embedding), 0.0) as similarity, distance
+	`, spanNodeReturn)
+	res, err := neo4j.ExecuteQuery(ctx, r.driver, query, map[string]any{
+		"seeds":     spanIds(seeds),
+		"hop":       hop,
+		"embedding": req.Embedding,
+		"max_nodes": r.maxNodes,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[rag][AdaptiveRetriever][error] cannot expand seed spans", err)
+		return nil, err
+	}
+
+	candidates := make([]Node, 0, len(res.Records))
+	for _, record := range res.Records {
+		candidates = append(candidates, recordToNode(record))
+	}
+	candidates = r.score(candidates)
+	nodes, tokens := fitTokenBudget(candidates, req.TokenBudget, countTokens)
+
+	log.Printf("[rag][AdaptiveRetriever] kept %d out of %d spans, %d tokens\n", len(nodes), len(candidates), tokens)
+
+	edges, err := fetchEdges(ctx, r.driver, spanIds(nodes))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Subgraph{Nodes: nodes, Edges: edges}, nil
+}
+
+// score combines the similarity and the proximity to a seed, the best spans first
+func (r *AdaptiveRetriever) score(candidates []Node) []Node {
+	for i, node := range candidates {
+		proximity := 1 / float64(1+node.Distance)
+		candidates[i].Score = r.similarityWeight*node.Similarity + (1-r.similarityWeight)*proximity
+	}
+	slices.SortStableFunc(candidates, func(a, b Node) int {
+		if a.Score > b.Score {
+			return -1
+		} else if a.Score < b.Score {
+			return 1
+		}
+		return 0
+	})
+	return candidates
+}
+
+// fitTokenBudget keeps the spans in order while their summaries fit the budget, a span that does not fit
+// is skipped so that a shorter one after it can still be kept. The first span is always kept so that
+// there is something to answer from.
+func fitTokenBudget(candidates []Node, budget int, countTokens func(string) int) ([]Node, int) {
+	nodes := make([]Node, 0, len(candidates))
+	tokens := 0
+	for i, node := range candidates {
+		t := countTokens(node.Summary)
+		if i > 0 && budget > 0 && tokens+t > budget {
+			continue
+		}
+		tokens += t
+		nodes = append(nodes, node)
+	}
+	return nodes, tokens
+}
@@ -0,0 +1,56 @@
+package rag
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	r := &AdaptiveRetriever{similarityWeight: 0.7}
+	candidates := r.score([]Node{
+		{SpanId: "far", Similarity: 0.9, Distance: 3},
+		{SpanId: "seed", Similarity: 0.5, Distance: 0},
+		{SpanId: "neighbour", Similarity: 0.6, Distance: 1},
+		{SpanId: "tie", Similarity: 0.6, Distance: 1},
+	})
+
+	// 0.7 * similarity + 0.3 / (1 + distance)
+	want := map[string]float64{"seed": 0.65, "far": 0.705, "neighbour": 0.57, "tie": 0.57}
+	for _, n := range candidates {
+		if math.Abs(n.Score-want[n.SpanId]) > 1e-9 {
+			t.Errorf("score of %s = %f, want %f", n.SpanId, n.Score, want[n.SpanId])
+		}
+	}
+	if got := nodeIds(candidates); !slices.Equal(got, []string{"far", "seed", "neighbour", "tie"}) {
+		t.Errorf("order = %v, want the best first and ties in their order", got)
+	}
+}
+
+func TestFitTokenBudget(t *testing.T) {
+	candidates := []Node{
+		{SpanId: "a", Summary: "12345678"},
+		{SpanId: "b", Summary: "1234567890123456"},
+		{SpanId: "c", Summary: "1234"},
+		{SpanId: "d", Summary: "12345678"},
+	}
+	tests := []struct {
+		name       string
+		budget     int
+		want       []string
+		wantTokens int
+	}{
+		{"unlimited", 0, []string{"a", "b", "c", "d"}, 9},
+		{"skips the spans that do not fit", 5, []string{"a", "c", "d"}, 5},
+		{"stops at the budget", 3, []string{"a", "c"}, 3},
+		{"keeps the best span over the budget", 1, []string{"a"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, tokens := fitTokenBudget(candidates, tt.budget, estimateTokens)
+			if got := nodeIds(nodes); !slices.Equal(got, tt.want) || tokens != tt.wantTokens {
+				t.Errorf("fitTokenBudget(%d) = %v with %d tokens, want %v with %d tokens", tt.budget, got, tokens, tt.want, tt.wantTokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"log"
+)
+
+// FixedHopRetriever starts from the single most similar span and follows paths of exactly Hop hops.
+type FixedHopRetriever struct {
+	driver neo4j.DriverWithContext
+}
+
+func (r *FixedHopRetriever) Retrieve(ctx context.Context, req Request) (*Subgraph, error) {
+	seeds, err := topSimilarSpans(ctx, r.driver, req.TraceId, req.Embedding, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(seeds) == 0 {
+		return &Subgraph{}, nil
+	}
+	seed := seeds[0]
+
+	// the number of hops cannot be bind using params
+	query := fmt.Sprintf(`
+		MATCH p=(seed: Span {span_id: $span_id})-[r:INVOKES_CHILD|INVOKES_FOLLOWS*%d]-(s2: Span)
+		UNWIND range(0, length(p)) as i
+		WITH nodes(p)[i] as s, min(i) as distance
+		RETURN %s, distance
+	`, req.Hop, spanNodeReturn)
+	res, err := neo4j.ExecuteQuery(ctx, r.driver, query, map[string]any{
+		"span_id": seed.SpanId,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[rag][FixedHopRetriever][error] cannot expand seed span", err)
+		return nil, err
+	}
+
+	nodes := make([]Node, 0, len(res.Records))
+	for _, record := range res.Records {
+		node := recordToNode(record)
+		if node.SpanId == seed.SpanId {
+			node = seed
+		}
+		// the passage drops the lowest scored spans first when it is over the token budget, so the spans
+		// closest to the seed are kept
+		node.Score = 1 / float64(1+node.Distance)
+		nodes = append(nodes, node)
+	}
+
+	edges, err := fetchEdges(ctx, r.driver, spanIds(nodes))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Subgraph{Nodes: nodes, Edges: edges}, nil
+}
@@ -0,0 +1,228 @@
+package rag
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const passageEdgeTypes = `Edge types:
+INVOKES_CHILD means that a span calls or invokes another span
+INVOKES_FOLLOWS means that a span is started after another span finished
+`
+
+// PassageBuilder renders a subgraph as the graph-rag passage. Spans are ordered by the call tree and
+// their start time, so the same subgraph always gives the same passage, and the passage is cut down
+// to the token budget by truncating the longest summaries first and then dropping the lowest scored spans.
+type PassageBuilder struct {
+	tokenizer *Tokenizer
+	// 0 means unlimited
+	tokenBudget int
+}
+
+func NewPassageBuilder(tokenizer *Tokenizer, tokenBudget int) *PassageBuilder {
+	return &PassageBuilder{tokenizer: tokenizer, tokenBudget: tokenBudget}
+}
+
+// Sections are appended after the spans, e.g. the critical path of the trace.
+type Section struct {
+	Title   string
+	Content string
+}
+
+func (b *PassageBuilder) Build(subgraph *Subgraph, sections ...Section) string {
+	nodes, edges := orderByCallTree(subgraph)
+	summaries := make([]string, len(nodes))
+	for i, n := range nodes {
+		summaries[i] = strings.TrimSpace(n.Summary)
+	}
+
+	if b.tokenBudget > 0 {
+		nodes, edges, summaries = b.fit(nodes, edges, summaries, sections)
+	}
+
+	return render(nodes, edges, summaries, sections)
+}
+
+func render(nodes []Node, edges []Edge, summaries []string, sections []Section) string {
+	var sb strings.Builder
+	sb.WriteString(passageEdgeTypes)
+	sb.WriteString("\nEdges:\n")
+	for _, e := range edges {
+		sb.WriteString(fmt.Sprintf("(%s, %s, %s)\n", e.From, e.Relationship, e.To))
+	}
+	sb.WriteString("\nNodes:\n")
+	for i, n := range nodes {
+		sb.WriteString(renderNodeHeader(n))
+		sb.WriteString(fmt.Sprintf("Summary: %s\n\n", summaries[i]))
+	}
+	for _, s := range sections {
+		sb.WriteString(fmt.Sprintf("%s:\n%s\n", s.Title, strings.TrimSpace(s.Content)))
+	}
+	return sb.String()
+}
+
+func renderNodeHeader(n Node) string {
+	header := fmt.Sprintf("Span ID: %s\nOperation: %s\n", n.SpanId, n.OperationName)
+	if n.ServiceName != "" {
+		header += fmt.Sprintf("Service: %s\n", n.ServiceName)
+	}
+	header += fmt.Sprintf("Duration: %s\n", time.Duration(n.Duration).String())
+	if n.Status != "" {
+		header += fmt.Sprintf("Status: %s\n", n.Status)
+	}
+	return header
+}
+
+func (b *PassageBuilder) fit(nodes []Node, edges []Edge, summaries []string, sections []Section) ([]Node, []Edge, []string) {
+	for {
+		fixed := b.tokenizer.Count(render(nodes, edges, make([]string, len(nodes)), sections))
+		available := b.tokenBudget - fixed
+		if available >= 0 || len(nodes) <= 1 {
+			return nodes, edges, b.truncateSummaries(summaries, available)
+		}
+		nodes, edges, summaries = dropLowestScored(nodes, edges, summaries)
+	}
+}
+
+// truncateSummaries finds the largest per-summary limit that fits the available tokens
+// and truncates the summaries above it, short summaries are left untouched.
+func (b *PassageBuilder) truncateSummaries(summaries []string, available int) []string {
+	counts := make([]int, len(summaries))
+	total := 0
+	for i, s := range summaries {
+		counts[i] = b.tokenizer.Count(s)
+		total += counts[i]
+	}
+	if total <= available {
+		return summaries
+	}
+	if available <= 0 {
+		return make([]string, len(summaries))
+	}
+
+	sorted := slices.Clone(counts)
+	slices.Sort(sorted)
+	limit := 0
+	remaining := available
+	for i, c := range sorted {
+		share := remaining / (len(sorted) - i)
+		if c > share {
+			limit = share
+			break
+		}
+		remaining -= c
+		limit = c
+	}
+
+	truncated := make([]string, len(summaries))
+	for i, s := range summaries {
+		if counts[i] <= limit {
+			truncated[i] = s
+			continue
+		}
+		// one token is kept for the ellipsis
+		truncated[i] = strings.TrimSpace(b.tokenizer.Truncate(s, limit-1)) + "..."
+	}
+	return truncated
+}
+
+func dropLowestScored(nodes []Node, edges []Edge, summaries []string) ([]Node, []Edge, []string) {
+	lowest := 0
+	for i, n := range nodes {
+		if n.Score < nodes[lowest].Score || (n.Score == nodes[lowest].Score && n.Distance > nodes[lowest].Distance) {
+			lowest = i
+		}
+	}
+	dropped := nodes[lowest].SpanId
+
+	nodes = slices.Delete(slices.Clone(nodes), lowest, lowest+1)
+	summaries = slices.Delete(slices.Clone(summaries), lowest, lowest+1)
+	edges = slices.DeleteFunc(slices.Clone(edges), func(e Edge) bool {
+		return e.From == dropped || e.To == dropped
+	})
+	return nodes, edges, summaries
+}
+
+// orderByCallTree walks the spans depth first from the roots, children in start time order,
+// and returns the deduplicated edges in the same order.
+func orderByCallTree(subgraph *Subgraph) ([]Node, []Edge) {
+	byId := make(map[string]Node, len(subgraph.Nodes))
+	for _, n := range subgraph.Nodes {
+		if _, ok := byId[n.SpanId]; !ok {
+			byId[n.SpanId] = n
+		}
+	}
+
+	seenEdges := make(map[Edge]struct{})
+	children := make(map[string][]Edge)
+	hasParent := make(map[string]bool)
+	for _, e := range subgraph.Edges {
+		if _, ok := seenEdges[e]; ok {
+			continue
+		}
+		if _, ok := byId[e.From]; !ok {
+			continue
+		}
+		if _, ok := byId[e.To]; !ok {
+			continue
+		}
+		seenEdges[e] = struct{}{}
+		children[e.From] = append(children[e.From], e)
+		hasParent[e.To] = true
+	}
+
+	byStart := func(a, b Node) int {
+		if c := a.StartTime.Compare(b.StartTime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.SpanId, b.SpanId)
+	}
+
+	roots := make([]Node, 0)
+	for _, n := range byId {
+		if !hasParent[n.SpanId] {
+			roots = append(roots, n)
+		}
+	}
+	slices.SortFunc(roots, byStart)
+
+	nodes := make([]Node, 0, len(byId))
+	edges := make([]Edge, 0, len(seenEdges))
+	visited := make(map[string]bool)
+	var walk func(n Node)
+	walk = func(n Node) {
+		if visited[n.SpanId] {
+			return
+		}
+		visited[n.SpanId] = true
+		nodes = append(nodes, n)
+
+		out := children[n.SpanId]
+		slices.SortFunc(out, func(a, b Edge) int {
+			return byStart(byId[a.To], byId[b.To])
+		})
+		for _, e := range out {
+			edges = append(edges, e)
+			walk(byId[e.To])
+		}
+	}
+	for _, r := range roots {
+		walk(r)
+	}
+
+	// spans that are only reachable through a cycle
+	rest := make([]Node, 0)
+	for _, n := range byId {
+		if !visited[n.SpanId] {
+			rest = append(rest, n)
+		}
+	}
+	slices.SortFunc(rest, byStart)
+	for _, n := range rest {
+		walk(n)
+	}
+
+	return nodes, edges
+}
@@ -0,0 +1,152 @@
+package rag
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2024, 12, 19, 10, 0, 0, 0, time.UTC)
+
+func testNode(spanId string, offset int, score float64, summary string) Node {
+	return Node{
+		SpanId:        spanId,
+		OperationName: "op-" + spanId,
+		ServiceName:   "driver",
+		Summary:       summary,
+		Duration:      int64(time.Millisecond),
+		StartTime:     testStart.Add(time.Duration(offset) * time.Millisecond),
+		Score:         score,
+	}
+}
+
+// testSubgraph is a call tree a -> (c, b), c -> d, with the nodes and edges out of order, a duplicated edge,
+// an edge to a span outside of the subgraph and the cycle e <-> f that no root reaches
+func testSubgraph() *Subgraph {
+	return &Subgraph{
+		Nodes: []Node{
+			testNode("d", 3, 0.4, "d calls redis"),
+			testNode("b", 2, 0.6, "b finds the driver"),
+			testNode("f", 6, 0.1, "f retries"),
+			testNode("a", 0, 0.9, "a dispatches the request"),
+			testNode("e", 5, 0.2, "e waits"),
+			testNode("c", 1, 0.8, "c finds the customer"),
+			testNode("a", 0, 0.9, "a duplicate"),
+		},
+		Edges: []Edge{
+			{From: "c", Relationship: "INVOKES_CHILD", To: "d"},
+			{From: "a", Relationship: "INVOKES_CHILD", To: "b"},
+			{From: "a", Relationship: "INVOKES_CHILD", To: "c"},
+			{From: "a", Relationship: "INVOKES_CHILD", To: "b"},
+			{From: "b", Relationship: "INVOKES_CHILD", To: "missing"},
+			{From: "e", Relationship: "INVOKES_FOLLOWS", To: "f"},
+			{From: "f", Relationship: "INVOKES_FOLLOWS", To: "e"},
+		},
+	}
+}
+
+func nodeIds(nodes []Node) []string {
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.SpanId
+	}
+	return ids
+}
+
+func TestOrderByCallTree(t *testing.T) {
+	nodes, edges := orderByCallTree(testSubgraph())
+
+	if got, want := nodeIds(nodes), []string{"a", "c", "d", "b", "e", "f"}; !slices.Equal(got, want) {
+		t.Errorf("nodes = %v, want %v", got, want)
+	}
+	if nodes[0].Summary != "a dispatches the request" {
+		t.Errorf("kept %q, want the first node of a duplicated span", nodes[0].Summary)
+	}
+	want := []Edge{
+		{From: "a", Relationship: "INVOKES_CHILD", To: "c"},
+		{From: "c", Relationship: "INVOKES_CHILD", To: "d"},
+		{From: "a", Relationship: "INVOKES_CHILD", To: "b"},
+		{From: "e", Relationship: "INVOKES_FOLLOWS", To: "f"},
+		{From: "f", Relationship: "INVOKES_FOLLOWS", To: "e"},
+	}
+	if !slices.Equal(edges, want) {
+		t.Errorf("edges = %v, want %v", edges, want)
+	}
+}
+
+func TestTruncateSummaries(t *testing.T) {
+	// 4 characters per token
+	b := NewPassageBuilder(&Tokenizer{}, 0)
+	short := "abcd"
+	medium := strings.Repeat("m", 40)
+	long := strings.Repeat("l", 80)
+
+	tests := []struct {
+		name      string
+		available int
+		want      []string
+	}{
+		{"fits", 31, []string{short, medium, long}},
+		{"truncates the long summaries to the same limit", 15, []string{short, strings.Repeat("m", 24) + "...", strings.Repeat("l", 24) + "..."}},
+		{"keeps one token and the ellipsis", 6, []string{short, "mmmm...", "llll..."}},
+		{"no room", 0, []string{"", "", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := b.truncateSummaries([]string{short, medium, long}, tt.available)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("truncateSummaries(%d) = %q, want %q", tt.available, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildWithinBudget(t *testing.T) {
+	tokenizer := &Tokenizer{}
+	subgraph := testSubgraph()
+	unlimited := NewPassageBuilder(tokenizer, 0).Build(subgraph)
+	for _, summary := range []string{"a dispatches the request", "f retries", "d calls redis"} {
+		if !strings.Contains(unlimited, "Summary: "+summary+"\n") {
+			t.Errorf("the passage without budget misses %q", summary)
+		}
+	}
+
+	// the headers of every span but the lowest scored one, and a few tokens for the summaries
+	nodes, edges := orderByCallTree(subgraph)
+	nodes, edges, _ = dropLowestScored(nodes, edges, make([]string, len(nodes)))
+	budget := tokenizer.Count(render(nodes, edges, make([]string, len(nodes)), nil)) + 10
+
+	passage := NewPassageBuilder(tokenizer, budget).Build(subgraph)
+	if count := tokenizer.Count(passage); count > budget {
+		t.Errorf("the passage has %d tokens, over the budget of %d", count, budget)
+	}
+	if strings.Contains(passage, "Span ID: f\n") || strings.Contains(passage, "(e, INVOKES_FOLLOWS, f)") {
+		t.Errorf("the lowest scored span is still in the passage:\n%s", passage)
+	}
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		if !strings.Contains(passage, "Span ID: "+id+"\n") {
+			t.Errorf("the passage misses the span %s", id)
+		}
+	}
+	if !strings.Contains(passage, "...") {
+		t.Errorf("the summaries are not truncated:\n%s", passage)
+	}
+}
+
+func TestDropLowestScored(t *testing.T) {
+	nodes := []Node{
+		{SpanId: "a", Score: 0.5, Distance: 0},
+		{SpanId: "b", Score: 0.2, Distance: 1},
+		{SpanId: "c", Score: 0.2, Distance: 2},
+	}
+	edges := []Edge{{From: "a", To: "b"}, {From: "b", To: "c"}}
+
+	nodes, edges, summaries := dropLowestScored(nodes, edges, []string{"a", "b", "c"})
+	if got := nodeIds(nodes); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("kept %v, want the farthest of the tied spans dropped", got)
+	}
+	if !slices.Equal(edges, []Edge{{From: "a", To: "b"}}) || !slices.Equal(summaries, []string{"a", "b"}) {
+		t.Errorf("kept edges %v and summaries %v", edges, summaries)
+	}
+}
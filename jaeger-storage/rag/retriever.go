@@ -0,0 +1,44 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+const (
+	StrategyFixedHop = "fixed-hop"
+	StrategyAdaptive = "adaptive"
+)
+
+type Request struct {
+	TraceId   string
+	Embedding []float32
+	// exact number of hops for fixed-hop, maximum number of hops for adaptive, 2 when unset
+	Hop int
+	// number of seed spans for adaptive
+	TopK int
+	// maximum number of tokens of the span summaries kept by adaptive, 0 means unlimited
+	TokenBudget int
+	// counts the tokens of a summary, defaults to an estimate of 4 characters per token
+	CountTokens func(string) int
+}
+
+// Retriever selects the subgraph of a trace that is relevant to a question.
+type Retriever interface {
+	Retrieve(ctx context.Context, req Request) (*Subgraph, error)
+}
+
+func NewRetriever(strategy string, driver neo4j.DriverWithContext) (Retriever, error) {
+	switch strategy {
+	case "", StrategyFixedHop:
+		return &FixedHopRetriever{driver: driver}, nil
+	case StrategyAdaptive:
+		return &AdaptiveRetriever{driver: driver, similarityWeight: 0.7, maxNodes: 500}, nil
+	}
+	return nil, fmt.Errorf("unknown retrieval strategy %q, expects %s or %s", strategy, StrategyFixedHop, StrategyAdaptive)
+}
+
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
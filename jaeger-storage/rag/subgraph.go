@@ -0,0 +1,119 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"log"
+	"time"
+)
+
+type Node struct {
+	SpanId        string
+	OperationName string
+	ServiceName   string
+	Summary       string
+	Duration      int64
+	StartTime     time.Time
+	Status        string
+	// cosine similarity between the span and the question
+	Similarity float64
+	// number of hops from the closest seed span
+	Distance int
+	Score    float64
+}
+
+type Edge struct {
+	From         string
+	Relationship string
+	To           string
+}
+
+// Subgraph is the part of a trace that is handed to the LLM to answer a question.
+type Subgraph struct {
+	Nodes []Node
+	Edges []Edge
+}
+
+// spanNodeReturn returns the span properties read by recordToNode, the span has to be bound to s
+const spanNodeReturn = `s.span_id as span_id, s.operation_name as operation_name, s.summary as summary,
+	s.duration as duration, s.start_time as start_time, s.span_status as span_status,
+	[(service: Service)-[:CONTAINS]->(s) | service.name][0] as service_name`
+
+func recordToNode(record *neo4j.Record) Node {
+	node := Node{}
+	node.SpanId, _, _ = neo4j.GetRecordValue[string](record, "span_id")
+	node.OperationName, _, _ = neo4j.GetRecordValue[string](record, "operation_name")
+	node.ServiceName, _, _ = neo4j.GetRecordValue[string](record, "service_name")
+	node.Summary, _, _ = neo4j.GetRecordValue[string](record, "summary")
+	node.Duration, _, _ = neo4j.GetRecordValue[int64](record, "duration")
+	node.StartTime, _, _ = neo4j.GetRecordValue[time.Time](record, "start_time")
+	node.Status, _, _ = neo4j.GetRecordValue[string](record, "span_status")
+	if v, ok := record.Get("similarity"); ok && v != nil {
+		node.Similarity, _ = v.(float64)
+	}
+	if v, ok := record.Get("distance"); ok && v != nil {
+		distance, _ := v.(int64)
+		node.Distance = int(distance)
+	}
+	return node
+}
+
+// fetchEdges returns the INVOKES_* edges between the given spans
+func fetchEdges(ctx context.Context, driver neo4j.DriverWithContext, spanIds []string) ([]Edge, error) {
+	query := `
+		MATCH (a: Span)-[r:INVOKES_CHILD|INVOKES_FOLLOWS]->(b: Span)
+		WHERE a.span_id IN $span_ids AND b.span_id IN $span_ids
+		RETURN a.span_id as from, type(r) as relationship, b.span_id as to
+	`
+	res, err := neo4j.ExecuteQuery(ctx, driver, query, map[string]any{
+		"span_ids": spanIds,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[rag][fetchEdges][error] cannot fetch edges", err)
+		return nil, err
+	}
+
+	edges := make([]Edge, 0, len(res.Records))
+	for _, record := range res.Records {
+		from, _, _ := neo4j.GetRecordValue[string](record, "from")
+		relationship, _, _ := neo4j.GetRecordValue[string](record, "relationship")
+		to, _, _ := neo4j.GetRecordValue[string](record, "to")
+		edges = append(edges, Edge{From: from, Relationship: relationship, To: to})
+	}
+	return edges, nil
+}
+
+// topSimilarSpans returns the k spans of the trace closest to the question embedding
+func topSimilarSpans(ctx context.Context, driver neo4j.DriverWithContext, traceId string, embedding []float32, k int) ([]Node, error) {
+	query := fmt.Sprintf(`
+		MATCH (s: Span)<-[r:CONTAINS]-(t: Trace {trace_id: $traceId})
+		WITH s, coalesce(vector.similarity.cosine(s.embedding, $embedding), 0.0) AS similarity
+		ORDER BY similarity DESC
+		LIMIT $k
+		RETURN %s, similarity, 0 as distance
+	`, spanNodeReturn)
+	res, err := neo4j.ExecuteQuery(ctx, driver, query, map[string]any{
+		"embedding": embedding,
+		"traceId":   traceId,
+		"k":         k,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[rag][topSimilarSpans][error] cannot fetch similar spans", err)
+		return nil, err
+	}
+
+	nodes := make([]Node, 0, len(res.Records))
+	for _, record := range res.Records {
+		nodes = append(nodes, recordToNode(record))
+	}
+	return nodes, nil
+}
+
+func spanIds(nodes []Node) []string {
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.SpanId
+	}
+	return ids
+}
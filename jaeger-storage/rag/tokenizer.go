@@ -0,0 +1,67 @@
+package rag
+
+import (
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+	"log"
+	"sync"
+	"unicode/utf8"
+)
+
+// Tokenizer counts tokens the way the answering model does.
+type Tokenizer struct {
+	encoding *tiktoken.Tiktoken
+}
+
+var (
+	defaultTokenizer     *Tokenizer
+	defaultTokenizerOnce sync.Once
+)
+
+// DefaultTokenizer uses o200k_base, the encoding of gpt-4o-mini. The BPE ranks are embedded
+// in the binary so that no download is needed at runtime.
+func DefaultTokenizer() *Tokenizer {
+	defaultTokenizerOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+		encoding, err := tiktoken.GetEncoding("o200k_base")
+		if err != nil {
+			log.Println("[rag][DefaultTokenizer][error] cannot load encoding, falling back to estimates", err)
+		}
+		defaultTokenizer = &Tokenizer{encoding: encoding}
+	})
+	return defaultTokenizer
+}
+
+func (t *Tokenizer) Count(s string) int {
+	if t == nil || t.encoding == nil {
+		return estimateTokens(s)
+	}
+	return len(t.encoding.EncodeOrdinary(s))
+}
+
+// Truncate keeps the first n tokens of s.
+func (t *Tokenizer) Truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if t == nil || t.encoding == nil {
+		return cutOnRune(s, n*4)
+	}
+	tokens := t.encoding.EncodeOrdinary(s)
+	if len(tokens) <= n {
+		return s
+	}
+	// a token can hold part of a multibyte character, the decoded tokens are a prefix of s
+	return cutOnRune(s, len(t.encoding.Decode(tokens[:n])))
+}
+
+// cutOnRune keeps at most n bytes of s without splitting a UTF-8 character
+func cutOnRune(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
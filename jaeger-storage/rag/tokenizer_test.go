@@ -0,0 +1,47 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"short", "timeout", 2, "timeout"},
+		{"ascii", "redis timeout after 250ms", 2, "redis ti"},
+		{"multibyte on the cut", "aéé", 1, "aé"},
+		{"multibyte before the cut", "€€€€", 1, "€"},
+		{"nothing", "timeout", 0, ""},
+	}
+	estimate := &Tokenizer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimate.Truncate(tt.s, tt.n); got != tt.want {
+				t.Errorf("Truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateEncoding(t *testing.T) {
+	tokenizer := DefaultTokenizer()
+	if tokenizer.encoding == nil {
+		t.Skip("the encoding is not available")
+	}
+	s := strings.Repeat("Le pilote 🚕 a été assigné à la course. ", 4)
+	for n := 1; n < tokenizer.Count(s); n++ {
+		got := tokenizer.Truncate(s, n)
+		if !utf8.ValidString(got) || !strings.HasPrefix(s, got) {
+			t.Fatalf("Truncate(%d) = %q, want a valid prefix of the text", n, got)
+		}
+		if count := tokenizer.Count(got); count > n {
+			t.Errorf("Truncate(%d) kept %d tokens", n, count)
+		}
+	}
+}
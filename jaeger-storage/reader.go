@@ -8,7 +8,9 @@ import (
 	"github.com/jaegertracing/jaeger/storage/spanstore"
 	"github.com/jmoiron/sqlx"
 	"jaeger-storage/common"
+	"jaeger-storage/storage"
 	"log"
+	"time"
 )
 
 type ReaderDbClient struct {
@@ -180,3 +182,97 @@ func (r *ReaderDbClient) FindTraceIDs(ctx context.Context, query *spanstore.Trac
 	log.Println(fmt.Sprintf("[FindTraceIDs] received a request, query: %+v", query))
 	return []model.TraceID{}, nil
 }
+
+// FindTraceIDsByOperation returns the most recent traces with a span of the given service and operation,
+// used as the baseline of /api/compare. Imported traces are left out, their durations come from another system.
+func (r *ReaderDbClient) FindTraceIDsByOperation(ctx context.Context, serviceName string, operationName string, since time.Time, limit int, excludeTraceId string) ([]model.TraceID, error) {
+	//goland:noinspection ALL
+	query := `
+	SELECT spans.trace_id as trace_id
+	FROM spans
+			 INNER JOIN operations on operations.id = spans.operation_id
+			 INNER JOIN services on services.id = operations.service_id
+	WHERE services.name = :service_name
+	  AND operations.name = :operation_name
+	  AND spans.start_time >= :since
+	  AND spans.trace_id <> :exclude_trace_id
+	  AND spans.deleted_at IS NULL
+	  AND NOT spans.imported
+	GROUP BY spans.trace_id
+	ORDER BY max(spans.start_time) DESC
+	LIMIT :limit
+`
+	rows, err := r.db.NamedQueryContext(ctx, query, struct {
+		ServiceName    string    `db:"service_name"`
+		OperationName  string    `db:"operation_name"`
+		Since          time.Time `db:"since"`
+		ExcludeTraceId string    `db:"exclude_trace_id"`
+		Limit          int       `db:"limit"`
+	}{
+		ServiceName:    serviceName,
+		OperationName:  operationName,
+		Since:          since.UTC(),
+		ExcludeTraceId: excludeTraceId,
+		Limit:          limit,
+	})
+	if err != nil {
+		log.Println("[FindTraceIDsByOperation][error] an error occurred", err)
+		return nil, err
+	}
+
+	traceIds := make([]model.TraceID, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Println("[FindTraceIDsByOperation][error] an error occurred calling scan()", err)
+			return nil, err
+		}
+		traceId, err := model.TraceIDFromString(id)
+		if err != nil {
+			log.Println("[FindTraceIDsByOperation][error] invalid trace id", id, err)
+			continue
+		}
+		traceIds = append(traceIds, traceId)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return traceIds, nil
+}
+
+// FindLatencyAnomalies returns the most recent latency anomalies, the service and operation filters are ignored when empty.
+func (r *ReaderDbClient) FindLatencyAnomalies(ctx context.Context, serviceName string, operationName string, since time.Time, limit int) ([]storage.LatencyAnomalyRecord, error) {
+	//goland:noinspection ALL
+	query := `
+	SELECT span_id, trace_id, service_name, operation_name, kind, start_time, duration_ns, ratio,
+		   samples, p50_ns, p95_ns, p99_ns, ewma_ns, created_at
+	FROM latency_anomalies
+	WHERE created_at >= :since
+	  AND (:service_name = '' OR service_name = :service_name)
+	  AND (:operation_name = '' OR operation_name = :operation_name)
+	ORDER BY created_at DESC
+	LIMIT :limit
+`
+	query, args, err := r.db.BindNamed(query, struct {
+		ServiceName   string    `db:"service_name"`
+		OperationName string    `db:"operation_name"`
+		Since         time.Time `db:"since"`
+		Limit         int       `db:"limit"`
+	}{
+		ServiceName:   serviceName,
+		OperationName: operationName,
+		Since:         since,
+		Limit:         limit,
+	})
+	if err != nil {
+		log.Println("[FindLatencyAnomalies][error] cannot bind the query", err)
+		return nil, err
+	}
+
+	anomalies := make([]storage.LatencyAnomalyRecord, 0)
+	if err := r.db.SelectContext(ctx, &anomalies, query, args...); err != nil {
+		log.Println("[FindLatencyAnomalies][error] an error occurred", err)
+		return nil, err
+	}
+	return anomalies, nil
+}
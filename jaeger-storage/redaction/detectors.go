@@ -0,0 +1,89 @@
+package redaction
+
+import "regexp"
+
+// bearer tokens are redacted before jwt so that "Bearer eyJ..." is reported once
+var builtinDetectorOrder = []string{"bearer_token", "jwt", "api_key", "email", "credit_card", "ip"}
+
+var builtinDetectors = map[string]detector{
+	"bearer_token": {
+		name:    "bearer_token",
+		pattern: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`),
+	},
+	"jwt": {
+		name:    "jwt",
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	},
+	"api_key": {
+		name: "api_key",
+		// OpenAI, AWS access key, GitHub, Slack and Google API keys
+		pattern: regexp.MustCompile(`\b(?:sk-[A-Za-z0-9_-]{20,}|AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|xox[baprs]-[A-Za-z0-9-]{10,}|AIza[0-9A-Za-z_-]{35})\b`),
+	},
+	"email": {
+		name:    "email",
+		pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+	},
+	"credit_card": {
+		name: "credit_card",
+		// issuer prefixes of Visa, Mastercard, Amex, Diners, Discover and JCB, a bare run of digits is far more
+		// likely to be a timestamp or a duration in nanoseconds than a card number
+		pattern:  regexp.MustCompile(`\b(?:4|5[1-5]|2[2-7]|3[47]|3[068]|6(?:011|5|4[4-9])|35)(?:[ -]?\d){10,18}\b`),
+		validate: cardNumber,
+	},
+	"ip": {
+		name:    "ip",
+		pattern: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b|\b(?:[0-9a-fA-F]{1,4}:){7}[0-9a-fA-F]{1,4}\b`),
+	},
+}
+
+// cardNumber reports whether s has the length used by the issuer of its prefix and passes the Luhn checksum
+func cardNumber(s string) bool {
+	digits := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			digits = append(digits, s[i])
+		}
+	}
+	n := len(digits)
+	valid := false
+	switch {
+	case digits[0] == '4':
+		valid = n == 13 || n == 16 || n == 19
+	case digits[0] == '5' || digits[0] == '2':
+		valid = n == 16
+	case digits[0] == '3' && (digits[1] == '4' || digits[1] == '7'):
+		valid = n == 15
+	case digits[0] == '3' && digits[1] == '5':
+		valid = n >= 16 && n <= 19
+	case digits[0] == '3':
+		valid = n == 14
+	case digits[0] == '6':
+		valid = n >= 16 && n <= 19
+	}
+	return valid && luhn(string(digits))
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum used by card numbers,
+// so that long numeric IDs are not mistaken for them.
+func luhn(s string) bool {
+	sum := 0
+	double := false
+	digits := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
@@ -0,0 +1,209 @@
+package redaction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"jaeger-storage/common"
+	"jaeger-storage/usage"
+	"log"
+	"os"
+	"regexp"
+	"time"
+)
+
+type Rule struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+}
+
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// built-in detectors to run, all of them when empty
+	Detectors []string `yaml:"detectors"`
+	// custom regular expressions, the whole match is redacted
+	Rules []Rule `yaml:"rules"`
+	// keys whose values are always redacted, e.g. in "customer_id: 123" or "?customer_id=123"
+	Keys []string `yaml:"keys"`
+	// file that receives one JSON line per redaction, the standard logger is used when empty
+	AuditFile string `yaml:"audit_file"`
+}
+
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	// optional check to reduce false positives
+	validate func(match string) bool
+	// index of the submatch to replace, 0 replaces the whole match
+	group int
+}
+
+type AuditEntry struct {
+	Time          time.Time      `json:"time"`
+	Target        string         `json:"target"`
+	Caller        string         `json:"caller"`
+	ServiceName   string         `json:"service_name,omitempty"`
+	OperationName string         `json:"operation_name,omitempty"`
+	TraceId       string         `json:"trace_id,omitempty"`
+	Findings      map[string]int `json:"findings"`
+}
+
+// Redactor removes PII and secrets from text before it leaves the process or is persisted.
+type Redactor struct {
+	detectors []detector
+	auditFile string
+}
+
+func DefaultConfig() Config {
+	return Config{Enabled: true}
+}
+
+// LoadRedactor reads the config from the YAML file in REDACTION_CONFIG_FILE,
+// falling back to the default config when it is not set.
+func LoadRedactor() (*Redactor, error) {
+	config := DefaultConfig()
+	if path := os.Getenv("REDACTION_CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Println("[LoadRedactor][error] cannot read redaction config", path, err)
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			log.Println("[LoadRedactor][error] cannot parse redaction config", path, err)
+			return nil, err
+		}
+	}
+	return NewRedactor(config)
+}
+
+func NewRedactor(config Config) (*Redactor, error) {
+	if !config.Enabled {
+		log.Println("[NewRedactor] redaction is disabled")
+		return nil, nil
+	}
+
+	r := &Redactor{auditFile: config.AuditFile}
+
+	enabled := make(map[string]bool)
+	for _, name := range config.Detectors {
+		if _, ok := builtinDetectors[name]; !ok {
+			return nil, fmt.Errorf("unknown detector %q", name)
+		}
+		enabled[name] = true
+	}
+	for _, name := range builtinDetectorOrder {
+		if len(enabled) == 0 || enabled[name] {
+			r.detectors = append(r.detectors, builtinDetectors[name])
+		}
+	}
+
+	for _, rule := range config.Rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: invalid pattern: %w", rule.Name, err)
+		}
+		r.detectors = append(r.detectors, detector{name: rule.Name, pattern: re})
+	}
+
+	for _, key := range config.Keys {
+		r.detectors = append(r.detectors, detector{
+			name:    "key:" + key,
+			pattern: regexp.MustCompile(`(?i)(\b` + regexp.QuoteMeta(key) + `\b"?\s*[:=]\s*"?)([^\s&,;"]+)`),
+			group:   2,
+		})
+	}
+
+	return r, nil
+}
+
+// Redact replaces sensitive values in text and records an audit entry when anything was found.
+// target names what the text is used for, e.g. summarize_span or tag_summary.
+func (r *Redactor) Redact(ctx context.Context, target string, text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+
+	findings := make(map[string]int)
+	for _, d := range r.detectors {
+		text = d.apply(text, findings)
+	}
+
+	if len(findings) > 0 {
+		r.audit(ctx, target, findings)
+	}
+
+	return text
+}
+
+// RedactAttribute redacts the value of a tag or log field stored as a property. The whole value is replaced
+// when its key is one of the configured keys, as the key is not part of the value the key detectors look at.
+func (r *Redactor) RedactAttribute(ctx context.Context, target string, key string, value string) string {
+	if r == nil || value == "" {
+		return value
+	}
+
+	findings := make(map[string]int)
+	for _, d := range r.detectors {
+		if d.group != 0 && d.pattern.MatchString(key+"=x") {
+			findings[d.name]++
+			value = fmt.Sprintf("[REDACTED:%s]", d.name)
+			break
+		}
+		value = d.apply(value, findings)
+	}
+
+	if len(findings) > 0 {
+		r.audit(ctx, target, findings)
+	}
+
+	return value
+}
+
+func (d detector) apply(text string, findings map[string]int) string {
+	replacement := fmt.Sprintf("[REDACTED:%s]", d.name)
+	return d.pattern.ReplaceAllStringFunc(text, func(match string) string {
+		if d.group == 0 {
+			if d.validate != nil && !d.validate(match) {
+				return match
+			}
+			findings[d.name]++
+			return replacement
+		}
+
+		submatches := d.pattern.FindStringSubmatchIndex(match)
+		start, end := submatches[2*d.group], submatches[2*d.group+1]
+		if start < 0 {
+			return match
+		}
+		findings[d.name]++
+		return match[:start] + replacement + match[end:]
+	})
+}
+
+func (r *Redactor) audit(ctx context.Context, target string, findings map[string]int) {
+	attribution := usage.AttributionFromContext(ctx)
+	entry := AuditEntry{
+		Time:          time.Now(),
+		Target:        target,
+		Caller:        attribution.Caller,
+		ServiceName:   attribution.ServiceName,
+		OperationName: attribution.OperationName,
+		TraceId:       attribution.TraceId,
+		Findings:      findings,
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("[redaction][audit][error] cannot marshal audit entry", err)
+		return
+	}
+
+	if r.auditFile == "" {
+		log.Println("[redaction][audit]", string(data))
+		return
+	}
+	if err := common.WriteToFile(r.auditFile, string(data)+"\n"); err != nil {
+		log.Println("[redaction][audit][error] cannot write audit entry", err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -10,12 +11,22 @@ import (
 	"github.com/jaegertracing/jaeger/model/adjuster"
 	uiconv "github.com/jaegertracing/jaeger/model/converter/json"
 	ui "github.com/jaegertracing/jaeger/model/json"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
 	"github.com/jmoiron/sqlx"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
 	"golang.org/x/exp/slices"
+	"jaeger-storage/agent"
+	"jaeger-storage/analysis"
 	"jaeger-storage/clients"
 	"jaeger-storage/common"
+	"jaeger-storage/graphexport"
+	"jaeger-storage/incidents"
+	"jaeger-storage/rag"
+	"jaeger-storage/storage"
+	"jaeger-storage/text2cypher"
+	"jaeger-storage/usage"
 	"log"
+	"maps"
 	"math"
 	"net/http"
 	"strconv"
@@ -77,8 +88,24 @@ func convertModelToUI(trace *model.Trace, adjust bool) (*ui.Trace, *structuredEr
 	return uiTrace, uiError
 }
 
-func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWithContext, db *sqlx.DB) *gin.Engine {
+func criticalPathOf(ctx context.Context, spanReader *ReaderDbClient, traceId string) (*analysis.CriticalPathReport, error) {
+	id, err := model.TraceIDFromString(traceId)
+	if err != nil {
+		return nil, err
+	}
+	trace, err := spanReader.GetTrace(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(trace.Spans) == 0 {
+		return nil, spanstore.ErrTraceNotFound
+	}
+	return analysis.CriticalPath(trace)
+}
+
+func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWithContext, db *sqlx.DB, usageTracker *usage.Tracker, spanWriter *WriterClient) *gin.Engine {
 	r := gin.Default()
+	spanReader := NewReaderDBClient(db)
 
 	r.GET("/api/search", func(context *gin.Context) {
 		q := context.Query("query")
@@ -91,10 +118,23 @@ func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWith
 
 		limitInt, _ := strconv.Atoi(limit)
 
+		// optional infrastructure filters, the span has to run on the given host, pod or namespace
+		infrastructureFilter := `
+			where ($host IS NULL OR exists { (n)-[:RAN_ON]->(:Process)-[:RAN_ON*1..2]->(:Host { name: $host }) })
+			and ($pod IS NULL OR exists { (n)-[:RAN_ON]->(:Process)-[:RAN_ON]->(:Pod { name: $pod }) })
+			and ($namespace IS NULL OR exists { (n)-[:RAN_ON]->(:Process)-[:RAN_ON]->(:Pod { namespace: $namespace }) })
+		`
+		infrastructureParam := map[string]any{
+			"host":      common.NilIfEmpty(context.Query("host")),
+			"pod":       common.NilIfEmpty(context.Query("pod")),
+			"namespace": common.NilIfEmpty(context.Query("namespace")),
+		}
+
 		getTraceIdFulltextSearchQuery := `
 			call db.index.fulltext.queryNodes('span_summary_fulltext', $query, { limit: $limit })
 			yield node as n, score
 			with n, score
+			` + infrastructureFilter + `
 			match (n)<-[r:CONTAINS]-(t: Trace)
 			return t.trace_id as trace_id, score
 		`
@@ -103,6 +143,7 @@ func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWith
 			"limit": limitInt,
 			"query": q,
 		}
+		maps.Copy(param, infrastructureParam)
 
 		res, err := neo4j.ExecuteQuery(context, *neo4jDriver, getTraceIdFulltextSearchQuery, param, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
 		if err != nil {
@@ -142,7 +183,7 @@ func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWith
 		log.Println("fetchedTraces after normalizing score", fetchedTraces)
 
 		// get embedding
-		embedding, err := openaiClient.CreateEmbeddings(context, q)
+		embedding, err := openaiClient.CreateEmbeddings(usage.WithAttribution(context, usage.Attribution{Caller: usage.CallerSearch}), q)
 		if err != nil {
 			context.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
 			return
@@ -152,6 +193,8 @@ func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWith
 		getTraceIdQuery := `
 			call db.index.vector.queryNodes('span_summary', $limit, $embedding)
 			yield node as n, score
+			with n, score
+			` + infrastructureFilter + `
 			match (n)<-[r:CONTAINS]-(t: Trace)
 			return t.trace_id as trace_id, score
 		`
@@ -159,6 +202,7 @@ func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWith
 			"limit":     limitInt,
 			"embedding": embedding,
 		}
+		maps.Copy(param, infrastructureParam)
 
 		res, err = neo4j.ExecuteQuery(context, *neo4jDriver, getTraceIdQuery, param, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
 		if err != nil {
@@ -287,6 +331,12 @@ func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWith
 			Question string `json:"question"`
 			Hop      int    `json:"hop"`
 			Method   string `json:"method"`
+			// retrieval strategy for graph-rag, fixed-hop or adaptive
+			Strategy    string `json:"strategy"`
+			TopK        int    `json:"top_k"`
+			TokenBudget int    `json:"token_budget"`
+			// maximum number of model calls of the agent method, capped by AGENT_MAX_ITERATIONS
+			MaxIterations int `json:"max_iterations"`
 		}
 
 		req := askRequest{}
@@ -307,86 +357,117 @@ func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWith
 
 		// filter span by trace_id
 		// perform vector search, from that starting node, aggregate k hops
-		ctx := context.Background()
-		embedding, err := openaiClient.CreateEmbeddings(ctx, req.Question)
-		if err != nil {
-			log.Println("[/ask][CreateEmbeddings] an error occurred", err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+		ctx := usage.WithAttribution(context.Background(), usage.Attribution{
+			Caller:  usage.CallerAsk,
+			TraceId: req.TraceId,
+		})
+
+		if req.Method == "agent" {
+			maxIterations := agentMaxIterations()
+			if req.MaxIterations > 0 && req.MaxIterations < maxIterations {
+				maxIterations = req.MaxIterations
+			}
+			toolbox := agent.NewToolbox(*neo4jDriver, spanReader, req.TraceId)
+			result, err := agent.NewAgent(openaiClient, maxIterations).Run(ctx, toolbox, req.Question)
+			if err != nil {
+				log.Println("[/ask][agent] error occurred", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			c.JSON(http.StatusOK, result)
 			return
 		}
 
-		if req.Method == "graph-rag" {
-			query := `
-			MATCH (s: Span)<-[r:CONTAINS]-(t: Trace {trace_id: $traceId})
-			WITH s, vector.similarity.cosine(s.embedding, $embedding) AS score
-			RETURN s.span_id as span_id, score
-			ORDER BY score DESC
-			LIMIT 1
-		`
-
-			param := map[string]any{
-				"embedding": embedding,
-				"traceId":   req.TraceId,
+		if req.Method == "text2cypher" {
+			engine := text2cypher.NewEngine(*neo4jDriver, openaiClient, text2cypherOptions())
+			result, err := engine.Run(ctx, req.TraceId, req.Question)
+			if err != nil {
+				log.Println("[/ask][text2cypher] error occurred", err)
+				attempts := make([]text2cypher.Attempt, 0)
+				if result != nil {
+					attempts = result.Attempts
+				}
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, struct {
+					Error    string                `json:"error"`
+					Attempts []text2cypher.Attempt `json:"attempts"`
+				}{
+					Error:    err.Error(),
+					Attempts: attempts,
+				})
+				return
 			}
 
-			res, err := neo4j.ExecuteQuery(ctx, *neo4jDriver, query, param, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+			passage := result.Passage()
+			answer, promptVersion, err := openaiClient.GenerateAnswer(ctx, req.Question, passage, req.Method)
 			if err != nil {
-				log.Println("[search][ExecuteQuery] error occurred", err)
+				log.Println("an error occurred while generating an answer", err)
 				c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
 				return
 			}
 
-			spanId, _, err := neo4j.GetRecordValue[string](res.Records[0], "span_id")
-			log.Println(spanId)
+			c.JSON(http.StatusOK, struct {
+				Answer        string                `json:"answer"`
+				Sufficient    bool                  `json:"sufficient"`
+				PromptVersion string                `json:"prompt_version"`
+				Query         string                `json:"query"`
+				Rows          []map[string]any      `json:"rows"`
+				Truncated     bool                  `json:"truncated"`
+				Attempts      []text2cypher.Attempt `json:"attempts"`
+			}{
+				Answer:        answer.Answer,
+				Sufficient:    answer.Sufficient,
+				PromptVersion: promptVersion,
+				Query:         result.Query,
+				Rows:          result.Rows,
+				Truncated:     result.Truncated,
+				Attempts:      result.Attempts,
+			})
+			return
+		}
+
+		embedding, err := openaiClient.CreateEmbeddings(ctx, req.Question)
+		if err != nil {
+			log.Println("[/ask][CreateEmbeddings] an error occurred", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if req.Method == "graph-rag" {
+			retriever, err := rag.NewRetriever(req.Strategy, *neo4jDriver)
 			if err != nil {
-				log.Println("[search][GetRecordValue] error occurred", err)
-				c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+				c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
 				return
 			}
 
-			// TODO: find starting node, replace with span_id param
-			query2 := fmt.Sprintf("MATCH p=(s:Span{span_id: $span_id})-[r:INVOKES_CHILD|INVOKES_FOLLOWS*%d]-(s2:Span) return p", req.Hop)
-			param = map[string]any{
-				"span_id": spanId,
+			tokenBudget := req.TokenBudget
+			if tokenBudget <= 0 {
+				tokenBudget = passageTokenBudget()
 			}
-			res, err = neo4j.ExecuteQuery(ctx, *neo4jDriver, query2, param, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+			tokenizer := rag.DefaultTokenizer()
+			subgraph, err := retriever.Retrieve(ctx, rag.Request{
+				TraceId:     req.TraceId,
+				Embedding:   embedding,
+				Hop:         req.Hop,
+				TopK:        req.TopK,
+				TokenBudget: tokenBudget,
+				CountTokens: tokenizer.Count,
+			})
 			if err != nil {
-				log.Println("[search][ExecuteQuery] error occurred", err)
+				log.Println("[/ask][Retrieve] error occurred", err)
 				c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
 				return
 			}
-			visited := make(map[string]struct{})
-
-			var nodes = "Nodes\n"
-			var edges = "Edges\n"
 
-			// TODO: build the passage from the paths
-			for _, record := range res.Records {
-				path, _, _ := neo4j.GetRecordValue[neo4j.Path](record, "p")
-				var relationship string
-				for _, rel := range path.Relationships {
-					startNode := findNode(rel.StartElementId, path.Nodes)
-					endNode := findNode(rel.EndElementId, path.Nodes)
-
-					startSpanId, _ := neo4j.GetProperty[string](startNode, "span_id")
-					endSpanId, _ := neo4j.GetProperty[string](endNode, "span_id")
-					relationship += fmt.Sprintf("(%s, %s, %s)\n", startSpanId, rel.Type, endSpanId)
-				}
-				edges += relationship
-
-				var localNode string
-				for _, node := range path.Nodes {
-					if _, ok := visited[node.ElementId]; !ok {
-						visited[node.ElementId] = struct{}{}
-						sId, _ := neo4j.GetProperty[string](node, "span_id")
-						summary, _ := neo4j.GetProperty[string](node, "summary")
-						localNode += fmt.Sprintf("Span ID: %s\nSummary: %s\n", sId, summary)
-					}
-				}
-				nodes += localNode
+			// timing is not part of the graph, the critical path grounds questions about latency
+			sections := make([]rag.Section, 0, 1)
+			if report, err := criticalPathOf(ctx, spanReader, req.TraceId); err != nil {
+				log.Println("[/ask][criticalPathOf] cannot analyse critical path, passage will not include it", err)
+			} else {
+				sections = append(sections, rag.Section{Title: "Critical path analysis", Content: report.Summary()})
 			}
-			passage := edges + "\n" + nodes
-			answer, err := openaiClient.GenerateAnswer(ctx, req.Question, passage, req.Method)
+			passage := rag.NewPassageBuilder(tokenizer, tokenBudget).Build(subgraph, sections...)
+			answer, promptVersion, err := openaiClient.GenerateAnswer(ctx, req.Question, passage, req.Method)
 
 			if err != nil {
 				log.Println("an error occurred while generating an answer", err)
@@ -394,22 +475,22 @@ func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWith
 				return
 			}
 
-			answer = strings.ReplaceAll(answer, "<answer>", "")
-			answer = strings.ReplaceAll(answer, "</answer>", "")
-			answer = strings.TrimSpace(answer)
-
 			c.JSON(http.StatusOK, struct {
-				Answer  string `json:"answer"`
-				Passage string `json:"passage"`
+				Answer        string `json:"answer"`
+				Sufficient    bool   `json:"sufficient"`
+				PromptVersion string `json:"prompt_version"`
+				Passage       string `json:"passage"`
 			}{
-				Answer:  answer,
-				Passage: passage,
+				Answer:        answer.Answer,
+				Sufficient:    answer.Sufficient,
+				PromptVersion: promptVersion,
+				Passage:       passage,
 			})
 			return
 		} else if req.Method == "naive-rag" {
 			query := `
 			MATCH (s: Span)<-[r:CONTAINS]-(t: Trace {trace_id: $traceId})
-			WITH s, vector.similarity.cosine(s.embedding, $embedding) AS score
+			WITH s, coalesce(vector.similarity.cosine(s.embedding, $embedding), 0.0) AS score
 			RETURN s.summary as summary, score
 			ORDER BY score DESC
 			LIMIT $k
@@ -434,7 +515,7 @@ func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWith
 				passage += summary + "\n"
 			}
 
-			answer, err := openaiClient.GenerateAnswer(ctx, req.Question, passage, req.Method)
+			answer, promptVersion, err := openaiClient.GenerateAnswer(ctx, req.Question, passage, req.Method)
 
 			if err != nil {
 				log.Println("an error occurred while generating an answer", err)
@@ -442,28 +523,426 @@ func NewRouter(openaiClient *clients.OpenAIClient, neo4jDriver *neo4j.DriverWith
 				return
 			}
 
-			answer = strings.ReplaceAll(answer, "<answer>", "")
-			answer = strings.ReplaceAll(answer, "</answer>", "")
-			answer = strings.TrimSpace(answer)
-
 			c.JSON(http.StatusOK, struct {
-				Answer  string `json:"answer"`
-				Passage string `json:"passage"`
+				Answer        string `json:"answer"`
+				Sufficient    bool   `json:"sufficient"`
+				PromptVersion string `json:"prompt_version"`
+				Passage       string `json:"passage"`
 			}{
-				Answer:  answer,
-				Passage: passage,
+				Answer:        answer.Answer,
+				Sufficient:    answer.Sufficient,
+				PromptVersion: promptVersion,
+				Passage:       passage,
 			})
 		}
 	})
 
-	return r
-}
+	r.GET("/api/traces/:id/critical-path", func(c *gin.Context) {
+		if _, err := model.TraceIDFromString(c.Param("id")); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects a valid trace ID")
+			return
+		}
+		report, err := criticalPathOf(c, spanReader, c.Param("id"))
+		if errors.Is(err, spanstore.ErrTraceNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, "trace not found")
+			return
+		}
+		if errors.Is(err, analysis.ErrNoRoot) {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if err != nil {
+			log.Println("[/api/traces/:id/critical-path][error] cannot analyse the critical path", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
 
-func findNode(elementId string, nodes []neo4j.Node) *neo4j.Node {
-	for _, v := range nodes {
-		if v.ElementId == elementId {
-			return &v
+	r.GET("/api/traces/:id/export", func(c *gin.Context) {
+		format := c.DefaultQuery("format", "jaeger")
+		exportFormat, ok := exportFormats[format]
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects format jaeger, otlp-json, otlp-protobuf or annotated")
+			return
 		}
-	}
-	return nil
+		traceId, err := model.TraceIDFromString(c.Param("id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects a valid trace ID")
+			return
+		}
+		trace, err := spanReader.GetTrace(c, traceId)
+		if errors.Is(err, spanstore.ErrTraceNotFound) || (err == nil && len(trace.Spans) == 0) {
+			c.AbortWithStatusJSON(http.StatusNotFound, "trace not found")
+			return
+		}
+		if err != nil {
+			log.Println("[/api/traces/:id/export][error] cannot read trace", c.Param("id"), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := exportTrace(c, &buf, neo4jDriver, trace, format, c.DefaultQuery("embeddings", "true") == "true"); err != nil {
+			log.Println("[/api/traces/:id/export][error] cannot export trace", c.Param("id"), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, traceId.String(), exportFormat.extension))
+		c.Data(http.StatusOK, exportFormat.contentType, buf.Bytes())
+	})
+
+	r.GET("/api/traces/:id/graph", func(c *gin.Context) {
+		format := c.DefaultQuery("format", graphexport.FormatJSON)
+		exportFormat, ok := graphExportFormats[format]
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects format json, graphml or cypher")
+			return
+		}
+		graph, err := graphexport.Read(c, neo4jDriver, c.Param("id"), graphexport.Options{Embeddings: c.Query("embeddings") == "true"})
+		if errors.Is(err, graphexport.ErrTraceNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := graph.Write(&buf, format); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, c.Param("id"), exportFormat.extension))
+		c.Data(http.StatusOK, exportFormat.contentType, buf.Bytes())
+	})
+
+	// imports a Jaeger UI JSON or OTLP JSON document sent as the body, or the files of a multipart upload
+	r.POST("/api/import", func(c *gin.Context) {
+		summarization, err := ParseImportSummarization(c.Query("summarize"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		results := make([]importResult, 0)
+		if !strings.HasPrefix(c.ContentType(), "multipart/") {
+			result, err := importTraces(c, spanReader, spanWriter, c.Request.Body, summarization)
+			if err != nil {
+				log.Println("[/api/import][error] cannot import body", err)
+				c.AbortWithStatusJSON(importStatus(err), err.Error())
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"imported": append(results, result)})
+			return
+		}
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, headers := range form.File {
+			for _, header := range headers {
+				f, err := header.Open()
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+					return
+				}
+				result, err := importTraces(c, spanReader, spanWriter, f, summarization)
+				f.Close()
+				if err != nil {
+					log.Println("[/api/import][error] cannot import file", header.Filename, err)
+					c.AbortWithStatusJSON(importStatus(err), gin.H{"file": header.Filename, "error": err.Error(), "imported": results})
+					return
+				}
+				result.File = header.Filename
+				results = append(results, result)
+			}
+		}
+		if len(results) == 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects at least one file")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"imported": results})
+	})
+
+	r.GET("/api/anomalies", func(c *gin.Context) {
+		lookback, err := time.ParseDuration(c.DefaultQuery("lookback", "1h"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects lookback as a duration, e.g. 1h")
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit <= 0 || limit > 500 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects limit between 1 and 500")
+			return
+		}
+
+		anomalies, err := spanReader.FindLatencyAnomalies(c, c.Query("service"), c.Query("operation"), time.Now().Add(-lookback), limit)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// the summary, status and caller of each slow span tell what it was doing when it was slow
+		spanIds := make([]string, 0, len(anomalies))
+		for _, a := range anomalies {
+			spanIds = append(spanIds, a.SpanId)
+		}
+		res, err := neo4j.ExecuteQuery(c, *neo4jDriver, `
+			MATCH (s: Span) WHERE s.span_id IN $span_ids
+			OPTIONAL MATCH (parent: Span)-[:INVOKES_CHILD]->(s)
+			OPTIONAL MATCH (service: Service)-[:CONTAINS]->(parent)
+			RETURN s.span_id as span_id, s.summary as summary, s.span_status as span_status,
+				parent.operation_name as parent_operation, service.name as parent_service
+		`, map[string]any{"span_ids": spanIds}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+		if err != nil {
+			log.Println("[/anomalies][ExecuteQuery] cannot fetch the context of the anomalies, returning them without it", err)
+		} else {
+			contexts := make(map[string]*neo4j.Record, len(res.Records))
+			for _, record := range res.Records {
+				spanId, _, _ := neo4j.GetRecordValue[string](record, "span_id")
+				contexts[spanId] = record
+			}
+			for i := range anomalies {
+				record, ok := contexts[anomalies[i].SpanId]
+				if !ok {
+					continue
+				}
+				anomalies[i].Summary, _, _ = neo4j.GetRecordValue[string](record, "summary")
+				anomalies[i].SpanStatus, _, _ = neo4j.GetRecordValue[string](record, "span_status")
+				anomalies[i].ParentOperation, _, _ = neo4j.GetRecordValue[string](record, "parent_operation")
+				anomalies[i].ParentService, _, _ = neo4j.GetRecordValue[string](record, "parent_service")
+			}
+		}
+
+		c.JSON(http.StatusOK, struct {
+			Data []storage.LatencyAnomalyRecord `json:"data"`
+		}{
+			Data: anomalies,
+		})
+	})
+
+	r.GET("/api/incidents", func(c *gin.Context) {
+		lookback, err := time.ParseDuration(c.DefaultQuery("lookback", "24h"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects lookback as a duration, e.g. 24h")
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit <= 0 || limit > 200 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects limit between 1 and 200")
+			return
+		}
+
+		result, err := incidents.List(c, neo4jDriver, incidents.ListQuery{
+			Since:       time.Now().Add(-lookback),
+			ServiceName: c.Query("service"),
+			Limit:       limit,
+		})
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, struct {
+			Data []incidents.Incident `json:"data"`
+		}{
+			Data: result,
+		})
+	})
+
+	r.POST("/api/compare", func(c *gin.Context) {
+		type compareRequest struct {
+			TraceId         string `json:"trace_id"`
+			BaselineTraceId string `json:"baseline_trace_id"`
+			// the baseline is made of recent traces of an operation when no baseline trace is given
+			Baseline struct {
+				Service   string `json:"service"`
+				Operation string `json:"operation"`
+				Limit     int    `json:"limit"`
+				Lookback  string `json:"lookback"`
+			} `json:"baseline"`
+		}
+
+		req := compareRequest{}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		targetId, err := model.TraceIDFromString(req.TraceId)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects a valid trace_id")
+			return
+		}
+
+		ctx := usage.WithAttribution(context.Background(), usage.Attribution{
+			Caller:  usage.CallerCompare,
+			TraceId: req.TraceId,
+		})
+		target, err := spanReader.GetTrace(ctx, targetId)
+		if err != nil {
+			log.Println("[/compare][GetTrace] cannot read the target trace", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(target.Spans) == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, "trace not found")
+			return
+		}
+
+		baselineIds := make([]model.TraceID, 0)
+		if req.BaselineTraceId != "" {
+			id, err := model.TraceIDFromString(req.BaselineTraceId)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, "expects a valid baseline_trace_id")
+				return
+			}
+			baselineIds = append(baselineIds, id)
+		} else {
+			// defaults to the request served by the root span of the target
+			service, operation := req.Baseline.Service, req.Baseline.Operation
+			if service == "" || operation == "" {
+				roots := analysis.NewCallTree(target).Roots
+				if len(roots) == 0 {
+					c.AbortWithStatusJSON(http.StatusUnprocessableEntity, "the trace has no root span to pick a baseline from, set baseline_trace_id or baseline.service and baseline.operation")
+					return
+				}
+				if service == "" {
+					service = roots[0].Process.GetServiceName()
+				}
+				if operation == "" {
+					operation = roots[0].OperationName
+				}
+			}
+			limit := req.Baseline.Limit
+			if limit <= 0 || limit > 50 {
+				limit = 10
+			}
+			lookback := 24 * time.Hour
+			if req.Baseline.Lookback != "" {
+				lookback, err = time.ParseDuration(req.Baseline.Lookback)
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusBadRequest, "expects baseline.lookback as a duration, e.g. 24h")
+					return
+				}
+			}
+			baselineIds, err = spanReader.FindTraceIDsByOperation(ctx, service, operation, time.Now().Add(-lookback), limit, req.TraceId)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+				return
+			}
+			if len(baselineIds) == 0 {
+				c.AbortWithStatusJSON(http.StatusNotFound, fmt.Sprintf("no baseline trace of %s %s in the last %s", service, operation, lookback))
+				return
+			}
+		}
+
+		baseline := make([]*model.Trace, 0, len(baselineIds))
+		for _, id := range baselineIds {
+			trace, err := spanReader.GetTrace(ctx, id)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+				return
+			}
+			baseline = append(baseline, trace)
+		}
+
+		comparison, err := analysis.Compare(baseline, target)
+		if errors.Is(err, analysis.ErrNoRoot) {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		// the summaries tell the LLM what the differing spans do
+		spanIds := make([]string, 0)
+		for _, diffs := range [][]analysis.SpanDiff{comparison.Added, comparison.Missing, comparison.Changed} {
+			for _, d := range diffs {
+				spanIds = append(spanIds, d.TargetSpanId, d.BaselineSpanId)
+			}
+		}
+		summaries := make(map[string]string)
+		res, err := neo4j.ExecuteQuery(ctx, *neo4jDriver, `
+			MATCH (s: Span) WHERE s.span_id IN $span_ids
+			RETURN s.span_id as span_id, s.summary as summary
+		`, map[string]any{"span_ids": spanIds}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+		if err != nil {
+			log.Println("[/compare][ExecuteQuery] cannot fetch span summaries, explaining without them", err)
+		} else {
+			for _, record := range res.Records {
+				spanId, _, _ := neo4j.GetRecordValue[string](record, "span_id")
+				summary, _, _ := neo4j.GetRecordValue[string](record, "summary")
+				summaries[spanId] = summary
+			}
+		}
+
+		description := comparison.Describe(summaries)
+		explanation, err := openaiClient.ExplainComparison(ctx, description)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, struct {
+			*analysis.Comparison
+			Explanation string `json:"explanation"`
+			Passage     string `json:"passage"`
+		}{
+			Comparison:  comparison,
+			Explanation: explanation,
+			Passage:     description,
+		})
+	})
+
+	r.GET("/api/usage", func(c *gin.Context) {
+		from, err := time.Parse(time.DateOnly, c.DefaultQuery("from", time.Now().UTC().Format(time.DateOnly)))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects from in YYYY-MM-DD format")
+			return
+		}
+		to, err := time.Parse(time.DateOnly, c.DefaultQuery("to", from.Format(time.DateOnly)))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, "expects to in YYYY-MM-DD format")
+			return
+		}
+
+		var groupBy []string
+		if g := c.Query("group_by"); g != "" {
+			groupBy = strings.Split(g, ",")
+		}
+
+		aggregates, err := usageTracker.Aggregates(c, usage.AggregateQuery{
+			From:    from,
+			To:      to,
+			GroupBy: groupBy,
+		})
+		if errors.Is(err, usage.ErrUnknownGroupBy) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, struct {
+			Data                []usage.Aggregate `json:"data"`
+			Today               usage.Totals      `json:"today"`
+			Budget              usage.Budget      `json:"budget"`
+			SummarizationPaused bool              `json:"summarization_paused"`
+		}{
+			Data:                aggregates,
+			Today:               usageTracker.Today(),
+			Budget:              usageTracker.Budget(),
+			SummarizationPaused: usageTracker.BudgetExceeded(),
+		})
+	})
+
+	return r
 }
@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jmoiron/sqlx"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// weight of the newest duration in the exponentially weighted moving average
+const latencyEwmaAlpha = 0.05
+
+type LatencyKey struct {
+	ServiceName   string
+	OperationName string
+	SpanKind      string
+}
+
+// LatencyBaseline is the usual latency of an operation over its recent spans.
+type LatencyBaseline struct {
+	Samples int           `json:"samples" db:"samples"`
+	P50     time.Duration `json:"p50" db:"p50_ns"`
+	P95     time.Duration `json:"p95" db:"p95_ns"`
+	P99     time.Duration `json:"p99" db:"p99_ns"`
+	Ewma    time.Duration `json:"ewma" db:"ewma_ns"`
+}
+
+// LatencyAnomaly is a span that was much slower than the baseline of its operation.
+type LatencyAnomaly struct {
+	Baseline LatencyBaseline
+	Duration time.Duration
+	// duration divided by the median of the baseline
+	Ratio float64
+}
+
+// Describe is added to the raw span given to the summarization prompt.
+func (a *LatencyAnomaly) Describe() string {
+	if a == nil {
+		return ""
+	}
+	return fmt.Sprintf("latency anomaly: %.1fx slower than usual, usually p50 %s, p95 %s, p99 %s over the last %d calls\n",
+		a.Ratio, a.Baseline.P50, a.Baseline.P95, a.Baseline.P99, a.Baseline.Samples)
+}
+
+// Properties are set on the Span node, durations are in nanoseconds like the span duration.
+func (a *LatencyAnomaly) Properties() map[string]any {
+	if a == nil {
+		return map[string]any{"latency_anomaly": false}
+	}
+	return map[string]any{
+		"latency_anomaly": true,
+		"latency_ratio":   a.Ratio,
+		"latency_p50":     a.Baseline.P50.Nanoseconds(),
+		"latency_p95":     a.Baseline.P95.Nanoseconds(),
+		"latency_p99":     a.Baseline.P99.Nanoseconds(),
+		"latency_ewma":    a.Baseline.Ewma.Nanoseconds(),
+	}
+}
+
+// LatencyAnomalyRecord is a latency anomaly stored in Postgres, the context fields are read from Neo4j by /api/anomalies.
+type LatencyAnomalyRecord struct {
+	SpanId        string        `json:"span_id" db:"span_id"`
+	TraceId       string        `json:"trace_id" db:"trace_id"`
+	ServiceName   string        `json:"service_name" db:"service_name"`
+	OperationName string        `json:"operation_name" db:"operation_name"`
+	SpanKind      string        `json:"span_kind" db:"kind"`
+	StartTime     time.Time     `json:"start_time" db:"start_time"`
+	Duration      time.Duration `json:"duration" db:"duration_ns"`
+	Ratio         float64       `json:"ratio" db:"ratio"`
+	LatencyBaseline
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	SpanStatus      string    `json:"span_status" db:"-"`
+	Summary         string    `json:"summary" db:"-"`
+	ParentService   string    `json:"parent_service,omitempty" db:"-"`
+	ParentOperation string    `json:"parent_operation,omitempty" db:"-"`
+}
+
+type latencySeries struct {
+	// the most recent durations, oldest first
+	durations []time.Duration
+	// the same durations in ascending order, kept sorted as they come and go so that reading the percentiles
+	// does not sort the window on every span
+	sorted []time.Duration
+	ewma   float64
+	// each series has its own lock, writers of different operations do not wait for each other
+	mutex sync.Mutex
+}
+
+// LatencyBaselines keeps a rolling window of durations per service, operation and span kind. A window is warmed up
+// from the spans stored in Postgres the first time its operation is seen, so baselines survive restarts.
+type LatencyBaselines struct {
+	db         *sqlx.DB
+	window     int
+	minSamples int
+	// a span is an anomaly when it is slower than p99 and at least factor times the median
+	factor float64
+	series map[LatencyKey]*latencySeries
+	// guards series, not the series themselves
+	mutex sync.Mutex
+}
+
+func NewLatencyBaselines(db *sqlx.DB) *LatencyBaselines {
+	return &LatencyBaselines{
+		db:         db,
+		window:     envInt("LATENCY_BASELINE_WINDOW", 1000),
+		minSamples: envInt("LATENCY_ANOMALY_MIN_SAMPLES", 30),
+		factor:     envFloat("LATENCY_ANOMALY_FACTOR", 3),
+		series:     make(map[LatencyKey]*latencySeries),
+	}
+}
+
+func envInt(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i <= 0 {
+		log.Println("[envInt][error] invalid", name, v, err)
+		return fallback
+	}
+	return i
+}
+
+func envFloat(name string, fallback float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		log.Println("[envFloat][error] invalid", name, v, err)
+		return fallback
+	}
+	return f
+}
+
+func latencyKeyOf(span *model.Span) LatencyKey {
+	spanKind, _ := span.GetSpanKind()
+	return LatencyKey{
+		ServiceName:   span.Process.GetServiceName(),
+		OperationName: span.GetOperationName(),
+		SpanKind:      spanKind.String(),
+	}
+}
+
+// warmUp reads the most recent durations of the operation from Postgres
+func (b *LatencyBaselines) warmUp(ctx context.Context, key LatencyKey) *latencySeries {
+	//goland:noinspection ALL
+	query := `
+	SELECT EXTRACT(EPOCH FROM spans.duration) as seconds
+	FROM spans
+			 INNER JOIN operations on operations.id = spans.operation_id
+			 INNER JOIN services on services.id = operations.service_id
+	WHERE services.name = $1
+	  AND operations.name = $2
+	  AND spans.kind = $3
+	  AND NOT spans.imported
+	  AND spans.deleted_at IS NULL
+	ORDER BY spans.start_time DESC
+	LIMIT $4
+`
+	series := &latencySeries{durations: make([]time.Duration, 0), sorted: make([]time.Duration, 0)}
+	var seconds []float64
+	if err := b.db.SelectContext(ctx, &seconds, query, key.ServiceName, key.OperationName, key.SpanKind, b.window); err != nil {
+		log.Println("[LatencyBaselines][warmUp][error] cannot read recent durations, starting from scratch", err)
+		return series
+	}
+	// oldest first so that the moving average ends on the newest duration
+	slices.Reverse(seconds)
+	for _, s := range seconds {
+		series.add(time.Duration(s*float64(time.Second)), b.window)
+	}
+	return series
+}
+
+func (s *latencySeries) add(duration time.Duration, window int) {
+	if len(s.durations) == 0 {
+		s.ewma = float64(duration)
+	} else {
+		s.ewma = latencyEwmaAlpha*float64(duration) + (1-latencyEwmaAlpha)*s.ewma
+	}
+	s.durations = append(s.durations, duration)
+	i, _ := slices.BinarySearch(s.sorted, duration)
+	s.sorted = slices.Insert(s.sorted, i, duration)
+	for len(s.durations) > window {
+		oldest := s.durations[0]
+		s.durations = s.durations[1:]
+		i, _ := slices.BinarySearch(s.sorted, oldest)
+		s.sorted = slices.Delete(s.sorted, i, i+1)
+	}
+}
+
+func (s *latencySeries) percentile(p float64) time.Duration {
+	return s.sorted[max(int(math.Ceil(p*float64(len(s.sorted))))-1, 0)]
+}
+
+func (s *latencySeries) baseline() LatencyBaseline {
+	return LatencyBaseline{
+		Samples: len(s.sorted),
+		P50:     s.percentile(0.5),
+		P95:     s.percentile(0.95),
+		P99:     s.percentile(0.99),
+		Ewma:    time.Duration(s.ewma),
+	}
+}
+
+func (b *LatencyBaselines) seriesOf(ctx context.Context, key LatencyKey) *latencySeries {
+	b.mutex.Lock()
+	series, ok := b.series[key]
+	b.mutex.Unlock()
+	if ok {
+		return series
+	}
+
+	// the query runs without the lock, when two spans of a new operation race the first one wins
+	series = b.warmUp(ctx, key)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if existing, ok := b.series[key]; ok {
+		return existing
+	}
+	b.series[key] = series
+	return series
+}
+
+// Check compares the span with the baseline of its operation, before the span is part of it.
+// Returns nil when the span is not an anomaly or the baseline has too few samples.
+func (b *LatencyBaselines) Check(ctx context.Context, span *model.Span) *LatencyAnomaly {
+	series := b.seriesOf(ctx, latencyKeyOf(span))
+
+	series.mutex.Lock()
+	defer series.mutex.Unlock()
+	if len(series.durations) < b.minSamples {
+		return nil
+	}
+	baseline := series.baseline()
+	if span.Duration <= baseline.P99 || float64(span.Duration) < b.factor*float64(baseline.P50) {
+		return nil
+	}
+	ratio := float64(span.Duration) / float64(max(baseline.P50, 1))
+	return &LatencyAnomaly{Baseline: baseline, Duration: span.Duration, Ratio: ratio}
+}
+
+// Percentile returns the p-th percentile, between 0 and 1, of the recent durations of the span operation.
+// The second value is false when the baseline has too few samples to be trusted.
+func (b *LatencyBaselines) Percentile(ctx context.Context, span *model.Span, p float64) (time.Duration, bool) {
+	series := b.seriesOf(ctx, latencyKeyOf(span))
+
+	series.mutex.Lock()
+	defer series.mutex.Unlock()
+	if len(series.sorted) == 0 || len(series.sorted) < b.minSamples {
+		return 0, false
+	}
+	return series.percentile(p), true
+}
+
+// Observe adds the duration of a stored span to the baseline of its operation.
+func (b *LatencyBaselines) Observe(ctx context.Context, span *model.Span) {
+	series := b.seriesOf(ctx, latencyKeyOf(span))
+
+	series.mutex.Lock()
+	defer series.mutex.Unlock()
+	series.add(span.Duration, b.window)
+}
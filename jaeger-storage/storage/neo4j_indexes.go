@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"jaeger-storage/common"
+	"log"
+	"os"
+	"strings"
+)
+
+var defaultIndexedAttributeKeys = []string{
+	"http.status_code",
+	"http.method",
+	"http.route",
+	"db.system",
+	"rpc.method",
+	"customer_id",
+	"driverID",
+	"level",
+	"event",
+	"error",
+}
+
+// IndexedAttributeKeys returns the tag and log field keys from INDEXED_ATTRIBUTE_KEYS (comma separated).
+func IndexedAttributeKeys() []string {
+	v := os.Getenv("INDEXED_ATTRIBUTE_KEYS")
+	if v == "" {
+		return defaultIndexedAttributeKeys
+	}
+	keys := make([]string, 0)
+	for _, k := range strings.Split(v, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// EnsureAttributeIndexes creates neo4j indexes for the given tag and log field keys, both on Span and Log nodes
+func (w *Neo4jWriter) EnsureAttributeIndexes(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		tagProperty := common.AttributePropertyName(common.TagPropertyPrefix, key)
+		fieldProperty := common.AttributePropertyName(common.LogFieldPropertyPrefix, key)
+		// index names and properties cannot be bind using params, property names only contain [A-Za-z0-9_]
+		queries := []string{
+			fmt.Sprintf("CREATE INDEX span_%s IF NOT EXISTS FOR (s: Span) ON (s.%s)", tagProperty, tagProperty),
+			fmt.Sprintf("CREATE INDEX log_%s IF NOT EXISTS FOR (l: Log) ON (l.%s)", fieldProperty, fieldProperty),
+		}
+		for _, q := range queries {
+			if _, err := neo4j.ExecuteQuery(ctx, *w.driver, q, nil, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j")); err != nil {
+				log.Println("[neo4j][EnsureAttributeIndexes][error] cannot create index", q, err)
+				return err
+			}
+		}
+	}
+
+	log.Printf("[neo4j][EnsureAttributeIndexes] ensured indexes for attribute keys %v\n", keys)
+
+	return nil
+}
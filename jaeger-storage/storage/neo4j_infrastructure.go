@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"jaeger-storage/common"
+	"log"
+)
+
+// upsertInfrastructure links the span to the process, host and pod it ran on
+func (w *Neo4jWriter) upsertInfrastructure(ctx context.Context, span *model.Span) error {
+	if span.Process == nil {
+		return nil
+	}
+	info := common.ExtractProcessInfo(span.Process)
+
+	query := `
+			MATCH (service: Service {name: $service_name})
+			MATCH (span: Span { span_id: $span_id })
+			MERGE (process: Process { process_key: $process_key })
+			SET process += $process_properties
+			MERGE (service)-[:RUNS_AS]->(process)
+			MERGE (span)-[:RAN_ON]->(process)
+			FOREACH (_ IN CASE WHEN $hostname IS NULL THEN [] ELSE [1] END |
+				MERGE (host: Host { name: $hostname })
+				SET host.ips = CASE
+					WHEN $ip IS NULL OR $ip IN coalesce(host.ips, []) THEN host.ips
+					ELSE coalesce(host.ips, []) + $ip
+				END
+				MERGE (process)-[:RAN_ON]->(host)
+			)
+			FOREACH (_ IN CASE WHEN $pod_name IS NULL THEN [] ELSE [1] END |
+				MERGE (pod: Pod { name: $pod_name, namespace: coalesce($namespace, 'default') })
+				MERGE (process)-[:RAN_ON]->(pod)
+				FOREACH (__ IN CASE WHEN $node_name IS NULL THEN [] ELSE [1] END |
+					MERGE (node: Host { name: $node_name })
+					MERGE (pod)-[:RAN_ON]->(node)
+				)
+			)
+		`
+	_, err := neo4j.ExecuteQuery(ctx, *w.driver, query, map[string]any{
+		"service_name":       info.ServiceName,
+		"span_id":            span.SpanID.String(),
+		"process_key":        info.Key,
+		"process_properties": info.Properties(),
+		"hostname":           common.NilIfEmpty(info.Hostname),
+		"ip":                 common.NilIfEmpty(info.Ip),
+		"pod_name":           common.NilIfEmpty(info.PodName),
+		"namespace":          common.NilIfEmpty(info.Namespace),
+		"node_name":          common.NilIfEmpty(info.NodeName),
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[neo4j][upsertInfrastructure][error] cannot upsert process", err)
+		return err
+	}
+
+	return nil
+}
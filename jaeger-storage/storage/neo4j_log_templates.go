@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"jaeger-storage/common"
+	"jaeger-storage/logtemplate"
+	"log"
+	"slices"
+	"strings"
+)
+
+// number of distinct variable values shown for a repeated log in the summarization prompt
+const logTemplateExamples = 3
+
+// LoadLogTemplates restores the templates mined before the restart, so that logs keep matching their template
+func (w *Neo4jWriter) LoadLogTemplates(ctx context.Context) error {
+	res, err := neo4j.ExecuteQuery(ctx, *w.driver, `
+		MATCH (t: LogTemplate)
+		RETURN t.template_id as template_id, t.template as template
+	`, nil, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[neo4j][LoadLogTemplates][error] cannot read log templates", err)
+		return err
+	}
+	for _, record := range res.Records {
+		id, _, _ := neo4j.GetRecordValue[string](record, "template_id")
+		template, _, _ := neo4j.GetRecordValue[string](record, "template")
+		w.logMiner.Load(id, template)
+	}
+	log.Printf("[neo4j][LoadLogTemplates] loaded %d log templates\n", len(res.Records))
+	return nil
+}
+
+// logLine renders the fields of a log on a single line, in the order they were logged
+func logLine(l common.InternalLog) string {
+	fields := make([]string, 0, len(l.Fields))
+	for _, f := range l.Fields {
+		fields = append(fields, fmt.Sprintf("%s: %v", f.Key, f.Value))
+	}
+	return strings.Join(fields, " ")
+}
+
+func (w *Neo4jWriter) mineLogTemplates(internalLogs []common.InternalLog) []logtemplate.Match {
+	matches := make([]logtemplate.Match, 0, len(internalLogs))
+	for _, l := range internalLogs {
+		matches = append(matches, w.logMiner.Add(logLine(l)))
+	}
+	return matches
+}
+
+// templatedLogs describes the logs to the summarizer, a log repeated with different values is given once as its
+// template with the number of occurrences and a few of the values
+func templatedLogs(internalLogs []common.InternalLog, matches []logtemplate.Match) string {
+	type group struct {
+		template string
+		lines    []string
+	}
+	groups := make([]*group, 0)
+	byId := make(map[string]*group)
+	for i, m := range matches {
+		g, ok := byId[m.Id]
+		if !ok {
+			g = &group{}
+			byId[m.Id] = g
+			groups = append(groups, g)
+		}
+		// the template may have generalised since the first log of the group
+		g.template = m.Template.Template
+		g.lines = append(g.lines, logLine(internalLogs[i]))
+	}
+
+	var sb strings.Builder
+	for _, g := range groups {
+		if len(g.lines) == 1 {
+			sb.WriteString(g.lines[0] + "\n")
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%dx %s\n", len(g.lines), g.template))
+		examples := make([]string, 0, logTemplateExamples)
+		for _, line := range g.lines {
+			example := strings.Join(logtemplate.Variables(g.template, line), ", ")
+			if example != "" && len(examples) < logTemplateExamples && !slices.Contains(examples, example) {
+				examples = append(examples, example)
+			}
+		}
+		if len(examples) > 0 {
+			sb.WriteString(fmt.Sprintf("values: [%s]\n", strings.Join(examples, "], [")))
+		}
+	}
+	return sb.String()
+}
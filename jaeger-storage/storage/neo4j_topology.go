@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"log"
+	"time"
+)
+
+// upper bounds of the latency histogram buckets kept on CALLS edges, the last bucket is unbounded
+var callLatencyBucketsMs = []int64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+func callLatencyBucketsNs() []int64 {
+	bounds := make([]int64, len(callLatencyBucketsMs))
+	for i, ms := range callLatencyBucketsMs {
+		bounds[i] = (time.Duration(ms) * time.Millisecond).Nanoseconds()
+	}
+	return bounds
+}
+
+// upsertOperation links the span to the operation it is an instance of
+func (w *Neo4jWriter) upsertOperation(ctx context.Context, span *model.Span) error {
+	query := `
+			MATCH (service: Service {name: $service_name})
+			MATCH (span: Span { span_id: $span_id })
+			MERGE (operation: Operation { service_name: $service_name, name: $operation_name })
+			MERGE (service)-[:EXPOSES]->(operation)
+			MERGE (span)-[:INSTANCE_OF]->(operation)
+		`
+	_, err := neo4j.ExecuteQuery(ctx, *w.driver, query, map[string]any{
+		"service_name":   span.Process.GetServiceName(),
+		"operation_name": span.GetOperationName(),
+		"span_id":        span.SpanID.String(),
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Println("[neo4j][upsertOperation][error] cannot upsert operation", err)
+		return err
+	}
+
+	return nil
+}
+
+// recordCall aggregates a parent to child invocation into the CALLS edges between operations and
+// between services. Each invocation is counted once, even if the spans are linked again. The counters are read
+// and incremented in a single SET, which holds the write lock of the edge, so concurrent writers do not lose counts.
+func (w *Neo4jWriter) recordCall(ctx context.Context, parentSpanId string, childSpanId string) error {
+	query := `
+			MATCH (parent: Span { span_id: $span_id_parent })-[invoke]->(child: Span { span_id: $span_id_child })
+			WHERE type(invoke) IN ['INVOKES_CHILD', 'INVOKES_FOLLOWS'] AND invoke.counted IS NULL
+			SET invoke.counted = true
+			WITH parent, child
+			MATCH (parent)-[:INSTANCE_OF]->(parent_operation: Operation)<-[:EXPOSES]-(parent_service: Service)
+			MATCH (child)-[:INSTANCE_OF]->(child_operation: Operation)<-[:EXPOSES]-(child_service: Service)
+			WITH parent_operation, parent_service, child_operation, child_service, child,
+				size([b IN $buckets WHERE b < child.duration]) AS bucket,
+				CASE WHEN child.span_status = 'ERROR' THEN 1 ELSE 0 END AS is_error
+			MERGE (parent_operation)-[operation_call:CALLS]->(child_operation)
+			SET operation_call.call_count = coalesce(operation_call.call_count, 0) + 1,
+				operation_call.error_count = coalesce(operation_call.error_count, 0) + is_error,
+				operation_call.total_duration = coalesce(operation_call.total_duration, 0) + child.duration,
+				operation_call.max_duration = CASE WHEN child.duration > coalesce(operation_call.max_duration, 0) THEN child.duration ELSE operation_call.max_duration END,
+				operation_call.latency_buckets_ms = $buckets_ms,
+				operation_call.latency_histogram = [i IN range(0, size($buckets)) | coalesce(operation_call.latency_histogram[i], 0) + CASE WHEN i = bucket THEN 1 ELSE 0 END]
+			WITH parent_service, child_service, child, bucket, is_error
+			WHERE parent_service <> child_service
+			MERGE (parent_service)-[service_call:CALLS]->(child_service)
+			SET service_call.call_count = coalesce(service_call.call_count, 0) + 1,
+				service_call.error_count = coalesce(service_call.error_count, 0) + is_error,
+				service_call.total_duration = coalesce(service_call.total_duration, 0) + child.duration,
+				service_call.max_duration = CASE WHEN child.duration > coalesce(service_call.max_duration, 0) THEN child.duration ELSE service_call.max_duration END,
+				service_call.latency_buckets_ms = $buckets_ms,
+				service_call.latency_histogram = [i IN range(0, size($buckets)) | coalesce(service_call.latency_histogram[i], 0) + CASE WHEN i = bucket THEN 1 ELSE 0 END]
+		`
+	_, err := neo4j.ExecuteQuery(ctx, *w.driver, query, map[string]any{
+		"span_id_parent": parentSpanId,
+		"span_id_child":  childSpanId,
+		"buckets":        callLatencyBucketsNs(),
+		"buckets_ms":     callLatencyBucketsMs,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+	if err != nil {
+		log.Printf("[neo4j][recordCall][error] cannot record call, parent span id: %s, child span id: %s, err: %s\n", parentSpanId, childSpanId, err)
+		return err
+	}
+
+	return nil
+}
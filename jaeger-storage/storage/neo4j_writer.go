@@ -7,9 +7,12 @@ import (
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
 	"jaeger-storage/clients"
 	"jaeger-storage/common"
+	"jaeger-storage/logtemplate"
+	"jaeger-storage/prompts"
+	"jaeger-storage/redaction"
+	"jaeger-storage/usage"
 	"log"
 	"os"
-	"strings"
 	"sync"
 )
 
@@ -23,61 +26,98 @@ type Neo4jWriter struct {
 	missingParents map[string][]relationshipSpan
 	mutex          sync.Mutex
 	openaiClient   *clients.OpenAIClient
+	usageTracker   *usage.Tracker
+	policy         *SummarizationPolicy
+	redactor       *redaction.Redactor
+	logMiner       *logtemplate.Miner
 }
 
-func NewNeo4jWriter(driver *neo4j.DriverWithContext, openaiClient *clients.OpenAIClient) *Neo4jWriter {
+func NewNeo4jWriter(driver *neo4j.DriverWithContext, openaiClient *clients.OpenAIClient, usageTracker *usage.Tracker, policy *SummarizationPolicy, redactor *redaction.Redactor, logMiner *logtemplate.Miner) *Neo4jWriter {
 	return &Neo4jWriter{
 		driver:         driver,
 		missingParents: make(map[string][]relationshipSpan),
 		mutex:          sync.Mutex{},
 		openaiClient:   openaiClient,
+		usageTracker:   usageTracker,
+		policy:         policy,
+		redactor:       redactor,
+		logMiner:       logMiner,
 	}
 }
 
-func (w *Neo4jWriter) summarizeAndCreateEmbeddings(ctx context.Context, span *model.Span, internalLogs []common.InternalLog) error {
-	spanKind, _ := span.GetSpanKind()
-	// todo: change check tags???
-	actionKind := "http"
+func rawTags(span *model.Span) string {
+	var tagsRaw string
+	for i := 0; i < len(span.Tags); i++ {
+		t := span.Tags[i]
+		tagsRaw += fmt.Sprintf("%s: %s\n", t.Key, t.Value())
+	}
+	return tagsRaw
+}
 
-	spanRaw := fmt.Sprintf("service name: %s\noperation name: %s\nspan id: %s\nduration: %d nanoseconds\nstart time: %s\nspan kind: %s\naction kind: %s\n", span.Process.GetServiceName(), span.GetOperationName(), span.SpanID.String(), span.Duration.Nanoseconds(), span.StartTime.String(), spanKind.String(), actionKind)
+// writeTemplateSummary describes the span without calling the LLM
+func (w *Neo4jWriter) writeTemplateSummary(ctx context.Context, span *model.Span, internalLogs []common.InternalLog, anomaly *LatencyAnomaly, reason string) error {
+	spanSummary := templateSpanSummary(span, internalLogs) + anomaly.Describe()
+	tagsRaw := w.redactor.Redact(ctx, "tag_summary", rawTags(span))
+
+	query := `
+		MATCH (span: Span { span_id: $span_id })
+		SET span.span_summary = $span_summary,
+			span.log_summary = $log_summary,
+			span.tag_summary = $tag_summary,
+			span.summary = $summary,
+			span.summarized = false,
+			span.summary_reason = $summary_reason
+	`
+	_, err := neo4j.ExecuteQuery(ctx, *w.driver, query, map[string]any{
+		"span_id":        span.SpanID.String(),
+		"span_summary":   spanSummary,
+		"log_summary":    "",
+		"tag_summary":    tagsRaw,
+		"summary":        spanSummary + tagsRaw,
+		"summary_reason": reason,
+	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
 
-	spanSummary, err := w.openaiClient.SummarizeSpan(ctx, spanRaw)
 	if err != nil {
-		log.Println("[neo4j][summarizeAndCreateEmbeddings] an error occurred while summarizing the span", spanRaw, err)
+		log.Println("[neo4j][writeTemplateSummary] cannot set summary", err)
 		return err
 	}
-	spanSummary = strings.ReplaceAll(spanSummary, "<summary>", "")
-	spanSummary = strings.ReplaceAll(spanSummary, "</summary>", "")
-	spanSummary = strings.TrimSpace(spanSummary)
 
-	var logsRaw string
-	for i := 0; i < len(internalLogs); i++ {
-		l := internalLogs[i]
-		var value string
-		for j := 0; j < len(l.Fields); j++ {
-			currentLog := l.Fields[j]
-			value += fmt.Sprintf("%s: %s\n", currentLog.Key, currentLog.Value)
-		}
-		logsRaw += value + "\n"
+	log.Printf("[neo4j][writeTemplateSummary] skipped summarization for span ID: %s, reason: %s\n", span.SpanID.String(), reason)
+
+	return nil
+}
+
+func (w *Neo4jWriter) summarizeAndCreateEmbeddings(ctx context.Context, span *model.Span, internalLogs []common.InternalLog, logTemplates []logtemplate.Match, anomaly *LatencyAnomaly, reason string) error {
+	spanKind, _ := span.GetSpanKind()
+	action := common.ClassifySpan(span)
+
+	spanRaw := fmt.Sprintf("service name: %s\noperation name: %s\nspan id: %s\nduration: %d nanoseconds\nstart time: %s\nspan kind: %s\naction kind: %s\n", span.Process.GetServiceName(), span.GetOperationName(), span.SpanID.String(), span.Duration.Nanoseconds(), span.StartTime.String(), spanKind.String(), action.Kind)
+	spanRaw += action.Describe()
+	spanRaw += common.ClassifySpanErrors(span).Describe()
+	spanRaw += anomaly.Describe()
+
+	// the prompt templates can be overridden per service and per SDK language
+	scope := prompts.Scope{
+		ServiceName: span.Process.GetServiceName(),
+		Language:    common.ExtractProcessInfo(span.Process).SdkLanguage,
+	}
+	spanSummary, spanPromptVersion, err := w.openaiClient.SummarizeSpan(ctx, scope, spanRaw)
+	if err != nil {
+		log.Println("[neo4j][summarizeAndCreateEmbeddings] an error occurred while summarizing the span", spanRaw, err)
+		return err
 	}
 
-	logSummary, err := w.openaiClient.SummarizeLog(ctx, logsRaw)
-	logSummary = strings.TrimSpace(logSummary)
-	logSummary = strings.ReplaceAll(logSummary, "#EMPTY#", "")
+	// repeated logs are sent once as a template to save tokens
+	logsRaw := templatedLogs(internalLogs, logTemplates)
 
+	logSummary, logPromptVersion, err := w.openaiClient.SummarizeLog(ctx, scope, logsRaw)
 	if err != nil {
 		log.Println("[neo4j][summarizeAndCreateEmbeddings][error] an error occurred summarizing the logs", logsRaw, err)
 		return err
 	}
-	logSummary = strings.ReplaceAll(logSummary, "<summary>", "")
-	logSummary = strings.ReplaceAll(logSummary, "</summary>", "")
-	logSummary = strings.TrimSpace(logSummary)
 
-	var tagsRaw string
-	for i := 0; i < len(span.Tags); i++ {
-		t := span.Tags[i]
-		tagsRaw += fmt.Sprintf("%s: %s\n", t.Key, t.Value())
-	}
+	// tags are stored and embedded as is, so they are redacted before that
+	tagsRaw := w.redactor.Redact(ctx, "tag_summary", rawTags(span))
 
 	//tagsSummary, err := w.openaiClient.SummarizeLog(ctx, tagsRaw)
 	//tagsSummary = strings.TrimSpace(tagsSummary)
@@ -103,15 +143,22 @@ func (w *Neo4jWriter) summarizeAndCreateEmbeddings(ctx context.Context, span *mo
 			span.log_summary = $log_summary,
 			span.tag_summary = $tag_summary,
 			span.summary = $summary,
-			span.embedding = $embedding
+			span.embedding = $embedding,
+			span.summarized = true,
+			span.summary_reason = $summary_reason,
+			span.span_summary_prompt = $span_summary_prompt,
+			span.log_summary_prompt = $log_summary_prompt
 	`
 	_, err = neo4j.ExecuteQuery(ctx, *w.driver, query, map[string]any{
-		"span_id":      span.SpanID.String(),
-		"span_summary": spanSummary,
-		"log_summary":  logSummary,
-		"tag_summary":  tagsRaw,
-		"embedding":    embedding,
-		"summary":      spanSummary + logSummary + tagsRaw,
+		"span_id":             span.SpanID.String(),
+		"span_summary":        spanSummary,
+		"log_summary":         logSummary,
+		"tag_summary":         tagsRaw,
+		"embedding":           embedding,
+		"summary":             spanSummary + logSummary + tagsRaw,
+		"summary_reason":      reason,
+		"span_summary_prompt": spanPromptVersion,
+		"log_summary_prompt":  logPromptVersion,
 		//"log_embedding": logEmbedding,
 	}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
 
@@ -124,10 +171,10 @@ func (w *Neo4jWriter) summarizeAndCreateEmbeddings(ctx context.Context, span *mo
 
 	content := fmt.Sprintf("summary for spanid: %s\n"+
 		"raw span: %s\n"+
-		"span summary: %s\n"+
+		"span summary (%s): %s\n"+
 		"raw log: %s\n"+
-		"log summary: %s\n"+
-		"--------------------------------\n", span.SpanID.String(), spanRaw, spanSummary, logsRaw, logSummary)
+		"log summary (%s): %s\n"+
+		"--------------------------------\n", span.SpanID.String(), spanRaw, spanPromptVersion, spanSummary, logsRaw, logPromptVersion, logSummary)
 
 	if os.Getenv("DEBUG") == "true" {
 		common.WriteToFile("summary.log", content)
@@ -136,7 +183,7 @@ func (w *Neo4jWriter) summarizeAndCreateEmbeddings(ctx context.Context, span *mo
 	return nil
 }
 
-func (w *Neo4jWriter) upsertServiceTraceSpan(ctx context.Context, span *model.Span) error {
+func (w *Neo4jWriter) upsertServiceTraceSpan(ctx context.Context, span *model.Span, anomaly *LatencyAnomaly, imported bool) error {
 	neo4jQuery := `
 			MERGE (service: Service {name: $service_name})
 			MERGE (trace: Trace { trace_id: $trace_id })
@@ -152,38 +199,42 @@ func (w *Neo4jWriter) upsertServiceTraceSpan(ctx context.Context, span *model.Sp
 				action_kind: $action_kind,
 				span_status: $span_status
 			})
+			SET span += $action_attributes,
+				span += $tag_properties,
+				span += $latency_properties,
+				span.error_type = $error_type,
+				span.error_message = $error_message,
+				span.warnings = $warnings,
+				span.imported = $imported
 			MERGE (service)-[r_contain:CONTAINS]->(span)
 			MERGE (trace)-[r_contain_span:CONTAINS]->(span)
 			RETURN (span)
 		`
 	spanKind, _ := span.GetSpanKind()
-	// todo: change check tags???
-	actionKind := "http"
-	spanStatus := "OK"
-
-	for _, v := range span.GetTags() {
-		if v.Key == "otel.status_code" {
-			if v.VStr == "ERROR" {
-				spanStatus = "ERROR"
-				break
-			}
-		}
-	}
+	action := common.ClassifySpan(span)
+	errorReport := common.ClassifySpanErrors(span)
+	primaryError, _ := errorReport.Primary()
 
 	param := map[string]any{
-		"service_name":   span.Process.GetServiceName(),
-		"operation_name": span.GetOperationName(),
-		"span_id":        span.SpanID.String(),
-		"duration":       span.Duration.Nanoseconds(),
-		"start_time":     span.StartTime,
-		"log_summary":    "TODO: empty-for-now",
-		"tag_summary":    "TODO: empty-for-now",
-		"span_summary":   "TODO: empty-for-now",
-		"span_kind":      spanKind.String(),
-		// TODO: lookup from tags/logs
-		"action_kind": actionKind,
-		"span_status": spanStatus,
-		"trace_id":    span.TraceID.String(),
+		"service_name":       span.Process.GetServiceName(),
+		"operation_name":     span.GetOperationName(),
+		"span_id":            span.SpanID.String(),
+		"duration":           span.Duration.Nanoseconds(),
+		"start_time":         span.StartTime,
+		"log_summary":        "TODO: empty-for-now",
+		"tag_summary":        "TODO: empty-for-now",
+		"span_summary":       "TODO: empty-for-now",
+		"span_kind":          spanKind.String(),
+		"action_kind":        action.Kind,
+		"action_attributes":  action.Attributes,
+		"tag_properties":     common.TagProperties(span.Tags, w.redactAttribute(ctx, "tag_properties")),
+		"latency_properties": anomaly.Properties(),
+		"span_status":        errorReport.Status,
+		"error_type":         common.NilIfEmpty(primaryError.Type),
+		"error_message":      common.NilIfEmpty(primaryError.Message),
+		"warnings":           errorReport.Warnings,
+		"imported":           imported,
+		"trace_id":           span.TraceID.String(),
 	}
 	_, err := neo4j.ExecuteQuery(ctx, *w.driver, neo4jQuery, param, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
 	if err != nil {
@@ -196,10 +247,70 @@ func (w *Neo4jWriter) upsertServiceTraceSpan(ctx context.Context, span *model.Sp
 	return nil
 }
 
-func (w *Neo4jWriter) insertLogs(ctx context.Context, span *model.Span, internalLogs []common.InternalLog) error {
+// insertErrors links the errors raised by the span to both the span and its service,
+// so they can be queried without reading the summaries
+func (w *Neo4jWriter) insertErrors(ctx context.Context, span *model.Span) error {
+	errorReport := common.ClassifySpanErrors(span)
+	query := `
+			MATCH (service: Service {name: $service_name})
+			MATCH (span: Span { span_id: $span_id })
+			MERGE (e: Error { error_id: $error_id })
+			SET e.type = $type,
+				e.raw_type = $raw_type,
+				e.message = $message,
+				e.stacktrace = $stacktrace,
+				e.source = $source,
+				e.timestamp = $timestamp,
+				e.trace_id = $trace_id
+			MERGE (span)-[:RAISED]->(e)
+			MERGE (service)-[:RAISED]->(e)
+		`
+	for i, e := range errorReport.Errors {
+		_, err := neo4j.ExecuteQuery(ctx, *w.driver, query, map[string]any{
+			"service_name": span.Process.GetServiceName(),
+			"span_id":      span.SpanID.String(),
+			"error_id":     fmt.Sprintf("%s-%d", span.SpanID.String(), i),
+			"type":         e.Type,
+			"raw_type":     common.NilIfEmpty(e.RawType),
+			"message":      common.NilIfEmpty(e.Message),
+			"stacktrace":   common.NilIfEmpty(e.Stacktrace),
+			"source":       e.Source,
+			"timestamp":    e.Timestamp,
+			"trace_id":     span.TraceID.String(),
+		}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
+
+		if err != nil {
+			log.Println("[neo4j][insertErrors][error] cannot create errors", err)
+			return err
+		}
+	}
+
+	if len(errorReport.Errors) > 0 {
+		log.Printf("[neo4j][insertErrors] successfully inserted %d errors for span ID: %s\n", len(errorReport.Errors), span.SpanID.String())
+	}
+
+	return nil
+}
+
+func (w *Neo4jWriter) redactAttribute(ctx context.Context, target string) common.RedactFunc {
+	return func(key string, value string) string {
+		return w.redactor.RedactAttribute(ctx, target, key, value)
+	}
+}
+
+func (w *Neo4jWriter) insertLogs(ctx context.Context, span *model.Span, internalLogs []common.InternalLog, logTemplates []logtemplate.Match) error {
+	// the template is only replaced by a more general one, as concurrent writers may hold older snapshots of it
 	createLogsQuery := `
 			MATCH(span: Span { span_id: $span_id })	
-			CREATE (n: Log { value: $value, timestamp: $timestamp })<-[r:PRODUCES]-(span)	
+			CREATE (n: Log { value: $value, timestamp: $timestamp, template_id: $template_id, variables: $variables })<-[r:PRODUCES]-(span)
+			SET n += $fields
+			MERGE (t: LogTemplate { template_id: $template_id })
+			ON CREATE SET t.template = $template, t.count = 0, t.first_seen = $timestamp, t.last_seen = $timestamp
+			SET t.template = CASE WHEN size(split($template, '<*>')) > size(split(t.template, '<*>')) THEN $template ELSE t.template END,
+				t.count = t.count + 1,
+				t.first_seen = CASE WHEN $timestamp < t.first_seen THEN $timestamp ELSE t.first_seen END,
+				t.last_seen = CASE WHEN $timestamp > t.last_seen THEN $timestamp ELSE t.last_seen END
+			MERGE (n)-[:INSTANCE_OF]->(t)
 		`
 	for i := 0; i < len(internalLogs); i++ {
 		l := internalLogs[i]
@@ -210,9 +321,13 @@ func (w *Neo4jWriter) insertLogs(ctx context.Context, span *model.Span, internal
 		}
 
 		_, err := neo4j.ExecuteQuery(ctx, *w.driver, createLogsQuery, map[string]any{
-			"span_id":   span.SpanID.String(),
-			"value":     value,
-			"timestamp": l.Timestamp,
+			"span_id":     span.SpanID.String(),
+			"value":       value,
+			"timestamp":   l.Timestamp,
+			"fields":      common.LogFieldProperties(l.Fields, w.redactAttribute(ctx, "log_field_properties")),
+			"template_id": logTemplates[i].Id,
+			"template":    logTemplates[i].Template.Template,
+			"variables":   logTemplates[i].Variables,
 		}, neo4j.EagerResultTransformer, neo4j.ExecuteQueryWithDatabase("neo4j"))
 
 		if err != nil {
@@ -267,6 +382,9 @@ func (w *Neo4jWriter) createRelationshipBetweenSpan(ctx context.Context, span *m
 			w.mutex.Unlock()
 		} else {
 			log.Printf("[neo4j][createRelationshipBetweenSpan] successfully associated span child: %s with span parent: %s", span.SpanID.String(), span.ParentSpanID())
+			if err := w.recordCall(ctx, r.SpanId, span.SpanID.String()); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -292,6 +410,12 @@ func (w *Neo4jWriter) associateMissingSpan(ctx context.Context, span *model.Span
 
 		if err != nil {
 			log.Println("[neo4j][associateMissingSpan][error] cannot associate missing spans", err)
+			w.mutex.Unlock()
+			return err
+		}
+
+		if err := w.recordCall(ctx, span.SpanID.String(), missingSpan.childSpanId); err != nil {
+			w.mutex.Unlock()
 			return err
 		}
 
@@ -302,27 +426,64 @@ func (w *Neo4jWriter) associateMissingSpan(ctx context.Context, span *model.Span
 	return nil
 }
 
-func (w *Neo4jWriter) WriteSpan(ctx context.Context, span *model.Span, internalRefs []common.InternalSpanRef, internalLogs []common.InternalLog) error {
-	if span.Process.GetServiceName() != "jaeger-all-in-one" {
-		if err := w.upsertServiceTraceSpan(ctx, span); err != nil {
-			return err
-		}
+func (w *Neo4jWriter) WriteSpan(ctx context.Context, span *model.Span, internalRefs []common.InternalSpanRef, internalLogs []common.InternalLog, action FilterAction, anomaly *LatencyAnomaly, imported bool) error {
+	// set first, the properties are redacted before the summaries and the audit entries name the span
+	ctx = usage.WithAttribution(ctx, usage.Attribution{
+		Caller:        usage.CallerIngest,
+		ServiceName:   span.Process.GetServiceName(),
+		OperationName: span.GetOperationName(),
+		TraceId:       span.TraceID.String(),
+	})
 
-		if err := w.insertLogs(ctx, span, internalLogs); err != nil {
-			return err
-		}
+	if err := w.upsertServiceTraceSpan(ctx, span, anomaly, imported); err != nil {
+		return err
+	}
 
-		if err := w.createRelationshipBetweenSpan(ctx, span, internalRefs); err != nil {
-			return err
-		}
+	if err := w.upsertOperation(ctx, span); err != nil {
+		return err
+	}
 
-		if err := w.associateMissingSpan(ctx, span); err != nil {
-			return err
-		}
+	if err := w.upsertInfrastructure(ctx, span); err != nil {
+		return err
+	}
 
-		if err := w.summarizeAndCreateEmbeddings(ctx, span, internalLogs); err != nil {
-			return err
-		}
+	logTemplates := w.mineLogTemplates(internalLogs)
+	if err := w.insertLogs(ctx, span, internalLogs, logTemplates); err != nil {
+		return err
+	}
+
+	if err := w.insertErrors(ctx, span); err != nil {
+		return err
+	}
+
+	if err := w.createRelationshipBetweenSpan(ctx, span, internalRefs); err != nil {
+		return err
+	}
+
+	if err := w.associateMissingSpan(ctx, span); err != nil {
+		return err
+	}
+
+	decision := w.policy.Evaluate(ctx, span, imported)
+	switch action {
+	case FilterActionNoSummarize:
+		decision = SummarizationDecision{Summarize: false, Reason: "filtered"}
+	case FilterActionSummarize:
+		decision = SummarizationDecision{Summarize: true, Reason: "forced"}
+	}
+	if !decision.Summarize && decision.Reason == "not-sampled" && anomaly != nil {
+		decision = SummarizationDecision{Summarize: true, Reason: "latency-anomaly"}
+	}
+	if decision.Summarize && w.usageTracker.BudgetExceeded() {
+		decision = SummarizationDecision{Summarize: false, Reason: "budget-exceeded"}
+	}
+
+	if !decision.Summarize {
+		return w.writeTemplateSummary(ctx, span, internalLogs, anomaly, decision.Reason)
+	}
+
+	if err := w.summarizeAndCreateEmbeddings(ctx, span, internalLogs, logTemplates, anomaly, decision.Reason); err != nil {
+		return err
 	}
 	return nil
 }
@@ -12,11 +12,17 @@ import (
 )
 
 type SqlWriter struct {
-	db *sqlx.DB
+	db               *sqlx.DB
+	latencyBaselines *LatencyBaselines
 }
 
-func NewSqlWriter(db *sqlx.DB) *SqlWriter {
-	return &SqlWriter{db: db}
+func NewSqlWriter(db *sqlx.DB, latencyBaselines *LatencyBaselines) *SqlWriter {
+	return &SqlWriter{db: db, latencyBaselines: latencyBaselines}
+}
+
+// CheckLatency compares the span with the latency baseline of its operation, it returns nil when the span is not an anomaly
+func (w *SqlWriter) CheckLatency(ctx context.Context, span *model.Span) *LatencyAnomaly {
+	return w.latencyBaselines.Check(ctx, span)
 }
 
 func (w *SqlWriter) upsertService(ctx context.Context, p common.InternalService) (int64, error) {
@@ -50,14 +56,24 @@ func (w *SqlWriter) upsertOperation(ctx context.Context, p common.InternalOperat
 
 func (w *SqlWriter) insertSpan(ctx context.Context, p common.InternalSpan) (int64, error) {
 	//goland:noinspection ALL
-	query := "INSERT INTO spans(span_id, trace_id, operation_id, flags, start_time, duration, tags, service_id, process_id, process_tags, warnings, logs, kind, refs, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) RETURNING id"
+	query := "INSERT INTO spans(span_id, trace_id, operation_id, flags, start_time, duration, tags, service_id, process_id, process_tags, warnings, logs, kind, refs, imported, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) RETURNING id"
 	var id int64
-	err := w.db.GetContext(ctx, &id, query, p.SpanId, p.TraceId, p.OperationId, p.Flags, p.StartTime, p.Duration.Seconds(), p.Tags, p.ServiceId, p.ProcessId, p.ProcessTags, p.WarningsPq, p.Logs, p.Kind, p.Refs, p.CreatedAt)
+	err := w.db.GetContext(ctx, &id, query, p.SpanId, p.TraceId, p.OperationId, p.Flags, p.StartTime, p.Duration.Seconds(), p.Tags, p.ServiceId, p.ProcessId, p.ProcessTags, p.WarningsPq, p.Logs, p.Kind, p.Refs, p.Imported, p.CreatedAt)
 
 	return id, err
 }
 
-func (w *SqlWriter) WriteSpan(ctx context.Context, span *model.Span, tags, processTags, logs, references []byte) error {
+func (w *SqlWriter) insertLatencyAnomaly(ctx context.Context, span *model.Span, anomaly *LatencyAnomaly) error {
+	//goland:noinspection ALL
+	query := "INSERT INTO latency_anomalies(span_id, trace_id, service_name, operation_name, kind, start_time, duration_ns, ratio, samples, p50_ns, p95_ns, p99_ns, ewma_ns, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)"
+	spanKind, _ := span.GetSpanKind()
+	_, err := w.db.ExecContext(ctx, query, span.SpanID.String(), span.TraceID.String(), span.Process.GetServiceName(), span.GetOperationName(), spanKind.String(), span.StartTime, anomaly.Duration.Nanoseconds(), anomaly.Ratio, anomaly.Baseline.Samples, anomaly.Baseline.P50.Nanoseconds(), anomaly.Baseline.P95.Nanoseconds(), anomaly.Baseline.P99.Nanoseconds(), anomaly.Baseline.Ewma.Nanoseconds(), time.Now())
+
+	return err
+}
+
+// WriteSpan stores the span, a span that is not imported is added to the latency baseline of its operation
+func (w *SqlWriter) WriteSpan(ctx context.Context, span *model.Span, tags, processTags, logs, references []byte, anomaly *LatencyAnomaly, imported bool) error {
 	//	upsert InternalService
 	serviceId, err := w.upsertService(ctx, common.InternalService{
 		Name:      span.Process.GetServiceName(),
@@ -93,6 +109,7 @@ func (w *SqlWriter) WriteSpan(ctx context.Context, span *model.Span, tags, proce
 		Logs:        logs,
 		Kind:        spanKind.String(),
 		Refs:        references,
+		Imported:    imported,
 		CreatedAt:   time.Now(),
 	}
 	spanId, err := w.insertSpan(ctx, spanData)
@@ -102,5 +119,15 @@ func (w *SqlWriter) WriteSpan(ctx context.Context, span *model.Span, tags, proce
 		return err
 	}
 	log.Println(fmt.Sprintf("[sql][writespan] successfully inserted span with primary key: %d, spanId: %s, serviceName: %s, operationName: %s", spanId, span.SpanID.String(), span.Process.GetServiceName(), span.GetOperationName()))
+
+	if anomaly != nil {
+		if err := w.insertLatencyAnomaly(ctx, span, anomaly); err != nil {
+			log.Println("[sql][writespan][error] cannot insert latency anomaly", err)
+			return err
+		}
+	}
+	if !imported {
+		w.latencyBaselines.Observe(ctx, span)
+	}
 	return nil
 }
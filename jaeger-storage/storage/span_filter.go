@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+type FilterAction string
+
+const (
+	// FilterActionStore stores the span everywhere and summarizes it according to the summarization policy
+	FilterActionStore FilterAction = "store"
+	// FilterActionDrop does not store the span at all
+	FilterActionDrop FilterAction = "drop"
+	// FilterActionPostgresOnly stores the span in postgres but not in the knowledge graph
+	FilterActionPostgresOnly FilterAction = "postgres-only"
+	// FilterActionNoSummarize stores the span everywhere without an LLM summary
+	FilterActionNoSummarize FilterAction = "no-summarize"
+	// FilterActionSummarize stores the span everywhere and summarizes it even when the summarization policy would not
+	FilterActionSummarize FilterAction = "summarize"
+)
+
+type SpanFilterMatch struct {
+	Services       []string `yaml:"services"`
+	OperationRegex string   `yaml:"operation_regex"`
+	SpanKinds      []string `yaml:"span_kinds"`
+	// tag key to expected value, "*" matches any value as long as the tag exists
+	Tags map[string]string `yaml:"tags"`
+}
+
+type SpanFilterRule struct {
+	Name   string          `yaml:"name"`
+	Action FilterAction    `yaml:"action"`
+	Match  SpanFilterMatch `yaml:"match"`
+}
+
+type SpanFilterConfig struct {
+	DefaultAction FilterAction     `yaml:"default_action"`
+	Rules         []SpanFilterRule `yaml:"rules"`
+}
+
+type compiledSpanFilterRule struct {
+	SpanFilterRule
+	operationRegex *regexp.Regexp
+}
+
+type compiledSpanFilter struct {
+	defaultAction FilterAction
+	rules         []compiledSpanFilterRule
+}
+
+// SpanFilter decides how an incoming span is stored. Rules are evaluated in order and the first match wins.
+type SpanFilter struct {
+	path    string
+	modTime time.Time
+	current atomic.Pointer[compiledSpanFilter]
+}
+
+// DefaultSpanFilterConfig skips the traces jaeger produces about itself.
+func DefaultSpanFilterConfig() SpanFilterConfig {
+	return SpanFilterConfig{
+		DefaultAction: FilterActionStore,
+		Rules: []SpanFilterRule{
+			{
+				Name:   "jaeger-self-traces",
+				Action: FilterActionDrop,
+				Match:  SpanFilterMatch{Services: []string{"jaeger-all-in-one"}},
+			},
+		},
+	}
+}
+
+func NewSpanFilter(config SpanFilterConfig) (*SpanFilter, error) {
+	f := &SpanFilter{}
+	if err := f.set(config); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// LoadSpanFilter reads the rules from the YAML file in SPAN_FILTER_FILE,
+// falling back to the default rules when it is not set.
+func LoadSpanFilter() (*SpanFilter, error) {
+	path := os.Getenv("SPAN_FILTER_FILE")
+	if path == "" {
+		return NewSpanFilter(DefaultSpanFilterConfig())
+	}
+
+	f := &SpanFilter{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compileSpanFilter(config SpanFilterConfig) (*compiledSpanFilter, error) {
+	compiled := &compiledSpanFilter{defaultAction: config.DefaultAction}
+	if compiled.defaultAction == "" {
+		compiled.defaultAction = FilterActionStore
+	}
+	if err := validateFilterAction(compiled.defaultAction); err != nil {
+		return nil, err
+	}
+
+	for _, rule := range config.Rules {
+		if err := validateFilterAction(rule.Action); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		c := compiledSpanFilterRule{SpanFilterRule: rule}
+		if rule.Match.OperationRegex != "" {
+			re, err := regexp.Compile(rule.Match.OperationRegex)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: invalid operation_regex: %w", rule.Name, err)
+			}
+			c.operationRegex = re
+		}
+		compiled.rules = append(compiled.rules, c)
+	}
+
+	return compiled, nil
+}
+
+func validateFilterAction(action FilterAction) error {
+	switch action {
+	case FilterActionStore, FilterActionDrop, FilterActionPostgresOnly, FilterActionNoSummarize, FilterActionSummarize:
+		return nil
+	}
+	return fmt.Errorf("unknown filter action %q", action)
+}
+
+func (f *SpanFilter) set(config SpanFilterConfig) error {
+	compiled, err := compileSpanFilter(config)
+	if err != nil {
+		return err
+	}
+	f.current.Store(compiled)
+	return nil
+}
+
+func (f *SpanFilter) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.Println("[SpanFilter][reload][error] cannot stat filter file", f.path, err)
+		return err
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		log.Println("[SpanFilter][reload][error] cannot read filter file", f.path, err)
+		return err
+	}
+	config := SpanFilterConfig{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		log.Println("[SpanFilter][reload][error] cannot parse filter file", f.path, err)
+		return err
+	}
+	if err := f.set(config); err != nil {
+		log.Println("[SpanFilter][reload][error] invalid filter file", f.path, err)
+		return err
+	}
+	f.modTime = info.ModTime()
+	log.Printf("[SpanFilter][reload] loaded %d span filter rules from %s\n", len(config.Rules), f.path)
+	return nil
+}
+
+// Watch reloads the rules whenever the file changes. An invalid file keeps the previous rules.
+func (f *SpanFilter) Watch(ctx context.Context, interval time.Duration) {
+	if f.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(f.path)
+			if err != nil {
+				log.Println("[SpanFilter][Watch][error] cannot stat filter file", f.path, err)
+				continue
+			}
+			if info.ModTime().Equal(f.modTime) {
+				continue
+			}
+			_ = f.reload()
+		}
+	}
+}
+
+func (f *SpanFilter) Evaluate(span *model.Span) FilterAction {
+	compiled := f.current.Load()
+	for _, rule := range compiled.rules {
+		if rule.matches(span) {
+			return rule.Action
+		}
+	}
+	return compiled.defaultAction
+}
+
+func (r compiledSpanFilterRule) matches(span *model.Span) bool {
+	if len(r.Match.Services) > 0 && !slices.Contains(r.Match.Services, span.Process.GetServiceName()) {
+		return false
+	}
+	if r.operationRegex != nil && !r.operationRegex.MatchString(span.GetOperationName()) {
+		return false
+	}
+	if len(r.Match.SpanKinds) > 0 {
+		spanKind, _ := span.GetSpanKind()
+		if !slices.Contains(r.Match.SpanKinds, spanKind.String()) {
+			return false
+		}
+	}
+	for key, expected := range r.Match.Tags {
+		tag, ok := model.KeyValues(span.Tags).FindByKey(key)
+		if !ok {
+			return false
+		}
+		if expected != "*" && tag.AsString() != expected {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"gopkg.in/yaml.v3"
+	"jaeger-storage/common"
+	"log"
+	"math/rand"
+	"os"
+	"slices"
+)
+
+type SummarizationPolicyConfig struct {
+	// always summarize spans with an error status or error logs
+	AlwaysOnError bool `yaml:"always_on_error"`
+	// summarize spans slower than this percentile of the latency baseline of their operation, 0 disables it
+	LatencyPercentile float64 `yaml:"latency_percentile"`
+	// probability of summarizing any other span
+	SampleRate float64 `yaml:"sample_rate"`
+	// services that are always summarized
+	AllowServices []string `yaml:"allow_services"`
+	// services that are never summarized
+	DenyServices []string `yaml:"deny_services"`
+}
+
+type SummarizationDecision struct {
+	Summarize bool
+	Reason    string
+}
+
+// SummarizationPolicy decides per span whether it is worth an LLM summary.
+type SummarizationPolicy struct {
+	config SummarizationPolicyConfig
+	// the same baselines as the latency anomalies, so that both agree on what is slow
+	latencyBaselines *LatencyBaselines
+}
+
+// DefaultSummarizationPolicyConfig summarizes every span, which is the behaviour before policies existed.
+func DefaultSummarizationPolicyConfig() SummarizationPolicyConfig {
+	return SummarizationPolicyConfig{
+		AlwaysOnError:     true,
+		LatencyPercentile: 0,
+		SampleRate:        1,
+	}
+}
+
+func NewSummarizationPolicy(config SummarizationPolicyConfig, latencyBaselines *LatencyBaselines) *SummarizationPolicy {
+	return &SummarizationPolicy{
+		config:           config,
+		latencyBaselines: latencyBaselines,
+	}
+}
+
+// LoadSummarizationPolicy reads the policy from the YAML file in SUMMARIZATION_POLICY_FILE,
+// falling back to the default policy when it is not set.
+func LoadSummarizationPolicy(latencyBaselines *LatencyBaselines) (*SummarizationPolicy, error) {
+	config := DefaultSummarizationPolicyConfig()
+	path := os.Getenv("SUMMARIZATION_POLICY_FILE")
+	if path == "" {
+		return NewSummarizationPolicy(config, latencyBaselines), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Println("[LoadSummarizationPolicy][error] cannot read policy file", path, err)
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		log.Println("[LoadSummarizationPolicy][error] cannot parse policy file", path, err)
+		return nil, err
+	}
+	log.Printf("[LoadSummarizationPolicy] loaded summarization policy from %s: %+v\n", path, config)
+
+	return NewSummarizationPolicy(config, latencyBaselines), nil
+}
+
+// Evaluate decides whether the span is summarized. Imported spans are not compared with the latency baseline,
+// their durations come from another system.
+func (p *SummarizationPolicy) Evaluate(ctx context.Context, span *model.Span, imported bool) SummarizationDecision {
+	serviceName := span.Process.GetServiceName()
+	isOutlier := !imported && p.isLatencyOutlier(ctx, span)
+
+	if slices.Contains(p.config.DenyServices, serviceName) {
+		return SummarizationDecision{Summarize: false, Reason: "service-denied"}
+	}
+	if slices.Contains(p.config.AllowServices, serviceName) {
+		return SummarizationDecision{Summarize: true, Reason: "service-allowed"}
+	}
+	if p.config.AlwaysOnError && common.ClassifySpanErrors(span).Status == common.SpanStatusError {
+		return SummarizationDecision{Summarize: true, Reason: "error"}
+	}
+	if isOutlier {
+		return SummarizationDecision{Summarize: true, Reason: "latency-outlier"}
+	}
+	if p.config.SampleRate >= 1 || rand.Float64() < p.config.SampleRate {
+		return SummarizationDecision{Summarize: true, Reason: "sampled"}
+	}
+
+	return SummarizationDecision{Summarize: false, Reason: "not-sampled"}
+}
+
+// isLatencyOutlier reports whether the span is slower than the configured percentile of its operation.
+// The sql writer adds the span to the baseline concurrently, it may already be part of the percentile.
+func (p *SummarizationPolicy) isLatencyOutlier(ctx context.Context, span *model.Span) bool {
+	if p.config.LatencyPercentile <= 0 || p.latencyBaselines == nil {
+		return false
+	}
+	percentile, ok := p.latencyBaselines.Percentile(ctx, span, p.config.LatencyPercentile)
+	return ok && span.Duration > percentile
+}
+
+// templateSpanSummary is a cheap description for spans that are not summarized by the LLM,
+// it keeps them readable in the graph-rag passage.
+func templateSpanSummary(span *model.Span, internalLogs []common.InternalLog) string {
+	spanKind, _ := span.GetSpanKind()
+	status := common.ClassifySpanErrors(span).Status
+	action := common.ClassifySpan(span)
+	return fmt.Sprintf("The operation \"%s\" in service %s is a %s %s span that lasted %s with status %s and produced %d logs. Its span ID is %s.\n%s",
+		span.GetOperationName(), span.Process.GetServiceName(), spanKind.String(), action.Kind, span.Duration.String(), status, len(internalLogs), span.SpanID.String(), action.Describe())
+}
@@ -0,0 +1,218 @@
+package text2cypher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j/dbtype"
+	"log"
+	"strings"
+	"time"
+)
+
+// Generator writes a Cypher query answering the question, feedback explains why the previous attempt was rejected.
+type Generator interface {
+	GenerateCypher(ctx context.Context, schema string, question string, feedback string) (string, error)
+}
+
+type Options struct {
+	// maximum number of rows read from the result
+	MaxRows int
+	// the query is cancelled after this duration
+	Timeout time.Duration
+	// number of generations before giving up when the query is rejected
+	MaxAttempts int
+}
+
+// Engine turns a question into a validated, read-only, trace scoped Cypher query and runs it.
+type Engine struct {
+	driver    neo4j.DriverWithContext
+	generator Generator
+	options   Options
+}
+
+// Attempt is a generated query and why it was rejected, if it was.
+type Attempt struct {
+	Query string `json:"query"`
+	Error string `json:"error,omitempty"`
+}
+
+type Result struct {
+	Query    string           `json:"query"`
+	Columns  []string         `json:"columns"`
+	Rows     []map[string]any `json:"rows"`
+	Attempts []Attempt        `json:"attempts"`
+	// true when the result had more than MaxRows rows
+	Truncated bool `json:"truncated"`
+}
+
+func NewEngine(driver neo4j.DriverWithContext, generator Generator, options Options) *Engine {
+	if options.MaxRows <= 0 {
+		options.MaxRows = 100
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 3
+	}
+	return &Engine{driver: driver, generator: generator, options: options}
+}
+
+func (e *Engine) Run(ctx context.Context, traceId string, question string) (*Result, error) {
+	attempts := make([]Attempt, 0, e.options.MaxAttempts)
+	feedback := ""
+	for i := 0; i < e.options.MaxAttempts; i++ {
+		response, err := e.generator.GenerateCypher(ctx, Schema, question, feedback)
+		if err != nil {
+			return nil, err
+		}
+		query := ExtractQuery(response)
+
+		result, err := e.execute(ctx, traceId, query)
+		if err == nil {
+			result.Attempts = append(attempts, Attempt{Query: query})
+			return result, nil
+		}
+
+		var unsafe *ErrUnsafeQuery
+		var neo4jErr *neo4j.Neo4jError
+		if !errors.As(err, &unsafe) && !errors.As(err, &neo4jErr) {
+			// e.g. the database is unreachable, generating again will not help
+			return nil, err
+		}
+		log.Printf("[text2cypher][Run] attempt %d rejected: %s\n", i+1, err)
+		attempts = append(attempts, Attempt{Query: query, Error: err.Error()})
+		feedback = fmt.Sprintf("The previous query was rejected.\n<query>\n%s\n</query>\n<error>\n%s\n</error>", query, err)
+	}
+	return &Result{Attempts: attempts, Columns: []string{}, Rows: []map[string]any{}},
+		fmt.Errorf("no valid query after %d attempts", e.options.MaxAttempts)
+}
+
+func (e *Engine) execute(ctx context.Context, traceId string, query string) (*Result, error) {
+	if err := Validate(query); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.options.Timeout)
+	defer cancel()
+
+	session := e.driver.NewSession(ctx, neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: "neo4j",
+		FetchSize:    e.options.MaxRows + 1,
+	})
+	defer session.Close(ctx)
+	params := map[string]any{"trace_id": traceId}
+
+	// EXPLAIN plans the query without running it, the server tells whether it writes
+	_, err := session.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		res, err := tx.Run(ctx, "EXPLAIN "+query, params)
+		if err != nil {
+			return nil, err
+		}
+		summary, err := res.Consume(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if summary.StatementType() != neo4j.StatementTypeReadOnly {
+			return nil, &ErrUnsafeQuery{Reason: fmt.Sprintf("the query plan is not read-only (%s)", summary.StatementType())}
+		}
+		return nil, nil
+	}, neo4j.WithTxTimeout(e.options.Timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := session.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		res, err := tx.Run(ctx, query, params)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := res.Keys()
+		if err != nil {
+			return nil, err
+		}
+		result := &Result{Query: query, Columns: keys, Rows: make([]map[string]any, 0)}
+		for res.Next(ctx) {
+			if len(result.Rows) == e.options.MaxRows {
+				result.Truncated = true
+				break
+			}
+			row := make(map[string]any, len(keys))
+			for i, v := range res.Record().Values {
+				row[keys[i]] = plainValue(v)
+			}
+			result.Rows = append(result.Rows, row)
+		}
+		return result, res.Err()
+	}, neo4j.WithTxTimeout(e.options.Timeout))
+	if err != nil {
+		return nil, err
+	}
+	return result.(*Result), nil
+}
+
+// plainValue converts graph values to maps that can be marshalled to JSON, embeddings are dropped
+func plainValue(v any) any {
+	switch value := v.(type) {
+	case dbtype.Node:
+		properties := make(map[string]any, len(value.Props)+1)
+		for k, p := range value.Props {
+			if k != "embedding" {
+				properties[k] = plainValue(p)
+			}
+		}
+		properties["labels"] = value.Labels
+		return properties
+	case dbtype.Relationship:
+		properties := make(map[string]any, len(value.Props)+1)
+		for k, p := range value.Props {
+			properties[k] = plainValue(p)
+		}
+		properties["type"] = value.Type
+		return properties
+	case dbtype.Path:
+		nodes := make([]any, len(value.Nodes))
+		for i, n := range value.Nodes {
+			nodes[i] = plainValue(n)
+		}
+		return nodes
+	case []any:
+		values := make([]any, len(value))
+		for i, item := range value {
+			values[i] = plainValue(item)
+		}
+		return values
+	case map[string]any:
+		values := make(map[string]any, len(value))
+		for k, item := range value {
+			values[k] = plainValue(item)
+		}
+		return values
+	}
+	return v
+}
+
+// Passage renders the rows for the LLM composing the answer, one JSON object per row.
+func (r *Result) Passage() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Query:\n%s\n\nRows:\n", r.Query))
+	if len(r.Rows) == 0 {
+		sb.WriteString("no rows\n")
+	}
+	for _, row := range r.Rows {
+		b, err := json.Marshal(row)
+		if err != nil {
+			b = []byte(fmt.Sprintf("%v", row))
+		}
+		sb.Write(b)
+		sb.WriteString("\n")
+	}
+	if r.Truncated {
+		sb.WriteString(fmt.Sprintf("only the first %d rows are shown\n", len(r.Rows)))
+	}
+	return sb.String()
+}
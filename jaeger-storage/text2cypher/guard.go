@@ -0,0 +1,211 @@
+package text2cypher
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ErrUnsafeQuery is returned when a generated query does not pass the guardrails
+type ErrUnsafeQuery struct {
+	Reason string
+}
+
+func (e *ErrUnsafeQuery) Error() string {
+	return "unsafe query: " + e.Reason
+}
+
+var (
+	// strings and comments are matched by a single expression so that a quote in a comment, or // in a string, is not misread
+	literalOrCommentRegex = regexp.MustCompile(`(?s)'(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*"|//[^\n]*|/\*.*?\*/`)
+	writeClauseRegex      = regexp.MustCompile(`(?i)\b(CREATE|MERGE|DELETE|DETACH|SET|REMOVE|DROP|FOREACH|LOAD\s+CSV|IN\s+TRANSACTIONS|GRANT|DENY|REVOKE|ALTER|RENAME|SHOW|TERMINATE|START|STOP|USE)\b`)
+	callRegex             = regexp.MustCompile(`(?i)\bCALL\s*(\{|\(|[^\s({]+)`)
+	// a MATCH clause runs up to the next clause, OPTIONAL MATCH does not filter rows and cannot scope a query
+	matchClauseRegex = regexp.MustCompile(`(?is)(\bOPTIONAL\s+)?\bMATCH\b(.*?)(?:\b(?:OPTIONAL|MATCH|WHERE|WITH|RETURN|UNWIND|CALL|ORDER|SKIP|LIMIT|UNION)\b|$)`)
+	traceAnchorRegex = regexp.MustCompile(`(?i)\(\s*\w*\s*:\s*Trace\s*\{\s*trace_id\s*:\s*\$trace_id\s*\}\s*\)`)
+	// a path pattern is nodes joined by relationships, wherever it is: MATCH, EXISTS, pattern comprehensions
+	nodePattern = `\(\s*(?:[A-Za-z_]\w*)?\s*(?::\s*\w+\s*)*(?:\{[^{}]*\})?\s*\)`
+	pathRegex   = regexp.MustCompile(nodePattern + `(?:\s*<?-\s*(?:\[[^\[\]]*\])?\s*->?\s*` + nodePattern + `)*`)
+	nodeRegex   = regexp.MustCompile(`\(\s*([A-Za-z_]\w*)?`)
+	// WITH and UNION change the variables in scope, a WITH clause runs up to the next clause. STARTS WITH and ENDS WITH
+	// are matched to be skipped
+	scopeRegex       = regexp.MustCompile(`(?is)\b(?:STARTS|ENDS)\s+WITH\b|\bUNION(?:\s+ALL)?\b|\bWITH\b(.*?)(?:\b(?:OPTIONAL|MATCH|WHERE|WITH|RETURN|UNWIND|CALL|ORDER|SKIP|LIMIT|UNION)\b|$)`)
+	projectionRegex  = regexp.MustCompile(`(?is)^\s*(?:DISTINCT\s+)?(?:([A-Za-z_]\w*)|([A-Za-z_]\w*)\s+AS\s+([A-Za-z_]\w*))\s*$`)
+	cypherFenceRegex = regexp.MustCompile("(?s)```(?:cypher)?\\s*(.*?)```")
+)
+
+// ExtractQuery returns the query of an LLM response, with or without a markdown code fence.
+func ExtractQuery(response string) string {
+	if m := cypherFenceRegex.FindStringSubmatch(response); m != nil {
+		response = m[1]
+	}
+	return strings.TrimSuffix(strings.TrimSpace(response), ";")
+}
+
+// Validate statically checks that the query only reads, calls no procedure and is scoped to
+// the trace by a MATCH on the trace node with the $trace_id parameter, every other node being reached through a path from it. It is only the first line of defence, the query is also
+// EXPLAINed and run in a read transaction.
+func Validate(query string) error {
+	if strings.TrimSpace(query) == "" {
+		return &ErrUnsafeQuery{Reason: "the query is empty"}
+	}
+
+	// keywords inside strings and comments are harmless, e.g. a span summary containing "set"
+	stripped := literalOrCommentRegex.ReplaceAllString(query, "''")
+
+	if strings.Contains(stripped, ";") {
+		return &ErrUnsafeQuery{Reason: "only a single statement is allowed"}
+	}
+	if m := writeClauseRegex.FindString(stripped); m != "" {
+		return &ErrUnsafeQuery{Reason: fmt.Sprintf("%s is not allowed, the query must be read-only", strings.ToUpper(m))}
+	}
+	for _, m := range callRegex.FindAllStringSubmatch(stripped, -1) {
+		target := m[1]
+		if target == "{" || target == "(" {
+			// subqueries are checked as part of the whole query
+			continue
+		}
+		// even the index procedures read across traces
+		return &ErrUnsafeQuery{Reason: fmt.Sprintf("CALL %s is not allowed, the query must not call procedures", target)}
+	}
+	if !anchoredToTrace(stripped) {
+		return &ErrUnsafeQuery{Reason: "the query must be scoped to the trace by matching (t: Trace {trace_id: $trace_id})"}
+	}
+	if path := disconnectedPath(stripped); path != "" {
+		return &ErrUnsafeQuery{Reason: fmt.Sprintf("the pattern %s is not connected to the trace, every node must be reached through a path from (t: Trace {trace_id: $trace_id})", path)}
+	}
+	return nil
+}
+
+// anchoredToTrace reports whether a MATCH clause of the query has the trace node in its pattern. Using $trace_id
+// elsewhere, e.g. in WHERE $trace_id IS NOT NULL, does not restrict the spans read to the trace.
+func anchoredToTrace(query string) bool {
+	for start := 0; start < len(query); {
+		m := matchClauseRegex.FindStringSubmatchIndex(query[start:])
+		if m == nil {
+			return false
+		}
+		optional := m[2] >= 0
+		if !optional && traceAnchorRegex.MatchString(query[start+m[4]:start+m[5]]) {
+			return true
+		}
+		// the clause keyword that ended the pattern starts the next match
+		start += m[5]
+	}
+	return false
+}
+
+// disconnectedPath returns the first path pattern that shares no variable with a path from the trace node, e.g.
+// MATCH (t:Trace {trace_id: $trace_id}) MATCH (s:Span) reads the spans of every trace. Variables are followed through
+// WITH, which only keeps the variables it projects as is or renames, and are forgotten at UNION. Subqueries are
+// not scoped separately, which only rejects more queries.
+func disconnectedPath(query string) string {
+	connected := make(map[string]bool)
+	start := 0
+	for _, m := range scopeRegex.FindAllStringSubmatchIndex(query, -1) {
+		if m[2] < 0 && !strings.HasPrefix(strings.ToUpper(query[m[0]:m[1]]), "UNION") {
+			// a string predicate
+			continue
+		}
+		if path := disconnectedPathIn(query[start:m[0]], connected); path != "" {
+			return path
+		}
+		projected := make(map[string]bool)
+		if m[2] >= 0 {
+			for _, item := range splitTopLevel(query[m[2]:m[3]]) {
+				if strings.TrimSpace(item) == "*" {
+					projected = connected
+					break
+				}
+				// any other expression is not a node of the trace
+				p := projectionRegex.FindStringSubmatch(item)
+				switch {
+				case p == nil:
+				case p[1] != "":
+					projected[p[1]] = connected[p[1]]
+				case p[2] != "":
+					projected[p[3]] = connected[p[2]]
+				}
+			}
+			// the clause keyword that ended the projection is part of the next scope
+			start = m[3]
+		} else {
+			start = m[1]
+		}
+		connected = projected
+	}
+	return disconnectedPathIn(query[start:], connected)
+}
+
+// disconnectedPathIn connects the paths of one scope to the trace node and to the connected variables, it adds
+// the variables of the connected paths to connected
+func disconnectedPathIn(scope string, connected map[string]bool) string {
+	type path struct {
+		text      string
+		variables []string
+		connected bool
+	}
+	paths := make([]*path, 0)
+	for _, m := range pathRegex.FindAllStringIndex(scope, -1) {
+		// the arguments of a function call, e.g. count(s), are not a pattern
+		if m[0] > 0 && isWordByte(scope[m[0]-1]) {
+			continue
+		}
+		p := &path{text: scope[m[0]:m[1]], connected: traceAnchorRegex.MatchString(scope[m[0]:m[1]])}
+		for _, n := range nodeRegex.FindAllStringSubmatch(p.text, -1) {
+			if n[1] != "" {
+				p.variables = append(p.variables, n[1])
+			}
+		}
+		paths = append(paths, p)
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, p := range paths {
+			if !p.connected && slices.ContainsFunc(p.variables, func(v string) bool { return connected[v] }) {
+				p.connected = true
+			}
+			if !p.connected {
+				continue
+			}
+			for _, v := range p.variables {
+				if !connected[v] {
+					connected[v] = true
+					changed = true
+				}
+			}
+		}
+	}
+	for _, p := range paths {
+		if !p.connected {
+			return p.text
+		}
+	}
+	return ""
+}
+
+// splitTopLevel splits the items of a projection on the commas outside of brackets
+func splitTopLevel(s string) []string {
+	items := make([]string, 0)
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, s[start:])
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
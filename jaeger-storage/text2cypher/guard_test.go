@@ -0,0 +1,129 @@
+package text2cypher
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		safe  bool
+	}{
+		{
+			name:  "anchored on the trace",
+			query: "MATCH (t: Trace {trace_id: $trace_id})-[:CONTAINS]->(s: Span) RETURN s.operation_name",
+			safe:  true,
+		},
+		{
+			name:  "anchor at the end of the pattern",
+			query: "MATCH (s:Span)<-[:CONTAINS]-(:Trace {trace_id: $trace_id}) WHERE s.span_status = 'ERROR' RETURN count(s)",
+			safe:  true,
+		},
+		{
+			name:  "anchor in a later MATCH",
+			query: "MATCH (s:Span {span_status: 'ERROR'})\nMATCH (:Trace {trace_id: $trace_id})-[:CONTAINS]->(s) RETURN s.span_id",
+			safe:  true,
+		},
+		{
+			name:  "paths connected through variables",
+			query: "MATCH (t:Trace {trace_id: $trace_id})-[:CONTAINS]->(s:Span), (s)-[:INVOKES_CHILD]->(c:Span)\nMATCH (c)-[:PRODUCES]->(l:Log) RETURN s, c, count(l)",
+			safe:  true,
+		},
+		{
+			name:  "variables kept by WITH",
+			query: "MATCH (t:Trace {trace_id: $trace_id})-[:CONTAINS]->(s:Span) WHERE s.operation_name STARTS WITH 'SQL' WITH DISTINCT s, s.duration AS d ORDER BY d DESC LIMIT 3 WITH s AS slow MATCH (slow)-[:PRODUCES]->(l:Log) RETURN slow.span_id, l.message",
+			safe:  true,
+		},
+		{
+			name:  "pattern comprehension and EXISTS from a bound span",
+			query: "MATCH (t:Trace {trace_id: $trace_id})-[:CONTAINS]->(s:Span) WHERE NOT EXISTS { MATCH (s)-[:INVOKES_CHILD]->(:Span) } RETURN s.span_id, [(service:Service)-[:CONTAINS]->(s) | service.name][0] AS service, count(s)",
+			safe:  true,
+		},
+		{
+			name:  "spans of every trace in a second MATCH",
+			query: "MATCH (t:Trace {trace_id:$trace_id}) MATCH (s:Span) RETURN s",
+		},
+		{
+			name:  "comma separated disconnected pattern",
+			query: "MATCH (t:Trace {trace_id: $trace_id})-[:CONTAINS]->(s:Span), (other:Span)-[:PRODUCES]->(l:Log) RETURN other, l",
+		},
+		{
+			name:  "anchor dropped by WITH",
+			query: "MATCH (t:Trace {trace_id: $trace_id}) WITH count(t) AS c MATCH (t:Span) RETURN t",
+		},
+		{
+			name:  "anchor renamed from an expression",
+			query: "MATCH (t:Trace {trace_id: $trace_id}) WITH t.trace_id AS s MATCH (s) RETURN s",
+		},
+		{
+			name:  "disconnected pattern comprehension",
+			query: "MATCH (t:Trace {trace_id: $trace_id}) RETURN [(s:Span) | s.summary] AS summaries",
+		},
+		{
+			name:  "disconnected EXISTS",
+			query: "MATCH (t:Trace {trace_id: $trace_id}) WHERE EXISTS { MATCH (s:Span {span_status: 'ERROR'}) } RETURN t",
+		},
+		{
+			name:  "disconnected OPTIONAL MATCH",
+			query: "MATCH (t:Trace {trace_id: $trace_id}) OPTIONAL MATCH (l:Log) RETURN l",
+		},
+		{
+			name:  "UNION with another trace",
+			query: "MATCH (t:Trace {trace_id: $trace_id})-[:CONTAINS]->(s:Span) RETURN s.summary AS summary UNION MATCH (s:Span) RETURN s.summary AS summary",
+		},
+		{
+			name:  "fulltext index",
+			query: "CALL db.index.fulltext.queryNodes('span_summary_fulltext', 'redis') YIELD node\nMATCH (:Trace {trace_id: $trace_id})-[:CONTAINS]->(node) RETURN node.span_id",
+		},
+		{
+			name:  "vector index",
+			query: "MATCH (t:Trace {trace_id: $trace_id})-[:CONTAINS]->(s:Span) CALL db.index.vector.queryNodes('span_embedding', 10, s.embedding) YIELD node RETURN node.summary",
+		},
+		{
+			name:  "parameter in WHERE only",
+			query: "MATCH (s:Span) WHERE $trace_id IS NOT NULL RETURN s",
+		},
+		{
+			name:  "parameter compared to another property",
+			query: "MATCH (s:Span) WHERE s.span_id <> $trace_id RETURN s",
+		},
+		{
+			name:  "anchor in OPTIONAL MATCH",
+			query: "MATCH (s:Span) OPTIONAL MATCH (t:Trace {trace_id: $trace_id}) RETURN s",
+		},
+		{
+			name:  "anchor in a string",
+			query: "MATCH (s:Span) WHERE s.summary = '(:Trace {trace_id: $trace_id})' RETURN s",
+		},
+		{
+			name:  "anchor in a comment",
+			query: "MATCH (s:Span) // (:Trace {trace_id: $trace_id})\nRETURN s",
+		},
+		{
+			name:  "write clause",
+			query: "MATCH (t: Trace {trace_id: $trace_id})-[:CONTAINS]->(s: Span) SET s.summary = '' RETURN s",
+		},
+		{
+			name:  "admin procedure",
+			query: "MATCH (t: Trace {trace_id: $trace_id}) CALL dbms.listConfig() YIELD name RETURN name",
+		},
+		{
+			name:  "several statements",
+			query: "MATCH (t: Trace {trace_id: $trace_id}) RETURN t; MATCH (s) RETURN s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.query)
+			if tt.safe && err != nil {
+				t.Errorf("Validate() = %v, want the query to pass", err)
+			}
+			var unsafe *ErrUnsafeQuery
+			if !tt.safe && !errors.As(err, &unsafe) {
+				t.Errorf("Validate() = %v, want ErrUnsafeQuery", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package text2cypher
+
+// Schema is the published schema of the graph written by storage.Neo4jWriter, it is given to the LLM
+// to generate Cypher from. Keep it in sync with the writer.
+const Schema = `
+Node labels and properties:
+(:Trace {trace_id: STRING})
+(:Span {span_id: STRING, operation_name: STRING, duration: INTEGER nanoseconds, start_time: DATE_TIME,
+	span_kind: STRING one of server, client, producer, consumer, internal, unspecified,
+	action_kind: STRING one of http, db, rpc, messaging, faas, internal,
+	span_status: STRING one of OK, WARNING, ERROR, error_type: STRING, error_message: STRING, warnings: LIST<STRING>,
+	summary: STRING, span_summary: STRING, log_summary: STRING, tag_summary: STRING,
+	span_summary_prompt: STRING, log_summary_prompt: STRING the prompt templates and versions the summaries were written with, e.g. summarize_span@v1,
+	latency_anomaly: BOOLEAN true when the span was much slower than usual for its operation, latency_ratio: FLOAT duration divided by the usual median,
+	latency_p50: INTEGER nanoseconds, latency_p95: INTEGER nanoseconds, latency_p99: INTEGER nanoseconds, latency_ewma: INTEGER nanoseconds, only set on anomalies,
+	http_method: STRING, http_route: STRING, http_target: STRING, http_url: STRING, http_status_code: INTEGER,
+	db_system: STRING, db_name: STRING, db_operation: STRING, db_statement: STRING, db_table: STRING,
+	rpc_system: STRING, rpc_service: STRING, rpc_method: STRING, rpc_status_code: INTEGER,
+	messaging_system: STRING, messaging_operation: STRING, messaging_destination: STRING,
+	every span tag is also stored as tag_<key> with dots replaced by underscores, e.g. tag_http_status_code})
+(:Log {value: STRING, timestamp: DATE_TIME, template_id: STRING, variables: LIST<STRING> the values of the wildcards of its template,
+	every log field is also stored as field_<key>, e.g. field_event, field_level})
+(:LogTemplate {template_id: STRING, template: STRING the fields of the log with the variable parts replaced by <*>,
+	count: INTEGER number of logs across all traces, first_seen: DATE_TIME, last_seen: DATE_TIME})
+(:Service {name: STRING})
+(:Operation {service_name: STRING, name: STRING})
+(:Process {process_key: STRING, service_name: STRING, hostname: STRING, ip: STRING, pid: STRING,
+	sdk_language: STRING, sdk_name: STRING, sdk_version: STRING, container_id: STRING})
+(:Host {name: STRING, ips: LIST<STRING>})
+(:Pod {name: STRING, namespace: STRING})
+(:Error {error_id: STRING, type: STRING, raw_type: STRING, message: STRING, stacktrace: STRING,
+	source: STRING, timestamp: DATE_TIME, trace_id: STRING})
+(:ErrorCluster {cluster_id: STRING, service_name: STRING, error_type: STRING, template: STRING the error message with IDs and numbers masked,
+	example_message: STRING, example_span_id: STRING, example_trace_id: STRING, first_seen: DATE_TIME, last_seen: DATE_TIME, count: INTEGER})
+(:Incident {incident_id: STRING, title: STRING, description: STRING, services: LIST<STRING>, first_seen: DATE_TIME, last_seen: DATE_TIME,
+	count: INTEGER, cluster_count: INTEGER})
+
+Relationships:
+(:Trace)-[:CONTAINS]->(:Span) the spans of a trace
+(:Service)-[:CONTAINS]->(:Span) the spans produced by a service
+(:Span)-[:INVOKES_CHILD]->(:Span) the parent span calls the child span
+(:Span)-[:INVOKES_FOLLOWS]->(:Span) the second span started after the first one finished
+(:Span)-[:PRODUCES]->(:Log) the log events of a span
+(:Log)-[:INSTANCE_OF]->(:LogTemplate) logs with the same message and different values share a template
+(:Span)-[:INSTANCE_OF]->(:Operation)
+(:Service)-[:EXPOSES]->(:Operation)
+(:Operation)-[:CALLS {call_count, error_count, total_duration, max_duration}]->(:Operation) aggregated over all traces
+(:Service)-[:CALLS {call_count, error_count, total_duration, max_duration}]->(:Service) aggregated over all traces
+(:Service)-[:RUNS_AS]->(:Process)
+(:Span)-[:RAN_ON]->(:Process)
+(:Process)-[:RAN_ON]->(:Host)
+(:Process)-[:RAN_ON]->(:Pod)
+(:Pod)-[:RAN_ON]->(:Host)
+(:Span)-[:RAISED]->(:Error)
+(:Service)-[:RAISED]->(:Error)
+(:Span)-[:MEMBER_OF]->(:ErrorCluster) the span raised an error of the cluster
+(:Error)-[:MEMBER_OF]->(:ErrorCluster)
+(:Service)-[:RAISED]->(:ErrorCluster)
+(:ErrorCluster)-[:PART_OF]->(:Incident) clusters of errors happening in the same traces
+`
@@ -0,0 +1,243 @@
+package traceio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	uiconv "github.com/jaegertracing/jaeger/model/converter/json"
+	ui "github.com/jaegertracing/jaeger/model/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ReadJaegerJSON reads the traces of the Jaeger UI JSON format, i.e. the file downloaded from the trace page of the
+// Jaeger UI or the response of its API, {"data": [trace, ...]}. A single trace object is read as well.
+func ReadJaegerJSON(r io.Reader) ([]*model.Span, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return readJaegerJSON(data)
+}
+
+func readJaegerJSON(data []byte) ([]*model.Span, error) {
+	var envelope struct {
+		Data []ui.Trace `json:"data"`
+	}
+	if err := decode(data, &envelope); err != nil {
+		return nil, err
+	}
+	traces := envelope.Data
+	if len(traces) == 0 {
+		var trace ui.Trace
+		if err := decode(data, &trace); err != nil {
+			return nil, err
+		}
+		traces = []ui.Trace{trace}
+	}
+
+	spans := make([]*model.Span, 0)
+	for _, trace := range traces {
+		for _, s := range trace.Spans {
+			span, err := fromUISpan(s, trace.Processes)
+			if err != nil {
+				return nil, fmt.Errorf("span %s: %w", s.SpanID, err)
+			}
+			spans = append(spans, span)
+		}
+	}
+	if len(spans) == 0 {
+		return nil, fmt.Errorf("no span found, expected the Jaeger UI JSON format")
+	}
+	spans = uniqueSpans(spans)
+	sortByStartTime(spans)
+	return spans, nil
+}
+
+// WriteJaegerJSON writes the spans of a trace in the Jaeger UI JSON format, the inverse of ReadJaegerJSON. The spans
+// are written as stored, without the clock skew adjustment of the Jaeger UI.
+func WriteJaegerJSON(w io.Writer, spans []*model.Span) error {
+	return json.NewEncoder(w).Encode(struct {
+		Data []*ui.Trace `json:"data"`
+	}{
+		Data: []*ui.Trace{uiconv.FromDomain(&model.Trace{Spans: spans})},
+	})
+}
+
+// decode keeps the numbers as json.Number so that int64 tags do not lose precision
+func decode(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// uniqueSpans drops the repeated spans, exports of the Jaeger UI can contain a span twice
+func uniqueSpans(spans []*model.Span) []*model.Span {
+	type key struct {
+		traceId model.TraceID
+		spanId  model.SpanID
+	}
+	seen := make(map[key]bool, len(spans))
+	unique := make([]*model.Span, 0, len(spans))
+	for _, span := range spans {
+		k := key{span.TraceID, span.SpanID}
+		if !seen[k] {
+			seen[k] = true
+			unique = append(unique, span)
+		}
+	}
+	return unique
+}
+
+// sortByStartTime writes the parents before their children in most traces, which saves the writer from waiting for them
+func sortByStartTime(spans []*model.Span) {
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].StartTime.Before(spans[j].StartTime)
+	})
+}
+
+func fromUISpan(s ui.Span, processes map[ui.ProcessID]ui.Process) (*model.Span, error) {
+	traceId, err := model.TraceIDFromString(string(s.TraceID))
+	if err != nil {
+		return nil, err
+	}
+	spanId, err := model.SpanIDFromString(string(s.SpanID))
+	if err != nil {
+		return nil, err
+	}
+
+	process := s.Process
+	if process == nil {
+		p, ok := processes[s.ProcessID]
+		if !ok {
+			return nil, fmt.Errorf("unknown process %q", s.ProcessID)
+		}
+		process = &p
+	}
+	processTags, err := fromUITags(process.Tags)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := fromUITags(s.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	references := make([]model.SpanRef, 0, len(s.References))
+	for _, ref := range s.References {
+		refTraceId, err := model.TraceIDFromString(string(ref.TraceID))
+		if err != nil {
+			return nil, err
+		}
+		refSpanId, err := model.SpanIDFromString(string(ref.SpanID))
+		if err != nil {
+			return nil, err
+		}
+		refType := model.ChildOf
+		if ref.RefType == ui.FollowsFrom {
+			refType = model.FollowsFrom
+		}
+		references = append(references, model.SpanRef{TraceID: refTraceId, SpanID: refSpanId, RefType: refType})
+	}
+	// the deprecated parent span ID of older exports
+	if len(references) == 0 && s.ParentSpanID != "" {
+		parentId, err := model.SpanIDFromString(string(s.ParentSpanID))
+		if err != nil {
+			return nil, err
+		}
+		references = append(references, model.NewChildOfRef(traceId, parentId))
+	}
+
+	logs := make([]model.Log, 0, len(s.Logs))
+	for _, l := range s.Logs {
+		fields, err := fromUITags(l.Fields)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, model.Log{Timestamp: fromMicros(l.Timestamp), Fields: fields})
+	}
+
+	return &model.Span{
+		TraceID:       traceId,
+		SpanID:        spanId,
+		OperationName: s.OperationName,
+		References:    references,
+		Flags:         model.Flags(s.Flags),
+		StartTime:     fromMicros(s.StartTime),
+		Duration:      time.Duration(s.Duration) * time.Microsecond,
+		Tags:          tags,
+		Logs:          logs,
+		Process:       model.NewProcess(process.ServiceName, processTags),
+		Warnings:      s.Warnings,
+	}, nil
+}
+
+func fromMicros(micros uint64) time.Time {
+	return time.UnixMicro(int64(micros)).UTC()
+}
+
+func fromUITags(kvs []ui.KeyValue) ([]model.KeyValue, error) {
+	tags := make([]model.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		tag, err := fromUITag(kv)
+		if err != nil {
+			return nil, fmt.Errorf("tag %q: %w", kv.Key, err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func fromUITag(kv ui.KeyValue) (model.KeyValue, error) {
+	switch kv.Type {
+	case ui.BoolType:
+		switch v := kv.Value.(type) {
+		case bool:
+			return model.Bool(kv.Key, v), nil
+		case string:
+			b, err := strconv.ParseBool(v)
+			return model.Bool(kv.Key, b), err
+		}
+	case ui.Int64Type:
+		switch v := kv.Value.(type) {
+		case json.Number:
+			i, err := v.Int64()
+			return model.Int64(kv.Key, i), err
+		case string:
+			i, err := strconv.ParseInt(v, 10, 64)
+			return model.Int64(kv.Key, i), err
+		}
+	case ui.Float64Type:
+		switch v := kv.Value.(type) {
+		case json.Number:
+			f, err := v.Float64()
+			return model.Float64(kv.Key, f), err
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			return model.Float64(kv.Key, f), err
+		}
+	case ui.BinaryType:
+		if v, ok := kv.Value.(string); ok {
+			b, err := base64.StdEncoding.DecodeString(v)
+			return model.Binary(kv.Key, b), err
+		}
+	case ui.StringType, "":
+		switch v := kv.Value.(type) {
+		case string:
+			return model.String(kv.Key, v), nil
+		case json.Number:
+			return model.String(kv.Key, v.String()), nil
+		case bool:
+			return model.String(kv.Key, strconv.FormatBool(v)), nil
+		case nil:
+			return model.String(kv.Key, ""), nil
+		}
+	default:
+		return model.KeyValue{}, fmt.Errorf("unknown type %q", kv.Type)
+	}
+	return model.KeyValue{}, fmt.Errorf("unexpected value %v for type %q", kv.Value, kv.Type)
+}
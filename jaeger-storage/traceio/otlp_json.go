@@ -0,0 +1,473 @@
+package traceio
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the OTLP JSON encoding of an ExportTraceServiceRequest, as written by the file exporter of the OpenTelemetry
+// collector or sent to /v1/traces. IDs are hex, 64 bit integers are strings and enums are numbers or their names.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	// name of scopeSpans before OTLP 0.15, still written by older SDKs
+	InstrumentationLibrarySpans []otlpScopeSpans `json:"instrumentationLibrarySpans,omitempty"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope                  otlpScope  `json:"scope"`
+	InstrumentationLibrary *otlpScope `json:"instrumentationLibrary,omitempty"`
+	Spans                  []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              otlpEnum       `json:"kind"`
+	StartTimeUnixNano otlpInt64      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   otlpInt64      `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Links             []otlpLink     `json:"links,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano otlpInt64      `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceId    string         `json:"traceId"`
+	SpanId     string         `json:"spanId"`
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    otlpEnum `json:"code"`
+	Message string   `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string    `json:"stringValue,omitempty"`
+	BoolValue   *bool      `json:"boolValue,omitempty"`
+	IntValue    *otlpInt64 `json:"intValue,omitempty"`
+	DoubleValue *float64   `json:"doubleValue,omitempty"`
+	BytesValue  *string    `json:"bytesValue,omitempty"`
+	ArrayValue  *struct {
+		Values []otlpAnyValue `json:"values"`
+	} `json:"arrayValue,omitempty"`
+	KvlistValue *struct {
+		Values []otlpKeyValue `json:"values"`
+	} `json:"kvlistValue,omitempty"`
+}
+
+// otlpInt64 is a 64 bit integer of the protobuf JSON mapping, written as a string and read from a string or a number
+type otlpInt64 int64
+
+func (i *otlpInt64) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	*i = otlpInt64(v)
+	return err
+}
+
+func (i otlpInt64) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatInt(int64(i), 10) + `"`), nil
+}
+
+// otlpEnum is an enum of the protobuf JSON mapping, which accepts the number as well as the name of the value
+type otlpEnum struct {
+	Number int
+	Name   string
+}
+
+func (e *otlpEnum) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &e.Name)
+	}
+	return json.Unmarshal(data, &e.Number)
+}
+
+func (e otlpEnum) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Number)
+}
+
+// is tells whether the enum is the value of the number or of the name, e.g. 2 or STATUS_CODE_ERROR
+func (e otlpEnum) is(number int, name string) bool {
+	if e.Name != "" {
+		return e.Name == name
+	}
+	return e.Number == number
+}
+
+var otlpSpanKinds = []struct {
+	number int
+	name   string
+	kind   string
+}{
+	{1, "SPAN_KIND_INTERNAL", "internal"},
+	{2, "SPAN_KIND_SERVER", "server"},
+	{3, "SPAN_KIND_CLIENT", "client"},
+	{4, "SPAN_KIND_PRODUCER", "producer"},
+	{5, "SPAN_KIND_CONSUMER", "consumer"},
+}
+
+// ReadOTLPJSON reads the spans of the OTLP JSON format. Resource attributes become process tags and service.name the
+// service, while the span kind, status, scope, events and links are mapped the way the Jaeger OTLP receiver maps them.
+func ReadOTLPJSON(r io.Reader) ([]*model.Span, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return readOTLPJSON(data)
+}
+
+func readOTLPJSON(data []byte) ([]*model.Span, error) {
+	var traces otlpTraces
+	if err := decode(data, &traces); err != nil {
+		return nil, err
+	}
+
+	spans := make([]*model.Span, 0)
+	for _, rs := range traces.ResourceSpans {
+		process := fromOTLPResource(rs.Resource)
+		for _, ss := range append(rs.ScopeSpans, rs.InstrumentationLibrarySpans...) {
+			scope := ss.Scope
+			if scope.Name == "" && ss.InstrumentationLibrary != nil {
+				scope = *ss.InstrumentationLibrary
+			}
+			for _, s := range ss.Spans {
+				span, err := fromOTLPSpan(s, scope, process)
+				if err != nil {
+					return nil, fmt.Errorf("span %s: %w", s.SpanId, err)
+				}
+				spans = append(spans, span)
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return nil, fmt.Errorf("no span found, expected the OTLP JSON format")
+	}
+	spans = uniqueSpans(spans)
+	sortByStartTime(spans)
+	return spans, nil
+}
+
+func fromOTLPResource(resource otlpResource) *model.Process {
+	serviceName := "unknown_service"
+	tags := make([]model.KeyValue, 0, len(resource.Attributes))
+	for _, kv := range resource.Attributes {
+		if kv.Key == "service.name" && kv.Value.StringValue != nil {
+			serviceName = *kv.Value.StringValue
+			continue
+		}
+		tags = append(tags, fromOTLPAttribute(kv))
+	}
+	return model.NewProcess(serviceName, tags)
+}
+
+func fromOTLPSpan(s otlpSpan, scope otlpScope, process *model.Process) (*model.Span, error) {
+	traceId, err := model.TraceIDFromString(s.TraceId)
+	if err != nil {
+		return nil, err
+	}
+	spanId, err := model.SpanIDFromString(s.SpanId)
+	if err != nil {
+		return nil, err
+	}
+	start, end := fromUnixNano(s.StartTimeUnixNano), fromUnixNano(s.EndTimeUnixNano)
+
+	references := make([]model.SpanRef, 0, len(s.Links)+1)
+	if s.ParentSpanId != "" {
+		parentId, err := model.SpanIDFromString(s.ParentSpanId)
+		if err != nil {
+			return nil, err
+		}
+		references = append(references, model.NewChildOfRef(traceId, parentId))
+	}
+	for _, link := range s.Links {
+		linkTraceId, err := model.TraceIDFromString(link.TraceId)
+		if err != nil {
+			return nil, err
+		}
+		linkSpanId, err := model.SpanIDFromString(link.SpanId)
+		if err != nil {
+			return nil, err
+		}
+		references = append(references, model.NewFollowsFromRef(linkTraceId, linkSpanId))
+	}
+
+	tags := make([]model.KeyValue, 0, len(s.Attributes)+6)
+	for _, kv := range s.Attributes {
+		tags = append(tags, fromOTLPAttribute(kv))
+	}
+	for _, k := range otlpSpanKinds {
+		if s.Kind.is(k.number, k.name) {
+			tags = append(tags, model.String("span.kind", k.kind))
+		}
+	}
+	switch {
+	case s.Status.Code.is(1, "STATUS_CODE_OK"):
+		tags = append(tags, model.String("otel.status_code", "OK"))
+	case s.Status.Code.is(2, "STATUS_CODE_ERROR"):
+		tags = append(tags, model.String("otel.status_code", "ERROR"), model.Bool("error", true))
+	}
+	if s.Status.Message != "" {
+		tags = append(tags, model.String("otel.status_description", s.Status.Message))
+	}
+	if scope.Name != "" {
+		tags = append(tags, model.String("otel.scope.name", scope.Name))
+	}
+	if scope.Version != "" {
+		tags = append(tags, model.String("otel.scope.version", scope.Version))
+	}
+	if s.TraceState != "" {
+		tags = append(tags, model.String("w3c.tracestate", s.TraceState))
+	}
+
+	logs := make([]model.Log, 0, len(s.Events))
+	for _, event := range s.Events {
+		fields := make([]model.KeyValue, 0, len(event.Attributes)+1)
+		if event.Name != "" {
+			fields = append(fields, model.String("event", event.Name))
+		}
+		for _, kv := range event.Attributes {
+			fields = append(fields, fromOTLPAttribute(kv))
+		}
+		logs = append(logs, model.Log{Timestamp: fromUnixNano(event.TimeUnixNano), Fields: fields})
+	}
+
+	return &model.Span{
+		TraceID:       traceId,
+		SpanID:        spanId,
+		OperationName: s.Name,
+		References:    references,
+		StartTime:     start,
+		Duration:      end.Sub(start),
+		Tags:          tags,
+		Logs:          logs,
+		Process:       process,
+	}, nil
+}
+
+func fromUnixNano(n otlpInt64) time.Time {
+	return time.Unix(0, int64(n)).UTC()
+}
+
+// fromOTLPAttribute keeps scalars typed, arrays and maps have no Jaeger type and are stored as their JSON
+func fromOTLPAttribute(kv otlpKeyValue) model.KeyValue {
+	v := kv.Value
+	switch {
+	case v.StringValue != nil:
+		return model.String(kv.Key, *v.StringValue)
+	case v.BoolValue != nil:
+		return model.Bool(kv.Key, *v.BoolValue)
+	case v.IntValue != nil:
+		return model.Int64(kv.Key, int64(*v.IntValue))
+	case v.DoubleValue != nil:
+		return model.Float64(kv.Key, *v.DoubleValue)
+	case v.BytesValue != nil:
+		if b, err := base64.StdEncoding.DecodeString(*v.BytesValue); err == nil {
+			return model.Binary(kv.Key, b)
+		}
+	case v.ArrayValue != nil || v.KvlistValue != nil:
+		if data, err := json.Marshal(anyValue(v)); err == nil {
+			return model.String(kv.Key, string(data))
+		}
+	}
+	return model.String(kv.Key, "")
+}
+
+func anyValue(v otlpAnyValue) any {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return int64(*v.IntValue)
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.BytesValue != nil:
+		return *v.BytesValue
+	case v.ArrayValue != nil:
+		values := make([]any, 0, len(v.ArrayValue.Values))
+		for _, value := range v.ArrayValue.Values {
+			values = append(values, anyValue(value))
+		}
+		return values
+	case v.KvlistValue != nil:
+		values := make(map[string]any, len(v.KvlistValue.Values))
+		for _, kv := range v.KvlistValue.Values {
+			values[kv.Key] = anyValue(kv.Value)
+		}
+		return values
+	}
+	return nil
+}
+
+// WriteOTLPJSON writes the spans in the OTLP JSON format, the inverse of ReadOTLPJSON
+func WriteOTLPJSON(w io.Writer, spans []*model.Span) error {
+	return json.NewEncoder(w).Encode(toOTLP(spans))
+}
+
+// toOTLP groups the spans by process into resources and by otel.scope.name into scopes
+func toOTLP(spans []*model.Span) otlpTraces {
+	traces := otlpTraces{ResourceSpans: make([]otlpResourceSpans, 0)}
+	resources := make(map[uint64]int)
+	for _, span := range spans {
+		hash, _ := model.HashCode(span.Process)
+		i, ok := resources[hash]
+		if !ok {
+			i = len(traces.ResourceSpans)
+			resources[hash] = i
+			traces.ResourceSpans = append(traces.ResourceSpans, otlpResourceSpans{
+				Resource:   toOTLPResource(span.Process),
+				ScopeSpans: make([]otlpScopeSpans, 0),
+			})
+		}
+
+		s, scope := toOTLPSpan(span)
+		rs := &traces.ResourceSpans[i]
+		j := slices.IndexFunc(rs.ScopeSpans, func(ss otlpScopeSpans) bool { return ss.Scope == scope })
+		if j < 0 {
+			j = len(rs.ScopeSpans)
+			rs.ScopeSpans = append(rs.ScopeSpans, otlpScopeSpans{Scope: scope, Spans: make([]otlpSpan, 0)})
+		}
+		rs.ScopeSpans[j].Spans = append(rs.ScopeSpans[j].Spans, s)
+	}
+	return traces
+}
+
+func toOTLPResource(process *model.Process) otlpResource {
+	attributes := make([]otlpKeyValue, 0, len(process.GetTags())+1)
+	attributes = append(attributes, toOTLPAttribute(model.String("service.name", process.GetServiceName())))
+	for _, tag := range process.GetTags() {
+		attributes = append(attributes, toOTLPAttribute(tag))
+	}
+	return otlpResource{Attributes: attributes}
+}
+
+// toOTLPSpan maps back the tags ReadOTLPJSON and the Jaeger OTLP receiver derive from the span kind, status, scope
+// and trace state
+func toOTLPSpan(span *model.Span) (otlpSpan, otlpScope) {
+	s := otlpSpan{
+		TraceId:           otlpTraceId(span.TraceID),
+		SpanId:            span.SpanID.String(),
+		Name:              span.OperationName,
+		StartTimeUnixNano: otlpInt64(span.StartTime.UnixNano()),
+		EndTimeUnixNano:   otlpInt64(span.StartTime.Add(span.Duration).UnixNano()),
+		Attributes:        make([]otlpKeyValue, 0, len(span.Tags)),
+	}
+	for _, ref := range span.References {
+		if s.ParentSpanId == "" && ref.RefType == model.ChildOf && ref.TraceID == span.TraceID {
+			s.ParentSpanId = ref.SpanID.String()
+			continue
+		}
+		s.Links = append(s.Links, otlpLink{TraceId: otlpTraceId(ref.TraceID), SpanId: ref.SpanID.String()})
+	}
+
+	scope := otlpScope{}
+	isError := false
+	for _, tag := range span.Tags {
+		switch {
+		case tag.Key == "span.kind" && tag.VType == model.StringType:
+			for _, k := range otlpSpanKinds {
+				if k.kind == tag.VStr {
+					s.Kind.Number = k.number
+				}
+			}
+		case tag.Key == "otel.status_code" && tag.VType == model.StringType:
+			switch tag.VStr {
+			case "OK":
+				s.Status.Code.Number = 1
+			case "ERROR":
+				s.Status.Code.Number = 2
+			}
+		case tag.Key == "otel.status_description":
+			s.Status.Message = tag.AsString()
+		case tag.Key == "error" && tag.VType == model.BoolType:
+			isError = tag.Bool()
+		case tag.Key == "otel.scope.name" || tag.Key == "otel.library.name":
+			scope.Name = tag.AsString()
+		case tag.Key == "otel.scope.version" || tag.Key == "otel.library.version":
+			scope.Version = tag.AsString()
+		case tag.Key == "w3c.tracestate":
+			s.TraceState = tag.AsString()
+		default:
+			s.Attributes = append(s.Attributes, toOTLPAttribute(tag))
+		}
+	}
+	if isError && s.Status.Code.Number == 0 {
+		s.Status.Code.Number = 2
+	}
+
+	for _, l := range span.Logs {
+		event := otlpEvent{TimeUnixNano: otlpInt64(l.Timestamp.UnixNano())}
+		for _, field := range l.Fields {
+			if field.Key == "event" && event.Name == "" && field.VType == model.StringType {
+				event.Name = field.VStr
+				continue
+			}
+			event.Attributes = append(event.Attributes, toOTLPAttribute(field))
+		}
+		s.Events = append(s.Events, event)
+	}
+	return s, scope
+}
+
+// otlpTraceId is always 32 hex characters, model.TraceID.String drops the high bits when they are 0
+func otlpTraceId(traceId model.TraceID) string {
+	return fmt.Sprintf("%016x%016x", traceId.High, traceId.Low)
+}
+
+func toOTLPAttribute(tag model.KeyValue) otlpKeyValue {
+	kv := otlpKeyValue{Key: tag.Key}
+	switch tag.VType {
+	case model.BoolType:
+		v := tag.Bool()
+		kv.Value.BoolValue = &v
+	case model.Int64Type:
+		v := otlpInt64(tag.Int64())
+		kv.Value.IntValue = &v
+	case model.Float64Type:
+		v := tag.Float64()
+		kv.Value.DoubleValue = &v
+	case model.BinaryType:
+		v := base64.StdEncoding.EncodeToString(tag.Binary())
+		kv.Value.BytesValue = &v
+	default:
+		v := tag.VStr
+		kv.Value.StringValue = &v
+	}
+	return kv
+}
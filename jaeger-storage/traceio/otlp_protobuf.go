@@ -0,0 +1,151 @@
+package traceio
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/jaegertracing/jaeger/model"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+)
+
+// WriteOTLPProtobuf writes the spans as the protobuf encoding of an OTLP TracesData, which is also the body of an OTLP
+// ExportTraceServiceRequest. The messages are encoded by hand with their field numbers from
+// opentelemetry/proto/trace/v1/trace.proto and common/v1/common.proto, the generated OTLP types are not a dependency.
+func WriteOTLPProtobuf(w io.Writer, spans []*model.Span) error {
+	var b []byte
+	for _, rs := range toOTLP(spans).ResourceSpans {
+		b = appendMessage(b, 1, appendResourceSpans(nil, rs))
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func appendMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendHexBytes(b []byte, num protowire.Number, s string) []byte {
+	id, err := hex.DecodeString(s)
+	if err != nil || len(id) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, id)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v otlpInt64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, uint64(v))
+}
+
+func appendEnum(b []byte, num protowire.Number, e otlpEnum) []byte {
+	if e.Number == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(e.Number))
+}
+
+func appendResourceSpans(b []byte, rs otlpResourceSpans) []byte {
+	resource := make([]byte, 0)
+	for _, kv := range rs.Resource.Attributes {
+		resource = appendMessage(resource, 1, appendKeyValue(nil, kv))
+	}
+	b = appendMessage(b, 1, resource)
+	for _, ss := range rs.ScopeSpans {
+		b = appendMessage(b, 2, appendScopeSpans(nil, ss))
+	}
+	return b
+}
+
+func appendScopeSpans(b []byte, ss otlpScopeSpans) []byte {
+	scope := appendString(nil, 1, ss.Scope.Name)
+	scope = appendString(scope, 2, ss.Scope.Version)
+	b = appendMessage(b, 1, scope)
+	for _, s := range ss.Spans {
+		b = appendMessage(b, 2, appendSpan(nil, s))
+	}
+	return b
+}
+
+func appendSpan(b []byte, s otlpSpan) []byte {
+	b = appendHexBytes(b, 1, s.TraceId)
+	b = appendHexBytes(b, 2, s.SpanId)
+	b = appendString(b, 3, s.TraceState)
+	b = appendHexBytes(b, 4, s.ParentSpanId)
+	b = appendString(b, 5, s.Name)
+	b = appendEnum(b, 6, s.Kind)
+	b = appendFixed64(b, 7, s.StartTimeUnixNano)
+	b = appendFixed64(b, 8, s.EndTimeUnixNano)
+	for _, kv := range s.Attributes {
+		b = appendMessage(b, 9, appendKeyValue(nil, kv))
+	}
+	for _, e := range s.Events {
+		event := appendFixed64(nil, 1, e.TimeUnixNano)
+		event = appendString(event, 2, e.Name)
+		for _, kv := range e.Attributes {
+			event = appendMessage(event, 3, appendKeyValue(nil, kv))
+		}
+		b = appendMessage(b, 11, event)
+	}
+	for _, l := range s.Links {
+		link := appendHexBytes(nil, 1, l.TraceId)
+		link = appendHexBytes(link, 2, l.SpanId)
+		for _, kv := range l.Attributes {
+			link = appendMessage(link, 4, appendKeyValue(nil, kv))
+		}
+		b = appendMessage(b, 13, link)
+	}
+	status := appendString(nil, 2, s.Status.Message)
+	status = appendEnum(status, 3, s.Status.Code)
+	return appendMessage(b, 15, status)
+}
+
+func appendKeyValue(b []byte, kv otlpKeyValue) []byte {
+	b = appendString(b, 1, kv.Key)
+	return appendMessage(b, 2, appendAnyValue(nil, kv.Value))
+}
+
+func appendAnyValue(b []byte, v otlpAnyValue) []byte {
+	switch {
+	case v.StringValue != nil:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, *v.StringValue)
+	case v.BoolValue != nil:
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(*v.BoolValue))
+	case v.IntValue != nil:
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(*v.IntValue))
+	case v.DoubleValue != nil:
+		b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(*v.DoubleValue))
+	case v.ArrayValue != nil:
+		array := make([]byte, 0)
+		for _, value := range v.ArrayValue.Values {
+			array = appendMessage(array, 1, appendAnyValue(nil, value))
+		}
+		b = appendMessage(b, 5, array)
+	case v.KvlistValue != nil:
+		kvlist := make([]byte, 0)
+		for _, kv := range v.KvlistValue.Values {
+			kvlist = appendMessage(kvlist, 1, appendKeyValue(nil, kv))
+		}
+		b = appendMessage(b, 6, kvlist)
+	case v.BytesValue != nil:
+		data, _ := base64.StdEncoding.DecodeString(*v.BytesValue)
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendBytes(b, data)
+	}
+	return b
+}
@@ -0,0 +1,358 @@
+package traceio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/jaegertracing/jaeger/model"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// protoField is a field of a protobuf message, decoded without the schema
+type protoField struct {
+	num   protowire.Number
+	typ   protowire.Type
+	value uint64
+	bytes []byte
+}
+
+func protoFields(t *testing.T, b []byte) []protoField {
+	t.Helper()
+	fields := make([]protoField, 0)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		f := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.value, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("field %d has the unexpected wire type %d", num, typ)
+		}
+		if n < 0 {
+			t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// the decoders below follow opentelemetry/proto/trace/v1/trace.proto and common/v1/common.proto
+
+func decodeTracesData(t *testing.T, b []byte) otlpTraces {
+	traces := otlpTraces{}
+	for _, f := range protoFields(t, b) {
+		if f.num == 1 {
+			traces.ResourceSpans = append(traces.ResourceSpans, decodeResourceSpans(t, f.bytes))
+		}
+	}
+	return traces
+}
+
+func decodeResourceSpans(t *testing.T, b []byte) otlpResourceSpans {
+	rs := otlpResourceSpans{}
+	for _, f := range protoFields(t, b) {
+		switch f.num {
+		case 1:
+			for _, rf := range protoFields(t, f.bytes) {
+				if rf.num == 1 {
+					rs.Resource.Attributes = append(rs.Resource.Attributes, decodeKeyValue(t, rf.bytes))
+				}
+			}
+		case 2:
+			rs.ScopeSpans = append(rs.ScopeSpans, decodeScopeSpans(t, f.bytes))
+		}
+	}
+	return rs
+}
+
+func decodeScopeSpans(t *testing.T, b []byte) otlpScopeSpans {
+	ss := otlpScopeSpans{}
+	for _, f := range protoFields(t, b) {
+		switch f.num {
+		case 1:
+			for _, sf := range protoFields(t, f.bytes) {
+				switch sf.num {
+				case 1:
+					ss.Scope.Name = string(sf.bytes)
+				case 2:
+					ss.Scope.Version = string(sf.bytes)
+				}
+			}
+		case 2:
+			ss.Spans = append(ss.Spans, decodeSpan(t, f.bytes))
+		}
+	}
+	return ss
+}
+
+func decodeSpan(t *testing.T, b []byte) otlpSpan {
+	s := otlpSpan{}
+	for _, f := range protoFields(t, b) {
+		switch f.num {
+		case 1:
+			s.TraceId = hex.EncodeToString(f.bytes)
+		case 2:
+			s.SpanId = hex.EncodeToString(f.bytes)
+		case 3:
+			s.TraceState = string(f.bytes)
+		case 4:
+			s.ParentSpanId = hex.EncodeToString(f.bytes)
+		case 5:
+			s.Name = string(f.bytes)
+		case 6:
+			s.Kind.Number = int(f.value)
+		case 7:
+			s.StartTimeUnixNano = otlpInt64(f.value)
+		case 8:
+			s.EndTimeUnixNano = otlpInt64(f.value)
+		case 9:
+			s.Attributes = append(s.Attributes, decodeKeyValue(t, f.bytes))
+		case 11:
+			event := otlpEvent{}
+			for _, ef := range protoFields(t, f.bytes) {
+				switch ef.num {
+				case 1:
+					event.TimeUnixNano = otlpInt64(ef.value)
+				case 2:
+					event.Name = string(ef.bytes)
+				case 3:
+					event.Attributes = append(event.Attributes, decodeKeyValue(t, ef.bytes))
+				}
+			}
+			s.Events = append(s.Events, event)
+		case 13:
+			link := otlpLink{}
+			for _, lf := range protoFields(t, f.bytes) {
+				switch lf.num {
+				case 1:
+					link.TraceId = hex.EncodeToString(lf.bytes)
+				case 2:
+					link.SpanId = hex.EncodeToString(lf.bytes)
+				case 4:
+					link.Attributes = append(link.Attributes, decodeKeyValue(t, lf.bytes))
+				}
+			}
+			s.Links = append(s.Links, link)
+		case 15:
+			for _, sf := range protoFields(t, f.bytes) {
+				switch sf.num {
+				case 2:
+					s.Status.Message = string(sf.bytes)
+				case 3:
+					s.Status.Code.Number = int(sf.value)
+				}
+			}
+		}
+	}
+	return s
+}
+
+func decodeKeyValue(t *testing.T, b []byte) otlpKeyValue {
+	kv := otlpKeyValue{}
+	for _, f := range protoFields(t, b) {
+		switch f.num {
+		case 1:
+			kv.Key = string(f.bytes)
+		case 2:
+			kv.Value = decodeAnyValue(t, f.bytes)
+		}
+	}
+	return kv
+}
+
+func decodeAnyValue(t *testing.T, b []byte) otlpAnyValue {
+	v := otlpAnyValue{}
+	for _, f := range protoFields(t, b) {
+		switch f.num {
+		case 1:
+			s := string(f.bytes)
+			v.StringValue = &s
+		case 2:
+			b := protowire.DecodeBool(f.value)
+			v.BoolValue = &b
+		case 3:
+			i := otlpInt64(f.value)
+			v.IntValue = &i
+		case 4:
+			d := math.Float64frombits(f.value)
+			v.DoubleValue = &d
+		case 7:
+			s := base64.StdEncoding.EncodeToString(f.bytes)
+			v.BytesValue = &s
+		default:
+			t.Errorf("unexpected value field %d", f.num)
+		}
+	}
+	return v
+}
+
+func testSpans(t *testing.T) []*model.Span {
+	traceId, err := model.TraceIDFromString("e72ef241661424eb6970b65f6fd74b30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 12, 19, 10, 0, 0, 123456789, time.UTC)
+	frontend := model.NewProcess("frontend", []model.KeyValue{model.String("hostname", "web-1"), model.Int64("pid", 42)})
+	redis := model.NewProcess("redis-manual", []model.KeyValue{model.String("ip", "10.0.0.12")})
+	return []*model.Span{
+		{
+			TraceID:       traceId,
+			SpanID:        model.NewSpanID(1),
+			OperationName: "/dispatch",
+			StartTime:     start,
+			Duration:      730 * time.Millisecond,
+			Tags: []model.KeyValue{
+				model.String("span.kind", "server"),
+				model.String("http.method", "GET"),
+				model.Int64("http.status_code", 200),
+				model.String("otel.scope.name", "net/http"),
+				model.String("otel.scope.version", "1.0.0"),
+				model.String("w3c.tracestate", "vendor=value"),
+			},
+			Process: frontend,
+		},
+		{
+			TraceID:       traceId,
+			SpanID:        model.NewSpanID(2),
+			OperationName: "GetDriver",
+			StartTime:     start.Add(10 * time.Millisecond),
+			Duration:      80 * time.Millisecond,
+			References: []model.SpanRef{
+				model.NewChildOfRef(traceId, model.NewSpanID(1)),
+				model.NewFollowsFromRef(model.NewTraceID(0, 7), model.NewSpanID(9)),
+			},
+			Tags: []model.KeyValue{
+				model.String("span.kind", "client"),
+				model.String("otel.status_code", "ERROR"),
+				model.String("otel.status_description", "redis timeout"),
+				model.Bool("error", true),
+				model.Float64("retry.ratio", 0.25),
+				model.Bool("cache.hit", false),
+				model.Binary("payload", []byte{0, 1, 0xfe}),
+			},
+			Logs: []model.Log{
+				{
+					Timestamp: start.Add(60 * time.Millisecond),
+					Fields: []model.KeyValue{
+						model.String("event", "redis timeout"),
+						model.String("driver_id", "T7991012"),
+						model.Int64("attempt", -1),
+					},
+				},
+			},
+			Process: redis,
+		},
+	}
+}
+
+func TestWriteOTLPProtobufRoundTrip(t *testing.T) {
+	spans := testSpans(t)
+	var buf bytes.Buffer
+	if err := WriteOTLPProtobuf(&buf, spans); err != nil {
+		t.Fatal(err)
+	}
+	decoded := decodeTracesData(t, buf.Bytes())
+
+	if len(decoded.ResourceSpans) != 2 {
+		t.Fatalf("decoded %d resources, want one per process", len(decoded.ResourceSpans))
+	}
+	server := decoded.ResourceSpans[0].ScopeSpans[0]
+	if server.Scope != (otlpScope{Name: "net/http", Version: "1.0.0"}) || server.Spans[0].Kind.Number != 2 || server.Spans[0].TraceState != "vendor=value" {
+		t.Errorf("server span = %+v in scope %+v", server.Spans[0], server.Scope)
+	}
+	client := decoded.ResourceSpans[1].ScopeSpans[0].Spans[0]
+	if client.TraceId != "e72ef241661424eb6970b65f6fd74b30" || client.ParentSpanId != "0000000000000001" || client.Kind.Number != 3 {
+		t.Errorf("client span = %+v", client)
+	}
+	if client.Status.Code.Number != 2 || client.Status.Message != "redis timeout" {
+		t.Errorf("status = %+v, want the error with its description", client.Status)
+	}
+	if int64(client.EndTimeUnixNano-client.StartTimeUnixNano) != (80 * time.Millisecond).Nanoseconds() {
+		t.Errorf("start %d and end %d are not 80ms apart", client.StartTimeUnixNano, client.EndTimeUnixNano)
+	}
+	if len(client.Links) != 1 || client.Links[0].TraceId != "00000000000000000000000000000007" {
+		t.Errorf("links = %+v, want the follows from reference", client.Links)
+	}
+
+	// the protobuf and the JSON encodings read back as the same spans
+	data, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromProtobuf, err := ReadOTLPJSON(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jsonBuf bytes.Buffer
+	if err := WriteOTLPJSON(&jsonBuf, spans); err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := ReadOTLPJSON(&jsonBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromProtobuf, fromJSON) {
+		t.Errorf("the protobuf encoding reads back as\n%+v\nthe JSON encoding as\n%+v", fromProtobuf, fromJSON)
+	}
+
+	// and as the spans that were written, the tags are the same up to their order
+	for i, span := range fromProtobuf {
+		want := spans[i]
+		if span.TraceID != want.TraceID || span.SpanID != want.SpanID || span.OperationName != want.OperationName ||
+			!span.StartTime.Equal(want.StartTime) || span.Duration != want.Duration || span.Process.ServiceName != want.Process.ServiceName {
+			t.Errorf("span %d = %s %s %s at %s for %s, want %s %s %s at %s for %s", i, span.TraceID, span.SpanID, span.OperationName, span.StartTime, span.Duration,
+				want.TraceID, want.SpanID, want.OperationName, want.StartTime, want.Duration)
+		}
+		if !sameTags(span.Tags, want.Tags) {
+			t.Errorf("span %d tags = %v, want %v", i, span.Tags, want.Tags)
+		}
+		if !sameTags(span.Process.Tags, want.Process.Tags) {
+			t.Errorf("span %d process tags = %v, want %v", i, span.Process.Tags, want.Process.Tags)
+		}
+		if len(span.Logs) != len(want.Logs) {
+			t.Fatalf("span %d has %d logs, want %d", i, len(span.Logs), len(want.Logs))
+		}
+		for j, l := range span.Logs {
+			if !l.Timestamp.Equal(want.Logs[j].Timestamp) || !sameTags(l.Fields, want.Logs[j].Fields) {
+				t.Errorf("span %d log %d = %v, want %v", i, j, l, want.Logs[j])
+			}
+		}
+		if (len(span.References) > 0 || len(want.References) > 0) && !reflect.DeepEqual(span.References, want.References) {
+			t.Errorf("span %d references = %v, want %v", i, span.References, want.References)
+		}
+	}
+}
+
+// sameTags compares the tags regardless of their order
+func sameTags(got []model.KeyValue, want []model.KeyValue) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for _, w := range want {
+		found := false
+		for _, g := range got {
+			if g.Equal(&w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
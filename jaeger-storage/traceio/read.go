@@ -0,0 +1,31 @@
+package traceio
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jaegertracing/jaeger/model"
+	"io"
+)
+
+const (
+	FormatJaeger = "jaeger"
+	FormatOTLP   = "otlp"
+)
+
+// Read reads the spans of a Jaeger UI JSON or OTLP JSON file, the format is detected from the top level keys
+func Read(r io.Reader) ([]*model.Span, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, "", fmt.Errorf("expected a JSON object: %w", err)
+	}
+	if _, ok := keys["resourceSpans"]; ok {
+		spans, err := readOTLPJSON(data)
+		return spans, FormatOTLP, err
+	}
+	spans, err := readJaegerJSON(data)
+	return spans, FormatJaeger, err
+}
@@ -0,0 +1,85 @@
+package traceio
+
+import (
+	"github.com/jaegertracing/jaeger/model"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadJaegerJSON(t *testing.T) {
+	f, err := os.Open("../../HotrodData/SampleData/hotrod11.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	spans, format, err := Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != FormatJaeger || len(spans) != 39 {
+		t.Fatalf("read %d spans as %s, want 39 spans as %s", len(spans), format, FormatJaeger)
+	}
+
+	traceId, _ := model.TraceIDFromString("84b3b3e7e90f8d9c4bc5a661ae3c742b")
+	byId := make(map[model.SpanID]*model.Span, len(spans))
+	roots := make([]*model.Span, 0)
+	for i, span := range spans {
+		if span.TraceID != traceId {
+			t.Errorf("span %s has the trace ID %s", span.SpanID, span.TraceID)
+		}
+		if i > 0 && span.StartTime.Before(spans[i-1].StartTime) {
+			t.Errorf("span %s starts before the span read before it", span.SpanID)
+		}
+		byId[span.SpanID] = span
+		if len(span.References) == 0 {
+			roots = append(roots, span)
+		}
+	}
+	if len(roots) != 1 || roots[0].SpanID.String() != "518948ac18876398" || roots[0].OperationName != "/dispatch" || roots[0].Process.ServiceName != "frontend" {
+		t.Fatalf("roots = %v, want /dispatch of frontend", roots)
+	}
+
+	span := byId[model.NewSpanID(0x57b3e9417a852e39)]
+	if span == nil {
+		t.Fatal("span 57b3e9417a852e39 not found")
+	}
+	if len(span.References) != 1 || span.References[0].RefType != model.ChildOf || span.References[0].TraceID != traceId || span.References[0].SpanID.String() != "a3cd538c449a5ee1" {
+		t.Errorf("references = %v, want a child of a3cd538c449a5ee1", span.References)
+	}
+	if byId[span.ParentSpanID()] == nil {
+		t.Errorf("the parent %s of GetDriver is not in the trace", span.ParentSpanID())
+	}
+	if span.OperationName != "GetDriver" || span.Process.ServiceName != "redis-manual" {
+		t.Errorf("span = %s of %s", span.OperationName, span.Process.ServiceName)
+	}
+	tags := make(map[string]string)
+	for _, tag := range span.Process.Tags {
+		tags[tag.Key] = tag.AsString()
+	}
+	if tags["host.name"] != "874ba54cd763" || tags["telemetry.sdk.language"] != "go" || len(tags) != 5 {
+		t.Errorf("process tags = %v", tags)
+	}
+}
+
+func TestReadRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not JSON", "trace"},
+		{"not an object", `[{"traceID": "84b3b3e7e90f8d9c4bc5a661ae3c742b"}]`},
+		{"other document", `{"name": "jaeger-storage", "version": 1}`},
+		{"no traces", `{"data": [], "total": 0}`},
+		{"OTLP without spans", `{"resourceSpans": []}`},
+		{"unknown process", `{"data": [{"traceID": "84b3b3e7e90f8d9c4bc5a661ae3c742b", "spans": [{"traceID": "84b3b3e7e90f8d9c4bc5a661ae3c742b", "spanID": "518948ac18876398", "processID": "p1"}], "processes": {}}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if spans, _, err := Read(strings.NewReader(tt.data)); err == nil {
+				t.Errorf("Read() = %d spans, want an error", len(spans))
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package usage
+
+import "context"
+
+const (
+	CallerIngest  = "ingest"
+	CallerAsk     = "ask"
+	CallerSearch  = "search"
+	CallerCompare = "compare"
+	// the background job describing incidents
+	CallerIncidents = "incidents"
+	// the explanations of the alerts sent to webhooks
+	CallerAlerting = "alerting"
+	// the scoring of the answers by the eval subcommand
+	CallerEval = "eval"
+)
+
+// Attribution describes who an LLM call is made on behalf of.
+type Attribution struct {
+	Caller        string
+	ServiceName   string
+	OperationName string
+	TraceId       string
+}
+
+type attributionKey struct{}
+
+func WithAttribution(ctx context.Context, attribution Attribution) context.Context {
+	return context.WithValue(ctx, attributionKey{}, attribution)
+}
+
+func AttributionFromContext(ctx context.Context) Attribution {
+	attribution, ok := ctx.Value(attributionKey{}).(Attribution)
+	if !ok {
+		return Attribution{Caller: "unknown"}
+	}
+	if attribution.Caller == "" {
+		attribution.Caller = "unknown"
+	}
+	return attribution
+}
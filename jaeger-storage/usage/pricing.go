@@ -0,0 +1,36 @@
+package usage
+
+import "strings"
+
+// price in USD per 1 million tokens
+type modelPrice struct {
+	prompt     float64
+	completion float64
+}
+
+// adapted from https://openai.com/api/pricing/
+var prices = map[string]modelPrice{
+	"gpt-3.5-turbo":          {prompt: 0.5, completion: 1.5},
+	"gpt-4o-mini":            {prompt: 0.15, completion: 0.6},
+	"gpt-4o":                 {prompt: 2.5, completion: 10},
+	"gpt-4-turbo":            {prompt: 10, completion: 30},
+	"text-embedding-3-small": {prompt: 0.02},
+	"text-embedding-3-large": {prompt: 0.13},
+}
+
+// Cost returns the estimated cost in USD. Dated model snapshots such as
+// gpt-4o-mini-2024-07-18 are priced as their base model.
+func Cost(model string, promptTokens, completionTokens int) float64 {
+	price, ok := prices[model]
+	if !ok {
+		longest := ""
+		for name, p := range prices {
+			if strings.HasPrefix(model, name) && len(name) > len(longest) {
+				longest = name
+				price = p
+			}
+		}
+	}
+
+	return (float64(promptTokens)*price.prompt + float64(completionTokens)*price.completion) / 1_000_000
+}
@@ -0,0 +1,223 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Record struct {
+	Attribution
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+type Budget struct {
+	DailyTokens  int64   `json:"daily_tokens"`
+	DailyCostUsd float64 `json:"daily_cost_usd"`
+}
+
+type Totals struct {
+	Day              string  `json:"day" db:"day"`
+	Requests         int64   `json:"requests" db:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens" db:"completion_tokens"`
+	CostUsd          float64 `json:"cost_usd" db:"cost_usd"`
+}
+
+type Aggregate struct {
+	Day              string  `json:"day,omitempty" db:"day"`
+	Caller           string  `json:"caller,omitempty" db:"caller"`
+	Model            string  `json:"model,omitempty" db:"model"`
+	ServiceName      string  `json:"service_name,omitempty" db:"service_name"`
+	OperationName    string  `json:"operation_name,omitempty" db:"operation_name"`
+	TraceId          string  `json:"trace_id,omitempty" db:"trace_id"`
+	Requests         int64   `json:"requests" db:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens" db:"completion_tokens"`
+	CostUsd          float64 `json:"cost_usd" db:"cost_usd"`
+}
+
+type AggregateQuery struct {
+	From    time.Time
+	To      time.Time
+	GroupBy []string
+}
+
+// columns that can be used to group the daily aggregates
+var groupByColumns = map[string]string{
+	"day":       "day",
+	"caller":    "caller",
+	"model":     "model",
+	"service":   "service_name",
+	"operation": "operation_name",
+	"trace":     "trace_id",
+}
+
+// Tracker persists token usage per day and enforces the daily budget.
+type Tracker struct {
+	db     *sqlx.DB
+	budget Budget
+	today  Totals
+	mutex  sync.Mutex
+}
+
+func NewTracker(db *sqlx.DB) (*Tracker, error) {
+	budget, err := budgetFromEnv()
+	if err != nil {
+		log.Println("[usage][NewTracker][error] invalid budget", err)
+		return nil, err
+	}
+	t := &Tracker{
+		db:     db,
+		budget: budget,
+	}
+	t.today = Totals{Day: today()}
+	if err := t.loadToday(context.Background()); err != nil {
+		log.Println("[usage][NewTracker][error] cannot load today's usage", err)
+	}
+	return t, nil
+}
+
+// budgetFromEnv fails on invalid values rather than ignoring them, a typo must not silently lift the budget
+func budgetFromEnv() (Budget, error) {
+	budget := Budget{}
+	if v := os.Getenv("LLM_DAILY_TOKEN_BUDGET"); v != "" {
+		tokens, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || tokens < 0 {
+			return budget, fmt.Errorf("invalid LLM_DAILY_TOKEN_BUDGET %q, expects a non-negative number of tokens", v)
+		}
+		budget.DailyTokens = tokens
+	}
+	if v := os.Getenv("LLM_DAILY_COST_BUDGET_USD"); v != "" {
+		cost, err := strconv.ParseFloat(v, 64)
+		if err != nil || cost < 0 {
+			return budget, fmt.Errorf("invalid LLM_DAILY_COST_BUDGET_USD %q, expects a non-negative amount in USD", v)
+		}
+		budget.DailyCostUsd = cost
+	}
+	return budget, nil
+}
+
+func today() string {
+	return time.Now().UTC().Format(time.DateOnly)
+}
+
+func (t *Tracker) loadToday(ctx context.Context) error {
+	//goland:noinspection ALL
+	query := "SELECT COALESCE(SUM(requests), 0) as requests, COALESCE(SUM(prompt_tokens), 0) as prompt_tokens, COALESCE(SUM(completion_tokens), 0) as completion_tokens, COALESCE(SUM(cost_usd), 0) as cost_usd FROM llm_usage WHERE day = $1"
+	totals := Totals{}
+	if err := t.db.GetContext(ctx, &totals, query, t.today.Day); err != nil {
+		return err
+	}
+	totals.Day = t.today.Day
+	t.today = totals
+	return nil
+}
+
+func (t *Tracker) Record(ctx context.Context, r Record) {
+	if t == nil {
+		return
+	}
+	cost := Cost(r.Model, r.PromptTokens, r.CompletionTokens)
+	day := today()
+
+	t.mutex.Lock()
+	if t.today.Day != day {
+		t.today = Totals{Day: day}
+	}
+	t.today.Requests++
+	t.today.PromptTokens += int64(r.PromptTokens)
+	t.today.CompletionTokens += int64(r.CompletionTokens)
+	t.today.CostUsd += cost
+	t.mutex.Unlock()
+
+	//goland:noinspection ALL
+	query := `INSERT INTO llm_usage(day, caller, model, service_name, operation_name, trace_id, requests, prompt_tokens, completion_tokens, cost_usd, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, 1, $7, $8, $9, $10, $10)
+		ON CONFLICT (day, caller, model, service_name, operation_name, trace_id) DO UPDATE SET
+			requests = llm_usage.requests + 1,
+			prompt_tokens = llm_usage.prompt_tokens + EXCLUDED.prompt_tokens,
+			completion_tokens = llm_usage.completion_tokens + EXCLUDED.completion_tokens,
+			cost_usd = llm_usage.cost_usd + EXCLUDED.cost_usd,
+			updated_at = EXCLUDED.updated_at`
+
+	// the request context may already be cancelled once the LLM call returns,
+	// the usage should still be persisted
+	_, err := t.db.ExecContext(context.WithoutCancel(ctx), query, day, r.Caller, r.Model, r.ServiceName, r.OperationName, r.TraceId, r.PromptTokens, r.CompletionTokens, cost, time.Now())
+	if err != nil {
+		log.Println("[usage][Record][error] cannot persist usage", err)
+	}
+}
+
+func (t *Tracker) Today() Totals {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.today.Day != today() {
+		t.today = Totals{Day: today()}
+	}
+	return t.today
+}
+
+func (t *Tracker) Budget() Budget {
+	return t.budget
+}
+
+// BudgetExceeded reports whether today's usage went over the configured daily budget.
+// A zero budget means unlimited.
+func (t *Tracker) BudgetExceeded() bool {
+	if t == nil {
+		return false
+	}
+	totals := t.Today()
+	if t.budget.DailyTokens > 0 && totals.PromptTokens+totals.CompletionTokens >= t.budget.DailyTokens {
+		return true
+	}
+	if t.budget.DailyCostUsd > 0 && totals.CostUsd >= t.budget.DailyCostUsd {
+		return true
+	}
+	return false
+}
+
+// ErrUnknownGroupBy is returned by Aggregates for a group_by it cannot group by, any other error comes from the database
+var ErrUnknownGroupBy = errors.New("unknown group_by")
+
+func (t *Tracker) Aggregates(ctx context.Context, q AggregateQuery) ([]Aggregate, error) {
+	columns := make([]string, 0, len(q.GroupBy))
+	for _, g := range q.GroupBy {
+		column, ok := groupByColumns[g]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownGroupBy, g)
+		}
+		if column == "day" {
+			column = "to_char(day, 'YYYY-MM-DD') as day"
+		}
+		columns = append(columns, column)
+	}
+
+	selectColumns := "COALESCE(SUM(requests), 0) as requests, COALESCE(SUM(prompt_tokens), 0) as prompt_tokens, COALESCE(SUM(completion_tokens), 0) as completion_tokens, COALESCE(SUM(cost_usd), 0) as cost_usd"
+	query := fmt.Sprintf("SELECT %s FROM llm_usage WHERE day BETWEEN $1 AND $2", selectColumns)
+	if len(columns) > 0 {
+		groupBy := make([]string, len(columns))
+		for i := range columns {
+			groupBy[i] = fmt.Sprintf("%d", i+1)
+		}
+		query = fmt.Sprintf("SELECT %s, %s FROM llm_usage WHERE day BETWEEN $1 AND $2 GROUP BY %s ORDER BY cost_usd DESC", strings.Join(columns, ", "), selectColumns, strings.Join(groupBy, ", "))
+	}
+
+	aggregates := make([]Aggregate, 0)
+	if err := t.db.SelectContext(ctx, &aggregates, query, q.From.Format(time.DateOnly), q.To.Format(time.DateOnly)); err != nil {
+		log.Println("[usage][Aggregates][error] cannot query usage", err)
+		return nil, err
+	}
+
+	return aggregates, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/jaegertracing/jaeger/model"
 	_ "github.com/lib/pq"
+	"jaeger-storage/alerting"
 	"jaeger-storage/common"
 	"jaeger-storage/storage"
 	"log"
@@ -13,18 +14,64 @@ import (
 type WriterClient struct {
 	neo4jWriter *storage.Neo4jWriter
 	sqlWriter   *storage.SqlWriter
+	spanFilter  *storage.SpanFilter
+	alerter     *alerting.Alerter
 }
 
-func NewWriterClient(sqlWriter *storage.SqlWriter, neo4jWriter *storage.Neo4jWriter) *WriterClient {
+func NewWriterClient(sqlWriter *storage.SqlWriter, neo4jWriter *storage.Neo4jWriter, spanFilter *storage.SpanFilter, alerter *alerting.Alerter) *WriterClient {
 	return &WriterClient{
 		sqlWriter:   sqlWriter,
 		neo4jWriter: neo4jWriter,
+		spanFilter:  spanFilter,
+		alerter:     alerter,
 	}
 }
 
+// ImportSummarization decides how the spans of an import are summarized
+type ImportSummarization string
+
+const (
+	// ImportSummarizationPolicy summarizes the imported spans like live spans, per span filter and summarization policy
+	ImportSummarizationPolicy ImportSummarization = "policy"
+	// ImportSummarizationSkip stores template summaries only, the import makes no LLM call
+	ImportSummarizationSkip ImportSummarization = "skip"
+	// ImportSummarizationReplay summarizes every imported span the span filter keeps in the knowledge graph, the usage
+	// budget still applies
+	ImportSummarizationReplay ImportSummarization = "replay"
+)
+
+func ParseImportSummarization(value string) (ImportSummarization, error) {
+	switch s := ImportSummarization(value); s {
+	case ImportSummarizationPolicy, ImportSummarizationSkip, ImportSummarizationReplay:
+		return s, nil
+	case "":
+		return ImportSummarizationPolicy, nil
+	}
+	return "", fmt.Errorf("unknown summarization %q, expected policy, skip or replay", value)
+}
+
 func (c *WriterClient) WriteSpan(ctx context.Context, span *model.Span) error {
-	if span.Process.GetServiceName() == "jaeger-all-in-one" {
-		//log.Println("skipping jaeger-all-in-one")
+	return c.writeSpan(ctx, span, c.spanFilter.Evaluate(span), false)
+}
+
+// ImportSpan writes a span read from a file. Imported spans go through the span filter but they are not live traffic:
+// they are marked as imported, they are neither checked against nor added to the latency baselines, they do not feed
+// the alerter and their errors are not clustered.
+func (c *WriterClient) ImportSpan(ctx context.Context, span *model.Span, summarization ImportSummarization) error {
+	action := c.spanFilter.Evaluate(span)
+	if action == storage.FilterActionStore || action == storage.FilterActionNoSummarize || action == storage.FilterActionSummarize {
+		switch summarization {
+		case ImportSummarizationSkip:
+			action = storage.FilterActionNoSummarize
+		case ImportSummarizationReplay:
+			action = storage.FilterActionSummarize
+		}
+	}
+	return c.writeSpan(ctx, span, action, true)
+}
+
+func (c *WriterClient) writeSpan(ctx context.Context, span *model.Span, action storage.FilterAction, imported bool) error {
+	if action == storage.FilterActionDrop {
 		return nil
 	}
 
@@ -57,12 +104,26 @@ func (c *WriterClient) WriteSpan(ctx context.Context, span *model.Span) error {
 		return err
 	}
 
+	// checked before the writers run, the sql writer adds the span to the baseline once it is stored
+	var anomaly *storage.LatencyAnomaly
+	if !imported {
+		anomaly = c.sqlWriter.CheckLatency(ctx, span)
+	}
+
 	errChan := make(chan error)
+	writers := 1
 
-	go func() { errChan <- c.sqlWriter.WriteSpan(ctx, span, tags, processTags, logs, references) }()
-	go func() { errChan <- c.neo4jWriter.WriteSpan(ctx, span, internalRefs, internalLogs) }()
+	go func() {
+		errChan <- c.sqlWriter.WriteSpan(ctx, span, tags, processTags, logs, references, anomaly, imported)
+	}()
+	if action != storage.FilterActionPostgresOnly {
+		writers++
+		go func() {
+			errChan <- c.neo4jWriter.WriteSpan(ctx, span, internalRefs, internalLogs, action, anomaly, imported)
+		}()
+	}
 	var accumulatedErr string
-	for i := 0; i < 2; i++ {
+	for i := 0; i < writers; i++ {
 		e := <-errChan
 		if e != nil {
 			accumulatedErr += e.Error()
@@ -73,5 +134,9 @@ func (c *WriterClient) WriteSpan(ctx context.Context, span *model.Span) error {
 		return fmt.Errorf(accumulatedErr)
 	}
 
+	if !imported {
+		c.alerter.ObserveSpan(span, anomaly)
+	}
+
 	return nil
 }